@@ -0,0 +1,201 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// RemovalReason is why an entry was removed, for ArchiveOnExpire
+type RemovalReason string
+
+// removal reasons
+const (
+	RemovalExpire RemovalReason = "expire" // including expired entries deleted before the sweep
+	RemovalDelete RemovalReason = "delete" // Delete, Take, Clear, DeleteByPrefix, ...
+	RemovalEvict  RemovalReason = "evict"  // for capacity, memory pressure, a gone parent, or ScrubPolicy
+)
+
+// ArchivedEntry is a removed entry, as ArchiveOnExpire delivers it
+type ArchivedEntry struct {
+	Key       string
+	Value     interface{}
+	CreatedAt time.Time // of the entry, its value may have changed since
+	Deadline  time.Time // zero if the entry did not expire
+	RemovedAt time.Time
+	Reason    RemovalReason
+}
+
+const (
+	defaultArchiveBuffer   = 10000
+	defaultArchiveAttempts = 3
+	defaultArchiveBackoff  = time.Millisecond * 100
+)
+
+// ArchiveOnExpire makes the store deliver the expired entries to sink,
+// instead of dropping them, for retention. A goroutine delivers them in
+// batches, in the order they were removed, outside the lock. A batch that
+// sink fails is tried again (see ArchiveRetry); one that fails every attempt
+// goes to OnArchiveError. Removals wait in a buffer (see ArchiveBuffer); those
+// it has no room for are dropped, and counted in Stats.ArchiveDropped. Stop
+// delivers the buffer, and later removals are delivered by goroutines of
+// their own. See ArchiveRemovals, to archive other removals.
+func ArchiveOnExpire(sink func(batch []ArchivedEntry) error) StoreOption {
+	return func(opt *storeOpt) {
+		opt.archiveSink = sink
+	}
+}
+
+// ArchiveRemovals sets the removals ArchiveOnExpire archives, RemovalExpire
+// by default
+func ArchiveRemovals(reasons ...RemovalReason) StoreOption {
+	return func(opt *storeOpt) {
+		opt.archiveReasons = reasons
+	}
+}
+
+// ArchiveRetry sets how many times a batch is tried, 3 by default, and the
+// backoff between the attempts, which doubles after each one
+func ArchiveRetry(attempts int, backoff time.Duration) StoreOption {
+	return func(opt *storeOpt) {
+		opt.archiveAttempts = attempts
+		opt.archiveBackoff = backoff
+	}
+}
+
+// ArchiveBuffer sets the number of removed entries waiting for delivery,
+// 10000 by default
+func ArchiveBuffer(n int) StoreOption {
+	return func(opt *storeOpt) {
+		opt.archiveBuffer = n
+	}
+}
+
+// OnArchiveError sets the function that gets the batches ArchiveOnExpire
+// could not deliver, with the error of the last attempt
+func OnArchiveError(onArchiveError func(batch []ArchivedEntry, err error)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onArchiveError = onArchiveError
+	}
+}
+
+// startArchive starts the goroutine that delivers the archive, on creation
+func (kv *Store) startArchive() {
+	if kv.archiveSink == nil {
+		return
+	}
+	if kv.archiveReasons == nil {
+		kv.archiveReasons = []RemovalReason{RemovalExpire}
+	}
+	if kv.archiveBuffer <= 0 {
+		kv.archiveBuffer = defaultArchiveBuffer
+	}
+	if kv.archiveAttempts <= 0 {
+		kv.archiveAttempts = defaultArchiveAttempts
+	}
+	if kv.archiveBackoff <= 0 {
+		kv.archiveBackoff = defaultArchiveBackoff
+	}
+	kv.archiveReady = make(chan struct{}, 1)
+	kv.archiveDone = make(chan struct{})
+	go kv.archiveLoop()
+}
+
+// stopArchive waits for the buffer to be delivered, on Stop
+func (kv *Store) stopArchive() {
+	if kv.archiveDone != nil {
+		<-kv.archiveDone
+	}
+}
+
+// archive queues the removal of e, if its reason is archived; it must be
+// called under the lock
+func (kv *Store) archive(k string, e *entry) {
+	if kv.archiveSink == nil {
+		return
+	}
+	reason := RemovalDelete
+	switch {
+	case kv.expired(e):
+		reason = RemovalExpire
+	case kv.evicting:
+		reason = RemovalEvict
+	}
+	if !kv.archives(reason) {
+		return
+	}
+	if len(kv.archived) >= kv.archiveBuffer {
+		kv.stats.ArchiveDropped++
+		return
+	}
+	ae := ArchivedEntry{Key: k, Value: e.value, CreatedAt: e.createdAt, RemovedAt: kv.now(), Reason: reason}
+	if e.timeout != nil {
+		ae.Deadline = e.expiresAt
+	}
+	kv.archived = append(kv.archived, ae)
+	if kv.archiveStopped {
+		batch := kv.archived
+		kv.archived = nil
+		kv.spawnLocked(func() { kv.deliver(batch) })
+		return
+	}
+	select {
+	case kv.archiveReady <- struct{}{}:
+	default:
+	}
+}
+
+func (kv *Store) archives(reason RemovalReason) bool {
+	for _, r := range kv.archiveReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func (kv *Store) archiveLoop() {
+	defer close(kv.archiveDone)
+	for {
+		stopped := false
+		select {
+		case <-kv.archiveReady:
+		case <-kv.stop:
+			stopped = true
+		}
+		kv.mx.Lock()
+		batch := kv.archived
+		kv.archived = nil
+		if stopped {
+			kv.archiveStopped = true
+		}
+		kv.mx.Unlock()
+		if len(batch) > 0 {
+			kv.spawnWait(func() { kv.deliver(batch) })
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// deliver delivers batch to the sink, with the retries
+func (kv *Store) deliver(batch []ArchivedEntry) {
+	backoff := kv.archiveBackoff
+	var err error
+	for attempt := 1; attempt <= kv.archiveAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		err = try(func() error { return kv.archiveSink(batch) })
+		if err == nil {
+			return
+		}
+	}
+	if kv.onArchiveError == nil {
+		return
+	}
+	try(func() error {
+		kv.onArchiveError(batch, err)
+		return nil
+	})
+}
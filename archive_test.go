@@ -0,0 +1,150 @@
+package tinykv
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakySink is an archive sink that fails the first attempt of each batch
+type flakySink struct {
+	mx        sync.Mutex
+	attempts  int
+	failNext  bool
+	delivered []ArchivedEntry
+	batches   chan []ArchivedEntry
+}
+
+func newFlakySink() *flakySink {
+	return &flakySink{failNext: true, batches: make(chan []ArchivedEntry, 100)}
+}
+
+func (s *flakySink) sink(batch []ArchivedEntry) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.attempts++
+	if s.failNext {
+		s.failNext = false
+		return errors.New("unavailable")
+	}
+	s.failNext = true
+	s.delivered = append(s.delivered, batch...)
+	s.batches <- batch
+	return nil
+}
+
+// receive waits for n archived entries
+func (s *flakySink) receive(t *testing.T, n int) []ArchivedEntry {
+	var got []ArchivedEntry
+	for len(got) < n {
+		select {
+		case batch := <-s.batches:
+			got = append(got, batch...)
+		case <-time.After(time.Second * 2):
+			t.Fatalf("got %d archived of %d: %v", len(got), n, got)
+		}
+	}
+	return got
+}
+
+func TestArchiveOnExpire(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	start := clock.Now()
+	s := newFlakySink()
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		MaxEntries(3),
+		ArchiveOnExpire(s.sink),
+		ArchiveRemovals(RemovalExpire, RemovalDelete, RemovalEvict),
+		ArchiveRetry(3, time.Millisecond))
+	assert.Equal([]RemovalReason{RemovalExpire, RemovalDelete, RemovalEvict}, kv.Config().ArchiveRemovals)
+
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	kv.Put("b", 2, ExpiresAfter(time.Minute))
+	clock.Advance(time.Second * 2)
+	kv.Put("c", 3)
+	kv.ExpireNow()
+	kv.Delete("b")
+	kv.Put("d", 4, ExpiresAfter(time.Minute))
+	kv.Put("e", 5)
+	kv.Put("f", 6) // evicts d, the soonest to expire
+	kv.Take("c")
+
+	got := s.receive(t, 4)
+	kv.Stop()
+	var keys []string
+	var reasons []RemovalReason
+	for _, ae := range got {
+		keys = append(keys, ae.Key)
+		reasons = append(reasons, ae.Reason)
+	}
+	// in the order of removal, in and across batches
+	assert.Equal([]string{"a", "b", "d", "c"}, keys)
+	assert.Equal([]RemovalReason{RemovalExpire, RemovalDelete, RemovalEvict, RemovalDelete}, reasons)
+	a := got[0]
+	assert.Equal(1, a.Value)
+	assert.True(a.CreatedAt.Equal(start))
+	assert.True(a.Deadline.Equal(start.Add(time.Second)))
+	assert.True(a.RemovedAt.Equal(start.Add(time.Second * 2)))
+	assert.True(got[3].Deadline.IsZero())
+
+	// every batch failed once
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	assert.Equal(len(got), len(s.delivered))
+	assert.True(s.attempts >= 2)
+	assert.Equal(0, s.attempts%2)
+}
+
+func TestArchiveFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var (
+		called  = make(chan struct{}, 10)
+		release = make(chan struct{})
+		failed  = make(chan []ArchivedEntry, 10)
+	)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		ArchiveOnExpire(func(batch []ArchivedEntry) error {
+			called <- struct{}{}
+			<-release
+			return errors.New("down")
+		}),
+		ArchiveRetry(2, time.Millisecond),
+		ArchiveBuffer(2),
+		OnArchiveError(func(batch []ArchivedEntry, err error) {
+			assert.EqualError(err, "down")
+			failed <- batch
+		}))
+
+	// only expirations by default
+	kv.Put("deleted", 0)
+	kv.Delete("deleted")
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	<-called
+
+	// while a is being delivered, the buffer has room for two more
+	for _, k := range []string{"b", "c", "d"} {
+		kv.Put(k, 1, ExpiresAfter(time.Second))
+	}
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	assert.Equal(int64(1), kv.Stats().ArchiveDropped)
+	close(release)
+
+	batch := <-failed
+	assert.Len(batch, 1)
+	assert.Equal("a", batch[0].Key)
+	assert.Len(<-failed, 2)
+	assert.Len(called, 3) // two attempts of each batch, the first one received
+	kv.Stop()
+}
@@ -0,0 +1,86 @@
+package tinykv
+
+import (
+	"context"
+)
+
+// AwaitSweep waits until the expiration loop has completed minSweeps more
+// sweeps (see Stats.Sweeps), so a test can wait for the janitor instead of
+// sleeping. It returns the error of ctx if it is done first, and ErrStopped
+// if the store is stopped. ExpireNow is not a sweep of the loop.
+func (kv *Store) AwaitSweep(ctx context.Context, minSweeps int) error {
+	kv.mx.Lock()
+	target := kv.stats.Sweeps + int64(minSweeps)
+	for kv.stats.Sweeps < target {
+		swept := kv.swept
+		kv.mx.Unlock()
+		select {
+		case <-swept:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-kv.stop:
+			return ErrStopped
+		}
+		kv.mx.Lock()
+	}
+	kv.mx.Unlock()
+	return nil
+}
+
+// AwaitExpiration waits until k expires and its expiration notifications are
+// dispatched: the callbacks have returned (even if they are asynchronous, see
+// SynchronousNotifications), or the entry is sent to the ExpiredStream. It
+// returns ErrNotFound if there is no k, the error of ctx if it is done first
+// (like when k is deleted instead), and ErrStopped if the store is stopped.
+func (kv *Store) AwaitExpiration(ctx context.Context, k string) error {
+	kv.active()
+	kv.mx.Lock()
+	if _, ok := kv.kv.get(k); !ok {
+		kv.mx.Unlock()
+		return opError("await-expiration", k, ErrNotFound)
+	}
+	dispatched := make(chan struct{})
+	if kv.expirationWaiters == nil {
+		kv.expirationWaiters = make(map[string][]chan struct{})
+	}
+	kv.expirationWaiters[k] = append(kv.expirationWaiters[k], dispatched)
+	kv.mx.Unlock()
+
+	select {
+	case <-dispatched:
+		return nil
+	case <-ctx.Done():
+		return opError("await-expiration", k, ctx.Err())
+	case <-kv.stop:
+		return opError("await-expiration", k, ErrStopped)
+	}
+}
+
+// countSweep counts a sweep of the expiration loop, under the lock
+func (kv *Store) countSweep() {
+	kv.stats.Sweeps++
+	close(kv.swept)
+	kv.swept = make(chan struct{})
+}
+
+// dispatched releases the AwaitExpiration calls of the expired entries,
+// once their notifications are dispatched, and closes their values under
+// CloseOnRemoval
+func (kv *Store) dispatched(expired map[string]*entry) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if kv.closeOnRemoval {
+		for k, e := range expired {
+			kv.closeRemoved(k, e.value)
+		}
+	}
+	if len(kv.expirationWaiters) == 0 {
+		return
+	}
+	for k := range expired {
+		for _, ch := range kv.expirationWaiters[k] {
+			close(ch)
+		}
+		delete(kv.expirationWaiters, k)
+	}
+}
@@ -0,0 +1,101 @@
+package tinykv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// awaiting waits until there is an AwaitExpiration call for k
+func awaiting(kv *Store, k string) {
+	s := kv
+	for {
+		s.mx.Lock()
+		n := len(s.expirationWaiters[k])
+		s.mx.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAwaitSweep(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Millisecond * 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(kv.AwaitSweep(ctx, 0))
+	before := kv.Stats().Sweeps
+	assert.NoError(kv.AwaitSweep(ctx, 3))
+	assert.True(kv.Stats().Sweeps >= before+3)
+
+	short, cancelShort := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancelShort()
+	kv.SetExpirationInterval(time.Hour)
+	assert.Equal(context.DeadlineExceeded, kv.AwaitSweep(short, 1))
+
+	go kv.Stop()
+	assert.Equal(ErrStopped, kv.AwaitSweep(ctx, 1))
+}
+
+func TestAwaitExpiration(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, synchronous := range []bool{false, true} {
+		notified := make(chan string, 1)
+		options := []StoreOption{OnExpire(func(k string, v interface{}) {
+			time.Sleep(time.Millisecond * 10)
+			notified <- k
+		})}
+		if synchronous {
+			options = append(options, SynchronousNotifications())
+		}
+		kv := NewStore(time.Millisecond*5, options...)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+
+		kv.Put("a", 1, ExpiresAfter(time.Millisecond*5))
+		assert.NoError(kv.AwaitExpiration(ctx, "a"))
+		// the callback has returned
+		assert.Len(notified, 1)
+
+		err := kv.AwaitExpiration(ctx, "a")
+		assert.Equal(ErrNotFound, errors.Cause(err))
+
+		kv.Put("b", 1)
+		short, cancelShort := context.WithTimeout(ctx, time.Millisecond*20)
+		err = kv.AwaitExpiration(short, "b")
+		assert.Equal(context.DeadlineExceeded, errors.Cause(err))
+		cancelShort()
+
+		go kv.Stop()
+		err = kv.AwaitExpiration(ctx, "b")
+		assert.Equal(ErrStopped, errors.Cause(err))
+		cancel()
+	}
+}
+
+func TestAwaitExpirationLazy(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+	ch, cancelStream := kv.ExpiredStream(1)
+	defer cancelStream()
+
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	done := make(chan error, 1)
+	go func() { done <- kv.AwaitExpiration(context.Background(), "a") }()
+	awaiting(kv, "a")
+	clock.Advance(time.Second * 2)
+	_, ok := kv.Get("a")
+	assert.False(ok)
+	assert.NoError(<-done)
+	assert.Equal("a", (<-ch).Key)
+}
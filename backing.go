@@ -0,0 +1,225 @@
+package tinykv
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Backing is the source of truth behind the store, see WithBacking. ttl is
+// zero for entries that do not expire.
+type Backing interface {
+	Load(k string) (v interface{}, ttl time.Duration, ok bool, err error)
+	Store(k string, v interface{}, ttl time.Duration) error
+	Remove(k string) error
+}
+
+// WritePolicy is how writes reach the Backing
+type WritePolicy int
+
+// write policies
+const (
+	WriteThrough WritePolicy = iota + 1 // by the write, which returns its error
+	WriteBehind                         // by a goroutine, from a queue
+)
+
+func (p WritePolicy) String() string {
+	switch p {
+	case WriteThrough:
+		return "write-through"
+	case WriteBehind:
+		return "write-behind"
+	}
+	return "unknown"
+}
+
+// defaultWriteBehindBuffer is the size of the WriteBehind queue, without
+// WriteBehindBuffer
+const defaultWriteBehindBuffer = 1024
+
+// WithBacking puts b behind the store. On a miss, Get (and GetE) loads the
+// entry from b, outside the lock and once per key for concurrent misses (see
+// Refreshes), and puts it with the ttl b returns. Put, CAS, TryPut and
+// ForcePut store the written value in b, and Delete, DeleteE, ForceDelete and
+// Take (of an entry in memory) remove the key from b, after the write in
+// memory: with WriteThrough, as part of the call (Put and DeleteE return the
+// error of b, while the write in memory stays); with WriteBehind, through a
+// queue (see WriteBehindBuffer), which a goroutine drains in order. The
+// errors that cannot be returned go to OnBackingError.
+//
+// Expiration, evictions and bulk removals (Clear, DeleteByPrefix, ...) only
+// drop the entries from memory; other writes (Append, AddToSet, IncrWindow,
+// leases, ...) are not propagated.
+func WithBacking(b Backing, writePolicy WritePolicy) StoreOption {
+	return func(opt *storeOpt) {
+		opt.backing = b
+		opt.writePolicy = writePolicy
+	}
+}
+
+// WriteBehindBuffer sets the size of the WriteBehind queue. When it is full,
+// writes wait for room.
+func WriteBehindBuffer(n int) StoreOption {
+	return func(opt *storeOpt) {
+		opt.writeBehindBuffer = n
+	}
+}
+
+// OnBackingError sets the function that gets the errors of the Backing that
+// the calls cannot return: those of WriteBehind, and of Delete, ForceDelete
+// and Take. op is "store" or "remove".
+func OnBackingError(onBackingError func(op, k string, err error)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onBackingError = onBackingError
+	}
+}
+
+// startBacking starts the WriteBehind goroutine, on creation
+func (kv *Store) startBacking() {
+	if kv.backing == nil || kv.writePolicy != WriteBehind {
+		return
+	}
+	size := kv.writeBehindBuffer
+	if size <= 0 {
+		size = defaultWriteBehindBuffer
+	}
+	kv.writeBehind = make(chan backingWrite, size)
+	kv.writeBehindDone = make(chan struct{})
+	go kv.writeBehindLoop()
+}
+
+// stopBacking waits for the WriteBehind queue to drain, on Stop; later
+// writes go to the Backing directly
+func (kv *Store) stopBacking() {
+	if kv.writeBehind == nil {
+		return
+	}
+	kv.writeBehindMx.Lock()
+	kv.writeBehindClosed = true
+	close(kv.writeBehind)
+	kv.writeBehindMx.Unlock()
+	<-kv.writeBehindDone
+}
+
+func (kv *Store) writeBehindLoop() {
+	defer close(kv.writeBehindDone)
+	for w := range kv.writeBehind {
+		w := w
+		kv.spawnWait(func() {
+			if err := kv.writeBacking(w); err != nil {
+				kv.backingFailed(w.op(), w.key, err)
+			}
+		})
+	}
+}
+
+// backingWrite is a write to the Backing; remove is set for a removal
+type backingWrite struct {
+	key    string
+	value  interface{}
+	ttl    time.Duration
+	remove bool
+}
+
+func (w backingWrite) op() string {
+	if w.remove {
+		return "remove"
+	}
+	return "store"
+}
+
+// writeBacking writes w to the Backing
+func (kv *Store) writeBacking(w backingWrite) error {
+	err := try(func() error {
+		if w.remove {
+			return kv.backing.Remove(w.key)
+		}
+		return kv.backing.Store(w.key, w.value, w.ttl)
+	})
+	return errors.Wrapf(err, "backing %s", w.op())
+}
+
+// propagate sends w to the Backing, by the policy; for WriteBehind, it
+// returns nil once w is queued
+func (kv *Store) propagate(w backingWrite) error {
+	if kv.writeBehind == nil {
+		return kv.writeBacking(w)
+	}
+	kv.writeBehindMx.RLock()
+	defer kv.writeBehindMx.RUnlock()
+	if kv.writeBehindClosed {
+		return kv.writeBacking(w)
+	}
+	kv.writeBehind <- w
+	return nil
+}
+
+// backingStore stores the entry just put for k in the Backing, with its
+// remaining time
+func (kv *Store) backingStore(k string, v interface{}) error {
+	var ttl time.Duration
+	kv.mx.Lock()
+	if e, ok := kv.kv.get(k); ok && e.timeout != nil {
+		ttl = e.expiresAt.Sub(kv.now())
+		if ttl <= 0 {
+			ttl = time.Nanosecond
+		}
+	}
+	kv.mx.Unlock()
+	return kv.propagate(backingWrite{key: k, value: v, ttl: ttl})
+}
+
+// backingRemove removes k from the Backing, if there is one
+func (kv *Store) backingRemove(k string) error {
+	if kv.backing == nil {
+		return nil
+	}
+	return kv.propagate(backingWrite{key: k, remove: true})
+}
+
+// backingRemoved is backingRemove, for the calls that cannot return errors
+func (kv *Store) backingRemoved(k string) {
+	if err := kv.backingRemove(k); err != nil {
+		kv.backingFailed("remove", k, err)
+	}
+}
+
+func (kv *Store) backingFailed(op, k string, err error) {
+	if kv.onBackingError == nil {
+		return
+	}
+	try(func() error {
+		kv.onBackingError(op, k, err)
+		return nil
+	})
+}
+
+// load loads k from the Backing on a miss, and puts it, unless k was put
+// meanwhile. Concurrent loads of k share one refresh (see Refreshes).
+func (kv *Store) load(k string) (interface{}, error) {
+	return kv.refreshOnce(k, kv.loadAndPut)
+}
+
+func (kv *Store) loadAndPut(ctx context.Context, k string) (interface{}, error) {
+	v, ttl, ok, err := kv.loadContext(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	opt := kv.putOptions(nil)
+	if ttl > 0 {
+		opt.expiresAfter = ttl
+	}
+	opt.loaded = true
+	opt.cas = func(_ interface{}, found bool) bool { return !found }
+	if err := kv.putWith(k, v, opt, false); err != nil && errors.Cause(err) != ErrCASCond {
+		return nil, err
+	}
+	kv.mx.Lock()
+	kv.stats.BackingLoads++
+	kv.mx.Unlock()
+	return v, nil
+}
@@ -0,0 +1,239 @@
+package tinykv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBacking is a Backing that records the calls
+type fakeBacking struct {
+	mx      sync.Mutex
+	m       map[string]interface{}
+	ttls    map[string]time.Duration
+	calls   []string
+	loading chan struct{} // if set, Load waits for it
+	failing bool
+	slow    time.Duration
+}
+
+func newFakeBacking() *fakeBacking {
+	return &fakeBacking{m: make(map[string]interface{}), ttls: make(map[string]time.Duration)}
+}
+
+func (b *fakeBacking) Load(k string) (interface{}, time.Duration, bool, error) {
+	b.record("load " + k)
+	if b.loading != nil {
+		<-b.loading
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.failing {
+		return nil, 0, false, errors.New("down")
+	}
+	v, ok := b.m[k]
+	return v, b.ttls[k], ok, nil
+}
+
+func (b *fakeBacking) Store(k string, v interface{}, ttl time.Duration) error {
+	time.Sleep(b.slow)
+	b.record(fmt.Sprintf("store %s=%v", k, v))
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.failing {
+		return errors.New("down")
+	}
+	b.m[k] = v
+	b.ttls[k] = ttl
+	return nil
+}
+
+func (b *fakeBacking) Remove(k string) error {
+	time.Sleep(b.slow)
+	b.record("remove " + k)
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.failing {
+		return errors.New("down")
+	}
+	delete(b.m, k)
+	return nil
+}
+
+func (b *fakeBacking) record(call string) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.calls = append(b.calls, call)
+}
+
+func (b *fakeBacking) recorded() []string {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return append([]string(nil), b.calls...)
+}
+
+func TestBackingWriteThrough(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	b := newFakeBacking()
+	kv := NewStore(time.Hour, Clock(clock.Now), WithBacking(b, WriteThrough))
+	defer kv.Stop()
+	assert.Equal(WriteThrough, kv.Config().Backing)
+
+	assert.NoError(kv.Put("a", 1, ExpiresAfter(time.Minute)))
+	assert.NoError(kv.Put("b", 2))
+	assert.Equal(map[string]interface{}{"a": 1, "b": 2}, b.m)
+	assert.Equal(time.Minute, b.ttls["a"])
+	assert.Equal(time.Duration(0), b.ttls["b"])
+
+	kv.Delete("a")
+	assert.NoError(kv.DeleteE("b"))
+	assert.Equal(ErrNotFound, errors.Cause(kv.DeleteE("nothing")))
+	assert.Empty(b.m)
+	assert.Equal([]string{"store a=1", "store b=2", "remove a", "remove b", "remove nothing"}, b.recorded())
+
+	// the error of the Backing is returned, and the write in memory stays
+	b.failing = true
+	err := kv.Put("c", 3)
+	assert.EqualError(errors.Cause(err), "down")
+	v, _ := kv.Get("c")
+	assert.Equal(3, v)
+	assert.Error(kv.DeleteE("c"))
+}
+
+func TestBackingReadThrough(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	b := newFakeBacking()
+	b.m["a"], b.ttls["a"] = 1, time.Minute
+	b.m["b"] = 2
+	kv := NewStore(time.Hour, Clock(clock.Now), WithBacking(b, WriteThrough))
+	defer kv.Stop()
+
+	v, ok := kv.Get("a")
+	assert.True(ok)
+	assert.Equal(1, v)
+	meta, _ := kv.GetMeta("a")
+	assert.Equal(time.Minute, meta.Remaining)
+	v, _ = kv.Get("a") // from memory now
+	assert.Equal(1, v)
+	assert.Equal([]string{"load a"}, b.recorded())
+	assert.Equal(int64(1), kv.Stats().BackingLoads)
+
+	// loading is not a write to the Backing
+	v, _ = kv.Get("b")
+	assert.Equal(2, v)
+	meta, _ = kv.GetMeta("b")
+	assert.True(meta.ExpiresAt.IsZero())
+
+	_, err := kv.GetE("missing")
+	assert.Equal(ErrNotFound, errors.Cause(err))
+
+	// an expired entry is loaded again
+	clock.Advance(time.Minute * 2)
+	v, ok = kv.Get("a")
+	assert.True(ok)
+	assert.Equal(1, v)
+	assert.Equal([]string{"load a", "load b", "load missing", "load a"}, b.recorded())
+
+	b.failing = true
+	_, err = kv.GetE("c")
+	assert.EqualError(errors.Cause(err), "down")
+}
+
+func TestBackingSingleflight(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newFakeBacking()
+	b.m["a"] = 1
+	b.loading = make(chan struct{})
+	kv := NewStore(time.Hour, WithBacking(b, WriteThrough))
+	defer kv.Stop()
+
+	const n = 10
+	var wg sync.WaitGroup
+	values := make(chan interface{}, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _ := kv.Get("a")
+			values <- v
+		}()
+	}
+	for {
+		s := kv
+		s.mx.Lock()
+		loading := s.refreshes["a"] != nil
+		s.mx.Unlock()
+		if loading {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Millisecond * 10) // the others join
+	close(b.loading)
+	wg.Wait()
+	close(values)
+	for v := range values {
+		assert.Equal(1, v)
+	}
+	assert.Equal([]string{"load a"}, b.recorded())
+}
+
+func TestBackingWriteBehind(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newFakeBacking()
+	b.slow = time.Millisecond
+	var failed []string
+	kv := NewStore(time.Hour,
+		WithBacking(b, WriteBehind),
+		WriteBehindBuffer(4),
+		OnBackingError(func(op, k string, err error) { failed = append(failed, op+" "+k) }))
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		k := fmt.Sprint(i)
+		assert.NoError(kv.Put(k, i))
+		want = append(want, fmt.Sprintf("store %s=%d", k, i))
+	}
+	kv.Delete("0")
+	want = append(want, "remove 0")
+
+	// Stop drains the queue, in order
+	kv.Stop()
+	assert.Equal(want, b.recorded())
+	assert.Len(b.m, 19)
+	assert.Empty(failed)
+
+	// after Stop, writes go to the Backing directly, and the errors that
+	// cannot be returned go to OnBackingError
+	b.failing = true
+	assert.Error(kv.Put("x", 1))
+	kv.Delete("x")
+	assert.Equal([]string{"remove x"}, failed)
+}
+
+func TestBackingWriteBehindErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newFakeBacking()
+	b.failing = true
+	failed := make(chan string, 2)
+	kv := NewStore(time.Hour,
+		WithBacking(b, WriteBehind),
+		OnBackingError(func(op, k string, err error) { failed <- op + " " + k }))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("a", 1))
+	assert.NoError(kv.DeleteE("a"))
+	assert.Equal("store a", <-failed)
+	assert.Equal("remove a", <-failed)
+}
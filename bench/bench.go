@@ -0,0 +1,198 @@
+// Package bench runs a synthetic load against a tinykv store, to validate a
+// configuration against an expected workload. It only uses the KV interface
+// (and StatsProvider, if the KV has it), so it can also run against wrappers
+// of a store.
+package bench
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dc0d/tinykv"
+)
+
+// Profile describes a workload
+type Profile struct {
+	Goroutines int           // concurrent clients, at least 1
+	Keys       int           // key cardinality, at least 1
+	ReadRatio  float64       // fraction of operations that are Gets, in [0, 1]
+	MinTTL     time.Duration // TTLs of puts are uniform in [MinTTL, MaxTTL];
+	MaxTTL     time.Duration // zero MaxTTL means puts do not expire
+	Duration   time.Duration
+	Seed       int64
+}
+
+// Result is what RunLoadProfile measured
+type Result struct {
+	Elapsed     time.Duration
+	Operations  int64
+	Reads       int64
+	Hits        int64
+	Writes      int64
+	Throughput  float64 // operations per second
+	P50         time.Duration
+	P99         time.Duration
+	Expirations int64 // expired entries removed during the run, from Stats (if any)
+	PeakEntries int   // sampled every millisecond
+}
+
+// latencySamples is the size of the reservoir of latencies, per goroutine
+const latencySamples = 10000
+
+// RunLoadProfile runs p against kv, and reports the results. The keys are
+// "bench-0" to "bench-<Keys-1>".
+func RunLoadProfile(kv tinykv.KV, p Profile) Result {
+	if p.Goroutines < 1 {
+		p.Goroutines = 1
+	}
+	if p.Keys < 1 {
+		p.Keys = 1
+	}
+	keys := make([]string, p.Keys)
+	for i := range keys {
+		keys[i] = "bench-" + strconv.Itoa(i)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		stop    = make(chan struct{})
+		workers = make([]*worker, p.Goroutines)
+		peak    int
+	)
+	expirations := func() int64 { return 0 }
+	if stats, ok := kv.(tinykv.StatsProvider); ok {
+		expirations = func() int64 { return stats.Stats().ExpirationLagCount }
+	}
+	expirationsBefore := expirations()
+	start := time.Now()
+	for i := range workers {
+		w := &worker{
+			kv:   kv,
+			p:    p,
+			keys: keys,
+			rnd:  rand.New(rand.NewSource(p.Seed + int64(i))),
+		}
+		workers[i] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(stop)
+		}()
+	}
+
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			if n := kv.Len(); n > peak {
+				peak = n
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	<-time.After(p.Duration)
+	close(stop)
+	wg.Wait()
+	<-sampled
+	elapsed := time.Since(start)
+
+	res := Result{
+		Elapsed:     elapsed,
+		Expirations: expirations() - expirationsBefore,
+		PeakEntries: peak,
+	}
+	var latencies []time.Duration
+	for _, w := range workers {
+		res.Reads += w.reads
+		res.Hits += w.hits
+		res.Writes += w.writes
+		latencies = append(latencies, w.latencies...)
+	}
+	res.Operations = res.Reads + res.Writes
+	if elapsed > 0 {
+		res.Throughput = float64(res.Operations) / elapsed.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.P50 = percentile(latencies, 0.50)
+	res.P99 = percentile(latencies, 0.99)
+	return res
+}
+
+type worker struct {
+	kv   tinykv.KV
+	p    Profile
+	keys []string
+	rnd  *rand.Rand
+
+	reads, hits, writes int64
+	latencies           []time.Duration
+}
+
+func (w *worker) run(stop <-chan struct{}) {
+	for n := int64(0); ; n++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		k := w.keys[w.rnd.Intn(len(w.keys))]
+		read := w.rnd.Float64() < w.p.ReadRatio
+		var options []tinykv.PutOption
+		if !read && w.p.MaxTTL > 0 {
+			options = append(options, tinykv.ExpiresAfter(w.ttl()))
+		}
+
+		start := time.Now()
+		if read {
+			_, ok := w.kv.Get(k)
+			if ok {
+				w.hits++
+			}
+		} else {
+			w.kv.Put(k, n, options...)
+		}
+		w.record(n, time.Since(start))
+
+		if read {
+			w.reads++
+		} else {
+			w.writes++
+		}
+	}
+}
+
+func (w *worker) ttl() time.Duration {
+	if w.p.MaxTTL <= w.p.MinTTL {
+		return w.p.MaxTTL
+	}
+	return w.p.MinTTL + time.Duration(w.rnd.Int63n(int64(w.p.MaxTTL-w.p.MinTTL)))
+}
+
+// record keeps a uniform sample of the latencies (reservoir sampling)
+func (w *worker) record(n int64, d time.Duration) {
+	if len(w.latencies) < latencySamples {
+		w.latencies = append(w.latencies, d)
+		return
+	}
+	if i := w.rnd.Int63n(n + 1); i < latencySamples {
+		w.latencies[i] = d
+	}
+}
+
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q * float64(len(sorted)-1))
+	return sorted[i]
+}
@@ -0,0 +1,48 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dc0d/tinykv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunLoadProfile(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := tinykv.NewStore(time.Millisecond * 5)
+	defer kv.Stop()
+
+	res := RunLoadProfile(kv, Profile{
+		Goroutines: 4,
+		Keys:       100,
+		ReadRatio:  0.8,
+		MinTTL:     time.Millisecond,
+		MaxTTL:     time.Millisecond * 5,
+		Duration:   time.Millisecond * 100,
+		Seed:       1,
+	})
+
+	assert.True(res.Elapsed >= time.Millisecond*100, res.Elapsed)
+	assert.True(res.Operations > 0)
+	assert.Equal(res.Operations, res.Reads+res.Writes)
+	assert.True(res.Reads > res.Writes, res)
+	assert.True(res.Hits > 0 && res.Hits <= res.Reads, res)
+	assert.True(res.Throughput > 0)
+	assert.True(res.P50 > 0 && res.P50 <= res.P99, res)
+	assert.True(res.Expirations > 0, res)
+	assert.True(res.PeakEntries > 0 && res.PeakEntries <= 100, res)
+}
+
+func TestRunLoadProfileNoTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := tinykv.NewStore(time.Millisecond * 5)
+	defer kv.Stop()
+
+	res := RunLoadProfile(kv, Profile{Keys: 10, Duration: time.Millisecond * 20})
+	assert.Equal(int64(0), res.Reads)
+	assert.Equal(int64(0), res.Expirations)
+	assert.Equal(10, res.PeakEntries)
+}
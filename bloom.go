@@ -0,0 +1,113 @@
+package tinykv
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// bloom is a bloom filter, safe for concurrent reads (mayContain) while
+// being added to. Bits are only ever set; removal needs a rebuild.
+// The number of bits is a power of two, so a mask replaces the modulo.
+type bloom struct {
+	bits   []uint64
+	mask   uint64
+	hashes uint64
+}
+
+func newBloom(expectedEntries int, fpRate float64) *bloom {
+	if expectedEntries < 1 {
+		expectedEntries = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+	n := float64(expectedEntries)
+	m := uint64(math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	hashes := uint64(math.Round(float64(m) / n * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	size := uint64(64)
+	for size < m {
+		size <<= 1
+	}
+	return &bloom{
+		bits:   make([]uint64, size/64),
+		mask:   size - 1,
+		hashes: hashes,
+	}
+}
+
+func (b *bloom) add(k string) {
+	h1, h2 := bloomHash(k)
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) & b.mask
+		addr := &b.bits[bit/64]
+		mask := uint64(1) << (bit % 64)
+		for {
+			old := atomic.LoadUint64(addr)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(addr, old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+func (b *bloom) mayContain(k string) bool {
+	h1, h2 := bloomHash(k)
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) & b.mask
+		if atomic.LoadUint64(&b.bits[bit/64])&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash is FNV-1a, split in two for double hashing
+func bloomHash(k string) (uint64, uint64) {
+	h := fnv1a(k)
+	return h, (h >> 33) | 1
+}
+
+//-----------------------------------------------------------------------------
+
+func (kv *Store) filterMiss(k string) bool {
+	f, _ := kv.filter.Load().(*bloom)
+	if f == nil {
+		return false
+	}
+	return !f.mayContain(k)
+}
+
+// filterAdd must be called under the lock
+func (kv *Store) filterAdd(k string) {
+	f, _ := kv.filter.Load().(*bloom)
+	if f == nil {
+		return
+	}
+	f.add(k)
+}
+
+// filterRemoved must be called under the lock. After enough removals,
+// the filter gets rebuilt from the live keys, to get rid of stale bits.
+func (kv *Store) filterRemoved() {
+	if kv.missFilterEntries <= 0 {
+		return
+	}
+	kv.filterDirty++
+	if kv.filterDirty < kv.missFilterEntries/2+1 {
+		return
+	}
+	kv.filterDirty = 0
+	expected := kv.missFilterEntries
+	if kv.kv.len() > expected {
+		expected = kv.kv.len()
+	}
+	f := newBloom(expected, kv.missFilterFPRate)
+	kv.kv.each(func(k string, _ *entry) bool {
+		f.add(k)
+		return true
+	})
+	kv.filter.Store(f)
+}
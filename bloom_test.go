@@ -0,0 +1,82 @@
+package tinykv
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissFilterNoFalseNegatives(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(-1, MissFilter(100, 0.01))
+	defer kv.Stop()
+
+	_, ok := kv.Get("never")
+	assert.False(ok)
+
+	live := make(map[string]bool)
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 300; i++ {
+			k := strconv.Itoa(round*1000 + i)
+			kv.Put(k, i)
+			live[k] = true
+		}
+		// enough removals to force rebuilds
+		n := 0
+		for k := range live {
+			if n%3 == 0 {
+				kv.Delete(k)
+				delete(live, k)
+			}
+			n++
+		}
+		for k := range live {
+			_, ok := kv.Get(k)
+			assert.True(ok, k)
+		}
+	}
+
+	misses := 0
+	for i := 0; i < 1000; i++ {
+		if !kv.filterMiss("absent-" + strconv.Itoa(i)) {
+			misses++
+		}
+	}
+	assert.True(misses < 100, misses)
+}
+
+func benchmarkMissWorkload(b *testing.B, kv KV) {
+	for i := 0; i < 1000; i++ {
+		kv.Put(strconv.Itoa(i), i)
+	}
+	keys := make([]string, 20000)
+	for i := range keys {
+		if i%20 == 0 {
+			keys[i] = strconv.Itoa(i % 1000)
+			continue
+		}
+		keys[i] = "absent-" + strconv.Itoa(i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			kv.Get(keys[n%len(keys)])
+			n++
+		}
+	})
+}
+
+func BenchmarkGetMostlyMisses(b *testing.B) {
+	kv := New(-1)
+	defer kv.Stop()
+	benchmarkMissWorkload(b, kv)
+}
+
+func BenchmarkGetMostlyMissesFiltered(b *testing.B) {
+	kv := NewStore(-1, MissFilter(1000, 0.01))
+	defer kv.Stop()
+	benchmarkMissWorkload(b, kv)
+}
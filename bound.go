@@ -0,0 +1,152 @@
+package tinykv
+
+import (
+	"github.com/pkg/errors"
+)
+
+// evict reasons of entries put using BoundTo
+const (
+	EvictParentExpired EvictReason = "parent-expired"
+	EvictParentDeleted EvictReason = "parent-deleted"
+)
+
+// BoundTo bounds the lifetime of the entry to the one of parentKey in the
+// parent store: when that is deleted or expires, the entry is removed as a
+// bulk removal, and reported to OnEvict with the reason EvictParentDeleted or
+// EvictParentExpired. Its own timeout still applies. If parentKey is not in
+// parent, Put returns ErrNotFound. It is supported by Put and CAS. The store
+// watches each parent key (WatchPrefix) until it is removed, or the store is
+// stopped.
+func BoundTo(parent *Store, parentKey string) PutOption {
+	return func(opt *putOpt) {
+		opt.boundTo = &parentRef{kv: parent, key: parentKey}
+	}
+}
+
+type parentRef struct {
+	kv  *Store
+	key string
+}
+
+// binding is the set of entries bound to a parent key
+type binding struct {
+	ref    parentRef
+	keys   map[string]struct{}
+	done   bool // the parent key is gone
+	cancel CancelFunc
+}
+
+var errBindingDone = errors.New("binding done")
+
+// putBound puts an entry bound to a parent key
+func (kv *Store) putBound(k string, v interface{}, opt *putOpt, force bool) error {
+	for {
+		b, err := kv.bind(*opt.boundTo)
+		if err != nil {
+			return err
+		}
+		bound := *opt
+		bound.boundTo = nil
+		bound.binding = b
+		err = kv.putWith(k, v, &bound, force)
+		if err != errBindingDone {
+			return err
+		}
+	}
+}
+
+// bind returns the binding of ref, watching the parent key if it is new
+func (kv *Store) bind(ref parentRef) (*binding, error) {
+	kv.mx.Lock()
+	b := kv.bindings[ref]
+	if b != nil && !b.done {
+		kv.mx.Unlock()
+		return b, nil
+	}
+	b = &binding{ref: ref, keys: make(map[string]struct{})}
+	if kv.bindings == nil {
+		kv.bindings = make(map[parentRef]*binding)
+	}
+	kv.bindings[ref] = b
+	kv.mx.Unlock()
+
+	// outside the lock: the parent may be bound to this store too
+	events, cancel := ref.kv.WatchPrefix(ref.key)
+	kv.mx.Lock()
+	b.cancel = cancel
+	kv.mx.Unlock()
+	go kv.watchParent(b, events)
+	if _, ok := ref.kv.GetMeta(ref.key); !ok {
+		kv.removeBound(b, EvictParentDeleted)
+		return nil, errors.Wrapf(ErrNotFound, "parent key %q", ref.key)
+	}
+	return b, nil
+}
+
+func (kv *Store) watchParent(b *binding, events <-chan Event) {
+	for ev := range events {
+		if ev.Key != b.ref.key {
+			continue
+		}
+		switch ev.Type {
+		case EventExpire:
+			kv.removeBound(b, EvictParentExpired)
+		case EventDelete:
+			kv.removeBound(b, EvictParentDeleted)
+		}
+	}
+}
+
+// removeBound removes the entries bound to b, once its parent key is gone
+func (kv *Store) removeBound(b *binding, reason EvictReason) {
+	kv.mx.Lock()
+	if b.done {
+		kv.mx.Unlock()
+		return
+	}
+	b.done = true
+	if kv.bindings[b.ref] == b {
+		delete(kv.bindings, b.ref)
+	}
+	br := kv.newBulkRemoval(string(reason), true)
+	br.evicts = true
+	for k := range b.keys {
+		if e, ok := kv.kv.get(k); ok && e.bound == b {
+			br.remove(k, e)
+		}
+	}
+	kv.stats.Evictions += int64(br.count)
+	kv.done(br)
+	cancel := b.cancel
+	kv.mx.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if br.count > 0 {
+		kv.notifyBulkRemoval(br)
+		kv.notifyEvictions(br.removed, reason)
+	}
+}
+
+// unbind forgets that k is bound, when its entry is removed or replaced
+func (e *entry) unbind(k string) {
+	if e.bound != nil {
+		delete(e.bound.keys, k)
+	}
+}
+
+// unbindAll stops watching the parent keys, on Stop
+func (kv *Store) unbindAll() {
+	kv.mx.Lock()
+	var cancels []CancelFunc
+	for _, b := range kv.bindings {
+		if b.cancel != nil {
+			cancels = append(cancels, b.cancel)
+		}
+	}
+	kv.bindings = nil
+	kv.mx.Unlock()
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
@@ -0,0 +1,159 @@
+package tinykv
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type eviction struct {
+	key    string
+	reason EvictReason
+}
+
+func receiveEvictions(t *testing.T, ch <-chan eviction, n int) []eviction {
+	var got []eviction
+	for len(got) < n {
+		select {
+		case ev := <-ch:
+			got = append(got, ev)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("got %d evictions, want %d", len(got), n)
+		}
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].key < got[j].key })
+	return got
+}
+
+func newBoundStores(clock *fakeClock) (parent, child *Store, evicted chan eviction) {
+	evicted = make(chan eviction, 16)
+	parent = NewStore(time.Hour, Clock(clock.Now))
+	child = NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnEvict(func(k string, v interface{}, reason EvictReason) {
+			evicted <- eviction{k, reason}
+		}),
+		Debug())
+	return
+}
+
+func TestBoundToParentExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	parent, child, evicted := newBoundStores(clock)
+	defer parent.Stop()
+	defer child.Stop()
+
+	assert.NoError(parent.Put("session", 1, ExpiresAfter(time.Minute)))
+	assert.NoError(child.Put("cart", 2, BoundTo(parent, "session")))
+	assert.NoError(child.Put("prefs", 3, BoundTo(parent, "session"), ExpiresAfter(time.Hour)))
+	assert.NoError(child.Put("other", 4))
+
+	clock.Advance(time.Minute + time.Second)
+	parent.ExpireNow()
+
+	assert.Equal([]eviction{
+		{"cart", EvictParentExpired},
+		{"prefs", EvictParentExpired},
+	}, receiveEvictions(t, evicted, 2))
+	_, ok := child.Get("cart")
+	assert.False(ok)
+	_, ok = child.Get("prefs")
+	assert.False(ok)
+	_, ok = child.Get("other")
+	assert.True(ok)
+	assert.Equal(int64(2), child.Stats().Evictions)
+	assert.NoError(child.CheckInvariants())
+}
+
+func TestBoundToParentDeleted(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	parent, child, evicted := newBoundStores(clock)
+	defer parent.Stop()
+	defer child.Stop()
+
+	assert.NoError(parent.Put("session", 1))
+	assert.NoError(child.Put("cart", 2, BoundTo(parent, "session")))
+	// overwritten without BoundTo, so no longer bound
+	assert.NoError(child.Put("prefs", 3, BoundTo(parent, "session")))
+	assert.NoError(child.Put("prefs", 3))
+
+	parent.Delete("session")
+
+	assert.Equal([]eviction{{"cart", EvictParentDeleted}}, receiveEvictions(t, evicted, 1))
+	_, ok := child.Get("prefs")
+	assert.True(ok)
+
+	// once the parent key is back, entries can be bound to it again
+	assert.NoError(parent.Put("session", 5))
+	assert.NoError(child.Put("cart", 6, BoundTo(parent, "session")))
+	parent.Delete("session")
+	assert.Equal([]eviction{{"cart", EvictParentDeleted}}, receiveEvictions(t, evicted, 1))
+}
+
+func TestBoundToOwnTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	parent, child, evicted := newBoundStores(clock)
+	defer parent.Stop()
+	defer child.Stop()
+
+	assert.NoError(parent.Put("session", 1, ExpiresAfter(time.Hour)))
+	assert.NoError(child.Put("token", 2, BoundTo(parent, "session"), ExpiresAfter(time.Minute)))
+
+	clock.Advance(time.Minute + time.Second)
+	_, ok := child.Get("token")
+	assert.False(ok)
+	select {
+	case ev := <-evicted:
+		t.Fatalf("unexpected eviction %v", ev)
+	default:
+	}
+	assert.NoError(child.CheckInvariants())
+}
+
+func TestBoundToMissingParent(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	parent, child, _ := newBoundStores(clock)
+	defer parent.Stop()
+	defer child.Stop()
+
+	err := child.Put("cart", 1, BoundTo(parent, "session"))
+	assert.Equal(ErrNotFound, errors.Cause(err))
+	_, ok := child.Get("cart")
+	assert.False(ok)
+
+	err = child.Put("cart", 1, BoundTo(nil, "session"))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	_, err = child.Append("list", 1, BoundTo(parent, "session"))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	_, err = child.PutAfter("later", 1, time.Minute, BoundTo(parent, "session"))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+}
+
+func TestBoundToCAS(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	parent, child, evicted := newBoundStores(clock)
+	defer parent.Stop()
+	defer child.Stop()
+
+	assert.NoError(parent.Put("session", 1))
+	assert.NoError(child.Put("cart", 1))
+	err := child.CAS("cart", 2, func(v interface{}, found bool) bool { return found }, BoundTo(parent, "session"))
+	assert.NoError(err)
+
+	parent.Delete("session")
+	assert.Equal([]eviction{{"cart", EvictParentDeleted}}, receiveEvictions(t, evicted, 1))
+}
@@ -0,0 +1,208 @@
+package tinykv
+
+import (
+	"strings"
+)
+
+// OnBulkRemoval sets the function that gets one notification per bulk
+// removal call (Clear, DeleteByPrefix, DeleteWhere and shedding because of
+// memory pressure), with the number of entries removed and up to
+// bulkSampleKeys of their keys. Once it is set, bulk removals do not fire
+// per-key notifications (OnEvict), unless PerKeyBulkNotifications is set too.
+func OnBulkRemoval(onBulkRemoval func(op string, count int, sampleKeys []string)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onBulkRemoval = onBulkRemoval
+	}
+}
+
+// PerKeyBulkNotifications keeps the per-key notifications of bulk removals,
+// in addition to the OnBulkRemoval notification.
+func PerKeyBulkNotifications() StoreOption {
+	return func(opt *storeOpt) {
+		opt.perKeyBulkNotifications = true
+	}
+}
+
+// Clear deletes all entries, including read-only ones, and returns
+// the number of entries deleted. Protected entries are left in place, and
+// counted in Stats.ProtectedSkips.
+func (kv *Store) Clear() int {
+	kv.active()
+	kv.mx.Lock()
+	if b := kv.clearUnprotected(); b != nil {
+		kv.done(b)
+		kv.mx.Unlock()
+		kv.notifyBulkRemoval(b)
+		return b.count
+	}
+	b := kv.newBulkRemoval("clear", false)
+	b.count = kv.kv.len()
+	kv.kv.each(func(k string, _ *entry) bool {
+		if len(b.samples) == bulkSampleKeys {
+			return false
+		}
+		b.samples = append(b.samples, k)
+		return true
+	})
+	kv.clear()
+	kv.walClear()
+	kv.done(b)
+	kv.mx.Unlock()
+	kv.notifyBulkRemoval(b)
+	return b.count
+}
+
+func (kv *Store) clear() {
+	if kv.watchers != nil || kv.closeOnRemoval || kv.archiveSink != nil || kv.retainedKeys > 0 {
+		kv.kv.each(func(k string, e *entry) bool {
+			if e.refs > 0 {
+				kv.hold(k, e)
+				return true
+			}
+			kv.emitRemoved(k, e)
+			kv.archive(k, e)
+			kv.closeRemoved(k, e.value)
+			return true
+		})
+	}
+	var kept []*timeout // pending puts and expectations
+	for _, to := range kv.heap {
+		if !to.isEntry() && to.soft == nil && !to.stale {
+			kept = append(kept, to)
+			continue
+		}
+		to.stale = true
+	}
+	for _, b := range kv.bindings {
+		b.keys = make(map[string]struct{})
+	}
+	kv.kv = kv.newKeyMap(0)
+	kv.mapPeak = 0
+	kv.totalCost = 0
+	for _, q := range kv.quotaByPrefix {
+		q.entries, q.cost = 0, 0
+	}
+	for _, ix := range kv.uniqueByName {
+		ix.reset()
+	}
+	kv.audit.tracked = nil
+	kv.removalTokens = nil
+	kv.mapGen++
+	kv.heap = th{}
+	for _, to := range kept {
+		timeheapPush(&kv.heap, to)
+	}
+	kv.index.reset()
+	if f, _ := kv.filter.Load().(*bloom); f != nil {
+		kv.filterDirty = 0
+		kv.filter.Store(newBloom(kv.missFilterEntries, kv.missFilterFPRate))
+	}
+	kv.readReset()
+}
+
+// DeleteByPrefix deletes the entries with keys starting with prefix,
+// except read-only and protected ones, and returns the number of entries deleted
+func (kv *Store) DeleteByPrefix(prefix string) int {
+	kv.active()
+	kv.mx.Lock()
+	b := kv.newBulkRemoval("delete-by-prefix", false)
+	if kv.index != nil {
+		for _, k := range kv.index.view() {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			if e, _ := kv.kv.get(k); !e.readOnly && !kv.skipProtected(e) {
+				b.remove(k, e)
+			}
+		}
+	} else {
+		kv.kv.each(func(k string, e *entry) bool {
+			if strings.HasPrefix(k, prefix) && !e.readOnly && !kv.skipProtected(e) {
+				b.remove(k, e)
+			}
+			return true
+		})
+	}
+	kv.done(b)
+	kv.mx.Unlock()
+	kv.notifyBulkRemoval(b)
+	return b.count
+}
+
+// DeleteWhere deletes the entries for which fn returns true, except read-only
+// and protected ones, and returns the number of entries deleted. fn is called
+// under the lock, so it must not use the store.
+func (kv *Store) DeleteWhere(fn func(k string, v interface{}) bool) int {
+	kv.active()
+	kv.mx.Lock()
+	b := kv.newBulkRemoval("delete-where", false)
+	kv.kv.each(func(k string, e *entry) bool {
+		if !e.readOnly && fn(k, e.value) && !kv.skipProtected(e) {
+			b.remove(k, e)
+		}
+		return true
+	})
+	kv.done(b)
+	kv.mx.Unlock()
+	kv.notifyBulkRemoval(b)
+	return b.count
+}
+
+//-----------------------------------------------------------------------------
+
+const bulkSampleKeys = 10
+
+// bulkRemoval accounts for the entries removed by a bulk removal
+type bulkRemoval struct {
+	kv      *Store
+	op      string
+	count   int
+	samples []string
+	removed map[string]interface{} // only when per-key notifications are due
+	evicts  bool                   // the removals are evictions
+}
+
+func (kv *Store) newBulkRemoval(op string, perKey bool) *bulkRemoval {
+	b := &bulkRemoval{kv: kv, op: op}
+	if perKey && (kv.onBulkRemoval == nil || kv.perKeyBulkNotifications) {
+		b.removed = make(map[string]interface{})
+	}
+	return b
+}
+
+// remove removes the entry e of k, under the lock
+func (b *bulkRemoval) remove(k string, e *entry) {
+	b.kv.evicting = b.evicts
+	b.kv.remove(k)
+	b.kv.evicting = false
+	b.count++
+	if len(b.samples) < bulkSampleKeys {
+		b.samples = append(b.samples, k)
+	}
+	if b.removed != nil {
+		b.removed[k] = e.value
+	}
+}
+
+// done records the stats of b, under the lock
+func (kv *Store) done(b *bulkRemoval) {
+	kv.stats.BulkRemovals++
+	kv.stats.BulkRemoved += int64(b.count)
+}
+
+func (kv *Store) notifyBulkRemoval(b *bulkRemoval) {
+	if kv.onBulkRemoval == nil {
+		return
+	}
+	notify := func() {
+		try(func() error {
+			kv.onBulkRemoval(b.op, b.count, b.samples)
+			return nil
+		})
+	}
+	if kv.synchronousNotifications {
+		kv.deliverInline(notify)
+		return
+	}
+	kv.spawn(notify)
+}
@@ -0,0 +1,107 @@
+package tinykv
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bulkCall struct {
+	op      string
+	count   int
+	samples []string
+}
+
+func TestBulkRemoval(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		t.Run("indexed="+strconv.FormatBool(indexed), func(t *testing.T) {
+			assert := assert.New(t)
+
+			var calls []bulkCall
+			options := []StoreOption{
+				SynchronousNotifications(),
+				OnBulkRemoval(func(op string, count int, sampleKeys []string) {
+					calls = append(calls, bulkCall{op, count, sampleKeys})
+				}),
+				Debug(),
+			}
+			if indexed {
+				options = append(options, Indexed())
+			}
+			kv := NewStore(time.Hour, options...)
+			defer kv.Stop()
+
+			for i := 0; i < 20; i++ {
+				kv.Put("a:"+strconv.Itoa(i), i, ExpiresAfter(time.Minute))
+				kv.Put("b:"+strconv.Itoa(i), i)
+			}
+			kv.Put("a:readonly", 0, ReadOnly())
+
+			assert.Equal(20, kv.DeleteByPrefix("a:"))
+			assert.Len(kv.Prefix("a:"), 1)
+			assert.Equal(10, kv.DeleteWhere(func(k string, v interface{}) bool {
+				return v.(int)%2 == 0
+			}))
+			assert.NoError(kv.CheckInvariants())
+			assert.Equal(11, kv.Clear())
+			assert.Empty(kv.Keys())
+			assert.NoError(kv.CheckInvariants())
+
+			assert.Len(calls, 3)
+			assert.Equal("delete-by-prefix", calls[0].op)
+			assert.Equal(20, calls[0].count)
+			assert.Len(calls[0].samples, bulkSampleKeys)
+			for _, k := range calls[0].samples {
+				assert.True(strings.HasPrefix(k, "a:"))
+			}
+			assert.Equal("delete-where", calls[1].op)
+			assert.Equal(10, calls[1].count)
+			assert.Equal("clear", calls[2].op)
+			assert.Equal(11, calls[2].count)
+
+			stats := kv.Stats()
+			assert.Equal(int64(3), stats.BulkRemovals)
+			assert.Equal(int64(41), stats.BulkRemoved)
+		})
+	}
+}
+
+func TestBulkRemovalPerKey(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, perKey := range []bool{false, true} {
+		var (
+			bulk    []bulkCall
+			evicted []string
+		)
+		options := []StoreOption{
+			SynchronousNotifications(),
+			OnBulkRemoval(func(op string, count int, sampleKeys []string) {
+				bulk = append(bulk, bulkCall{op, count, sampleKeys})
+			}),
+			OnEvict(func(k string, v interface{}, reason EvictReason) {
+				evicted = append(evicted, k)
+			}),
+		}
+		if perKey {
+			options = append(options, PerKeyBulkNotifications())
+		}
+		kv := NewStore(time.Hour, options...)
+		for i := 0; i < 4; i++ {
+			kv.Put(strconv.Itoa(i), i)
+		}
+		kv.shed(0.5)
+		kv.Stop()
+
+		assert.Equal([]bulkCall{{"memory-pressure", 2, bulk[0].samples}}, bulk)
+		assert.Len(bulk[0].samples, 2)
+		if perKey {
+			assert.ElementsMatch(bulk[0].samples, evicted)
+		} else {
+			assert.Empty(evicted)
+		}
+	}
+}
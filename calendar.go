@@ -0,0 +1,80 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// Boundary is a calendar boundary, for ExpiresAtNext
+type Boundary int
+
+// boundaries
+const (
+	BoundaryMinute Boundary = iota + 1
+	BoundaryHour
+	BoundaryDay  // midnight
+	BoundaryWeek // midnight between Sunday and Monday
+)
+
+func (b Boundary) String() string {
+	switch b {
+	case BoundaryMinute:
+		return "minute"
+	case BoundaryHour:
+		return "hour"
+	case BoundaryDay:
+		return "day"
+	case BoundaryWeek:
+		return "week"
+	}
+	return "unknown"
+}
+
+// ExpiresAtNext entry will expire at the next boundary after the time of
+// the put (by the store clock), on the wall clock of loc. A day is from
+// midnight to midnight, so it can be 23 or 25 hours long, across a DST
+// change. It replaces ExpiresAt, and the other way around; for a sliding
+// entry, ExpiresAfter is still needed, as the duration it slides by.
+func ExpiresAtNext(boundary Boundary, loc *time.Location) PutOption {
+	return func(opt *putOpt) {
+		opt.boundary = boundary
+		opt.boundaryLoc = loc
+		opt.expiresAt = time.Time{}
+	}
+}
+
+// resolveBoundary turns the boundary of ExpiresAtNext into a deadline;
+// validate reports an invalid one
+func (kv *Store) resolveBoundary(opt *putOpt) {
+	if opt.boundary == 0 || !opt.boundary.valid() || opt.boundaryLoc == nil {
+		return
+	}
+	opt.expiresAt = nextBoundary(kv.now(), opt.boundary, opt.boundaryLoc)
+}
+
+func (b Boundary) valid() bool {
+	return b >= BoundaryMinute && b <= BoundaryWeek
+}
+
+// nextBoundary returns the first boundary after now, on the wall clock of loc
+func nextBoundary(now time.Time, b Boundary, loc *time.Location) time.Time {
+	t := now.In(loc)
+	switch b {
+	case BoundaryMinute, BoundaryHour:
+		// DST shifts are whole hours, so the local hour starts where it
+		// would if the offset at now held
+		step := time.Minute
+		if b == BoundaryHour {
+			step = time.Hour
+		}
+		_, offset := t.Zone()
+		shift := time.Duration(offset) * time.Second
+		return t.Add(shift).Truncate(step).Add(step).Add(-shift).In(loc)
+	case BoundaryWeek:
+		days := (8 - int(t.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		return time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,96 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // fixed locations, whatever the host has
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestNextBoundary(t *testing.T) {
+	assert := assert.New(t)
+
+	ny := mustLoadLocation(t, "America/New_York")
+	kolkata := mustLoadLocation(t, "Asia/Kolkata")
+	at := func(loc *time.Location, year int, month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, loc)
+	}
+	// in New York, 2021-03-14 02:00 EST jumps to 03:00 EDT (a 23 hour day),
+	// and 2021-11-07 02:00 EDT goes back to 01:00 EST (a 25 hour day)
+	springForward := at(time.UTC, 2021, 3, 14, 7, 0, 0)
+	fallBack := at(time.UTC, 2021, 11, 7, 6, 0, 0)
+
+	for _, c := range []struct {
+		name     string
+		now      time.Time
+		boundary Boundary
+		loc      *time.Location
+		after    time.Duration
+	}{
+		{"minute", at(ny, 2021, 3, 10, 12, 0, 30), BoundaryMinute, ny, time.Second * 30},
+		{"minute on it", at(ny, 2021, 3, 10, 12, 1, 0), BoundaryMinute, ny, time.Minute},
+		{"hour", at(ny, 2021, 3, 10, 12, 15, 0), BoundaryHour, ny, time.Minute * 45},
+		{"hour with a half hour offset", at(kolkata, 2021, 3, 10, 10, 15, 0), BoundaryHour, kolkata, time.Minute * 45},
+		{"hour before spring forward", springForward.Add(-time.Minute * 30), BoundaryHour, ny, time.Minute * 30},
+		{"hour before fall back", fallBack.Add(-time.Minute * 90), BoundaryHour, ny, time.Minute * 30},
+		{"hour in the repeated hour", fallBack.Add(-time.Minute * 30), BoundaryHour, ny, time.Minute * 30},
+		{"day", at(ny, 2021, 3, 13, 12, 0, 0), BoundaryDay, ny, time.Hour * 12},
+		{"day of spring forward", at(ny, 2021, 3, 14, 0, 30, 0), BoundaryDay, ny, time.Hour*22 + time.Minute*30},
+		{"day of fall back", at(ny, 2021, 11, 7, 0, 30, 0), BoundaryDay, ny, time.Hour*24 + time.Minute*30},
+		{"day at midnight", at(ny, 2021, 3, 10, 0, 0, 0), BoundaryDay, ny, time.Hour * 24},
+		{"day in another location", at(ny, 2021, 3, 10, 12, 0, 0), BoundaryDay, time.UTC, time.Hour * 7},
+		{"week", at(ny, 2021, 3, 10, 12, 0, 0), BoundaryWeek, ny, time.Hour * (4*24 + 12 - 1)},
+		{"week on sunday", at(ny, 2021, 3, 14, 12, 0, 0), BoundaryWeek, ny, time.Hour * 12},
+		{"week on monday", at(ny, 2021, 3, 15, 0, 0, 0), BoundaryWeek, ny, time.Hour * 24 * 7},
+	} {
+		next := nextBoundary(c.now, c.boundary, c.loc)
+		assert.Equal(c.after, next.Sub(c.now), "%s: %v -> %v", c.name, c.now, next)
+	}
+}
+
+func TestExpiresAtNext(t *testing.T) {
+	assert := assert.New(t)
+
+	ny := mustLoadLocation(t, "America/New_York")
+	clock := &fakeClock{now: time.Date(2021, 11, 7, 0, 30, 0, 0, ny)}
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("daily", 1, ExpiresAtNext(BoundaryDay, ny)))
+	meta, _ := kv.GetMeta("daily")
+	assert.True(meta.ExpiresAt.Equal(time.Date(2021, 11, 8, 0, 0, 0, 0, ny)))
+	assert.Equal(time.Hour*24+time.Minute*30, meta.Remaining)
+
+	clock.Advance(time.Hour * 24)
+	_, ok := kv.Get("daily")
+	assert.True(ok)
+	clock.Advance(time.Minute * 31)
+	kv.ExpireNow()
+	_, ok = kv.Get("daily")
+	assert.False(ok)
+
+	// the last of ExpiresAt and ExpiresAtNext wins
+	deadline := clock.Now().Add(time.Minute)
+	kv.Put("a", 1, ExpiresAtNext(BoundaryWeek, ny), ExpiresAt(deadline))
+	meta, _ = kv.GetMeta("a")
+	assert.True(meta.ExpiresAt.Equal(deadline))
+	kv.Put("b", 1, ExpiresAt(deadline), ExpiresAtNext(BoundaryHour, ny))
+	meta, _ = kv.GetMeta("b")
+	assert.Equal(time.Minute*59, meta.Remaining)
+
+	err := kv.Put("c", 1, ExpiresAtNext(BoundaryDay, nil))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	err = kv.Put("c", 1, ExpiresAtNext(Boundary(9), ny))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.NoError(kv.CheckInvariants())
+}
@@ -0,0 +1,165 @@
+package tinykv
+
+// EvictCapacity is the evict reason of the entries evicted by Put to make
+// room, under MaxEntries, MaxCost or a Quota
+const EvictCapacity EvictReason = "capacity"
+
+// MaxEntries limits the number of entries. When the store is full, Put (and
+// ForcePut and CAS) evicts entries to make room, those expiring soonest first,
+// then the ones without a timeout; read-only entries are never evicted. If
+// there is nothing to evict, ErrFull is returned. TryPut never evicts. Other
+// writes (Append, AddToSet, IncrWindow, leases, PutAfter activations and
+// ReplayWAL) are not limited. With Overflow, evicted entries are spilled
+// instead of dropped.
+func MaxEntries(n int) StoreOption {
+	return func(opt *storeOpt) {
+		opt.maxEntries = n
+	}
+}
+
+// MaxCost limits the total cost of the entries (see CostFunc), like
+// MaxEntries limits their number. The cost of each entry is computed on every
+// change, under the lock, so the cost function must be fast.
+func MaxCost(maxCost int64) StoreOption {
+	return func(opt *storeOpt) {
+		opt.maxCost = maxCost
+	}
+}
+
+// TryPut puts an entry like Put, but returns ErrFull instead of evicting
+// entries when that would go over MaxEntries or MaxCost. On ErrFull, the
+// store is left untouched.
+func (kv *Store) TryPut(k string, v interface{}, options ...PutOption) (err error) {
+	defer wrapOp(&err, "try-put", k)
+	kv.active()
+	opt := kv.putOptions(options)
+	opt.noEvict = true
+	return kv.putWith(k, v, opt, false)
+}
+
+// makeRoom evicts entries so v fits for k, under its Quota, MaxEntries and
+// MaxCost. If that is not possible, or evict is false, nothing is evicted and
+// ErrQuotaExceeded or ErrFull is returned.
+func (kv *Store) makeRoom(k string, v interface{}, evict bool) (*bulkRemoval, error) {
+	victims, err := kv.quotaVictims(k, v, evict)
+	if err != nil {
+		return nil, err
+	}
+	entries, cost := kv.excess(k, v)
+	for _, victim := range victims {
+		entries--
+		e, _ := kv.kv.get(victim)
+		cost -= e.cost
+	}
+	if entries > 0 || cost > 0 {
+		var more []string
+		if evict {
+			more = kv.victims(k, entries, cost, notIn(victims))
+		}
+		if more == nil {
+			return nil, ErrFull
+		}
+		victims = append(victims, more...)
+	}
+	if len(victims) == 0 {
+		return nil, nil
+	}
+	b := kv.newBulkRemoval("capacity", kv.onEvict != nil)
+	b.evicts = true
+	for _, victim := range victims {
+		e, _ := kv.kv.get(victim)
+		kv.spill(victim, e)
+		b.remove(victim, e)
+	}
+	kv.stats.Evictions += int64(b.count)
+	kv.done(b)
+	return b, nil
+}
+
+// excess returns the number of entries, and the cost, over the limits
+// if v was put for k
+func (kv *Store) excess(k string, v interface{}) (entries int, cost int64) {
+	old, ok := kv.kv.get(k)
+	if kv.maxEntries > 0 && !ok {
+		entries = kv.kv.len() + 1 - kv.maxEntries
+	}
+	if kv.maxCost > 0 {
+		cost = kv.totalCost + kv.cost(k, v) - kv.maxCost
+		if ok {
+			cost -= old.cost
+		}
+	}
+	return entries, cost
+}
+
+// victims picks the entries to evict, other than k and among those eligible,
+// to free entries and cost; it returns nil if there are not enough of them
+func (kv *Store) victims(k string, entries int, cost int64, eligible func(key string) bool) []string {
+	var victims []string
+	enough := func() bool { return entries <= 0 && cost <= 0 }
+	var popped []*timeout
+	for !enough() && len(kv.heap) > 0 {
+		to := timeheapPop(&kv.heap)
+		if to.stale {
+			continue
+		}
+		popped = append(popped, to)
+		e, _ := kv.kv.get(to.key)
+		if !to.isEntry() || to.key == k || e.readOnly || !eligible(to.key) { // k is not in the map yet
+			continue
+		}
+		victims = append(victims, to.key)
+		entries--
+		cost -= e.cost
+	}
+	for _, to := range popped {
+		timeheapPush(&kv.heap, to)
+	}
+	kv.kv.each(func(key string, e *entry) bool {
+		if enough() {
+			return false
+		}
+		if e.timeout != nil || e.readOnly || key == k || !eligible(key) {
+			return true
+		}
+		victims = append(victims, key)
+		entries--
+		cost -= e.cost
+		return true
+	})
+	if !enough() {
+		return nil
+	}
+	return victims
+}
+
+// notIn returns an eligible function for victims, that excludes keys
+func notIn(keys []string) func(key string) bool {
+	return func(key string) bool {
+		for _, k := range keys {
+			if k == key {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// account updates the total cost, and the quota of k, for a change of e,
+// which cost oldCost
+func (kv *Store) account(k string, e *entry, oldCost int64) {
+	if kv.maxCost <= 0 && len(kv.quotaByPrefix) == 0 {
+		return
+	}
+	e.cost = kv.cost(k, e.value)
+	kv.totalCost += e.cost - oldCost
+	kv.countQuota(k, 0, e.cost-oldCost)
+}
+
+func (kv *Store) notifyCapacityEvictions(b *bulkRemoval) {
+	if b == nil {
+		return
+	}
+	kv.notifyBulkRemoval(b)
+	kv.notifyEvictions(b.removed, EvictCapacity)
+}
@@ -0,0 +1,156 @@
+package tinykv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTryPutFull(t *testing.T) {
+	assert := assert.New(t)
+
+	var evicted []string
+	kv := NewStore(time.Hour,
+		MaxEntries(3),
+		SynchronousNotifications(),
+		OnEvict(func(k string, v interface{}, reason EvictReason) {
+			evicted = append(evicted, k)
+		}),
+		Debug())
+	defer kv.Stop()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(kv.TryPut(fmt.Sprintf("k%d", i), i))
+	}
+	assert.Equal(0, kv.Stats().RemainingEntries)
+
+	err := kv.TryPut("k3", 3)
+	assert.Equal(ErrFull, errors.Cause(err))
+	assert.Equal(3, kv.Stats().Entries)
+	assert.Equal(int64(1), kv.Stats().RejectedPuts)
+	assert.Empty(evicted)
+	for i := 0; i < 3; i++ {
+		_, ok := kv.Get(fmt.Sprintf("k%d", i))
+		assert.True(ok)
+	}
+
+	// replacing an entry takes no room
+	assert.NoError(kv.TryPut("k0", 10))
+
+	kv.Delete("k1")
+	assert.Equal(1, kv.Stats().RemainingEntries)
+	assert.NoError(kv.TryPut("k3", 3))
+	v, _ := kv.Get("k3")
+	assert.Equal(3, v)
+	assert.Empty(evicted)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestPutEvictsForCapacity(t *testing.T) {
+	assert := assert.New(t)
+
+	type eviction struct {
+		key    string
+		reason EvictReason
+	}
+	var evicted []eviction
+	kv := NewStore(time.Hour,
+		MaxEntries(3),
+		SynchronousNotifications(),
+		OnEvict(func(k string, v interface{}, reason EvictReason) {
+			evicted = append(evicted, eviction{k, reason})
+		}),
+		Debug())
+	defer kv.Stop()
+
+	kv.Put("permanent", 1)
+	kv.Put("later", 2, ExpiresAfter(time.Minute*2))
+	kv.Put("soon", 3, ExpiresAfter(time.Minute))
+
+	// the entry expiring soonest goes first
+	assert.NoError(kv.Put("new", 4))
+	assert.Equal([]eviction{{"soon", EvictCapacity}}, evicted)
+	assert.NoError(kv.Put("newer", 5))
+	assert.Equal(eviction{"later", EvictCapacity}, evicted[1])
+	assert.Equal(3, kv.Stats().Entries)
+	assert.Equal(int64(2), kv.Stats().Evictions)
+
+	// read-only entries are never evicted
+	kv.ForceDelete("permanent")
+	kv.ForceDelete("new")
+	kv.ForceDelete("newer")
+	for i := 0; i < 3; i++ {
+		kv.Put(fmt.Sprintf("ro%d", i), i, ReadOnly())
+	}
+	assert.Equal(ErrFull, errors.Cause(kv.Put("more", 1)))
+	assert.Equal(3, kv.Stats().Entries)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestMaxCost(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, MaxCost(10), Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("a", "xxxx")) // 5
+	assert.NoError(kv.Put("b", "xxxx")) // 5
+	assert.Equal(int64(0), kv.Stats().RemainingCost)
+	assert.Equal(-1, kv.Stats().RemainingEntries)
+
+	assert.Equal(ErrFull, errors.Cause(kv.TryPut("c", "x")))
+	// a smaller value for the same key fits
+	assert.NoError(kv.TryPut("a", "xx"))
+	assert.Equal(int64(2), kv.Stats().RemainingCost)
+
+	// too big to ever fit
+	assert.Equal(ErrFull, errors.Cause(kv.Put("big", "xxxxxxxxxxxxxxxxxx")))
+	_, ok := kv.Get("a")
+	assert.True(ok)
+
+	_, err := kv.Append("list", "x")
+	assert.NoError(err)
+	assert.NoError(kv.CheckInvariants())
+	kv.Clear()
+	assert.Equal(int64(10), kv.Stats().RemainingCost)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestTryPutCAS(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, MaxEntries(1), Debug())
+	defer kv.Stop()
+
+	kv.Put("a", 1)
+	err := kv.TryPut("b", 2, CAS(func(v interface{}, found bool) bool { return !found }))
+	assert.Equal(ErrFull, errors.Cause(err))
+	// a failed condition evicts nothing
+	err = kv.CAS("b", 2, func(v interface{}, found bool) bool { return found })
+	assert.Equal(ErrCASCond, errors.Cause(err))
+	_, ok := kv.Get("a")
+	assert.True(ok)
+	assert.NoError(kv.CAS("b", 2, func(v interface{}, found bool) bool { return !found }))
+	_, ok = kv.Get("a")
+	assert.False(ok)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestPutEvictsBeforeItsOwnTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), MaxEntries(1), Debug())
+	defer kv.Stop()
+
+	// the timeout of b is in the heap, before the one of a, while room
+	// is made for b
+	kv.Put("a", 1, ExpiresAfter(time.Hour))
+	assert.NoError(kv.Put("b", 2, ExpiresAfter(time.Minute)))
+	_, ok := kv.Get("a")
+	assert.False(ok)
+	assert.NoError(kv.CheckInvariants())
+}
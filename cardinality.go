@@ -0,0 +1,61 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// CardinalityAlarm makes the store count the keys it has not got yet (new
+// entries, not updates of existing ones) in windows of the given duration,
+// and call fn, once per window, when the count goes over newKeysThreshold.
+// fn gets the count at that point, and is called on its own goroutine, so it
+// can use the store. A window starts with the first new key after the last
+// one ended. It is an early warning for a runaway growth, see MaxEntries for
+// a hard limit. A window that is not positive, or a nil fn, turns it off.
+func CardinalityAlarm(window time.Duration, newKeysThreshold int, fn func(newKeys int)) StoreOption {
+	return func(opt *storeOpt) {
+		if window <= 0 || fn == nil {
+			return
+		}
+		opt.cardinalityWindow = window
+		opt.cardinalityThreshold = newKeysThreshold
+		opt.onCardinalityAlarm = fn
+	}
+}
+
+// cardinalityWindow counts the new keys of the current window; it stops
+// counting once the alarm went off, so it holds at most threshold+1 keys
+type cardinalityWindow struct {
+	start time.Time
+	keys  map[string]struct{}
+	fired bool
+}
+
+// countNewKey counts k, a key just added to the store, under the lock
+func (kv *Store) countNewKey(k string) {
+	if kv.onCardinalityAlarm == nil {
+		return
+	}
+	c := &kv.cardinality
+	now := kv.now()
+	if c.start.IsZero() || now.Sub(c.start) >= kv.cardinalityWindow {
+		c.start = now
+		c.keys = make(map[string]struct{})
+		c.fired = false
+	}
+	if c.fired {
+		return
+	}
+	c.keys[k] = struct{}{}
+	if len(c.keys) <= kv.cardinalityThreshold {
+		return
+	}
+	c.fired = true
+	c.keys = nil
+	newKeys, fn := kv.cardinalityThreshold+1, kv.onCardinalityAlarm
+	kv.spawnLocked(func() {
+		try(func() error {
+			fn(newKeys)
+			return nil
+		})
+	})
+}
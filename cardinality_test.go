@@ -0,0 +1,80 @@
+package tinykv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCardinalityAlarm(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	alarms := make(chan int, 10)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		CardinalityAlarm(time.Minute, 3, func(newKeys int) { alarms <- newKeys }))
+	defer kv.Stop()
+	assert.Equal(time.Minute, kv.Config().CardinalityWindow)
+	assert.Equal(3, kv.Config().CardinalityThreshold)
+
+	noAlarm := func() {
+		t.Helper()
+		select {
+		case n := <-alarms:
+			t.Fatalf("unexpected alarm: %d", n)
+		case <-time.After(time.Millisecond * 20):
+		}
+	}
+	alarm := func() int {
+		t.Helper()
+		select {
+		case n := <-alarms:
+			return n
+		case <-time.After(time.Second):
+			t.Fatal("no alarm")
+		}
+		return 0
+	}
+
+	// at the threshold: updates, and a key deleted and put again, do not
+	// count twice
+	kv.Put("a", 1)
+	kv.Put("b", 1)
+	kv.Put("a", 2)
+	kv.Delete("b")
+	kv.Put("b", 2)
+	kv.Put("c", 1)
+	noAlarm()
+
+	// over it, once per window
+	kv.Put("d", 1)
+	assert.Equal(4, alarm())
+	kv.Put("e", 1)
+	kv.Put("f", 1)
+	noAlarm()
+
+	// a new window counts from zero
+	clock.Advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		kv.Put(fmt.Sprint("w2-", i), 1)
+	}
+	noAlarm()
+	clock.Advance(time.Minute)
+	for i := 0; i < 4; i++ {
+		kv.Put(fmt.Sprint("w3-", i), 1)
+	}
+	assert.Equal(4, alarm())
+}
+
+func TestCardinalityAlarmOff(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, CardinalityAlarm(0, 1, func(int) { t.Fatal("alarm") }))
+	defer kv.Stop()
+	kv.Put("a", 1)
+	kv.Put("b", 1)
+	assert.Equal(time.Duration(0), kv.Config().CardinalityWindow)
+}
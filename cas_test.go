@@ -0,0 +1,110 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCASSemantics is the semantics table of CAS; each case runs with both
+// the CAS method and the (deprecated) CAS put option.
+func TestCASSemantics(t *testing.T) {
+	type state struct {
+		value     interface{} // nil: no entry
+		expiresIn time.Duration
+	}
+	cases := []struct {
+		name      string
+		existing  *state // nil: missing key
+		cond      bool
+		options   []PutOption
+		err       error
+		wantFound bool
+		want      state
+	}{
+		{"missing key, false", nil, false, nil, ErrCASCond, false, state{}},
+		{"missing key, true", nil, true, nil, nil, false, state{value: 2}},
+		{"missing key, true, ttl", nil, true, []PutOption{ExpiresAfter(time.Minute)}, nil, false, state{2, time.Minute}},
+		{"present, false", &state{1, time.Minute}, false, []PutOption{ExpiresAfter(time.Hour)}, ErrCASCond, true, state{1, time.Minute}},
+		{"present, true, keeps ttl", &state{1, time.Minute}, true, nil, nil, true, state{2, time.Minute}},
+		{"present, true, new ttl", &state{1, time.Minute}, true, []PutOption{ExpiresAfter(time.Hour)}, nil, true, state{2, time.Hour}},
+		{"present, true, KeepTTL", &state{1, time.Minute}, true, []PutOption{KeepTTL()}, nil, true, state{2, time.Minute}},
+		{"present, true, ResetTTL", &state{1, time.Minute}, true, []PutOption{ResetTTL()}, nil, true, state{value: 2}},
+		{"present, true, ResetTTL, new ttl", &state{1, time.Minute}, true, []PutOption{ResetTTL(), ExpiresAfter(time.Hour)}, nil, true, state{2, time.Hour}},
+		{"permanent, true, KeepTTL", &state{value: 1}, true, []PutOption{KeepTTL()}, nil, true, state{value: 2}},
+		{"KeepTTL and a new ttl", &state{1, time.Minute}, true, []PutOption{ExpiresAfter(time.Hour), KeepTTL()}, ErrInvalidOptions, false, state{1, time.Minute}},
+		{"both KeepTTL and ResetTTL", &state{value: 1}, true, []PutOption{KeepTTL(), ResetTTL()}, ErrInvalidOptions, false, state{value: 1}},
+	}
+	forms := map[string]func(kv KV, cond func(interface{}, bool) bool, options []PutOption) error{
+		"method": func(kv KV, cond func(interface{}, bool) bool, options []PutOption) error {
+			return kv.CAS("k", 2, cond, options...)
+		},
+		"option": func(kv KV, cond func(interface{}, bool) bool, options []PutOption) error {
+			return kv.Put("k", 2, append(options, CAS(cond))...)
+		},
+	}
+	for formName, form := range forms {
+		for _, c := range cases {
+			t.Run(formName+"/"+c.name, func(t *testing.T) {
+				assert := assert.New(t)
+
+				clock := newFakeClock()
+				kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+				defer kv.Stop()
+				if c.existing != nil {
+					var options []PutOption
+					if c.existing.expiresIn > 0 {
+						options = append(options, ExpiresAfter(c.existing.expiresIn))
+					}
+					kv.Put("k", c.existing.value, options...)
+				}
+
+				called, found := false, false
+				err := form(kv, func(old interface{}, ok bool) bool {
+					called, found = true, ok
+					return c.cond
+				}, c.options)
+				assert.Equal(c.err, errors.Cause(err))
+				assert.Equal(c.err != ErrInvalidOptions, called)
+				assert.Equal(c.wantFound, found)
+
+				v, ok := kv.Get("k")
+				assert.Equal(c.want.value != nil, ok)
+				assert.Equal(c.want.value, v)
+				if ok {
+					meta, _ := kv.GetMeta("k")
+					assert.Equal(c.want.expiresIn, meta.Remaining)
+				}
+				assert.NoError(kv.CheckInvariants())
+			})
+		}
+	}
+}
+
+func TestCASSliding(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	kv.Put("k", 1, ExpiresAfter(time.Minute), IsSliding(true))
+	clock.Advance(time.Second * 30)
+
+	// a failed CAS does not slide
+	assert.Equal(ErrCASCond, errors.Cause(kv.CAS("k", 2, func(interface{}, bool) bool { return false })))
+	meta, _ := kv.GetMeta("k")
+	assert.Equal(time.Second*30, meta.Remaining)
+
+	// a successful one, keeping the sliding timeout, slides
+	assert.NoError(kv.CAS("k", 2, func(interface{}, bool) bool { return true }))
+	meta, _ = kv.GetMeta("k")
+	assert.Equal(time.Minute, meta.Remaining)
+	assert.True(meta.IsSliding)
+
+	assert.Equal(ErrInvalidOptions, errors.Cause(kv.CAS("k", 3,
+		func(interface{}, bool) bool { return true },
+		CAS(func(interface{}, bool) bool { return true }))))
+}
@@ -0,0 +1,61 @@
+package tinykv
+
+import (
+	"hash/crc32"
+)
+
+// ChecksumValues makes the store keep a CRC32 checksum of []byte and string
+// values, computed on Put. Get and Take verify it and return ErrCorrupted
+// (and remove the entry) if the value has changed since, for example by a
+// caller mutating a shared slice. Other values are not checked.
+func ChecksumValues() StoreOption {
+	return func(opt *storeOpt) {
+		opt.checksumValues = true
+	}
+}
+
+// OnCorruption sets the function that gets called with the key of an entry
+// whose value failed the checksum verification (see ChecksumValues).
+func OnCorruption(onCorruption func(k string)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onCorruption = onCorruption
+	}
+}
+
+func checksum(v interface{}) (uint32, bool) {
+	switch x := v.(type) {
+	case []byte:
+		return crc32.ChecksumIEEE(x), true
+	case string:
+		return crc32.ChecksumIEEE([]byte(x)), true
+	}
+	return 0, false
+}
+
+func (e *entry) verify() bool {
+	if !e.hasChecksum {
+		return true
+	}
+	sum, _ := checksum(e.value)
+	return sum == e.checksum
+}
+
+// verify verifies the checksum of e and removes it if it is corrupted
+// (must be called under the lock)
+func (kv *Store) verify(k string, e *entry) bool {
+	if e.verify() {
+		return true
+	}
+	kv.remove(k)
+	return false
+}
+
+func (kv *Store) notifyCorruption(k string) {
+	if kv.onCorruption == nil {
+		return
+	}
+	try(func() error {
+		kv.onCorruption(k)
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package tinykv
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumValues(t *testing.T) {
+	assert := assert.New(t)
+
+	var corrupted []string
+	kv := NewStore(
+		-1,
+		ChecksumValues(),
+		OnCorruption(func(k string) { corrupted = append(corrupted, k) }))
+	defer kv.Stop()
+
+	shared := []byte("VALUE")
+	kv.Put("1", shared)
+	kv.Put("2", []byte("VALUE"))
+	kv.Put("3", "VALUE")
+	kv.Put("4", 4)
+
+	v, err := kv.GetE("1")
+	assert.NoError(err)
+	assert.Equal([]byte("VALUE"), v)
+
+	shared[0] = 'X'
+	_, err = kv.GetE("1")
+	assert.Equal(ErrCorrupted, errors.Cause(err))
+	assert.Equal([]string{"1"}, corrupted)
+	_, err = kv.GetE("1")
+	assert.Equal(ErrNotFound, errors.Cause(err))
+
+	raw, _ := kv.Get("2")
+	raw.([]byte)[0] = 'X'
+	_, err = kv.TakeE("2")
+	assert.Equal(ErrCorrupted, errors.Cause(err))
+	assert.Equal([]string{"1", "2"}, corrupted)
+
+	v, err = kv.TakeE("3")
+	assert.NoError(err)
+	assert.Equal("VALUE", v)
+	v, ok := kv.Get("4")
+	assert.True(ok)
+	assert.Equal(4, v)
+
+	value := []byte("VALUE")
+	kv.Put("5", []byte("OTHER"))
+	assert.NoError(kv.Put("5", value, CAS(func(interface{}, bool) bool { return true })))
+	v, err = kv.GetE("5")
+	assert.NoError(err)
+	assert.Equal(value, v)
+}
+
+func benchmarkGetBytes(b *testing.B, kv KV) {
+	kv.Put("1", make([]byte, 1024))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		kv.Get("1")
+	}
+}
+
+func BenchmarkGetBytes(b *testing.B) {
+	kv := New(-1)
+	defer kv.Stop()
+	benchmarkGetBytes(b, kv)
+}
+
+func BenchmarkGetBytesChecksum(b *testing.B) {
+	kv := NewStore(-1, ChecksumValues())
+	defer kv.Stop()
+	benchmarkGetBytes(b, kv)
+}
@@ -0,0 +1,54 @@
+package tinykv
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CoarseClock makes the store read the current time from a cached value,
+// updated every resolution by a background goroutine, instead of getting
+// the time on every access. Expiration (and sliding) may be off by up to
+// resolution. The expiration loop still uses the precise time for scheduling.
+func CoarseClock(resolution time.Duration) StoreOption {
+	return func(opt *storeOpt) {
+		opt.coarseClockResolution = resolution
+	}
+}
+
+// Now returns the current time on the clock of the store (see Clock and
+// CoarseClock), the time deadlines are set and checked against.
+func (kv *Store) Now() time.Time {
+	return kv.now()
+}
+
+type coarseClock struct {
+	now     int64 // unix nano
+	precise func() time.Time
+}
+
+func newCoarseClock(precise func() time.Time) *coarseClock {
+	c := &coarseClock{precise: precise}
+	c.update()
+	return c
+}
+
+func (c *coarseClock) Now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.now))
+}
+
+func (c *coarseClock) update() {
+	atomic.StoreInt64(&c.now, c.precise().UnixNano())
+}
+
+func (c *coarseClock) run(resolution time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(resolution)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.update()
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoarseClock(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), CoarseClock(time.Millisecond*5))
+	defer kv.Stop()
+	assert.Equal(time.Millisecond*5, kv.Config().CoarseClockResolution)
+
+	kv.Put("1", 1, ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+	<-time.After(time.Millisecond * 50)
+	_, ok := kv.Get("1")
+	assert.False(ok)
+}
+
+func TestNow(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+	assert.Equal(clock.Now(), kv.Now())
+
+	kv.Put("1", 1, ExpiresAfter(time.Second))
+	meta, _ := kv.GetMeta("1")
+	assert.Equal(kv.Now().Add(time.Second), meta.ExpiresAt)
+	clock.Advance(time.Minute)
+	assert.Equal(clock.Now(), kv.Now())
+}
+
+func TestCoarseClockPrecision(t *testing.T) {
+	assert := assert.New(t)
+
+	const (
+		resolution = time.Millisecond * 10
+		ttl        = time.Millisecond * 50
+	)
+	kv := NewStore(time.Hour, CoarseClock(resolution))
+	defer kv.Stop()
+
+	putAt := time.Now()
+	kv.Put("1", 1, ExpiresAfter(ttl))
+	for {
+		if _, ok := kv.Get("1"); !ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	elapsed := time.Since(putAt)
+	assert.True(elapsed >= ttl-resolution, elapsed)
+	assert.True(elapsed <= ttl+resolution+time.Millisecond*20, elapsed)
+}
+
+func BenchmarkGetSlidingTimeoutCoarseClock(b *testing.B) {
+	rg := NewStore(-1, CoarseClock(time.Millisecond))
+	defer rg.Stop()
+	rg.Put("1", 1, ExpiresAfter(time.Second*10), IsSliding(true))
+	for n := 0; n < b.N; n++ {
+		rg.Get("1")
+	}
+}
@@ -0,0 +1,108 @@
+package tinykv
+
+import (
+	"io"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// CloseOnRemoval makes the store close the values that implement io.Closer
+// when their entries are removed: by expiration (after the expiration
+// notifications), Delete, DeleteE, ForceDelete, bulk removals (Clear,
+// DeleteByPrefix, evictions, ...), or a put of another value for the key.
+// Take, TakeE, PopSoonest and PopLatest do not close the value, as it goes to
+// the caller; nor does Stop, for the entries left in the store. Close is
+// called on the dispatcher goroutine (the one of the watches), and its
+// errors go to OnCloseError. A value put under two keys is closed by the
+// removal of either one.
+func CloseOnRemoval() StoreOption {
+	return func(opt *storeOpt) {
+		opt.closeOnRemoval = true
+	}
+}
+
+// OnCloseError sets the function that gets the errors of Close, under
+// CloseOnRemoval
+func OnCloseError(onCloseError func(k string, err error)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onCloseError = onCloseError
+	}
+}
+
+// closingValue is a removed value, waiting to be closed
+type closingValue struct {
+	key    string
+	closer io.Closer
+}
+
+// closeRemoved queues v to be closed, if it is an io.Closer, it must be called
+// under the lock
+func (kv *Store) closeRemoved(k string, v interface{}) {
+	if !kv.closeOnRemoval {
+		return
+	}
+	c, ok := v.(io.Closer)
+	if !ok {
+		return
+	}
+	kv.closing = append(kv.closing, closingValue{key: k, closer: c})
+	if kv.closingStopped {
+		closing := kv.closing
+		kv.closing = nil
+		kv.spawnLocked(func() { kv.closeAll(closing) })
+		return
+	}
+	select {
+	case kv.eventsReady <- struct{}{}:
+	default:
+	}
+}
+
+// closeReplaced queues old to be closed, unless it is v, put again
+func (kv *Store) closeReplaced(k string, old, v interface{}) {
+	if sameValue(old, v) {
+		return
+	}
+	kv.closeRemoved(k, old)
+}
+
+// closeQueued closes the queued values, on the dispatcher goroutine; once
+// stopped, the later ones are closed by goroutines of their own
+func (kv *Store) closeQueued(stopped bool) {
+	kv.mx.Lock()
+	closing := kv.closing
+	kv.closing = nil
+	if stopped {
+		kv.closingStopped = true
+	}
+	kv.mx.Unlock()
+	if len(closing) > 0 {
+		kv.spawnWait(func() { kv.closeAll(closing) })
+	}
+}
+
+func (kv *Store) closeAll(closing []closingValue) {
+	for _, c := range closing {
+		c := c
+		err := try(c.closer.Close)
+		if err == nil || kv.onCloseError == nil {
+			continue
+		}
+		err = errors.Wrap(err, "close")
+		try(func() error {
+			kv.onCloseError(c.key, err)
+			return nil
+		})
+	}
+}
+
+// sameValue reports if a and b are the same value, without panicking on
+// values that are not comparable
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
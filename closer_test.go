@@ -0,0 +1,145 @@
+package tinykv
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCloser sends its name to closed, on each Close
+type fakeCloser struct {
+	name   string
+	closed chan<- string
+	err    error
+}
+
+func (c *fakeCloser) Close() error {
+	c.closed <- c.name
+	return c.err
+}
+
+// receiveClosed returns the names of the next n values closed, sorted
+func receiveClosed(t *testing.T, closed <-chan string, n int) []string {
+	var names []string
+	for len(names) < n {
+		select {
+		case name := <-closed:
+			names = append(names, name)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d closed of %d: %v", len(names), n, names)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCloseOnRemoval(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	closed := make(chan string, 100)
+	var expired []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		CloseOnRemoval(),
+		MaxEntries(10),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) { expired = append(expired, k) }),
+		Debug())
+	defer kv.Stop()
+	assert.True(kv.Config().CloseOnRemoval)
+	closer := func(name string) *fakeCloser { return &fakeCloser{name: name, closed: closed} }
+
+	// delete, and a put of another value
+	kv.Put("deleted", closer("deleted"))
+	kv.Put("deletedE", closer("deletedE"))
+	kv.Put("forced", closer("forced"), ReadOnly())
+	kv.Put("replaced", closer("replaced"))
+	kv.Put("swapped", closer("swapped"))
+	same := closer("same")
+	kv.Put("same", same)
+	kv.Delete("deleted")
+	assert.NoError(kv.DeleteE("deletedE"))
+	kv.ForceDelete("forced")
+	kv.Put("replaced", 1)
+	assert.NoError(kv.CAS("swapped", 2, func(interface{}, bool) bool { return true }))
+	kv.Put("same", same) // the same value, put again, stays open
+	assert.Equal([]string{"deleted", "deletedE", "forced", "replaced", "swapped"}, receiveClosed(t, closed, 5))
+
+	// expiration, by a sweep and by a Get, after the notifications
+	kv.Put("swept", closer("swept"), ExpiresAfter(time.Second))
+	kv.Put("read", closer("read"), ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+	_, ok := kv.Get("read")
+	assert.False(ok)
+	kv.ExpireNow()
+	assert.Equal([]string{"read", "swept"}, receiveClosed(t, closed, 2))
+	assert.Equal([]string{"read", "swept"}, expired)
+
+	// bulk removals
+	kv.Put("p/1", closer("p/1"))
+	kv.Put("p/2", closer("p/2"))
+	assert.Equal(2, kv.DeleteByPrefix("p/"))
+	assert.Equal([]string{"p/1", "p/2"}, receiveClosed(t, closed, 2))
+	kv.Put("cleared", closer("cleared"))
+	kv.Clear()
+	assert.Equal([]string{"cleared", "same"}, receiveClosed(t, closed, 2))
+
+	// eviction for capacity
+	for _, k := range []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"} {
+		kv.Put(k, closer(k))
+	}
+	kv.Put("10", 10)
+	assert.Len(receiveClosed(t, closed, 1), 1)
+	kv.Clear()
+	receiveClosed(t, closed, 9)
+
+	// the values that go to the caller stay open; the closes are queued in
+	// order, so the close of last would come after theirs
+	kv.Put("taken", closer("taken"))
+	kv.Put("popped", closer("popped"), ExpiresAfter(time.Hour))
+	_, ok = kv.Take("taken")
+	assert.True(ok)
+	k, _, ok := kv.PopSoonest()
+	assert.True(ok)
+	assert.Equal("popped", k)
+	kv.Put("last", closer("last"))
+	kv.Delete("last")
+	assert.Equal([]string{"last"}, receiveClosed(t, closed, 1))
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestCloseOnRemovalErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	closed := make(chan string, 10)
+	failed := make(chan error, 10)
+	kv := NewStore(time.Hour,
+		CloseOnRemoval(),
+		OnCloseError(func(k string, err error) { failed <- errors.Wrap(err, k) }))
+	assert.True(kv.Config().OnCloseError)
+
+	kv.Put("a", &fakeCloser{name: "a", closed: closed, err: errors.New("broken")})
+	kv.Put("b", []int{1}) // not a Closer, nor comparable
+	kv.Put("b", []int{2})
+	kv.Delete("a")
+	assert.EqualError(<-failed, "a: close: broken")
+
+	// after Stop, removals still close
+	kv.Stop()
+	kv.Put("c", &fakeCloser{name: "c", closed: closed})
+	kv.Delete("c")
+	assert.Equal([]string{"a", "c"}, receiveClosed(t, closed, 2))
+
+	// without CloseOnRemoval, nothing is closed
+	plain := NewStore(time.Hour)
+	defer plain.Stop()
+	plain.Put("d", &fakeCloser{name: "d", closed: closed})
+	plain.Delete("d")
+	plain.Put("e", &fakeCloser{name: "e", closed: closed})
+	plain.Clear()
+	assert.Len(closed, 0)
+}
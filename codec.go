@@ -0,0 +1,7 @@
+package tinykv
+
+// ValueCodec converts values to and from bytes, for persisting a store
+type ValueCodec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
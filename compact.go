@@ -0,0 +1,94 @@
+package tinykv
+
+const (
+	compactChunk    = 4096
+	compactMinPeak  = 4 * compactChunk
+	compactFraction = 4 // compact when the entries drop under 1/compactFraction of the peak
+)
+
+// Compact shrinks the internal structures, after a large drain: the map is
+// rebuilt into a new one, sized for the live entries, copying compactChunk
+// entries at a time under the lock; and the timeout heap is rebuilt without
+// its stale nodes. The janitor calls it after a sweep, when the entries
+// drop under 1/compactFraction of their peak.
+func (kv *Store) Compact() {
+	kv.mx.Lock()
+	if kv.compacting != nil { // already running
+		kv.mx.Unlock()
+		return
+	}
+	kv.compacting = make(map[string]struct{})
+	old, gen := kv.kv, kv.mapGen
+	keys := make([]string, 0, old.len())
+	old.each(func(k string, _ *entry) bool {
+		keys = append(keys, k)
+		return true
+	})
+	kv.mx.Unlock()
+
+	fresh := kv.newKeyMap(len(keys))
+	for len(keys) > 0 {
+		chunk := keys
+		if len(chunk) > compactChunk {
+			chunk = chunk[:compactChunk]
+		}
+		keys = keys[len(chunk):]
+		kv.mx.Lock()
+		for _, k := range chunk {
+			if e, ok := old.get(k); ok {
+				fresh.set(k, e)
+			}
+		}
+		kv.mx.Unlock()
+	}
+
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if kv.mapGen != gen { // cleared meanwhile
+		kv.compacting = nil
+		return
+	}
+	for k := range kv.compacting {
+		if e, ok := old.get(k); ok {
+			fresh.set(k, e)
+		} else {
+			fresh.delete(k)
+		}
+	}
+	kv.compacting = nil
+	kv.kv = fresh
+	kv.mapPeak = fresh.len()
+	kv.mapGen++
+
+	n := 0
+	for _, to := range kv.heap {
+		if !to.stale {
+			n++
+		}
+	}
+	live := make(th, 0, n)
+	for _, to := range kv.heap {
+		if !to.stale {
+			live = append(live, to)
+		}
+	}
+	for i, to := range live {
+		to.index = i
+	}
+	timeheapInit(&live)
+	kv.heap = live
+	kv.stats.Compactions++
+}
+
+// changed records that k changed, for a running Compact
+func (kv *Store) changed(k string) {
+	if kv.compacting != nil {
+		kv.compacting[k] = struct{}{}
+	}
+}
+
+func (kv *Store) shouldCompact() bool {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	return kv.mapPeak >= compactMinPeak && kv.kv.len() < kv.mapPeak/compactFraction
+}
@@ -0,0 +1,105 @@
+package tinykv
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func TestCompact(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	const n = 200000
+	for i := 0; i < n; i++ {
+		kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Hour))
+	}
+	for i := 100; i < n; i++ {
+		kv.Delete(strconv.Itoa(i))
+	}
+	stats := kv.Stats()
+	assert.Equal(100, stats.Entries)
+	assert.Equal(n, stats.MapPeak)
+	assert.Equal(n, stats.HeapLen)
+
+	before := heapInUse()
+	kv.Compact()
+	after := heapInUse()
+	assert.True(after < before/2, "before: %d, after: %d", before, after)
+
+	stats = kv.Stats()
+	assert.Equal(100, stats.Entries)
+	assert.Equal(100, stats.MapPeak)
+	assert.Equal(100, stats.HeapLen)
+	assert.Equal(100, stats.HeapCap)
+	assert.Equal(int64(1), stats.Compactions)
+	assert.NoError(kv.CheckInvariants())
+	for i := 0; i < 100; i++ {
+		v, ok := kv.Get(strconv.Itoa(i))
+		assert.True(ok)
+		assert.Equal(i, v)
+	}
+}
+
+func TestCompactConcurrentChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	for i := 0; i < compactChunk*3; i++ {
+		kv.Put(strconv.Itoa(i), i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < compactChunk*3; i += 2 {
+			kv.Delete(strconv.Itoa(i))
+			kv.Put("new"+strconv.Itoa(i), i)
+		}
+	}()
+	kv.Compact()
+	<-done
+	kv.Compact()
+
+	assert.Equal(compactChunk*3, kv.Stats().Entries)
+	for i := 0; i < compactChunk*3; i++ {
+		_, ok := kv.Get(strconv.Itoa(i))
+		assert.Equal(i%2 == 1, ok)
+	}
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestAutoCompact(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Millisecond*5, Clock(clock.Now))
+	defer kv.Stop()
+
+	for i := 0; i < compactMinPeak; i++ {
+		kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Second*time.Duration(1+i%2)))
+	}
+	clock.Advance(time.Second + time.Millisecond)
+	kv.ExpireNow()
+	assert.False(kv.shouldCompact())
+	clock.Advance(time.Second)
+	for i := 0; i < 100 && kv.Stats().Compactions == 0; i++ {
+		time.Sleep(time.Millisecond * 5)
+	}
+	stats := kv.Stats()
+	assert.Equal(int64(1), stats.Compactions)
+	assert.Equal(0, stats.MapPeak)
+}
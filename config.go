@@ -0,0 +1,186 @@
+package tinykv
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config is the effective configuration of a store. It is a copy,
+// changing it has no effect on the store.
+type Config struct {
+	ExpirationInterval       time.Duration
+	OnExpire                 bool
+	OnExpireBatch            bool
+	OnExpireDetailed         bool
+	OnExpireWithOrigin       bool
+	SynchronousNotifications bool
+	DefaultSliding           bool
+	CustomClock              bool
+	CoarseClockResolution    time.Duration
+	MissFilterEntries        int
+	MissFilterFPRate         float64
+	Debug                    bool
+	Indexed                  bool
+	CostFunc                 bool
+	ChecksumValues           bool
+	OnCorruption             bool
+	WAL                      bool
+	WALMaxBytes              int64
+	OnEvict                  bool
+	OnBulkRemoval            bool
+	PerKeyBulkNotifications  bool
+	CopyValues               bool
+	MemoryCheckEvery         time.Duration
+	MemoryHighWatermark      uint64
+	MemoryShedFraction       float64
+	JanitorRunning           bool
+	ExpirationPaused         bool
+	Name                     string
+	Registered               bool
+	ReadOptimized            bool
+	OnPanic                  bool
+	TrackHotKeys             int
+	MaxEntries               int
+	MaxCost                  int64
+	MinTTL                   time.Duration
+	RejectShortTTLs          bool
+	Overflow                 bool
+	DropSlowExpiredStream    bool
+	StreamAndCallbacks       bool
+	CardinalityWindow        time.Duration
+	CardinalityThreshold     int
+	Backing                  WritePolicy // 0 without WithBacking
+	SortedIteration          bool
+	OnSweep                  bool
+	Profiles                 []string // the names of DefineProfile, sorted
+	CloseOnRemoval           bool
+	OnCloseError             bool
+	Quotas                   []string // the prefixes of the quotas, sorted
+	OverQuota                QuotaPolicy
+	ArchiveRemovals          []RemovalReason // nil without ArchiveOnExpire
+	OnSoftExpire             bool
+	OnExpireE                bool
+	NotifyAttempts           int // 0 without OnExpireE
+	NotifyBackoff            time.Duration
+	OnNotifyDeadLetter       bool
+	MaxRetainAge             time.Duration
+	UniqueIndexes            []string // the names of the unique indexes, sorted
+	UniqueConflict           UniquePolicy
+	IdleFor                  time.Duration // 0 without OnIdle
+	TTLAuditRate             float64       // 0 without TTLAudit
+	TakeHandoff              HandoffPolicy
+	ScrubEvery               time.Duration // 0 without ScrubPolicy
+	Executor                 bool
+	RemovalConfirmWindow     time.Duration // 0 by default, for one minute
+	SuspendBacklog           int           // 0 by default, for 10000
+	ManualJanitor            bool
+	KeyMap                   bool // false for the built-in Go map
+	WriteRateWindow          time.Duration
+	WriteRateThreshold       int
+	Strict                   bool
+	StringValues             bool
+	MigrateOnRead            bool
+	ExpirationLagBudget      time.Duration // 0 without OnExpirationLag
+	ExpirationLagBacklog     int
+	OverwriteWindow          time.Duration
+}
+
+// Config returns the effective configuration of the store
+func (kv *Store) Config() Config {
+	janitorRunning := true
+	select {
+	case <-kv.stop:
+		janitorRunning = false
+	default:
+	}
+	kv.mx.Lock()
+	paused := kv.paused
+	profiles := kv.profileNames()
+	quotas := kv.quotaPrefixes()
+	kv.mx.Unlock()
+	registered, _ := Lookup(kv.name)
+	return Config{
+		ExpirationInterval:       kv.getExpirationInterval(),
+		OnExpire:                 kv.onExpire != nil,
+		OnExpireBatch:            kv.onExpireBatch != nil,
+		OnExpireDetailed:         kv.onExpireDetailed != nil,
+		OnExpireWithOrigin:       kv.onExpireWithOrigin != nil,
+		SynchronousNotifications: kv.synchronousNotifications,
+		DefaultSliding:           kv.defaultSliding,
+		CustomClock:              kv.customClock,
+		CoarseClockResolution:    kv.coarseClockResolution,
+		MissFilterEntries:        kv.missFilterEntries,
+		MissFilterFPRate:         kv.missFilterFPRate,
+		Debug:                    kv.debug,
+		Indexed:                  kv.indexed,
+		CostFunc:                 kv.costFunc != nil,
+		ChecksumValues:           kv.checksumValues,
+		OnCorruption:             kv.onCorruption != nil,
+		WAL:                      kv.walCodec != nil,
+		WALMaxBytes:              kv.walMaxBytes,
+		OnEvict:                  kv.onEvict != nil,
+		OnBulkRemoval:            kv.onBulkRemoval != nil,
+		PerKeyBulkNotifications:  kv.perKeyBulkNotifications,
+		CopyValues:               kv.copyValues != nil,
+		MemoryCheckEvery:         kv.memoryCheckEvery,
+		MemoryHighWatermark:      kv.memoryHighWatermark,
+		MemoryShedFraction:       kv.memoryShedFraction,
+		JanitorRunning:           janitorRunning,
+		ExpirationPaused:         paused,
+		Name:                     kv.name,
+		Registered:               kv.name != "" && registered == KV(kv),
+		ReadOptimized:            kv.readOptimized,
+		OnPanic:                  kv.onPanic != nil,
+		TrackHotKeys:             kv.hotKeysK,
+		MaxEntries:               kv.maxEntries,
+		MaxCost:                  kv.maxCost,
+		MinTTL:                   kv.minTTL,
+		RejectShortTTLs:          kv.rejectShortTTLs,
+		Overflow:                 kv.overflow != nil,
+		DropSlowExpiredStream:    kv.dropSlowExpiredStream,
+		StreamAndCallbacks:       kv.streamAndCallbacks,
+		CardinalityWindow:        kv.cardinalityWindow,
+		CardinalityThreshold:     kv.cardinalityThreshold,
+		Backing:                  kv.writePolicy,
+		SortedIteration:          kv.sortedIteration,
+		OnSweep:                  kv.onSweep != nil,
+		Profiles:                 profiles,
+		CloseOnRemoval:           kv.closeOnRemoval,
+		OnCloseError:             kv.onCloseError != nil,
+		Quotas:                   quotas,
+		OverQuota:                kv.quotaPolicy,
+		ArchiveRemovals:          append([]RemovalReason(nil), kv.archiveReasons...),
+		OnSoftExpire:             kv.onSoftExpire != nil,
+		OnExpireE:                kv.onExpireE != nil,
+		NotifyAttempts:           kv.notifyAttempts,
+		NotifyBackoff:            kv.notifyBackoff,
+		OnNotifyDeadLetter:       kv.onNotifyDeadLetter != nil,
+		MaxRetainAge:             kv.maxRetainAge,
+		UniqueIndexes:            kv.uniqueNames(),
+		UniqueConflict:           kv.uniquePolicy,
+		IdleFor:                  kv.idleFor,
+		TTLAuditRate:             kv.auditRate,
+		TakeHandoff:              kv.takeHandoff,
+		ScrubEvery:               kv.scrubEvery,
+		Executor:                 kv.executor != nil,
+		RemovalConfirmWindow:     kv.removalConfirmWindow,
+		SuspendBacklog:           kv.suspendBacklog,
+		ManualJanitor:            kv.manualJanitor != nil,
+		KeyMap:                   kv.keyMapFactory != nil,
+		WriteRateWindow:          kv.writeRateWindow,
+		WriteRateThreshold:       kv.writeRateThreshold,
+		Strict:                   kv.strict,
+		StringValues:             kv.stringValues,
+		MigrateOnRead:            kv.migrateOnRead != nil,
+		ExpirationLagBudget:      kv.lagBudget,
+		ExpirationLagBacklog:     kv.lagBacklog,
+		OverwriteWindow:          kv.overwriteWindow,
+	}
+}
+
+func (kv *Store) String() string {
+	kv.mx.Lock()
+	n := kv.kv.len()
+	kv.mx.Unlock()
+	return fmt.Sprintf("tinykv{entries: %d, config: %+v}", n, kv.Config())
+}
@@ -0,0 +1,75 @@
+package tinykv
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := New(-1)
+	cfg := kv.Config()
+	assert.Equal(Config{
+		ExpirationInterval: DefaultExpirationInterval,
+		JanitorRunning:     true,
+	}, cfg)
+	kv.Stop()
+	assert.False(kv.Config().JanitorRunning)
+
+	clock := newFakeClock()
+	kv = NewStore(
+		time.Minute,
+		OnExpire(func(string, interface{}) {}),
+		SynchronousNotifications(),
+		Clock(clock.Now),
+		MissFilter(100, 0.05),
+		Debug())
+	defer kv.Stop()
+	cfg = kv.Config()
+	assert.Equal(Config{
+		ExpirationInterval:       time.Minute,
+		OnExpire:                 true,
+		SynchronousNotifications: true,
+		CustomClock:              true,
+		MissFilterEntries:        100,
+		MissFilterFPRate:         0.05,
+		Debug:                    true,
+		JanitorRunning:           true,
+	}, cfg)
+
+	cfg.ExpirationInterval = time.Hour
+	assert.Equal(time.Minute, kv.Config().ExpirationInterval)
+
+	kv.Put("1", 1)
+	s := kv.String()
+	assert.True(strings.HasPrefix(s, "tinykv{entries: 1, config: {ExpirationInterval:1m0s"), s)
+}
+
+func TestDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Second*20, DefaultExpirationInterval)
+	for _, interval := range []time.Duration{0, -1} {
+		kv := NewStore(interval)
+		assert.Equal(DefaultExpirationInterval, kv.Config().ExpirationInterval)
+		kv.Stop()
+	}
+
+	// no default timeout: an entry put without one never expires
+	clock := newFakeClock()
+	kv := NewStore(0, Clock(clock.Now))
+	defer kv.Stop()
+	assert.NoError(kv.Put("k", 1))
+	meta, ok := kv.GetMeta("k")
+	assert.True(ok)
+	assert.True(meta.ExpiresAt.IsZero())
+	clock.Advance(time.Hour * 24 * 365)
+	kv.ExpireNow()
+	v, ok := kv.Get("k")
+	assert.True(ok)
+	assert.Equal(1, v)
+}
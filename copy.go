@@ -0,0 +1,116 @@
+package tinykv
+
+import (
+	"reflect"
+)
+
+// CopyValues makes the store keep copies of the values: Put stores
+// copier(v), and Get and Range return copier(stored), so changes to a value
+// after Put, or to a value returned by Get, are not visible through the
+// store. DeepCopy can be used as the copier, for common types. It is opt-in,
+// because of the cost of copying on each Put and Get.
+func CopyValues(copier func(interface{}) interface{}) StoreOption {
+	return func(opt *storeOpt) {
+		opt.copyValues = copier
+	}
+}
+
+func (kv *Store) copyValue(v interface{}) interface{} {
+	if kv.copyValues == nil {
+		return v
+	}
+	return kv.copyValues(v)
+}
+
+// DeepCopy copies v, following pointers, slices, maps, arrays, interfaces
+// and exported struct fields. Unexported struct fields, channels and functions
+// are copied shallowly. Cyclic values are not supported.
+func DeepCopy(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return deepCopy(reflect.ValueOf(v)).Interface()
+}
+
+func deepCopy(v reflect.Value) reflect.Value {
+	if isFlat(v.Type()) {
+		return v // copied by Set
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		res := reflect.New(v.Type().Elem())
+		res.Elem().Set(deepCopy(v.Elem()))
+		return res
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		res := reflect.New(v.Type()).Elem()
+		res.Set(deepCopy(v.Elem()))
+		return res
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		res := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		if isFlat(v.Type().Elem()) {
+			reflect.Copy(res, v)
+			return res
+		}
+		for i := 0; i < v.Len(); i++ {
+			res.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return res
+	case reflect.Array:
+		res := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			res.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return res
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		res := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			res.SetMapIndex(deepCopy(iter.Key()), deepCopy(iter.Value()))
+		}
+		return res
+	case reflect.Struct:
+		res := reflect.New(v.Type()).Elem()
+		res.Set(v) // unexported fields, shallowly
+		for i := 0; i < v.NumField(); i++ {
+			if f := res.Field(i); f.CanSet() {
+				f.Set(deepCopy(v.Field(i)))
+			}
+		}
+		return res
+	}
+	return v
+}
+
+// isFlat reports if values of t hold no references
+func isFlat(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.String:
+		return true
+	case reflect.Array:
+		return isFlat(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !isFlat(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
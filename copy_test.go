@@ -0,0 +1,115 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type copyTestValue struct {
+	Name   string
+	Tags   []string
+	Attrs  map[string]int
+	Next   *copyTestValue
+	Any    interface{}
+	hidden int
+}
+
+func TestCopyValues(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, CopyValues(DeepCopy))
+	defer kv.Stop()
+
+	v := &copyTestValue{
+		Name:   "a",
+		Tags:   []string{"x"},
+		Attrs:  map[string]int{"n": 1},
+		Next:   &copyTestValue{Name: "b"},
+		Any:    []int{1},
+		hidden: 7,
+	}
+	kv.Put("1", v)
+	v.Name = "changed"
+	v.Tags[0] = "changed"
+	v.Attrs["n"] = 2
+	v.Next.Name = "changed"
+	v.Any.([]int)[0] = 2
+
+	got, ok := kv.Get("1")
+	assert.True(ok)
+	assert.Equal(&copyTestValue{
+		Name:   "a",
+		Tags:   []string{"x"},
+		Attrs:  map[string]int{"n": 1},
+		Next:   &copyTestValue{Name: "b"},
+		Any:    []int{1},
+		hidden: 7,
+	}, got)
+
+	got.(*copyTestValue).Tags[0] = "changed"
+	again, _ := kv.Get("1")
+	assert.Equal("x", again.(*copyTestValue).Tags[0])
+
+	kv.Range(func(k string, v interface{}) bool {
+		v.(*copyTestValue).Name = "changed"
+		return true
+	})
+	again, _ = kv.Get("1")
+	assert.Equal("a", again.(*copyTestValue).Name)
+	assert.True(kv.Config().CopyValues)
+}
+
+func TestDeepCopy(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(DeepCopy(nil))
+	assert.Equal(1, DeepCopy(1))
+	assert.Equal([]byte(nil), DeepCopy([]byte(nil)))
+	b := []byte("abc")
+	c := DeepCopy(b).([]byte)
+	c[0] = 'x'
+	assert.Equal("abc", string(b))
+	arr := [2][]int{{1}, {2}}
+	arrCopy := DeepCopy(arr).([2][]int)
+	arrCopy[0][0] = 9
+	assert.Equal(1, arr[0][0])
+}
+
+type kbValue struct {
+	Data [1024]byte
+}
+
+func BenchmarkPutGetStruct(b *testing.B) {
+	kv := New(-1)
+	defer kv.Stop()
+	v := &kbValue{}
+	for n := 0; n < b.N; n++ {
+		kv.Put("1", v)
+		kv.Get("1")
+	}
+}
+
+func BenchmarkPutGetStructCopyValues(b *testing.B) {
+	kv := NewStore(-1, CopyValues(DeepCopy))
+	defer kv.Stop()
+	v := &kbValue{}
+	for n := 0; n < b.N; n++ {
+		kv.Put("1", v)
+		kv.Get("1")
+	}
+}
+
+func BenchmarkPutGetStructCopyValuesCustom(b *testing.B) {
+	kv := NewStore(-1, CopyValues(func(v interface{}) interface{} {
+		c := *v.(*kbValue)
+		return &c
+	}))
+	defer kv.Stop()
+	v := &kbValue{}
+	for n := 0; n < b.N; n++ {
+		kv.Put("1", v)
+		kv.Get("1")
+	}
+}
@@ -0,0 +1,151 @@
+package tinykv
+
+import (
+	"github.com/pkg/errors"
+)
+
+// CheckInvariants verifies the consistency of the internal structures
+// (the map and the timeout heap). It is only available when the store is
+// created with the Debug option, otherwise ErrNotDebug is returned.
+func (kv *Store) CheckInvariants() error {
+	if !kv.debug {
+		return ErrNotDebug
+	}
+	return kv.checkInvariants()
+}
+
+func (kv *Store) checkInvariants() error {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+
+	// copied, so the checks can return from their loops
+	entries := make(map[string]*entry, kv.kv.len())
+	kv.kv.each(func(k string, e *entry) bool {
+		entries[k] = e
+		return true
+	})
+	if len(entries) != kv.kv.len() {
+		return errors.Errorf("map ranges over %d entries, and has a length of %d", len(entries), kv.kv.len())
+	}
+
+	for i, to := range kv.heap {
+		if to.index != i {
+			return errors.Errorf("heap node %d (key %q) has index %d", i, to.key, to.index)
+		}
+		if i > 0 && kv.heap.Less(i, (i-1)/2) {
+			return errors.Errorf("heap node %d (key %q) is before its parent", i, to.key)
+		}
+		if to.stale {
+			continue
+		}
+		if to.pending != nil {
+			found := false
+			for _, t := range kv.pendings[to.key] {
+				found = found || t == to
+			}
+			if !found {
+				return errors.Errorf("heap node %d (key %q) is a pending put that is not tracked", i, to.key)
+			}
+			continue
+		}
+		if to.expect != nil {
+			found := false
+			for _, t := range kv.expectations[to.key] {
+				found = found || t == to
+			}
+			if !found {
+				return errors.Errorf("heap node %d (key %q) is an expectation that is not tracked", i, to.key)
+			}
+			continue
+		}
+		if to.soft != nil {
+			if e, ok := kv.kv.get(to.key); !ok || e.softNode != to {
+				return errors.Errorf("heap node %d (key %q) is a soft deadline of no entry and is not stale", i, to.key)
+			}
+			continue
+		}
+		e, ok := kv.kv.get(to.key)
+		if !ok {
+			return errors.Errorf("heap node %d (key %q) has no entry and is not stale", i, to.key)
+		}
+		if e.timeout != to {
+			return errors.Errorf("heap node %d (key %q) is not the timeout of its entry and is not stale", i, to.key)
+		}
+	}
+	for k, e := range entries {
+		if e.timeout == nil {
+			continue
+		}
+		to := e.timeout
+		if to.stale {
+			return errors.Errorf("entry %q has a stale timeout", k)
+		}
+		if to.key != k {
+			return errors.Errorf("entry %q has the timeout of key %q", k, to.key)
+		}
+		if e.condemned { // its timeout is out of the heap, during a sweep
+			continue
+		}
+		if to.index < 0 || to.index >= len(kv.heap) || kv.heap[to.index] != to {
+			return errors.Errorf("entry %q has heap index %d out of range", k, to.index)
+		}
+	}
+	for k, tos := range kv.pendings {
+		for _, to := range tos {
+			if to.stale || to.index < 0 || to.index >= len(kv.heap) || kv.heap[to.index] != to {
+				return errors.Errorf("pending put of %q is not in the heap", k)
+			}
+		}
+	}
+	for k, tos := range kv.expectations {
+		for _, to := range tos {
+			if to.stale || to.index < 0 || to.index >= len(kv.heap) || kv.heap[to.index] != to {
+				return errors.Errorf("expectation of %q is not in the heap", k)
+			}
+		}
+	}
+	for k, e := range entries {
+		if e.bound == nil {
+			continue
+		}
+		if _, ok := e.bound.keys[k]; !ok {
+			return errors.Errorf("entry %q is bound to %q, which does not track it", k, e.bound.ref.key)
+		}
+	}
+	if kv.maxCost > 0 {
+		var total int64
+		for k, e := range entries {
+			if e.cost != kv.cost(k, e.value) {
+				return errors.Errorf("entry %q has cost %d, expected %d", k, e.cost, kv.cost(k, e.value))
+			}
+			total += e.cost
+		}
+		if total != kv.totalCost {
+			return errors.Errorf("total cost is %d, expected %d", kv.totalCost, total)
+		}
+	}
+	if err := kv.checkQuotas(); err != nil {
+		return err
+	}
+	if err := kv.checkRetained(); err != nil {
+		return err
+	}
+	if err := kv.checkUniqueIndexes(); err != nil {
+		return err
+	}
+	if kv.index != nil {
+		keys := kv.index.view()
+		if len(keys) != kv.kv.len() {
+			return errors.Errorf("index has %d keys, expected %d", len(keys), kv.kv.len())
+		}
+		for i, k := range keys {
+			if i > 0 && keys[i-1] >= k {
+				return errors.Errorf("index key %q is out of order", k)
+			}
+			if _, ok := kv.kv.get(k); !ok {
+				return errors.Errorf("index key %q has no entry", k)
+			}
+		}
+	}
+	return nil
+}
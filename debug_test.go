@@ -0,0 +1,52 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func checkInvariants(t *testing.T, kv *Store) {
+	t.Helper()
+	assert.NoError(t, kv.checkInvariants())
+}
+
+func TestCheckInvariants(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := New(-1)
+	defer kv.Stop()
+	assert.Equal(ErrNotDebug, kv.CheckInvariants())
+
+	clock := newFakeClock()
+	kv = NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	kv.Put("1", 1, ExpiresAfter(time.Minute))
+	kv.Put("1", 1, ExpiresAfter(time.Minute*2))
+	kv.Put("2", 2, ExpiresAfter(time.Minute), IsSliding(true))
+	kv.Put("3", 3, ExpiresAfter(time.Minute))
+	kv.Put("3", 3)
+	kv.Put("4", 4, ExpiresAfter(time.Second), CAS(func(interface{}, bool) bool { return false }))
+	kv.Put("5", 5, ExpiresAfter(time.Second))
+	kv.Put("5", 5, ExpiresAfter(time.Minute), CAS(func(interface{}, bool) bool { return true }))
+	assert.NoError(kv.CheckInvariants())
+
+	clock.Advance(time.Second * 30)
+	kv.Get("2")
+	kv.Delete("1")
+	kv.Take("5")
+	assert.NoError(kv.CheckInvariants())
+
+	clock.Advance(time.Minute * 5)
+	kv.ExpireNow()
+	assert.NoError(kv.CheckInvariants())
+	assert.Equal(0, len(kv.heap))
+
+	s := kv
+	s.mx.Lock()
+	s.heap = append(s.heap, &timeout{key: "x", index: 0})
+	s.mx.Unlock()
+	assert.Error(kv.CheckInvariants())
+}
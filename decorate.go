@@ -0,0 +1,303 @@
+package tinykv
+
+import (
+	"strings"
+)
+
+// GetFunc is a Get, for a Middleware
+type GetFunc func(k string) (v interface{}, ok bool)
+
+// PutFunc is a Put, for a Middleware
+type PutFunc func(k string, v interface{}, options ...PutOption) error
+
+// DeleteFunc is a Delete, for a Middleware
+type DeleteFunc func(k string)
+
+// TakeFunc is a Take, for a Middleware
+type TakeFunc func(k string) (v interface{}, ok bool)
+
+// CASFunc is a CAS, for a Middleware
+type CASFunc func(k string, v interface{}, cond func(oldValue interface{}, found bool) bool, options ...PutOption) error
+
+// ExpiredFunc is an OnExpire function, for a Middleware
+type ExpiredFunc func(k string, v interface{})
+
+// Middleware intercepts operations of a KV, see Decorate: each of Get, Put,
+// Delete, Take and CAS wraps the operation of the next layer, toward the
+// base, in one of its own. A nil field passes the operation through. MapKey
+// maps the keys of the layer to those of the next one, for all the methods of
+// the KV, and UnmapKey maps them back (false for a key that is not in the
+// layer, like of another prefix), for Keys, Len, Range, the keys of OpErrors,
+// and the expiration notifications. Expired wraps the expiration
+// notifications, see DecorateExpired.
+type Middleware struct {
+	Get      func(next GetFunc) GetFunc
+	Put      func(next PutFunc) PutFunc
+	Delete   func(next DeleteFunc) DeleteFunc
+	Take     func(next TakeFunc) TakeFunc
+	CAS      func(next CASFunc) CASFunc
+	Expired  func(next ExpiredFunc) ExpiredFunc
+	MapKey   func(k string) string
+	UnmapKey func(k string) (string, bool)
+}
+
+// Decorate returns a KV that runs the operations through the middlewares,
+// the first one outermost, then base; the operations a middleware does not
+// intercept go to the next layer as they are, so a decorator need not
+// implement the whole KV. If base is a StatsProvider, so is the KV, unless a
+// middleware maps the keys (the Stats would be of the whole base). The
+// callbacks of base (like OnExpire) are set when it is created, so they are
+// wrapped apart, with DecorateExpired.
+func Decorate(base KV, mw ...Middleware) KV {
+	if len(mw) == 0 {
+		mw = []Middleware{{}}
+	}
+	next, mapped := base, false
+	for i := len(mw) - 1; i >= 0; i-- {
+		next = newDecorated(next, mw[i])
+		mapped = mapped || mw[i].MapKey != nil || mw[i].UnmapKey != nil
+	}
+	if stats, ok := base.(StatsProvider); ok && !mapped {
+		return decoratedStats{next.(*decorated), stats}
+	}
+	return next
+}
+
+// DecorateExpired wraps fn, an OnExpire function of the base of Decorate,
+// in the Expired funcs of the middlewares, the first one innermost, as the
+// notifications go from the base out; the keys are unmapped on the way, and
+// those not in a layer are skipped.
+func DecorateExpired(fn func(k string, v interface{}), mw ...Middleware) func(k string, v interface{}) {
+	f := ExpiredFunc(fn)
+	for _, m := range mw {
+		if m.Expired != nil {
+			f = m.Expired(f)
+		}
+		if m.UnmapKey != nil {
+			f = unmapExpired(f, m.UnmapKey)
+		}
+	}
+	return f
+}
+
+func unmapExpired(next ExpiredFunc, unmapKey func(k string) (string, bool)) ExpiredFunc {
+	return func(k string, v interface{}) {
+		if k, ok := unmapKey(k); ok {
+			next(k, v)
+		}
+	}
+}
+
+// decorated is a layer of Decorate, over next
+type decorated struct {
+	next     KV
+	mapKey   func(k string) string
+	unmapKey func(k string) (string, bool)
+	get      GetFunc
+	put      PutFunc
+	delete   DeleteFunc
+	take     TakeFunc
+	cas      CASFunc
+}
+
+func newDecorated(next KV, m Middleware) *decorated {
+	d := &decorated{next: next, mapKey: m.MapKey, unmapKey: m.UnmapKey}
+	d.get = func(k string) (interface{}, bool) { return next.Get(d.key(k)) }
+	d.put = func(k string, v interface{}, options ...PutOption) error {
+		return d.unmapErr(next.Put(d.key(k), v, options...))
+	}
+	d.delete = func(k string) { next.Delete(d.key(k)) }
+	d.take = func(k string) (interface{}, bool) { return next.Take(d.key(k)) }
+	d.cas = func(k string, v interface{}, cond func(interface{}, bool) bool, options ...PutOption) error {
+		return d.unmapErr(next.CAS(d.key(k), v, cond, options...))
+	}
+	if m.Get != nil {
+		d.get = m.Get(d.get)
+	}
+	if m.Put != nil {
+		d.put = m.Put(d.put)
+	}
+	if m.Delete != nil {
+		d.delete = m.Delete(d.delete)
+	}
+	if m.Take != nil {
+		d.take = m.Take(d.take)
+	}
+	if m.CAS != nil {
+		d.cas = m.CAS(d.cas)
+	}
+	return d
+}
+
+// key maps k to the key of the next layer
+func (d *decorated) key(k string) string {
+	if d.mapKey == nil {
+		return k
+	}
+	return d.mapKey(k)
+}
+
+// unmap maps k, of the next layer, back; ok is false if it is not in this one
+func (d *decorated) unmap(k string) (string, bool) {
+	if d.unmapKey == nil {
+		return k, true
+	}
+	return d.unmapKey(k)
+}
+
+// unmapErr maps the key of an OpError of the next layer back
+func (d *decorated) unmapErr(err error) error {
+	opErr, ok := err.(*OpError)
+	if !ok || d.unmapKey == nil {
+		return err
+	}
+	k, ok := d.unmapKey(opErr.Key)
+	if !ok {
+		return err
+	}
+	return &OpError{Op: opErr.Op, Key: k, Err: opErr.Err}
+}
+
+func (d *decorated) Get(k string) (interface{}, bool)  { return d.get(k) }
+func (d *decorated) Delete(k string)                   { d.delete(k) }
+func (d *decorated) Take(k string) (interface{}, bool) { return d.take(k) }
+func (d *decorated) Touch(k string) bool               { return d.next.Touch(d.key(k)) }
+func (d *decorated) Stop()                             { d.next.Stop() }
+
+func (d *decorated) Put(k string, v interface{}, options ...PutOption) error {
+	return d.put(k, v, options...)
+}
+
+func (d *decorated) CAS(k string, v interface{}, cond func(oldValue interface{}, found bool) bool, options ...PutOption) error {
+	return d.cas(k, v, cond, options...)
+}
+
+func (d *decorated) DeleteE(k string) error {
+	return d.unmapErr(d.next.DeleteE(d.key(k)))
+}
+
+func (d *decorated) GetE(k string) (interface{}, error) {
+	v, err := d.next.GetE(d.key(k))
+	return v, d.unmapErr(err)
+}
+
+func (d *decorated) TakeE(k string) (interface{}, error) {
+	v, err := d.next.TakeE(d.key(k))
+	return v, d.unmapErr(err)
+}
+
+func (d *decorated) Keys() []string {
+	keys := d.next.Keys()
+	if d.unmapKey == nil {
+		return keys
+	}
+	var mapped []string
+	for _, k := range keys {
+		if k, ok := d.unmapKey(k); ok {
+			mapped = append(mapped, k)
+		}
+	}
+	return mapped
+}
+
+// Len is the Len of the next layer, or the number of its Keys in this one,
+// under UnmapKey
+func (d *decorated) Len() int {
+	if d.unmapKey == nil {
+		return d.next.Len()
+	}
+	return len(d.Keys())
+}
+
+func (d *decorated) Range(fn func(k string, v interface{}) bool) {
+	d.next.Range(func(k string, v interface{}) bool {
+		if k, ok := d.unmap(k); ok {
+			return fn(k, v)
+		}
+		return true
+	})
+}
+
+// decoratedStats is a Decorate of a StatsProvider
+type decoratedStats struct {
+	*decorated
+	stats StatsProvider
+}
+
+func (d decoratedStats) Stats() Stats { return d.stats.Stats() }
+
+//-----------------------------------------------------------------------------
+
+// PrefixKeys is a Middleware that puts prefix before the keys, for a view of
+// a shared store: all the methods of the KV see the keys without it, and
+// only those with it (like Keys, or Range); with DecorateExpired, it strips
+// it from the expired keys, and skips the ones without it.
+func PrefixKeys(prefix string) Middleware {
+	return Middleware{
+		MapKey: func(k string) string { return prefix + k },
+		UnmapKey: func(k string) (string, bool) {
+			if !strings.HasPrefix(k, prefix) {
+				return "", false
+			}
+			return k[len(prefix):], true
+		},
+	}
+}
+
+// Logger is where LogOps writes; a *log.Logger is one
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// LogOps is a Middleware that logs the operations, and their outcome
+func LogOps(logger Logger) Middleware {
+	return Middleware{
+		Get: func(next GetFunc) GetFunc {
+			return func(k string) (interface{}, bool) {
+				v, ok := next(k)
+				logger.Printf("tinykv: get %q: found %v", k, ok)
+				return v, ok
+			}
+		},
+		Put: func(next PutFunc) PutFunc {
+			return func(k string, v interface{}, options ...PutOption) error {
+				err := next(k, v, options...)
+				logger.Printf("tinykv: put %q: %v", k, errString(err))
+				return err
+			}
+		},
+		Delete: func(next DeleteFunc) DeleteFunc {
+			return func(k string) {
+				next(k)
+				logger.Printf("tinykv: delete %q", k)
+			}
+		},
+		Take: func(next TakeFunc) TakeFunc {
+			return func(k string) (interface{}, bool) {
+				v, ok := next(k)
+				logger.Printf("tinykv: take %q: found %v", k, ok)
+				return v, ok
+			}
+		},
+		CAS: func(next CASFunc) CASFunc {
+			return func(k string, v interface{}, cond func(interface{}, bool) bool, options ...PutOption) error {
+				err := next(k, v, cond, options...)
+				logger.Printf("tinykv: cas %q: %v", k, errString(err))
+				return err
+			}
+		},
+		Expired: func(next ExpiredFunc) ExpiredFunc {
+			return func(k string, v interface{}) {
+				logger.Printf("tinykv: expired %q", k)
+				next(k, v)
+			}
+		},
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return err.Error()
+}
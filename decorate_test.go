@@ -0,0 +1,148 @@
+package tinykv
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// traceOps is a Middleware that records the calls around the next layer
+func traceOps(name string, trace *[]string) Middleware {
+	return Middleware{
+		Get: func(next GetFunc) GetFunc {
+			return func(k string) (interface{}, bool) {
+				*trace = append(*trace, name+" get "+k)
+				defer func() { *trace = append(*trace, name+" got "+k) }()
+				return next(k)
+			}
+		},
+		Put: func(next PutFunc) PutFunc {
+			return func(k string, v interface{}, options ...PutOption) error {
+				*trace = append(*trace, name+" put "+k)
+				return next(k, v, options...)
+			}
+		},
+		Expired: func(next ExpiredFunc) ExpiredFunc {
+			return func(k string, v interface{}) {
+				*trace = append(*trace, name+" expired "+k)
+				next(k, v)
+			}
+		},
+	}
+}
+
+func TestDecorate(t *testing.T) {
+	assert := assert.New(t)
+
+	var trace []string
+	outer, inner := traceOps("outer", &trace), traceOps("inner", &trace)
+	prefix := PrefixKeys("tenant/")
+
+	clock := newFakeClock()
+	var expired []string
+	base := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpire(DecorateExpired(func(k string, v interface{}) {
+			expired = append(expired, k)
+		}, outer, inner, prefix)))
+	defer base.Stop()
+	kv := Decorate(base, outer, inner, prefix)
+
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	v, ok := kv.Get("k")
+	assert.True(ok)
+	assert.Equal(1, v)
+	assert.Equal([]string{
+		"outer put k", "inner put k",
+		"outer get k", "inner get k", "inner got k", "outer got k",
+	}, trace)
+
+	// the base holds the prefixed key
+	assert.Equal([]string{"tenant/k"}, base.Keys())
+	_, ok = base.Get("k")
+	assert.False(ok)
+
+	assert.NoError(kv.CAS("k", 2, func(old interface{}, found bool) bool { return old == 1 }))
+	v, _ = base.Get("tenant/k")
+	assert.Equal(2, v)
+	v, ok = kv.Take("k")
+	assert.True(ok)
+	assert.Equal(2, v)
+	assert.Equal(0, base.Len())
+	assert.NoError(kv.Put("gone", 1))
+	kv.Delete("gone")
+	assert.Equal(0, base.Len())
+
+	// the expired keys come back without the prefix, and the keys of others
+	// are skipped
+	trace = nil
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	assert.NoError(base.Put("other/k", 1, ExpiresAfter(time.Second)))
+	clock.Advance(time.Second * 2)
+	base.ExpireNow()
+	assert.Equal([]string{"k"}, expired)
+	assert.Equal([]string{"outer put k", "inner put k", "inner expired k", "outer expired k"}, trace)
+}
+
+func TestDecoratePrefixView(t *testing.T) {
+	assert := assert.New(t)
+
+	base := NewStore(time.Hour)
+	defer base.Stop()
+	assert.NoError(base.Put("other/k", 1))
+	assert.NoError(base.Put("tenant/k", 2))
+	kv := Decorate(base, PrefixKeys("tenant/"))
+
+	assert.Equal([]string{"k"}, kv.Keys())
+	assert.Equal(1, kv.Len())
+	var ranged []string
+	kv.Range(func(k string, v interface{}) bool {
+		ranged = append(ranged, k)
+		return true
+	})
+	assert.Equal([]string{"k"}, ranged)
+	assert.True(kv.Touch("k"))
+	assert.False(kv.Touch("other/k"))
+
+	_, err := kv.GetE("missing")
+	var opErr *OpError
+	assert.True(errors.As(err, &opErr))
+	assert.Equal("missing", opErr.Key)
+	err = kv.CAS("k", 3, func(interface{}, bool) bool { return false })
+	assert.True(errors.As(err, &opErr))
+	assert.Equal("k", opErr.Key)
+
+	// the Stats of the whole base are not of the view
+	_, ok := kv.(StatsProvider)
+	assert.False(ok)
+	sp, ok := Decorate(base).(StatsProvider)
+	assert.True(ok)
+	assert.Equal(base.Stats(), sp.Stats())
+}
+
+func TestLogOps(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	kv := Decorate(NewStore(time.Hour), LogOps(log.New(&buf, "", 0)))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k", 1))
+	kv.Get("k")
+	assert.Error(kv.CAS("k", 2, func(interface{}, bool) bool { return false }))
+	kv.Take("k")
+	kv.Take("k")
+	kv.Delete("k")
+	assert.Equal(`tinykv: put "k": ok
+tinykv: get "k": found true
+tinykv: cas "k": tinykv: cas "k": CAS COND FAILED
+tinykv: take "k": found true
+tinykv: take "k": found false
+tinykv: delete "k"
+`, buf.String())
+}
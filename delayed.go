@@ -0,0 +1,134 @@
+package tinykv
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PutAfter puts an entry that becomes visible delay from now, with the given
+// options; its timeout starts then. Until then it is held in the timeout
+// heap, and cancel drops it. It becomes visible to the operations on k at
+// its time, and to Keys and Range once the janitor activates it (while
+// expiration is paused, only on access). It is not logged to the WAL until
+// then, Clear does not drop it, and Stop does. If the entry for k is
+// read-only at that time, it is dropped. CAS options and BoundTo are not
+// supported.
+func (kv *Store) PutAfter(k string, v interface{}, delay time.Duration, options ...PutOption) (cancel func(), err error) {
+	defer wrapOp(&err, "put-after", k)
+	kv.active()
+	opt := kv.putOptions(options)
+	if opt.cas != nil || opt.casMeta != nil {
+		return nil, errors.Wrap(ErrInvalidOptions, "CAS options passed to PutAfter")
+	}
+	if opt.boundTo != nil {
+		return nil, errors.Wrap(ErrInvalidOptions, "BoundTo passed to PutAfter")
+	}
+	if err := opt.validate(); err != nil {
+		return nil, err
+	}
+	if err := kv.checkString(v); err != nil {
+		return nil, err
+	}
+	if delay <= 0 {
+		return func() {}, kv.putWith(k, v, opt, false)
+	}
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if kv.isReadOnly(k) {
+		return nil, ErrReadOnly
+	}
+	to := &timeout{
+		expiresAt:  kv.now().Add(delay),
+		key:        k,
+		index:      -1,
+		slidesLeft: -1,
+		pending:    &pendingPut{value: kv.copyValue(v), opt: opt},
+	}
+	timeheapPush(&kv.heap, to)
+	if kv.pendings == nil {
+		kv.pendings = make(map[string][]*timeout)
+	}
+	kv.pendings[k] = append(kv.pendings[k], to)
+	kv.readInvalidate(k)
+	cancel = func() {
+		kv.mx.Lock()
+		defer kv.mx.Unlock()
+		kv.dropPending(to)
+	}
+	return cancel, nil
+}
+
+// pendingPut is the put of a heap node that activates an entry
+type pendingPut struct {
+	value interface{}
+	opt   *putOpt
+}
+
+// activateDue activates the pending puts for k that are due, in order
+func (kv *Store) activateDue(k string) {
+	if len(kv.pendings) == 0 {
+		return
+	}
+	tos := kv.pendings[k]
+	if len(tos) == 0 {
+		return
+	}
+	now := kv.now()
+	var due []*timeout
+	for _, to := range tos {
+		if to.expired(now) {
+			due = append(due, to)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].expiresAt.Before(due[j].expiresAt) })
+	for _, to := range due {
+		kv.activate(to)
+	}
+}
+
+func (kv *Store) activate(to *timeout) {
+	kv.dropPending(to)
+	if kv.isReadOnly(to.key) {
+		return
+	}
+	opt := *to.pending.opt
+	opt.activatedAt = to.expiresAt
+	kv.set(to.key, kv.newEntry(to.key, to.pending.value, &opt))
+}
+
+// dropPending takes to out of the heap and the pending puts
+func (kv *Store) dropPending(to *timeout) {
+	if to.stale {
+		return
+	}
+	to.stale = true
+	if to.index >= 0 && to.index < len(kv.heap) && kv.heap[to.index] == to {
+		timeheapRemove(&kv.heap, to.index)
+	}
+	tos := kv.pendings[to.key]
+	for i, t := range tos {
+		if t == to {
+			tos = append(tos[:i], tos[i+1:]...)
+			break
+		}
+	}
+	if len(tos) == 0 {
+		delete(kv.pendings, to.key)
+	} else {
+		kv.pendings[to.key] = tos
+	}
+}
+
+// dropAllPending drops all the pending puts, on Stop
+func (kv *Store) dropAllPending() {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	for _, tos := range kv.pendings {
+		for _, to := range append([]*timeout(nil), tos...) {
+			kv.dropPending(to)
+		}
+	}
+	kv.pendings = nil
+}
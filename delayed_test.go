@@ -0,0 +1,117 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	_, err := kv.PutAfter("1", 1, time.Minute, ExpiresAfter(time.Minute))
+	assert.NoError(err)
+	assert.NoError(kv.CheckInvariants())
+
+	clock.Advance(time.Second * 59)
+	_, ok := kv.Get("1")
+	assert.False(ok)
+	assert.Empty(kv.Keys())
+
+	// the timeout starts at activation
+	clock.Advance(time.Second * 2)
+	v, ok := kv.Get("1")
+	assert.True(ok)
+	assert.Equal(1, v)
+	meta, _ := kv.GetMeta("1")
+	assert.Equal(time.Minute-time.Second, meta.Remaining)
+
+	clock.Advance(time.Minute)
+	_, ok = kv.Get("1")
+	assert.False(ok)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestPutAfterJanitor(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	kv.Put("k", "old", ExpiresAfter(time.Minute))
+	kv.PutAfter("k", "new", time.Second*2)
+	kv.PutAfter("k", "newer", time.Second*3)
+	kv.PutAfter("other", 1, time.Second)
+
+	// pending puts are not popped, and survive Clear
+	_, v, ok := kv.PopSoonest()
+	assert.True(ok)
+	assert.Equal("old", v)
+	_, _, ok = kv.PopSoonest()
+	assert.False(ok)
+	kv.Clear()
+	assert.NoError(kv.CheckInvariants())
+
+	clock.Advance(time.Second * 4)
+	kv.ExpireNow()
+	assert.ElementsMatch([]string{"k", "other"}, kv.Keys())
+	v, _ = kv.Get("k")
+	assert.Equal("newer", v)
+	assert.Equal(0, kv.Stats().HeapLen)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestPutAfterCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	cancel, _ := kv.PutAfter("1", 1, time.Second)
+	cancel()
+	cancel()
+	assert.NoError(kv.CheckInvariants())
+	clock.Advance(time.Second * 2)
+	_, ok := kv.Get("1")
+	assert.False(ok)
+
+	// too late to cancel
+	cancel, _ = kv.PutAfter("2", 2, time.Second)
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	cancel()
+	_, ok = kv.Get("2")
+	assert.True(ok)
+
+	kv.Put("ro", 1, ReadOnly())
+	_, err := kv.PutAfter("ro", 2, time.Second)
+	assert.Equal(ErrReadOnly, errors.Cause(err))
+	_, err = kv.PutAfter("3", 3, time.Second, CAS(func(interface{}, bool) bool { return true }))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestPutAfterStop(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+
+	cancel, _ := kv.PutAfter("1", 1, time.Second)
+	kv.Stop()
+	assert.Equal(0, kv.Stats().HeapLen)
+	assert.NoError(kv.CheckInvariants())
+
+	clock.Advance(time.Second * 2)
+	_, ok := kv.Get("1")
+	assert.False(ok)
+	cancel()
+}
@@ -0,0 +1,53 @@
+package tinykv
+
+// Executor sets the function that runs the tasks of the store, instead of
+// goroutines of their own, to route them into a worker pool: the asynchronous
+// notifications (OnExpire and the like, OnEvict, OnBulkRemoval, the onMissing
+// of ExpectWithin, OnSoftExpire, OnCardinalityAlarm, OnIdle), the retries of
+// OnExpireE and its dead letters, the deliveries of ArchiveOnExpire, the
+// closes of CloseOnRemoval and the writes of WriteBehind. The loops of the
+// store (the janitor, the dispatcher, the archive, the retries, the write
+// behind) keep their goroutines, but submit the user code they run, and wait
+// for it, so its order is kept. A refresh runs in the goroutine of the miss,
+// as before. The store holds no lock when it submits a task, so submit may
+// run it inline, or queue it for later. A nil submit keeps the goroutines.
+func Executor(submit func(task func())) StoreOption {
+	return func(opt *storeOpt) {
+		opt.executor = submit
+	}
+}
+
+// spawn runs task through the Executor, or by a goroutine of its own; it
+// must not be called under the lock
+func (kv *Store) spawn(task func()) {
+	if kv.executor == nil {
+		go task()
+		return
+	}
+	kv.executor(task)
+}
+
+// spawnLocked is spawn, under the lock: task is submitted by a goroutine
+// of its own, once the lock can be released
+func (kv *Store) spawnLocked(task func()) {
+	if kv.executor == nil {
+		go task()
+		return
+	}
+	go kv.executor(task)
+}
+
+// spawnWait runs task through the Executor, and waits for it to return;
+// without one, task runs inline. It is for the loops of the store.
+func (kv *Store) spawnWait(task func()) {
+	if kv.executor == nil {
+		task()
+		return
+	}
+	done := make(chan struct{})
+	kv.executor(func() {
+		defer close(done)
+		task()
+	})
+	<-done
+}
@@ -0,0 +1,168 @@
+package tinykv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// idBacking is a Backing that reports the goroutines of its writes
+type idBacking struct {
+	*fakeBacking
+	ran chan<- uint64
+}
+
+func (b idBacking) Store(k string, v interface{}, ttl time.Duration) error {
+	b.ran <- goroutineID()
+	return b.fakeBacking.Store(k, v, ttl)
+}
+
+type idCloser chan<- uint64
+
+func (c idCloser) Close() error {
+	c <- goroutineID()
+	return nil
+}
+
+func TestExecutor(t *testing.T) {
+	type path struct {
+		name    string
+		options func(ran chan<- uint64) []StoreOption
+		trigger func(kv *Store, clock *fakeClock, ran chan<- uint64)
+	}
+	expire := func(kv *Store, clock *fakeClock, ran chan<- uint64) {
+		_ = kv.Put("k", 1, ExpiresAfter(time.Second))
+		clock.Advance(time.Second * 2)
+		kv.ExpireNow()
+	}
+	paths := []path{
+		{"expire", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{OnExpire(func(string, interface{}) { ran <- goroutineID() })}
+		}, expire},
+		{"evict", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{MaxEntries(1), OnEvict(func(string, interface{}, EvictReason) { ran <- goroutineID() })}
+		}, func(kv *Store, clock *fakeClock, ran chan<- uint64) {
+			_ = kv.Put("a", 1)
+			_ = kv.Put("b", 2)
+		}},
+		{"bulk", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{OnBulkRemoval(func(string, int, []string) { ran <- goroutineID() })}
+		}, func(kv *Store, clock *fakeClock, ran chan<- uint64) {
+			_ = kv.Put("a", 1)
+			kv.DeleteByPrefix("a")
+		}},
+		{"missing", nil, func(kv *Store, clock *fakeClock, ran chan<- uint64) {
+			kv.ExpectWithin("k", time.Second, func(string) { ran <- goroutineID() })
+			clock.Advance(time.Second * 2)
+			kv.ExpireNow()
+		}},
+		{"soft", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{OnSoftExpire(func(string, interface{}) { ran <- goroutineID() })}
+		}, func(kv *Store, clock *fakeClock, ran chan<- uint64) {
+			_ = kv.Put("k", 1, SoftExpiresAfter(time.Second), ExpiresAfter(time.Hour))
+			clock.Advance(time.Second * 2)
+			kv.ExpireNow()
+		}},
+		{"cardinality", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{CardinalityAlarm(time.Hour, 1, func(int) { ran <- goroutineID() })}
+		}, func(kv *Store, clock *fakeClock, ran chan<- uint64) {
+			_ = kv.Put("a", 1)
+			_ = kv.Put("b", 2)
+		}},
+		{"idle", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{OnIdle(time.Minute, func() { ran <- goroutineID() })}
+		}, func(kv *Store, clock *fakeClock, ran chan<- uint64) {
+			clock.Advance(time.Minute * 2)
+		}},
+		{"retry", func(ran chan<- uint64) []StoreOption {
+			attempts := 0
+			return []StoreOption{
+				NotifyRetry(2, time.Millisecond),
+				OnExpireE(func(string, interface{}) error {
+					attempts++
+					if attempts == 1 {
+						return errors.New("down")
+					}
+					ran <- goroutineID()
+					return nil
+				})}
+		}, expire},
+		{"dead-letter", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{
+				NotifyRetry(2, time.Millisecond),
+				OnExpireE(func(string, interface{}) error { return errors.New("down") }),
+				OnNotifyDeadLetter(func(string, interface{}, error) { ran <- goroutineID() })}
+		}, expire},
+		{"archive", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{ArchiveOnExpire(func([]ArchivedEntry) error {
+				ran <- goroutineID()
+				return nil
+			})}
+		}, expire},
+		{"close", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{CloseOnRemoval()}
+		}, func(kv *Store, clock *fakeClock, ran chan<- uint64) {
+			_ = kv.Put("k", idCloser(ran))
+			kv.Delete("k")
+		}},
+		{"write-behind", func(ran chan<- uint64) []StoreOption {
+			return []StoreOption{WithBacking(idBacking{newFakeBacking(), ran}, WriteBehind)}
+		}, func(kv *Store, clock *fakeClock, ran chan<- uint64) {
+			_ = kv.Put("k", 1)
+		}},
+	}
+
+	for _, p := range paths {
+		p := p
+		t.Run(p.name+"/queued", func(t *testing.T) {
+			tasks := make(chan func(), 100)
+			worker := make(chan uint64, 1)
+			go func() {
+				worker <- goroutineID()
+				for task := range tasks {
+					time.Sleep(time.Millisecond)
+					task()
+				}
+			}()
+			defer close(tasks)
+			ran := make(chan uint64, 10)
+			clock := newFakeClock()
+			options := []StoreOption{Clock(clock.Now), Executor(func(task func()) { tasks <- task })}
+			if p.options != nil {
+				options = append(options, p.options(ran)...)
+			}
+			kv := NewStore(time.Millisecond, options...)
+			defer kv.Stop()
+
+			p.trigger(kv, clock, ran)
+			select {
+			case id := <-ran:
+				assert.Equal(t, <-worker, id)
+			case <-time.After(time.Second * 5):
+				t.Fatal("not run")
+			}
+			assert.True(t, kv.Config().Executor)
+		})
+		t.Run(p.name+"/inline", func(t *testing.T) {
+			ran := make(chan uint64, 10)
+			clock := newFakeClock()
+			options := []StoreOption{Clock(clock.Now), Executor(func(task func()) { task() })}
+			if p.options != nil {
+				options = append(options, p.options(ran)...)
+			}
+			kv := NewStore(time.Millisecond, options...)
+			defer kv.Stop()
+
+			p.trigger(kv, clock, ran)
+			select {
+			case <-ran:
+			case <-time.After(time.Second * 5):
+				t.Fatal("not run")
+			}
+			assert.NoError(t, kv.AwaitSweep(context.Background(), 1))
+		})
+	}
+}
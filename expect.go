@@ -0,0 +1,100 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// ExpectWithin expects k to be written (by Put, or any other write) within d
+// from now; otherwise onMissing is called with k, once, when the janitor (or
+// ExpireNow) finds the deadline passed. A write of k before the deadline
+// cancels the expectation, and so does Stop; Clear does not. The expectation
+// is a node of the timeout heap, not an entry, so it is invisible to Get,
+// Keys and the like. onMissing is called like expiration notifications (see
+// SynchronousNotifications), so it must be fast.
+func (kv *Store) ExpectWithin(k string, d time.Duration, onMissing func(k string)) {
+	kv.active()
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	to := &timeout{
+		expiresAt:  kv.now().Add(d),
+		key:        k,
+		index:      -1,
+		slidesLeft: -1,
+		expect:     &expectation{onMissing: onMissing},
+	}
+	timeheapPush(&kv.heap, to)
+	if kv.expectations == nil {
+		kv.expectations = make(map[string][]*timeout)
+	}
+	kv.expectations[k] = append(kv.expectations[k], to)
+}
+
+// expectation is the callback of a heap node of ExpectWithin
+type expectation struct {
+	onMissing func(k string)
+}
+
+// fulfill drops the expectations of k, on a write
+func (kv *Store) fulfill(k string) {
+	if len(kv.expectations) == 0 {
+		return
+	}
+	for _, to := range kv.expectations[k] {
+		to.stale = true
+		if to.index >= 0 && to.index < len(kv.heap) && kv.heap[to.index] == to {
+			timeheapRemove(&kv.heap, to.index)
+		}
+	}
+	delete(kv.expectations, k)
+}
+
+// missed takes out the expectation to, which passed its deadline
+func (kv *Store) missed(to *timeout) {
+	to.stale = true
+	tos := kv.expectations[to.key]
+	for i, t := range tos {
+		if t == to {
+			tos = append(tos[:i], tos[i+1:]...)
+			break
+		}
+	}
+	if len(tos) == 0 {
+		delete(kv.expectations, to.key)
+	} else {
+		kv.expectations[to.key] = tos
+	}
+}
+
+// dropAllExpectations drops the expectations, on Stop
+func (kv *Store) dropAllExpectations() {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	for k := range kv.expectations {
+		kv.fulfill(k)
+	}
+}
+
+// notifyDue notifies the expectations and soft deadlines taken out by a sweep
+func (kv *Store) notifyDue(due []*timeout) {
+	if len(due) == 0 {
+		return
+	}
+	notify := func() {
+		for _, to := range due {
+			to := to
+			try(func() error {
+				if to.soft != nil {
+					kv.onSoftExpire(to.key, to.soft.value)
+					return nil
+				}
+				to.expect.onMissing(to.key)
+				return nil
+			})
+		}
+	}
+	if kv.synchronousNotifications {
+		kv.deliverInline(notify)
+		return
+	}
+	kv.spawn(notify)
+}
@@ -0,0 +1,78 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpectWithinMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), SynchronousNotifications(), Debug())
+	defer kv.Stop()
+
+	var missing []string
+	onMissing := func(k string) { missing = append(missing, k) }
+	kv.ExpectWithin("producer:1", time.Minute, onMissing)
+	kv.ExpectWithin("producer:2", time.Minute*2, onMissing)
+	assert.NoError(kv.CheckInvariants())
+
+	// not an entry
+	_, ok := kv.Get("producer:1")
+	assert.False(ok)
+	assert.Empty(kv.Keys())
+	assert.Equal(0, kv.Stats().Entries)
+
+	clock.Advance(time.Second * 59)
+	kv.ExpireNow()
+	assert.Empty(missing)
+
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	assert.Equal([]string{"producer:1"}, missing)
+
+	// once
+	clock.Advance(time.Minute)
+	kv.ExpireNow()
+	assert.Equal([]string{"producer:1", "producer:2"}, missing)
+	clock.Advance(time.Hour)
+	kv.ExpireNow()
+	assert.Len(missing, 2)
+
+	// a write after the deadline does not matter anymore
+	kv.Put("producer:1", 1)
+	assert.Len(missing, 2)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestExpectWithinFulfilled(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), SynchronousNotifications(), Debug())
+	defer kv.Stop()
+
+	var missing []string
+	onMissing := func(k string) { missing = append(missing, k) }
+	kv.Put("producer:1", 0)
+	kv.ExpectWithin("producer:1", time.Minute, onMissing)
+	kv.ExpectWithin("producer:2", time.Minute, onMissing)
+	kv.ExpectWithin("producer:3", time.Minute, onMissing)
+
+	clock.Advance(time.Second * 30)
+	kv.Put("producer:1", 1, ExpiresAfter(time.Second))
+	_, err := kv.Append("producer:2", 1)
+	assert.NoError(err)
+	// Clear does not cancel expectations
+	kv.Clear()
+	assert.NoError(kv.CheckInvariants())
+
+	clock.Advance(time.Minute)
+	kv.ExpireNow()
+	assert.Equal([]string{"producer:3"}, missing)
+	assert.Equal(0, kv.Stats().Entries)
+	assert.NoError(kv.CheckInvariants())
+}
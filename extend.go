@@ -0,0 +1,63 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// ExtendTTL adds extend to the deadline of the entries with a timeout whose
+// keys match, and returns the number of entries extended; entries without a
+// timeout are skipped. With extendSliding, sliding entries (and ones with an
+// idle timeout) also get their window extended, so later slides keep the
+// extension. Like Report, the keys are scanned under the lock in chunks, so
+// other operations are not blocked for the whole scan. match is called under
+// the lock, so it must not use the store. It does nothing if extend is not
+// positive.
+func (kv *Store) ExtendTTL(match func(k string) bool, extend time.Duration, extendSliding bool) int {
+	kv.active()
+	if extend <= 0 {
+		return 0
+	}
+	keys := kv.keys()
+	count := 0
+	for len(keys) > 0 {
+		chunk := keys
+		if len(chunk) > reportChunk {
+			chunk = chunk[:reportChunk]
+		}
+		keys = keys[len(chunk):]
+
+		kv.mx.Lock()
+		for _, k := range chunk {
+			e, ok := kv.kv.get(k)
+			if !ok || e.timeout == nil || kv.expired(e) || !match(k) {
+				continue
+			}
+			kv.extend(k, e, extend, extendSliding)
+			count++
+		}
+		kv.mx.Unlock()
+	}
+	return count
+}
+
+// extend moves the deadline of e by d, under the lock
+func (kv *Store) extend(k string, e *entry, d time.Duration, extendSliding bool) {
+	to := e.timeout
+	to.expiresAt = to.expiresAt.Add(d)
+	if !to.deadline.IsZero() {
+		to.deadline = to.deadline.Add(d)
+	}
+	if extendSliding {
+		switch {
+		case to.idleAfter > 0:
+			to.idleAfter += d
+		case to.isSliding && to.expiresAfter > 0:
+			to.expiresAfter += d
+		}
+	}
+	if to.index >= 0 && to.index < len(kv.heap) && kv.heap[to.index] == to {
+		timeheapFix(&kv.heap, to.index)
+	}
+	kv.readInvalidate(k)
+	kv.walPut(k, e)
+}
@@ -0,0 +1,77 @@
+package tinykv
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtendTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	kv.Put("session:1", 1, ExpiresAfter(time.Minute))
+	kv.Put("session:2", 2, ExpiresAfter(time.Minute*2))
+	kv.Put("session:permanent", 3)
+	kv.Put("cache:1", 4, ExpiresAfter(time.Minute))
+
+	sessions := func(k string) bool { return strings.HasPrefix(k, "session:") }
+	assert.Equal(2, kv.ExtendTTL(sessions, time.Minute*30, false))
+	meta, _ := kv.GetMeta("session:1")
+	assert.Equal(time.Minute*31, meta.Remaining)
+	assert.NoError(kv.CheckInvariants())
+
+	// non-matching keys expire on their original schedule
+	clock.Advance(time.Minute + time.Second)
+	kv.ExpireNow()
+	assert.ElementsMatch([]string{"session:1", "session:2", "session:permanent"}, kv.Keys())
+
+	clock.Advance(time.Minute * 30)
+	kv.ExpireNow()
+	assert.ElementsMatch([]string{"session:2", "session:permanent"}, kv.Keys())
+	clock.Advance(time.Minute)
+	kv.ExpireNow()
+	assert.Equal([]string{"session:permanent"}, kv.Keys())
+
+	assert.Equal(0, kv.ExtendTTL(sessions, 0, false))
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestExtendTTLSliding(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	kv.Put("window", 1, ExpiresAfter(time.Minute), IsSliding(true))
+	kv.Put("deadline", 1, ExpiresAfter(time.Minute), IsSliding(true))
+	kv.Put("idle", 1, IdleTimeout(time.Minute))
+
+	all := func(string) bool { return true }
+	kv.ExtendTTL(func(k string) bool { return k != "deadline" }, time.Minute, true)
+	kv.ExtendTTL(func(k string) bool { return k == "deadline" }, time.Minute, false)
+	for _, k := range []string{"window", "deadline", "idle"} {
+		meta, _ := kv.GetMeta(k)
+		assert.Equal(time.Minute*2, meta.Remaining, k)
+	}
+
+	// a slide renews the extended window only with extendSliding
+	kv.Touch("window")
+	kv.Touch("deadline")
+	kv.Touch("idle")
+	meta, _ := kv.GetMeta("window")
+	assert.Equal(time.Minute*2, meta.Remaining)
+	meta, _ = kv.GetMeta("idle")
+	assert.Equal(time.Minute*2, meta.Remaining)
+	meta, _ = kv.GetMeta("deadline")
+	assert.Equal(time.Minute, meta.Remaining)
+
+	assert.Equal(3, kv.ExtendTTL(all, time.Second, false))
+	assert.NoError(kv.CheckInvariants())
+}
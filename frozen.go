@@ -0,0 +1,82 @@
+package tinykv
+
+import (
+	"sort"
+)
+
+// Frozen returns a KV that serves a copy of m, as a fixed read-only dataset:
+// entries never expire, and all changes fail with ErrReadOnly (or are
+// ignored, by Delete and Take).
+func Frozen(m map[string]interface{}) KV {
+	kv := frozenKV{m: make(map[string]interface{}, len(m))}
+	for k, v := range m {
+		kv.m[k] = v
+		kv.keys = append(kv.keys, k)
+	}
+	sort.Strings(kv.keys)
+	return kv
+}
+
+type frozenKV struct {
+	m    map[string]interface{}
+	keys []string // sorted
+}
+
+func (kv frozenKV) readOnly(op, k string) error {
+	return opError(op, k, ErrReadOnly)
+}
+
+// mutate returns the error of a change (op) of k
+func (kv frozenKV) mutate(op, k string) error {
+	if _, ok := kv.m[k]; !ok {
+		return opError(op, k, ErrNotFound)
+	}
+	return kv.readOnly(op, k)
+}
+
+func (kv frozenKV) CAS(k string, v interface{}, cond func(oldValue interface{}, found bool) bool, options ...PutOption) error {
+	return kv.readOnly("cas", k)
+}
+
+func (frozenKV) Delete(k string)           {}
+func (kv frozenKV) DeleteE(k string) error { return kv.mutate("delete", k) }
+
+func (kv frozenKV) Get(k string) (interface{}, bool) {
+	v, ok := kv.m[k]
+	return v, ok
+}
+
+func (kv frozenKV) GetE(k string) (interface{}, error) {
+	v, ok := kv.m[k]
+	if !ok {
+		return nil, opError("get", k, ErrNotFound)
+	}
+	return v, nil
+}
+
+// Keys returns the keys, sorted; the returned slice must not be modified
+func (kv frozenKV) Keys() []string { return kv.keys }
+
+func (kv frozenKV) Len() int { return len(kv.m) }
+
+func (kv frozenKV) Put(k string, v interface{}, options ...PutOption) error {
+	return kv.readOnly("put", k)
+}
+
+func (kv frozenKV) Range(fn func(k string, v interface{}) bool) {
+	for _, k := range kv.keys {
+		if !fn(k, kv.m[k]) {
+			return
+		}
+	}
+}
+
+func (frozenKV) Take(k string) (interface{}, bool)      { return nil, false }
+func (kv frozenKV) TakeE(k string) (interface{}, error) { return nil, kv.mutate("take", k) }
+
+func (kv frozenKV) Touch(k string) bool {
+	_, ok := kv.m[k]
+	return ok
+}
+
+func (frozenKV) Stop() {}
@@ -0,0 +1,21 @@
+package tinykv_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dc0d/tinykv"
+	"github.com/dc0d/tinykv/tinykvtest"
+)
+
+func FuzzModel(f *testing.F) {
+	for seed := int64(0); seed < 5; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		clock := tinykvtest.NewClock()
+		tinykvtest.ExerciseClock(t, clock, func() tinykv.KV {
+			return tinykv.NewStore(time.Hour, tinykv.Clock(clock.Now), tinykv.Debug())
+		}, 500, seed)
+	})
+}
@@ -0,0 +1,58 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// Grace keeps the entry for up to d after its deadline. Meanwhile it is
+// expired for all operations (Get misses it, Keys skips it), except GetGraced,
+// which still returns its value, flagged as expired; a Put replaces it as
+// usual. The entry is removed, and the expiration notifications are sent, at
+// the end of the grace period, not at the deadline. It has no effect on an
+// entry without a timeout.
+func Grace(d time.Duration) PutOption {
+	return func(opt *putOpt) {
+		opt.grace = d
+	}
+}
+
+// GetGraced is like Get, but also returns an entry in the grace period after
+// its deadline (see Grace), with expired set. It does not slide such an entry.
+func (kv *Store) GetGraced(k string) (v interface{}, expired bool, ok bool) {
+	kv.active()
+	kv.mx.Lock()
+	kv.activateDue(k)
+	if e, found := kv.kv.get(k); found && kv.inGrace(e) {
+		v := kv.copyValue(e.value)
+		kv.mx.Unlock()
+		return v, true, true
+	}
+	kv.mx.Unlock()
+	v, err := kv.get(k)
+	return v, false, err == nil
+}
+
+// removeAt is the time the node is due, its deadline plus the grace period
+func (to *timeout) removeAt() time.Time {
+	if to.grace <= 0 {
+		return to.expiresAt
+	}
+	return to.expiresAt.Add(to.grace)
+}
+
+// due reports if the node is past its deadline and its grace period, so the
+// entry is to be removed
+func (to *timeout) due(now time.Time) bool {
+	if to == nil {
+		return false
+	}
+	return now.After(to.removeAt())
+}
+
+// inGrace reports if e is expired, but in its grace period
+func (kv *Store) inGrace(e *entry) bool {
+	if e.timeout == nil || e.grace <= 0 || !kv.expired(e) {
+		return false
+	}
+	return !e.due(kv.now())
+}
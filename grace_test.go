@@ -0,0 +1,92 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrace(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var notified []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) { notified = append(notified, k) }),
+		Debug())
+	defer kv.Stop()
+
+	kv.Put("a", 1, ExpiresAfter(time.Second), Grace(time.Second*2))
+	kv.Put("b", 2, ExpiresAfter(time.Second*2))
+
+	// before the deadline, it is live
+	v, expired, ok := kv.GetGraced("a")
+	assert.Equal(1, v)
+	assert.False(expired)
+	assert.True(ok)
+
+	// after the deadline, only GetGraced has it, flagged
+	clock.Advance(time.Second + time.Millisecond)
+	_, ok = kv.Get("a")
+	assert.False(ok)
+	assert.NotContains(kv.Keys(), "a")
+	v, expired, ok = kv.GetGraced("a")
+	assert.Equal(1, v)
+	assert.True(expired)
+	assert.True(ok)
+	kv.ExpireNow()
+	assert.Empty(notified)
+	assert.Equal(2, kv.Len())
+
+	// the sweep goes by removal times: b, without grace, first
+	clock.Advance(time.Second)
+	kv.ExpireNow()
+	assert.Equal([]string{"b"}, notified)
+	_, expired, ok = kv.GetGraced("a")
+	assert.True(expired && ok)
+
+	// after the grace period, it is removed and notified
+	clock.Advance(time.Second)
+	kv.ExpireNow()
+	assert.Equal([]string{"b", "a"}, notified)
+	_, _, ok = kv.GetGraced("a")
+	assert.False(ok)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestGraceLazy(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var notified []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) { notified = append(notified, k) }))
+	defer kv.Stop()
+
+	kv.Put("a", 1, ExpiresAfter(time.Second), Grace(time.Second))
+	clock.Advance(time.Second * 2)
+	_, ok := kv.Get("a")
+	assert.False(ok)
+	assert.Empty(notified)
+
+	// a put replaces the graced entry
+	kv.Put("a", 2, ExpiresAfter(time.Second), Grace(time.Second))
+	v, expired, ok := kv.GetGraced("a")
+	assert.Equal(2, v)
+	assert.False(expired)
+	assert.True(ok)
+
+	clock.Advance(time.Second*2 + time.Millisecond)
+	_, _, ok = kv.GetGraced("a")
+	assert.False(ok)
+	assert.Equal([]string{"a"}, notified)
+
+	err := kv.Put("b", 1, ExpiresAfter(time.Second), Grace(-time.Second))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+}
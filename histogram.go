@@ -0,0 +1,49 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// ExpirationHistogram counts the live entries by when they expire: bucket i
+// counts the entries expiring in [i*bucket, (i+1)*bucket) from now, up to
+// horizon. Entries without a timeout are not counted, and sliding entries
+// are counted at their current deadline. The entries are scanned under the
+// lock, in chunks. It returns nil if bucket or horizon are not positive.
+func (kv *Store) ExpirationHistogram(bucket, horizon time.Duration) []int {
+	n := histogramBuckets(bucket, horizon)
+	if n == 0 {
+		return nil
+	}
+	histogram := make([]int, n)
+	now := kv.now()
+	keys := kv.keys()
+	for len(keys) > 0 {
+		chunk := keys
+		if len(chunk) > reportChunk {
+			chunk = chunk[:reportChunk]
+		}
+		keys = keys[len(chunk):]
+
+		kv.mx.Lock()
+		for _, k := range chunk {
+			e, ok := kv.kv.get(k)
+			if !ok || e.timeout == nil || kv.expired(e) {
+				continue
+			}
+			d := e.expiresAt.Sub(now)
+			if d < 0 || d >= horizon {
+				continue
+			}
+			histogram[d/bucket]++
+		}
+		kv.mx.Unlock()
+	}
+	return histogram
+}
+
+func histogramBuckets(bucket, horizon time.Duration) int {
+	if bucket <= 0 || horizon <= 0 {
+		return 0
+	}
+	return int((horizon + bucket - 1) / bucket)
+}
@@ -0,0 +1,40 @@
+package tinykv
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpirationHistogram(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	put := func(n int, ttl time.Duration, options ...PutOption) {
+		for i := 0; i < n; i++ {
+			kv.Put(ttl.String()+strconv.Itoa(i), i, append(options, ExpiresAfter(ttl))...)
+		}
+	}
+	put(3, time.Second*30)
+	put(2, time.Minute+time.Second)
+	put(reportChunk+1, time.Minute*5)
+	put(1, time.Minute*2, IsSliding(true))
+	put(4, time.Hour) // beyond the horizon
+	kv.Put("permanent", 0)
+
+	assert.Equal([]int{3, 2, 1, 0, 0, reportChunk + 1}, kv.ExpirationHistogram(time.Minute, time.Minute*6))
+
+	// sliding entries count at their current deadline
+	clock.Advance(time.Second * 45)
+	kv.Get((time.Minute * 2).String() + "0")
+	assert.Equal([]int{2, 0, 1, 0, reportChunk + 1, 0}, kv.ExpirationHistogram(time.Minute, time.Minute*6))
+
+	assert.Equal([]int{2 + 1 + reportChunk + 1, 4}, kv.ExpirationHistogram(time.Hour/2, time.Hour))
+	assert.Nil(kv.ExpirationHistogram(0, time.Hour))
+	assert.Len(kv.ExpirationHistogram(time.Minute, time.Minute*90), 90)
+}
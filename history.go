@@ -0,0 +1,97 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// MaxHistoryDepth is the largest depth History accepts
+const MaxHistoryDepth = 64
+
+// Revision is a value written to a key, see History
+type Revision struct {
+	Value     interface{}
+	WrittenAt time.Time
+	Seq       uint64 // like Meta.Seq
+}
+
+// History keeps the last depth values written to the key, the current one
+// included, for GetHistory; depth is from 1 to MaxHistoryDepth, otherwise the
+// Put fails with ErrInvalidOptions. The history is of the key, while it has
+// an entry: a Put without History keeps it, at the same depth, and a Put with
+// another depth resizes it. The removal of the entry (expired, deleted or
+// taken) drops it. It holds the values, so it costs up to depth values per
+// entry.
+func History(depth int) PutOption {
+	return func(opt *putOpt) {
+		opt.historyDepth = depth
+		opt.hasHistory = true
+	}
+}
+
+// history is a ring of the last revisions of an entry
+type history struct {
+	revs []Revision
+	next int // where the next revision goes, once revs is full
+}
+
+func newHistory(depth int) *history {
+	return &history{revs: make([]Revision, 0, depth)}
+}
+
+func (h *history) push(r Revision) {
+	if len(h.revs) < cap(h.revs) {
+		h.revs = append(h.revs, r)
+		return
+	}
+	h.revs[h.next] = r
+	h.next = (h.next + 1) % len(h.revs)
+}
+
+// list returns the revisions, oldest first
+func (h *history) list() []Revision {
+	list := make([]Revision, 0, len(h.revs))
+	list = append(list, h.revs[h.next:]...)
+	return append(list, h.revs[:h.next]...)
+}
+
+// carryHistory returns the history of an entry replaced by one of fresh
+// history (nil without History): the one of the old entry, resized to the
+// depth of fresh
+func carryHistory(old, fresh *history) *history {
+	if old == nil {
+		return fresh
+	}
+	if fresh == nil {
+		return old
+	}
+	for _, r := range old.list() {
+		fresh.push(r)
+	}
+	return fresh
+}
+
+// recordHistory adds the value of e, just written, to its history
+func (kv *Store) recordHistory(e *entry) {
+	if e.history == nil {
+		return
+	}
+	e.history.push(Revision{Value: e.value, WrittenAt: kv.now(), Seq: e.seq})
+}
+
+// GetHistory returns the last values written to k, oldest first, the current
+// one last, if the entry for k was put with History; otherwise it returns
+// nil. It does not slide the entry.
+func (kv *Store) GetHistory(k string) []Revision {
+	kv.active()
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	e, ok := kv.kv.get(k)
+	if !ok || e.history == nil || kv.expired(e) {
+		return nil
+	}
+	list := e.history.list()
+	for i := range list {
+		list[i].Value = kv.copyValue(list[i].Value)
+	}
+	return list
+}
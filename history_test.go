@@ -0,0 +1,76 @@
+package tinykv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	assert.Nil(kv.GetHistory("k"))
+	for i := 1; i <= 5; i++ {
+		clock.Advance(time.Second)
+		assert.NoError(kv.Put("k", i, History(3)))
+	}
+	history := kv.GetHistory("k")
+	assert.Len(history, 3)
+	for i, r := range history {
+		assert.Equal(i+3, r.Value)
+		assert.WithinDuration(newFakeClock().Now().Add(time.Second*time.Duration(i+3)), r.WrittenAt, 0)
+		if i > 0 {
+			assert.True(r.Seq > history[i-1].Seq)
+		}
+	}
+	meta, _ := kv.GetMeta("k")
+	assert.Equal(meta.Seq, history[2].Seq)
+
+	// kept by a Put without History, and resized by one with another depth
+	assert.NoError(kv.Put("k", 6))
+	assert.Equal([]interface{}{4, 5, 6}, historyValues(kv.GetHistory("k")))
+	assert.NoError(kv.Put("k", 7, History(2)))
+	assert.Equal([]interface{}{6, 7}, historyValues(kv.GetHistory("k")))
+	assert.NoError(kv.CAS("k", 8, func(interface{}, bool) bool { return true }, History(4)))
+	assert.NoError(kv.Put("k", 9))
+	assert.Equal([]interface{}{6, 7, 8, 9}, historyValues(kv.GetHistory("k")))
+
+	// dropped by the removal
+	kv.Delete("k")
+	assert.NoError(kv.Put("k", 10))
+	assert.Nil(kv.GetHistory("k"))
+	assert.NoError(kv.Put("k", 11, History(2), ExpiresAfter(time.Second)))
+	clock.Advance(time.Second * 2)
+	assert.Nil(kv.GetHistory("k"))
+	assert.NoError(kv.Put("k", 12))
+	assert.Nil(kv.GetHistory("k"))
+}
+
+func TestHistoryDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+
+	for _, depth := range []int{0, -1, MaxHistoryDepth + 1} {
+		err := kv.Put("k", 1, History(depth))
+		assert.Equal(ErrInvalidOptions, errors.Cause(err), fmt.Sprint(depth))
+	}
+	assert.NoError(kv.Put("k", 1, History(MaxHistoryDepth)))
+	assert.Len(kv.GetHistory("k"), 1)
+}
+
+func historyValues(history []Revision) []interface{} {
+	var values []interface{}
+	for _, r := range history {
+		values = append(values, r.Value)
+	}
+	return values
+}
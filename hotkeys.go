@@ -0,0 +1,156 @@
+package tinykv
+
+import (
+	"sort"
+)
+
+// KeyCount is the estimated number of hits of a key, see TrackHotKeys
+type KeyCount struct {
+	Key   string
+	Count int64 // estimated hits, never less than the actual hits
+	Error int64 // max overestimation: actual hits are in [Count-Error, Count]
+}
+
+// TrackHotKeys makes the store track the k most read keys (hits of Get and
+// GetE), using the space-saving algorithm: memory is O(k) and each hit is
+// O(1), under the lock. Every key with more than N/k hits (N is the total
+// number of hits) is reported by HotKeys, and the Count of a key overestimates
+// its hits by at most Error, which is itself at most N/k. It turns the lock
+// free Get of ReadOptimized off, since hits are counted under the lock.
+func TrackHotKeys(k int) StoreOption {
+	return func(opt *storeOpt) {
+		opt.hotKeysK = k
+	}
+}
+
+// HotKeys returns the tracked keys, by descending estimated hits. Without
+// TrackHotKeys, it returns nil.
+func (kv *Store) HotKeys() []KeyCount {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if kv.hotKeys == nil {
+		return nil
+	}
+	return kv.hotKeys.top()
+}
+
+//-----------------------------------------------------------------------------
+
+// hotKeys is a stream-summary: buckets of counters with the same count, in a
+// list ordered by count, so a hit moves a counter to the next bucket in O(1)
+type hotKeys struct {
+	k        int
+	counters map[string]*hotCounter
+	min      *hotBucket
+}
+
+type hotBucket struct {
+	count      int64
+	prev, next *hotBucket
+	head       *hotCounter
+}
+
+type hotCounter struct {
+	key        string
+	err        int64
+	bucket     *hotBucket
+	prev, next *hotCounter
+}
+
+func newHotKeys(k int) *hotKeys {
+	if k < 1 {
+		k = 1
+	}
+	return &hotKeys{k: k, counters: make(map[string]*hotCounter, k)}
+}
+
+func (hk *hotKeys) hit(k string) {
+	if hk == nil {
+		return
+	}
+	c := hk.counters[k]
+	switch {
+	case c != nil:
+		hk.increment(c)
+	case len(hk.counters) < hk.k:
+		c = &hotCounter{key: k}
+		b := hk.min
+		if b == nil || b.count != 1 {
+			b = &hotBucket{count: 1, next: hk.min}
+			if hk.min != nil {
+				hk.min.prev = b
+			}
+			hk.min = b
+		}
+		b.attach(c)
+		hk.counters[k] = c
+	default:
+		// replace a key with the min count, k inherits it as its error
+		c = hk.min.head
+		delete(hk.counters, c.key)
+		c.key = k
+		c.err = hk.min.count
+		hk.counters[k] = c
+		hk.increment(c)
+	}
+}
+
+func (hk *hotKeys) increment(c *hotCounter) {
+	b := c.bucket
+	next := b.next
+	if next == nil || next.count != b.count+1 {
+		next = &hotBucket{count: b.count + 1, prev: b, next: b.next}
+		if b.next != nil {
+			b.next.prev = next
+		}
+		b.next = next
+	}
+	b.detach(c)
+	if b.head == nil {
+		if b.prev == nil {
+			hk.min = b.next
+		} else {
+			b.prev.next = b.next
+		}
+		b.next.prev = b.prev
+	}
+	next.attach(c)
+}
+
+func (hk *hotKeys) top() []KeyCount {
+	res := make([]KeyCount, 0, len(hk.counters))
+	for b := hk.min; b != nil; b = b.next {
+		for c := b.head; c != nil; c = c.next {
+			res = append(res, KeyCount{Key: c.key, Count: b.count, Error: c.err})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+func (b *hotBucket) attach(c *hotCounter) {
+	c.bucket = b
+	c.prev = nil
+	c.next = b.head
+	if b.head != nil {
+		b.head.prev = c
+	}
+	b.head = c
+}
+
+func (b *hotBucket) detach(c *hotCounter) {
+	if c.prev != nil {
+		c.prev.next = c.next
+	} else {
+		b.head = c.next
+	}
+	if c.next != nil {
+		c.next.prev = c.prev
+	}
+	c.prev, c.next, c.bucket = nil, nil, nil
+}
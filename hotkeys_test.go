@@ -0,0 +1,93 @@
+package tinykv
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, TrackHotKeys(2))
+	defer kv.Stop()
+	assert.Nil(NewStore(time.Hour).HotKeys())
+
+	kv.Put("a", 1)
+	kv.Put("b", 2)
+	kv.Put("c", 3)
+	for i := 0; i < 3; i++ {
+		kv.Get("a")
+	}
+	kv.Get("b")
+	kv.Get("missing") // misses are not counted
+	kv.Get("c")       // replaces b, inheriting its count as error
+
+	assert.Equal([]KeyCount{
+		{Key: "a", Count: 3},
+		{Key: "c", Count: 2, Error: 1},
+	}, kv.HotKeys())
+}
+
+func TestHotKeysZipf(t *testing.T) {
+	assert := assert.New(t)
+
+	const (
+		keys = 10000
+		hits = 200000
+		k    = 100
+		top  = 10
+	)
+	kv := NewStore(time.Hour, TrackHotKeys(k), ReadOptimized())
+	defer kv.Stop()
+	for i := 0; i < keys; i++ {
+		kv.Put(strconv.Itoa(i), i)
+	}
+
+	actual := make(map[string]int64)
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, keys-1)
+	for i := 0; i < hits; i++ {
+		key := strconv.Itoa(int(zipf.Uint64()))
+		kv.Get(key)
+		actual[key]++
+	}
+
+	hot := kv.HotKeys()
+	assert.Len(hot, k)
+	reported := make(map[string]bool)
+	for i, kc := range hot {
+		reported[kc.Key] = true
+		if i > 0 {
+			assert.True(hot[i-1].Count >= kc.Count)
+		}
+		assert.True(kc.Count-kc.Error <= actual[kc.Key], kc)
+		assert.True(actual[kc.Key] <= kc.Count, kc)
+		assert.True(kc.Error <= hits/k, kc)
+	}
+	// with s=1.1, the first keys are the most frequent
+	for i := 0; i < top; i++ {
+		assert.True(reported[strconv.Itoa(i)], i)
+	}
+	for key, n := range actual {
+		if n > hits/k {
+			assert.True(reported[key], key)
+		}
+	}
+}
+
+func BenchmarkHotKeysHit(b *testing.B) {
+	hk := newHotKeys(100)
+	keys := make([]string, 10000)
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, uint64(len(keys)-1))
+	for i := range keys {
+		keys[i] = strconv.Itoa(int(zipf.Uint64()))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hk.hit(keys[i%len(keys)])
+	}
+}
@@ -0,0 +1,49 @@
+package tinykv
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// OnIdle sets fn to be called, by a goroutine of its own (see Executor), when
+// the store has been idle for idleFor: no operation on its entries, from Get
+// and Put to Keys, Range, the list, set, window and lease operations, and the
+// subscriptions (the janitor does not count, nor do Stats, Config, Report and
+// the other methods that only look at the store). It is called once per idle
+// period: after an operation, it is called again when the store is idle
+// again. The store is checked by the janitor, after each sweep, so fn may be
+// called up to an expiration interval late. It can be used to tear down
+// stores that are no longer used.
+func OnIdle(idleFor time.Duration, fn func()) StoreOption {
+	return func(opt *storeOpt) {
+		opt.idleFor = idleFor
+		opt.onIdle = fn
+	}
+}
+
+// active records an operation, for OnIdle, and checks it, see Strict
+func (kv *Store) active() {
+	kv.checkUse()
+	if kv.onIdle != nil {
+		atomic.StoreInt64(&kv.lastActive, kv.now().UnixNano())
+	}
+}
+
+// checkIdle calls the OnIdle function, if the store is idle, once per idle
+// period; it is called by the janitor only
+func (kv *Store) checkIdle() {
+	if kv.onIdle == nil {
+		return
+	}
+	last := atomic.LoadInt64(&kv.lastActive)
+	if last == kv.idleNotified || kv.now().Sub(time.Unix(0, last)) <= kv.idleFor {
+		return
+	}
+	kv.idleNotified = last
+	kv.spawn(func() {
+		try(func() error {
+			kv.onIdle()
+			return nil
+		})
+	})
+}
@@ -0,0 +1,106 @@
+package tinykv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnIdle(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	idle := make(chan struct{}, 10)
+	kv := NewStore(time.Millisecond,
+		Clock(clock.Now),
+		OnIdle(time.Minute, func() { idle <- struct{}{} }))
+	defer kv.Stop()
+
+	awaitIdle := func() {
+		select {
+		case <-idle:
+		case <-time.After(time.Second * 5):
+			t.Fatal("OnIdle not called")
+		}
+	}
+	notIdle := func() {
+		assert.NoError(kv.AwaitSweep(context.Background(), 2))
+		select {
+		case <-idle:
+			t.Fatal("OnIdle called")
+		default:
+		}
+	}
+
+	assert.NoError(kv.Put("k", 1))
+	clock.Advance(time.Second * 59)
+	_, _ = kv.Get("k")
+	clock.Advance(time.Second * 59)
+	notIdle()
+
+	clock.Advance(time.Second * 2)
+	awaitIdle()
+	// once per idle period
+	clock.Advance(time.Hour)
+	notIdle()
+
+	// activity re-arms it
+	kv.Delete("k")
+	notIdle()
+	clock.Advance(time.Minute * 2)
+	awaitIdle()
+	assert.Equal(time.Minute, kv.Config().IdleFor)
+}
+
+func TestOnIdleOperations(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	idle := make(chan struct{}, 10)
+	kv := NewStore(time.Millisecond,
+		Clock(clock.Now),
+		OnIdle(time.Minute, func() { idle <- struct{}{} }))
+	defer kv.Stop()
+
+	ops := map[string]func(){
+		"keys":   func() { kv.Keys() },
+		"range":  func() { kv.Range(func(string, interface{}) bool { return true }) },
+		"touch":  func() { kv.Touch("k") },
+		"append": func() { _, _ = kv.Append("list", 1) },
+		"set":    func() { _, _ = kv.AddToSet("set", 1) },
+		"window": func() { _, _, _, _ = kv.IncrWindow("window", time.Second, 10) },
+		"lease":  func() { _, _, _ = kv.AcquireLease("lease", "me", time.Hour) },
+		"seen":   func() { _, _ = kv.SeenRecently("seen", time.Second) },
+		"pop":    func() { kv.PopSoonest() },
+		"drain":  func() { kv.Drain("list") },
+		"multi-cas": func() {
+			_ = kv.MultiCAS([]CASOp{{Key: "m", NewValue: 1, Cond: func(interface{}, bool) bool { return true }}})
+		},
+	}
+	// each one is 59 seconds after the one before, and checked 59 seconds
+	// after it
+	assert.NoError(kv.Put("k", 1))
+	clock.Advance(time.Second * 59)
+	for name, op := range ops {
+		op()
+		clock.Advance(time.Second * 59)
+		assert.NoError(kv.AwaitSweep(context.Background(), 2))
+		select {
+		case <-idle:
+			t.Fatalf("%s does not count as an operation", name)
+		default:
+		}
+	}
+
+	// looking at the store is not using it
+	kv.Stats()
+	kv.Config()
+	clock.Advance(time.Second * 2)
+	select {
+	case <-idle:
+	case <-time.After(time.Second * 5):
+		t.Fatal("OnIdle not called")
+	}
+}
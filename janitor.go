@@ -0,0 +1,136 @@
+package tinykv
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// unhealthyAfter is the number of expiration intervals without a sweep,
+// after which the janitor is considered dead or stuck
+const unhealthyAfter = 3
+
+// OnPanic is called with the recovered panic, when a sweep of the expiration
+// loop panics, or the WAL writer panics while writing the records of the
+// mutations other than puts (like the removals of expired entries). The
+// loops keep running; the entries of the failed sweep are retried on the
+// next one, and the failed write is returned by the next Put.
+func OnPanic(onPanic func(err error)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onPanic = onPanic
+	}
+}
+
+// OnSweep sets the function that is called after each sweep of the expiration
+// loop, with the number of entries it expired and how long it took. It is
+// called by the loop, so it must be fast. The store is not sharded, so shard
+// is always 0.
+func OnSweep(onSweep func(shard, expired int, took time.Duration)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onSweep = onSweep
+	}
+}
+
+// Healthy returns ErrUnhealthy if the store is stopped, or if the expiration
+// loop has not completed a sweep within the last few expiration intervals
+// (it is stuck, like in a blocking synchronous notification). It can be used
+// for readiness probes.
+func (kv *Store) Healthy() error {
+	select {
+	case <-kv.stop:
+		return errors.Wrap(ErrUnhealthy, "store is stopped")
+	default:
+	}
+	limit := kv.getExpirationInterval() * unhealthyAfter
+	kv.mx.Lock()
+	since := kv.preciseNow().Sub(kv.lastTick)
+	kv.mx.Unlock()
+	if since > limit {
+		return errors.Wrapf(ErrUnhealthy, "no sweep for %v", since)
+	}
+	return nil
+}
+
+// sweep is one run of the expiration loop; a panic is recovered and reported
+// to the OnPanic hook. It returns the time until the next expiration.
+func (kv *Store) sweep() time.Duration {
+	var interval time.Duration
+	var expiredCount int
+	start := kv.preciseNow()
+	err := try(func() error {
+		var expired map[string]*entry
+		var due []*timeout
+		interval, expired, due = kv.expireFunc()
+		expiredCount = len(expired)
+		kv.notify(expired)
+		kv.notifyDue(due)
+		if kv.shouldCompact() {
+			kv.Compact()
+		}
+		kv.checkIdle()
+		kv.scrub()
+		kv.checkLag()
+		return nil
+	})
+	if err != nil && kv.onPanic != nil {
+		try(func() error {
+			kv.onPanic(err)
+			return nil
+		})
+	}
+	if kv.onSweep != nil {
+		took := kv.preciseNow().Sub(start)
+		try(func() error {
+			kv.onSweep(0, expiredCount, took)
+			return nil
+		})
+	}
+	kv.mx.Lock()
+	kv.lastTick = kv.preciseNow()
+	kv.countSweep()
+	kv.mx.Unlock()
+	return interval
+}
+
+// JanitorStatus reports when the last sweep of the expiration loop happened
+// and how long it took, when the next one is scheduled, and the backlog:
+// the number of entries already past their deadline.
+func (kv *Store) JanitorStatus() (time.Time, time.Duration, time.Time, int) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	return kv.lastSweep, kv.lastSweepDuration, kv.nextSweep, kv.backlog()
+}
+
+// KickJanitor wakes the expiration loop up, to sweep immediately
+func (kv *Store) KickJanitor() {
+	select {
+	case kv.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (kv *Store) setNextSweep(interval time.Duration) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	kv.nextSweep = kv.preciseNow().Add(interval)
+}
+
+// backlog counts the heap nodes past their deadline; the subtree of
+// a node that is not expired, has no expired nodes.
+func (kv *Store) backlog() int {
+	now := kv.now()
+	count := 0
+	var walk func(i int)
+	walk = func(i int) {
+		if i >= len(kv.heap) || !kv.heap[i].due(now) {
+			return
+		}
+		if !kv.heap[i].stale && kv.heap[i].isEntry() {
+			count++
+		}
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+	return count
+}
@@ -0,0 +1,165 @@
+package tinykv
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJanitorStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	lastSweep, _, nextSweep, backlog := kv.JanitorStatus()
+	assert.True(lastSweep.IsZero())
+	assert.True(nextSweep.After(clock.Now().Add(time.Minute * 59)))
+	assert.Equal(0, backlog)
+
+	for i := 0; i < 10; i++ {
+		kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Second*time.Duration(i+1)))
+	}
+	kv.Put("10", 10, ExpiresAfter(time.Second))
+	kv.Put("10", 10) // stale heap node, not counted
+	kv.PauseExpiration()
+	clock.Advance(time.Second*5 + time.Millisecond)
+	_, _, _, backlog = kv.JanitorStatus()
+	assert.Equal(5, backlog)
+
+	kv.KickJanitor() // paused, nothing happens
+	<-time.After(time.Millisecond * 20)
+	_, _, _, backlog = kv.JanitorStatus()
+	assert.Equal(5, backlog)
+
+	kv.ResumeExpiration()
+	clock.Advance(time.Second * 2)
+	<-time.After(time.Millisecond * 20)
+	lastSweep, _, _, backlog = kv.JanitorStatus()
+	assert.Equal(clock.Now(), lastSweep)
+	assert.Equal(0, backlog)
+
+	clock.Advance(time.Second * 10)
+	_, _, _, backlog = kv.JanitorStatus()
+	assert.Equal(3, backlog)
+	kv.KickJanitor()
+	for i := 0; i < 100; i++ {
+		if _, _, _, backlog = kv.JanitorStatus(); backlog == 0 {
+			break
+		}
+		<-time.After(time.Millisecond)
+	}
+	assert.Equal(0, backlog)
+	_, ok := kv.Get("10")
+	assert.True(ok)
+}
+
+// panicWriter panics while armed
+type panicWriter struct{ armed int32 }
+
+func (w *panicWriter) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&w.armed) != 0 {
+		panic("disk on fire")
+	}
+	return len(p), nil
+}
+
+func TestJanitorSurvivesPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	w := &panicWriter{}
+	panics := make(chan error, 10)
+	kv := NewStore(time.Millisecond*10,
+		WAL(w, intCodec{}),
+		Debug(),
+		OnPanic(func(err error) { panics <- err }))
+	defer kv.Stop()
+
+	for i := 0; i < 3; i++ {
+		kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Millisecond*20))
+	}
+	atomic.StoreInt32(&w.armed, 1)
+	select {
+	case err := <-panics:
+		assert.Contains(err.Error(), "disk on fire")
+	case <-time.After(time.Second):
+		t.Fatal("no panic reported")
+	}
+	assert.NoError(kv.CheckInvariants())
+	assert.NoError(kv.Healthy())
+
+	// the loop is still alive, and expires the rest once the writer works
+	atomic.StoreInt32(&w.armed, 0)
+	deadline := time.Now().Add(time.Second)
+	for len(kv.Keys()) > 0 && time.Now().Before(deadline) {
+		<-time.After(time.Millisecond * 5)
+	}
+	assert.Empty(kv.Keys())
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestHealthy(t *testing.T) {
+	assert := assert.New(t)
+
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	kv := NewStore(time.Millisecond*10,
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) {
+			close(blocked)
+			<-release
+		}))
+	defer kv.Stop()
+	assert.NoError(kv.Healthy())
+
+	kv.Put("1", 1, ExpiresAfter(time.Millisecond))
+	<-blocked
+	<-time.After(time.Millisecond * 50)
+	assert.Equal(ErrUnhealthy, errors.Cause(kv.Healthy()))
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for kv.Healthy() != nil && time.Now().Before(deadline) {
+		<-time.After(time.Millisecond * 5)
+	}
+	assert.NoError(kv.Healthy())
+
+	kv.Stop()
+	assert.Equal(ErrUnhealthy, errors.Cause(kv.Healthy()))
+}
+
+func TestOnSweep(t *testing.T) {
+	assert := assert.New(t)
+
+	type sweep struct{ shard, expired int }
+	sweeps := make(chan sweep, 100)
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), OnSweep(func(shard, expired int, took time.Duration) {
+		assert.True(took >= 0)
+		sweeps <- sweep{shard, expired}
+	}))
+	defer kv.Stop()
+	assert.True(kv.Config().OnSweep)
+
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	kv.Put("b", 2, ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+	kv.KickJanitor()
+	select {
+	case s := <-sweeps:
+		assert.Equal(sweep{0, 2}, s)
+	case <-time.After(time.Second):
+		t.Fatal("no sweep")
+	}
+
+	// ExpireNow is not a sweep of the loop
+	kv.Put("c", 3, ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	assert.Len(sweeps, 0)
+}
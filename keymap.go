@@ -0,0 +1,218 @@
+package tinykv
+
+// KeyMap holds the entries of a store by key, in place of the built-in Go
+// map (see WithKeyMap). The values are opaque to it. The store calls it under
+// its lock only, so it need not be safe for concurrent use. Range stops when
+// fn returns false; like ranging over a Go map, fn may Delete the key it is
+// called for, or Set a key that is already there.
+type KeyMap interface {
+	Get(k string) (v interface{}, ok bool)
+	Set(k string, v interface{})
+	Delete(k string)
+	Len() int
+	Range(fn func(k string, v interface{}) bool)
+}
+
+// WithKeyMap sets the function that creates the maps of the entries of the
+// store, for a structure other than the built-in Go map; it is called again
+// by Clear and Compact, for a fresh map.
+func WithKeyMap(factory func() KeyMap) StoreOption {
+	return func(opt *storeOpt) {
+		opt.keyMapFactory = factory
+	}
+}
+
+// keyMap is the map of the entries of the store
+type keyMap interface {
+	get(k string) (*entry, bool)
+	set(k string, e *entry)
+	delete(k string)
+	len() int
+	each(fn func(k string, e *entry) bool)
+}
+
+// newKeyMap creates an empty map of the entries, sized for n entries if it
+// is a Go map
+func (kv *Store) newKeyMap(n int) keyMap {
+	if kv.keyMapFactory != nil {
+		return customKeyMap{kv.keyMapFactory()}
+	}
+	if kv.stringValues {
+		return make(stringKeyMap, n)
+	}
+	return make(goKeyMap, n)
+}
+
+// goKeyMap is the built-in map of the entries
+type goKeyMap map[string]*entry
+
+func (m goKeyMap) get(k string) (*entry, bool) {
+	e, ok := m[k]
+	return e, ok
+}
+
+func (m goKeyMap) set(k string, e *entry) { m[k] = e }
+func (m goKeyMap) delete(k string)        { delete(m, k) }
+func (m goKeyMap) len() int               { return len(m) }
+
+func (m goKeyMap) each(fn func(k string, e *entry) bool) {
+	for k, e := range m {
+		if !fn(k, e) {
+			return
+		}
+	}
+}
+
+// stringEntry is an entry of a StringValues store, with its value unboxed
+type stringEntry struct {
+	*entry
+	s string
+}
+
+// stringKeyMap is the map of the entries of a StringValues store, for
+// GetString to read the values without an interface conversion. The strings
+// are set by set, so an in-place change of a value must set the entry again,
+// see modified.
+type stringKeyMap map[string]stringEntry
+
+func (m stringKeyMap) get(k string) (*entry, bool) {
+	se, ok := m[k]
+	return se.entry, ok
+}
+
+func (m stringKeyMap) set(k string, e *entry) {
+	s, _ := e.value.(string)
+	m[k] = stringEntry{e, s}
+}
+
+func (m stringKeyMap) delete(k string) { delete(m, k) }
+func (m stringKeyMap) len() int        { return len(m) }
+
+func (m stringKeyMap) each(fn func(k string, e *entry) bool) {
+	for k, se := range m {
+		if !fn(k, se.entry) {
+			return
+		}
+	}
+}
+
+// customKeyMap is a KeyMap of WithKeyMap
+type customKeyMap struct {
+	m KeyMap
+}
+
+func (m customKeyMap) get(k string) (*entry, bool) {
+	v, ok := m.m.Get(k)
+	if !ok {
+		return nil, false
+	}
+	return v.(*entry), true
+}
+
+func (m customKeyMap) set(k string, e *entry) { m.m.Set(k, e) }
+func (m customKeyMap) delete(k string)        { m.m.Delete(k) }
+func (m customKeyMap) len() int               { return m.m.Len() }
+
+func (m customKeyMap) each(fn func(k string, e *entry) bool) {
+	m.m.Range(func(k string, v interface{}) bool {
+		return fn(k, v.(*entry))
+	})
+}
+
+//-----------------------------------------------------------------------------
+
+// ShardedKeyMap is a KeyMap that spreads the keys over a number of Go maps,
+// by the hash of the key, so none of them grows too large
+type ShardedKeyMap struct {
+	shards []map[string]interface{}
+	n      int
+	hash   func(key string) uint64
+}
+
+// ShardedKeyMapOption is an option of NewShardedKeyMap
+type ShardedKeyMapOption func(*ShardedKeyMap)
+
+// ShardHash sets the hash that places the keys: key goes to the shard
+// hash(key) % shards. The default is 64-bit FNV-1a, so the placement is the
+// same across runs and processes (unlike the hash of Go maps, seeded per
+// process); a custom hash must be deterministic too. The hash and the number
+// of shards are fixed at construction.
+func ShardHash(hash func(key string) uint64) ShardedKeyMapOption {
+	return func(m *ShardedKeyMap) {
+		m.hash = hash
+	}
+}
+
+// NewShardedKeyMap creates a *ShardedKeyMap of the given number of shards,
+// at least one
+func NewShardedKeyMap(shards int, options ...ShardedKeyMapOption) *ShardedKeyMap {
+	if shards < 1 {
+		shards = 1
+	}
+	m := &ShardedKeyMap{shards: make([]map[string]interface{}, shards), hash: fnv1a}
+	for _, opt := range options {
+		opt(m)
+	}
+	for i := range m.shards {
+		m.shards[i] = make(map[string]interface{})
+	}
+	return m
+}
+
+// ShardFor returns the shard that holds k (or would hold it), from 0 to the
+// number of shards minus one, so tools can predict the placement of keys
+func (m *ShardedKeyMap) ShardFor(k string) int {
+	return int(m.hash(k) % uint64(len(m.shards)))
+}
+
+func (m *ShardedKeyMap) shard(k string) map[string]interface{} {
+	return m.shards[m.ShardFor(k)]
+}
+
+// Get returns the value for k
+func (m *ShardedKeyMap) Get(k string) (v interface{}, ok bool) {
+	v, ok = m.shard(k)[k]
+	return
+}
+
+// Set sets the value for k
+func (m *ShardedKeyMap) Set(k string, v interface{}) {
+	s := m.shard(k)
+	if _, ok := s[k]; !ok {
+		m.n++
+	}
+	s[k] = v
+}
+
+// Delete deletes the value for k
+func (m *ShardedKeyMap) Delete(k string) {
+	s := m.shard(k)
+	if _, ok := s[k]; ok {
+		m.n--
+		delete(s, k)
+	}
+}
+
+// Len returns the number of keys
+func (m *ShardedKeyMap) Len() int { return m.n }
+
+// Range calls fn for each key, shard by shard, until it returns false
+func (m *ShardedKeyMap) Range(fn func(k string, v interface{}) bool) {
+	for _, s := range m.shards {
+		for k, v := range s {
+			if !fn(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// fnv1a is the 64-bit FNV-1a hash of k
+func fnv1a(k string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(k); i++ {
+		h ^= uint64(k[i])
+		h *= 1099511628211
+	}
+	return h
+}
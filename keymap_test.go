@@ -0,0 +1,127 @@
+package tinykv
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedKeyMap(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewShardedKeyMap(4)
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	m.Set("0", "zero")
+	assert.Equal(100, m.Len())
+	v, ok := m.Get("0")
+	assert.True(ok)
+	assert.Equal("zero", v)
+
+	// deleting while ranging
+	m.Range(func(k string, v interface{}) bool {
+		if n, err := strconv.Atoi(k); err == nil && n%2 == 1 {
+			m.Delete(k)
+		}
+		return true
+	})
+	m.Delete("missing")
+	assert.Equal(50, m.Len())
+	_, ok = m.Get("1")
+	assert.False(ok)
+
+	visited := 0
+	m.Range(func(string, interface{}) bool {
+		visited++
+		return visited < 10
+	})
+	assert.Equal(10, visited)
+	assert.Equal(1, len(NewShardedKeyMap(0).shards))
+}
+
+func TestShardFor(t *testing.T) {
+	assert := assert.New(t)
+
+	// 64-bit FNV-1a, modulo the number of shards
+	m := NewShardedKeyMap(8)
+	for k, shard := range map[string]int{
+		"":           5, // 0xcbf29ce484222325
+		"a":          4, // 0xaf63dc4c8601ec8c
+		"foobar":     0, // 0x85944171f73967e8
+		"user:1":     3, // 0xf7fd9aaa75081ceb
+		"user:2":     6, // 0xf7fd9baa75081e9e
+		"session:42": 1, // 0x20d5bc66f848db91
+	} {
+		assert.Equal(shard, m.ShardFor(k), "key %q", k)
+		m.Set(k, shard)
+		_, ok := m.shards[shard][k]
+		assert.True(ok, "key %q", k)
+	}
+	for i := 0; i < 100; i++ {
+		k := strconv.Itoa(i)
+		h := fnv.New64a()
+		h.Write([]byte(k))
+		assert.Equal(int(h.Sum64()%8), m.ShardFor(k))
+	}
+	assert.Equal(0, NewShardedKeyMap(1).ShardFor("a"))
+
+	byLength := NewShardedKeyMap(4, ShardHash(func(key string) uint64 { return uint64(len(key)) }))
+	assert.Equal(0, byLength.ShardFor(""))
+	assert.Equal(3, byLength.ShardFor("abc"))
+	assert.Equal(1, byLength.ShardFor("abcde"))
+	byLength.Set("abc", 3)
+	v, ok := byLength.Get("abc")
+	assert.True(ok)
+	assert.Equal(3, v)
+	assert.Len(byLength.shards[3], 1)
+}
+
+func TestWithKeyMap(t *testing.T) {
+	assert := assert.New(t)
+
+	var maps []*ShardedKeyMap
+	clock := newFakeClock()
+	kv := NewStore(time.Hour,
+		Debug(),
+		Clock(clock.Now),
+		MaxEntries(40),
+		WithKeyMap(func() KeyMap {
+			m := NewShardedKeyMap(4)
+			maps = append(maps, m)
+			return m
+		}))
+	defer kv.Stop()
+	assert.Len(maps, 1)
+	assert.True(kv.Config().KeyMap)
+
+	for i := 0; i < 50; i++ {
+		assert.NoError(kv.Put(fmt.Sprint("k", i), i, ExpiresAfter(time.Second*time.Duration(i+1))))
+	}
+	assert.Equal(40, maps[0].Len())
+	assert.NoError(kv.CheckInvariants())
+
+	clock.Advance(time.Second * 20)
+	kv.ExpireNow()
+	assert.Equal(31, kv.Len()) // k19 expires after its deadline
+	assert.Equal(31, maps[0].Len())
+	keys := kv.Keys()
+	sort.Strings(keys)
+	assert.Equal("k19", keys[0])
+
+	assert.Equal(10, kv.DeleteByPrefix("k2"))
+	kv.Compact()
+	assert.Len(maps, 2)
+	assert.Equal(21, maps[1].Len())
+	assert.NoError(kv.CheckInvariants())
+
+	assert.Equal(21, kv.Clear())
+	assert.Len(maps, 3)
+	assert.Equal(0, kv.Len())
+	assert.NoError(kv.CheckInvariants())
+}
@@ -0,0 +1,365 @@
+package tinykv
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Keys returns the keys of all entries. With the Indexed option,
+// keys are sorted, and the returned slice may be shared and must not be
+// modified.
+// With SortedIteration, keys are sorted too.
+func (kv *Store) Keys() []string {
+	kv.active()
+	return kv.keys()
+}
+
+// keys is Keys, for the scans of the store itself, which are not activity
+// for OnIdle
+func (kv *Store) keys() []string {
+	if kv.index != nil {
+		return kv.indexKeys("")
+	}
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	keys := make([]string, 0, kv.kv.len())
+	kv.kv.each(func(k string, e *entry) bool {
+		if kv.expired(e) {
+			return true
+		}
+		keys = append(keys, k)
+		return true
+	})
+	if kv.sortedIteration {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+// Len returns the number of entries, like Stats().Entries: expired entries
+// count until they are swept.
+func (kv *Store) Len() int {
+	kv.active()
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	return kv.kv.len()
+}
+
+// Prefix returns the keys that start with prefix. With the Indexed option,
+// keys are sorted, and the returned slice may be shared and must not be
+// modified.
+// With SortedIteration, keys are sorted too.
+func (kv *Store) Prefix(prefix string) []string {
+	kv.active()
+	if kv.index != nil {
+		return kv.indexKeys(prefix)
+	}
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	var keys []string
+	kv.kv.each(func(k string, e *entry) bool {
+		if !strings.HasPrefix(k, prefix) || kv.expired(e) {
+			return true
+		}
+		keys = append(keys, k)
+		return true
+	})
+	if kv.sortedIteration {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+// indexKeys returns the keys of the index that start with prefix, without
+// the keys of expired entries that are not swept yet. The lock is held to take
+// the pending changes and to find the expired keys (see expiredKeys); the
+// merge and the filtering run outside it. The snapshot is returned as it is if
+// all its keys are live; otherwise the live keys are copied.
+func (kv *Store) indexKeys(prefix string) []string {
+	ix := kv.index
+	ix.mx.Lock()
+	kv.mx.Lock()
+	keys, gen := ix.keys, ix.gen
+	var pending map[string]bool
+	if len(ix.pending) > 0 {
+		pending, ix.merging = ix.pending, ix.pending
+		ix.pending = make(map[string]bool)
+	}
+	expired := kv.expiredKeys()
+	kv.mx.Unlock()
+	if pending != nil {
+		keys = mergeKeys(keys, pending)
+		kv.mx.Lock()
+		if ix.gen == gen { // not cleared meanwhile
+			ix.keys = keys
+		}
+		ix.merging = nil
+		kv.mx.Unlock()
+	}
+	ix.mx.Unlock()
+
+	from := sort.SearchStrings(keys, prefix)
+	to := from
+	for to < len(keys) && strings.HasPrefix(keys[to], prefix) {
+		to++
+	}
+	keys = keys[from:to:to]
+	if len(expired) == 0 {
+		return keys
+	}
+	live := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !expired[k] {
+			live = append(live, k)
+		}
+	}
+	return live
+}
+
+// expiredKeys returns the keys of the expired entries that are not removed
+// yet: those of the due heap nodes (found without visiting the others), of
+// the entries claimed by a sweep in progress, and of the entries in their
+// grace period. It costs O(1) while nothing is due, plus the number of
+// entries with a grace period.
+func (kv *Store) expiredKeys() map[string]bool {
+	if kv.paused {
+		return nil
+	}
+	now := kv.now()
+	var expired map[string]bool
+	check := func(k string) {
+		if e, ok := kv.kv.get(k); ok && kv.expired(e) {
+			if expired == nil {
+				expired = make(map[string]bool)
+			}
+			expired[k] = true
+		}
+	}
+	var walk func(i int)
+	walk = func(i int) {
+		if i >= len(kv.heap) || !kv.heap[i].due(now) {
+			return // nor are its children
+		}
+		if to := kv.heap[i]; to.isEntry() && !to.stale {
+			check(to.key)
+		}
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+	for k := range kv.index.condemned {
+		check(k)
+	}
+	for k := range kv.index.graced {
+		check(k)
+	}
+	return expired
+}
+
+// Range calls fn for each entry, without sliding it, until fn returns false.
+// fn is called outside the lock, so it can use the store. Without the Indexed
+// option, all entries are copied first (and sorted by key, with
+// SortedIteration). With it, the index snapshot is used (in key order) and
+// each value is read when it is visited; entries deleted in between are
+// skipped.
+func (kv *Store) Range(fn func(k string, v interface{}) bool) {
+	kv.RangeMeta(func(k string, v interface{}, _ Meta) bool {
+		return fn(k, v)
+	})
+}
+
+// RangeMeta is like Range, and also passes the metadata of each entry
+func (kv *Store) RangeMeta(fn func(k string, v interface{}, meta Meta) bool) {
+	kv.active()
+	type item struct {
+		k    string
+		v    interface{}
+		meta Meta
+	}
+	if kv.index == nil {
+		kv.mx.Lock()
+		items := make([]item, 0, kv.kv.len())
+		kv.kv.each(func(k string, e *entry) bool {
+			if kv.expired(e) {
+				return true
+			}
+			items = append(items, item{k, kv.copyValue(e.value), e.meta(kv.now())})
+			return true
+		})
+		kv.mx.Unlock()
+		if kv.sortedIteration {
+			sort.Slice(items, func(i, j int) bool { return items[i].k < items[j].k })
+		}
+		for _, it := range items {
+			if !fn(it.k, it.v, it.meta) {
+				return
+			}
+		}
+		return
+	}
+	for _, k := range kv.keys() {
+		var it item
+		kv.mx.Lock()
+		e, ok := kv.kv.get(k)
+		if ok && kv.expired(e) {
+			ok = false
+		}
+		if ok {
+			it = item{k, kv.copyValue(e.value), e.meta(kv.now())}
+		}
+		kv.mx.Unlock()
+		if !ok {
+			continue
+		}
+		if !fn(it.k, it.v, it.meta) {
+			return
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// SortedIteration makes Keys, Prefix, Range and RangeMeta return the keys in
+// lexicographic order, so two dumps of an unchanged store are the same. It
+// sorts a copy of the keys on each call; Indexed gives the same order, from
+// an index kept up to date by mutations.
+func SortedIteration() StoreOption {
+	return func(opt *storeOpt) {
+		opt.sortedIteration = true
+	}
+}
+
+// Indexed makes the store maintain a sorted index of the keys, so Keys,
+// Prefix and Range work on an immutable snapshot, without copying all the
+// keys (and values) under the lock on each call. Each mutation records the
+// key as pending (O(1)); the next read merges the pending keys into a new
+// snapshot, O(n) on the number of keys, outside the lock, and reuses the
+// snapshot while nothing has changed. Under the lock, a read only takes the
+// pending keys and looks up the expired entries that are not swept yet, on
+// the due part of the timeout heap (and the entries with a Grace period), to
+// skip them. So it pays off when reads of the keys are less frequent than
+// mutations, like dashboards polling a big store.
+func Indexed() StoreOption {
+	return func(opt *storeOpt) {
+		opt.indexed = true
+	}
+}
+
+// keyIndex is a copy-on-write sorted index of keys. Its fields are guarded by
+// the lock of the store, except keys, which is replaced by a merge holding mx
+// too; mx serializes merges, and is taken before the lock of the store.
+type keyIndex struct {
+	mx        sync.Mutex
+	keys      []string        // sorted, never modified once published
+	pending   map[string]bool // true: added, false: removed
+	merging   map[string]bool // the pending keys of a merge in progress
+	gen       uint64          // incremented by reset, so a merge of older keys is dropped
+	graced    map[string]bool // keys of entries with a grace period
+	condemned map[string]bool // keys of entries claimed by a sweep
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		pending:   make(map[string]bool),
+		graced:    make(map[string]bool),
+		condemned: make(map[string]bool),
+	}
+}
+
+// reset empties the index, for Clear
+func (ix *keyIndex) reset() {
+	if ix == nil {
+		return
+	}
+	ix.keys = nil
+	ix.pending = make(map[string]bool)
+	ix.merging = nil
+	ix.gen++
+	ix.graced = make(map[string]bool)
+	ix.condemned = make(map[string]bool)
+}
+
+func (ix *keyIndex) add(k string, graced bool) {
+	if ix == nil {
+		return
+	}
+	ix.pending[k] = true
+	if graced {
+		ix.graced[k] = true
+	} else {
+		delete(ix.graced, k)
+	}
+}
+
+func (ix *keyIndex) remove(k string) {
+	if ix == nil {
+		return
+	}
+	ix.pending[k] = false
+	delete(ix.graced, k)
+	delete(ix.condemned, k)
+}
+
+// condemn records that the entry of k is claimed by a sweep, and acquit that
+// it is not anymore
+func (ix *keyIndex) condemn(k string) {
+	if ix == nil {
+		return
+	}
+	ix.condemned[k] = true
+}
+
+func (ix *keyIndex) acquit(k string) {
+	if ix == nil {
+		return
+	}
+	delete(ix.condemned, k)
+}
+
+// view returns the keys with all the changes, for the scans made under the
+// lock of the store. It does not publish them, as a merge may be in progress.
+func (ix *keyIndex) view() []string {
+	keys := ix.keys
+	if len(ix.merging) > 0 {
+		keys = mergeKeys(keys, ix.merging)
+	}
+	if len(ix.pending) > 0 {
+		keys = mergeKeys(keys, ix.pending)
+	}
+	return keys
+}
+
+// mergeKeys returns a new sorted slice, with the changes of pending applied
+// to the sorted keys
+func mergeKeys(keys []string, pending map[string]bool) []string {
+	added := make([]string, 0, len(pending))
+	for k, isAdded := range pending {
+		if isAdded {
+			added = append(added, k)
+		}
+	}
+	sort.Strings(added)
+	merged := make([]string, 0, len(keys)+len(added))
+	i, j := 0, 0
+	for i < len(keys) || j < len(added) {
+		var k string
+		switch {
+		case j == len(added) || (i < len(keys) && keys[i] < added[j]):
+			k = keys[i]
+			i++
+		case i == len(keys) || added[j] < keys[i]:
+			k = added[j]
+			j++
+		default: // equal
+			k = keys[i]
+			i++
+			j++
+		}
+		if isAdded, ok := pending[k]; ok && !isAdded {
+			continue
+		}
+		merged = append(merged, k)
+	}
+	return merged
+}
@@ -0,0 +1,244 @@
+package tinykv
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeysPrefixRange(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		t.Run("indexed="+strconv.FormatBool(indexed), func(t *testing.T) {
+			assert := assert.New(t)
+
+			clock := newFakeClock()
+			options := []StoreOption{Clock(clock.Now), Debug()}
+			if indexed {
+				options = append(options, Indexed())
+			}
+			kv := NewStore(time.Hour, options...)
+			defer kv.Stop()
+
+			assert.Empty(kv.Keys())
+			kv.Put("user:2", 2)
+			kv.Put("user:1", 1)
+			kv.Put("group:1", 10)
+			kv.Put("user:3", 3, ExpiresAfter(time.Second))
+			kv.Put("user:1", 11)
+
+			keys := kv.Keys()
+			sort.Strings(keys)
+			assert.Equal([]string{"group:1", "user:1", "user:2", "user:3"}, keys)
+
+			keys = kv.Prefix("user:")
+			sort.Strings(keys)
+			assert.Equal([]string{"user:1", "user:2", "user:3"}, keys)
+			assert.Empty(kv.Prefix("none"))
+
+			clock.Advance(time.Second * 2)
+			kv.Delete("user:2")
+			got := make(map[string]interface{})
+			kv.Range(func(k string, v interface{}) bool {
+				got[k] = v
+				return true
+			})
+			assert.Equal(map[string]interface{}{"group:1": 10, "user:1": 11}, got)
+
+			n := 0
+			kv.Range(func(k string, v interface{}) bool {
+				n++
+				return false
+			})
+			assert.Equal(1, n)
+
+			// user:3 is expired, but not swept yet
+			keys = kv.Keys()
+			sort.Strings(keys)
+			assert.Equal([]string{"group:1", "user:1"}, keys)
+			assert.Equal([]string{"user:1"}, kv.Prefix("user:"))
+
+			kv.ExpireNow()
+			keys = kv.Keys()
+			sort.Strings(keys)
+			assert.Equal([]string{"group:1", "user:1"}, keys)
+			assert.NoError(kv.CheckInvariants())
+
+			kv.Clear()
+			assert.Empty(kv.Keys())
+			_, ok := kv.Get("user:1")
+			assert.False(ok)
+			kv.Put("user:4", 4, ExpiresAfter(time.Second))
+			assert.Equal([]string{"user:4"}, kv.Prefix("user:"))
+			assert.NoError(kv.CheckInvariants())
+		})
+	}
+}
+
+func TestIndexedSnapshotIsImmutable(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Indexed(), Debug())
+	defer kv.Stop()
+
+	for i := 0; i < 10; i++ {
+		kv.Put(strconv.Itoa(i), i)
+	}
+	keys := kv.Keys()
+	assert.Len(keys, 10)
+	assert.True(sort.StringsAreSorted(keys))
+
+	// mutations while iterating, do not change the snapshot
+	var visited []string
+	kv.Range(func(k string, v interface{}) bool {
+		visited = append(visited, k)
+		kv.Delete(k)
+		kv.Put(k+"-new", v)
+		return true
+	})
+	assert.Equal(keys, visited)
+	assert.Len(keys, 10)
+	assert.Equal("0", keys[0])
+	assert.Len(kv.Keys(), 10)
+	assert.Equal("0-new", kv.Keys()[0])
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestIndexedExpiredKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Indexed(), Debug())
+	defer kv.Stop()
+
+	var want []string
+	for i := 0; i < 100; i++ {
+		k := strconv.Itoa(i)
+		switch i % 4 {
+		case 0:
+			kv.Put(k, i)
+			want = append(want, k)
+		case 1:
+			kv.Put(k, i, ExpiresAfter(time.Minute))
+			want = append(want, k)
+		case 2:
+			kv.Put(k, i, ExpiresAfter(time.Second))
+		case 3:
+			kv.Put(k, i, ExpiresAfter(time.Second), Grace(time.Hour))
+		}
+	}
+	sort.Strings(want)
+	clock.Advance(time.Second * 2)
+
+	// expired (or in their grace period), but not swept yet
+	assert.Equal(want, kv.Keys())
+	_, _, ok := kv.GetGraced("3")
+	assert.True(ok)
+	assert.Equal([]string{"1", "12", "13", "16", "17"}, kv.Prefix("1")[:5])
+
+	// a put brings one back, from the pending keys
+	kv.Put("2", 2)
+	assert.Contains(kv.Keys(), "2")
+	assert.NoError(kv.CheckInvariants())
+
+	clock.Advance(time.Minute)
+	kv.Put("2", 2)
+	assert.Equal([]string{"0", "12", "16", "2", "20"}, kv.Prefix("")[:5])
+	kv.ExpireNow()
+	assert.Len(kv.Keys(), 26)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestIndexedKeysConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Indexed(), Debug())
+	defer kv.Stop()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := strconv.Itoa(w*1000 + i)
+				kv.Put(k, i, ExpiresAfter(time.Millisecond))
+				if i%3 == 0 {
+					kv.Delete(k)
+				}
+				if i%100 == 0 {
+					kv.Clear()
+				}
+			}
+		}(w)
+	}
+	for i := 0; i < 100; i++ {
+		assert.True(sort.StringsAreSorted(kv.Keys()))
+	}
+	wg.Wait()
+	assert.NoError(kv.CheckInvariants())
+}
+
+func BenchmarkPutNIndexed(b *testing.B) {
+	rg := NewStore(-1, Indexed())
+	for n := 0; n < b.N; n++ {
+		k := strconv.Itoa(n)
+		rg.Put(k, n)
+	}
+}
+
+func benchmarkKeys(b *testing.B, kv KV) {
+	for i := 0; i < 100000; i++ {
+		kv.Put(strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		kv.Keys()
+	}
+}
+
+func BenchmarkKeys(b *testing.B) {
+	kv := New(-1)
+	defer kv.Stop()
+	benchmarkKeys(b, kv)
+}
+
+func BenchmarkKeysIndexed(b *testing.B) {
+	kv := NewStore(-1, Indexed())
+	defer kv.Stop()
+	benchmarkKeys(b, kv)
+}
+
+func TestSortedIteration(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, SortedIteration())
+	defer kv.Stop()
+	assert.True(kv.Config().SortedIteration)
+
+	var want, wantUsers []string
+	for i := 0; i < 100; i++ {
+		k := "user:" + strconv.Itoa(i)
+		if i%3 == 0 {
+			k = "group:" + strconv.Itoa(i)
+		} else {
+			wantUsers = append(wantUsers, k)
+		}
+		kv.Put(k, i)
+		want = append(want, k)
+	}
+	sort.Strings(want)
+	sort.Strings(wantUsers)
+
+	assert.Equal(want, kv.Keys())
+	assert.Equal(wantUsers, kv.Prefix("user:"))
+	var ranged []string
+	kv.Range(func(k string, v interface{}) bool {
+		ranged = append(ranged, k)
+		return true
+	})
+	assert.Equal(want, ranged)
+}
@@ -0,0 +1,56 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// OnExpirationLag sets the function that is called when the janitor falls
+// behind: after a sweep, if an entry was removed more than budget after its
+// deadline, since the last check (the lag of Stats.ExpirationLagMax), or if
+// more entries than the threshold of ExpirationLagBacklog are still past
+// their deadline. fn gets the worst lag, and the entries still past their
+// deadline; it is called at most once per expiration interval, by the loop,
+// so it must be fast. A budget that is not positive, or a nil fn, turns it
+// off.
+func OnExpirationLag(budget time.Duration, fn func(worstLag time.Duration, backlog int)) StoreOption {
+	return func(opt *storeOpt) {
+		if budget <= 0 || fn == nil {
+			return
+		}
+		opt.lagBudget = budget
+		opt.onExpirationLag = fn
+	}
+}
+
+// ExpirationLagBacklog sets the number of entries past their deadline, left
+// after a sweep, over which OnExpirationLag is called, even if the lag of
+// the removed entries is within the budget; by default, only the lag counts.
+func ExpirationLagBacklog(threshold int) StoreOption {
+	return func(opt *storeOpt) {
+		opt.lagBacklog = threshold
+	}
+}
+
+// checkLag calls the OnExpirationLag function, if the janitor fell behind
+// since the last check; it is called by the janitor only
+func (kv *Store) checkLag() {
+	if kv.onExpirationLag == nil {
+		return
+	}
+	kv.mx.Lock()
+	worst := kv.worstLag
+	kv.worstLag = 0
+	backlog := kv.backlog()
+	now := kv.now()
+	late := worst > kv.lagBudget || (kv.lagBacklog > 0 && backlog > kv.lagBacklog)
+	if !late || (!kv.lagAlerted.IsZero() && now.Sub(kv.lagAlerted) < kv.expirationInterval) {
+		kv.mx.Unlock()
+		return
+	}
+	kv.lagAlerted = now
+	kv.mx.Unlock()
+	try(func() error {
+		kv.onExpirationLag(worst, backlog)
+		return nil
+	})
+}
@@ -0,0 +1,156 @@
+package tinykv
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnExpireDetailed(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	type expiration struct{ deadline, removedAt time.Time }
+	got := make(map[string]expiration)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpireDetailed(func(k string, v interface{}, deadline, removedAt time.Time) {
+			got[k] = expiration{deadline, removedAt}
+		}))
+	defer kv.Stop()
+
+	start := clock.Now()
+	kv.Put("1", 1, ExpiresAfter(time.Second))
+	kv.Put("2", 2, ExpiresAfter(time.Second*4))
+	clock.Advance(time.Second * 10)
+	kv.ExpireNow()
+
+	assert.Equal(map[string]expiration{
+		"1": {start.Add(time.Second), clock.Now()},
+		"2": {start.Add(time.Second * 4), clock.Now()},
+	}, got)
+	stats := kv.Stats()
+	assert.Equal(time.Second*9, stats.ExpirationLagMax)
+	assert.Equal(time.Second*15, stats.ExpirationLagSum)
+	assert.Equal(int64(2), stats.ExpirationLagCount)
+}
+
+func TestExpirationLagSweepInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	const interval = time.Millisecond * 100
+	var (
+		mx  sync.Mutex
+		lag time.Duration
+	)
+	done := make(chan struct{})
+	kv := NewStore(interval, OnExpireDetailed(func(k string, v interface{}, deadline, removedAt time.Time) {
+		mx.Lock()
+		defer mx.Unlock()
+		lag = removedAt.Sub(deadline)
+		close(done)
+	}))
+	defer kv.Stop()
+
+	kv.Put("1", 1, ExpiresAfter(time.Millisecond))
+	select {
+	case <-done:
+	case <-time.After(interval * 5):
+		t.Fatal("not expired")
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	assert.True(lag > interval/2, lag)
+	assert.True(lag < interval*3, lag)
+	assert.Equal(lag, kv.Stats().ExpirationLagMax)
+}
+
+type lagAlert struct {
+	worst   time.Duration
+	backlog int
+}
+
+func TestOnExpirationLagPaused(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	j := &Janitor{}
+	var alerts []lagAlert
+	kv := NewStore(time.Minute,
+		Clock(clock.Now),
+		ManualJanitor(j),
+		OnExpirationLag(time.Second*5, func(worst time.Duration, backlog int) {
+			alerts = append(alerts, lagAlert{worst, backlog})
+		}),
+		ExpirationLagBacklog(5))
+	defer kv.Stop()
+	assert.Equal(time.Second*5, kv.Config().ExpirationLagBudget)
+	assert.Equal(5, kv.Config().ExpirationLagBacklog)
+
+	put := func(n int) {
+		for i := 0; i < n; i++ {
+			assert.NoError(kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Second)))
+		}
+	}
+
+	// within the budget
+	put(10)
+	clock.Advance(time.Second * 3)
+	j.Sweep()
+	assert.Len(alerts, 0)
+
+	// the backlog grows while the janitor is paused
+	put(10)
+	kv.PauseExpiration()
+	clock.Advance(time.Second * 3)
+	j.Sweep()
+	assert.Equal([]lagAlert{{0, 10}}, alerts)
+
+	// once per expiration interval
+	clock.Advance(time.Second * 10)
+	kv.ResumeExpiration()
+	j.Sweep()
+	assert.Len(alerts, 1)
+
+	put(10)
+	clock.Advance(time.Minute)
+	j.Sweep()
+	assert.Len(alerts, 2)
+	assert.Equal(time.Minute-time.Second, alerts[1].worst)
+	assert.Equal(0, alerts[1].backlog)
+}
+
+func TestOnExpirationLagStalledJanitor(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	stall := make(chan struct{})
+	alerts := make(chan lagAlert, 10)
+	kv := NewStore(time.Millisecond*10,
+		Clock(clock.Now),
+		OnSweep(func(int, int, time.Duration) { <-stall }),
+		OnExpirationLag(time.Second, func(worst time.Duration, backlog int) {
+			alerts <- lagAlert{worst, backlog}
+		}))
+	defer kv.Stop()
+
+	// the janitor is stuck in the hook of its first sweep
+	for i := 0; i < 10; i++ {
+		assert.NoError(kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Second)))
+	}
+	clock.Advance(time.Second * 4)
+	close(stall)
+
+	select {
+	case alert := <-alerts:
+		assert.Equal(time.Second*3, alert.worst)
+		assert.Equal(0, alert.backlog)
+	case <-time.After(time.Second * 5):
+		assert.Fail("no alert")
+	}
+	assert.Equal(0, kv.Len())
+}
@@ -0,0 +1,111 @@
+package tinykv
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Lease is the value of an entry acquired by AcquireLease; it is what Get
+// returns for it, and what OnExpire (and OnExpireDetailed) get when the lease
+// expires, so dead owners can be detected.
+type Lease struct {
+	Owner string
+}
+
+// AcquireLease acquires k for owner, for ttl. If k is already leased by
+// another owner, ok is false and currentOwner is that owner. Acquiring a lease
+// already held by owner renews it. A lease does not slide, it must be renewed
+// using RenewLease before ttl elapses, otherwise it expires. If k holds a value
+// that is not a lease, ErrTypeConflict is returned.
+func (kv *Store) AcquireLease(k, owner string, ttl time.Duration) (ok bool, currentOwner string, err error) {
+	defer wrapOp(&err, "acquire-lease", k)
+	kv.active()
+	if ttl <= 0 {
+		return false, "", errors.Wrapf(ErrInvalidOptions, "lease ttl %v", ttl)
+	}
+	if err := kv.checkString(Lease{}); err != nil {
+		return false, "", err
+	}
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e != nil {
+		l, err := leaseOf(k, e)
+		if err != nil {
+			kv.mx.Unlock()
+			return false, "", err
+		}
+		if l.Owner != owner {
+			kv.mx.Unlock()
+			return false, l.Owner, nil
+		}
+	}
+	kv.set(k, kv.newEntry(k, Lease{Owner: owner}, &putOpt{expiresAfter: ttl}))
+	kv.mx.Unlock()
+	kv.notify(expired)
+	return true, owner, nil
+}
+
+// RenewLease extends the lease on k, held by owner, to ttl from now. If the
+// lease is held by another owner, ErrNotOwner is returned; if it is gone,
+// ErrNotFound or ErrExpired.
+func (kv *Store) RenewLease(k, owner string, ttl time.Duration) (err error) {
+	defer wrapOp(&err, "renew-lease", k)
+	kv.active()
+	if ttl <= 0 {
+		return errors.Wrapf(ErrInvalidOptions, "lease ttl %v", ttl)
+	}
+	kv.mx.Lock()
+	e, expired, err := kv.ownedLease(k, owner)
+	if err == nil {
+		kv.set(k, kv.newEntry(k, e.value, &putOpt{expiresAfter: ttl}))
+	}
+	kv.mx.Unlock()
+	kv.notify(expired)
+	return err
+}
+
+// ReleaseLease deletes the lease on k, held by owner. If the lease is held by
+// another owner, ErrNotOwner is returned; if it is gone, ErrNotFound or
+// ErrExpired.
+func (kv *Store) ReleaseLease(k, owner string) (err error) {
+	defer wrapOp(&err, "release-lease", k)
+	kv.active()
+	kv.mx.Lock()
+	_, expired, err := kv.ownedLease(k, owner)
+	if err == nil {
+		kv.remove(k)
+	}
+	kv.mx.Unlock()
+	kv.notify(expired)
+	return err
+}
+
+// ownedLease finds the lease on k held by owner, it must be called under the
+// lock. Like lookup, an expired lease is returned in expired.
+func (kv *Store) ownedLease(k, owner string) (e *entry, expired map[string]*entry, err error) {
+	e, expired = kv.lookup(k)
+	if e == nil {
+		return nil, expired, lookupErr(expired)
+	}
+	l, err := leaseOf(k, e)
+	if err != nil {
+		return nil, nil, err
+	}
+	if l.Owner != owner {
+		return nil, nil, errors.Wrapf(ErrNotOwner, "held by %q", l.Owner)
+	}
+	return e, nil, nil
+}
+
+// leaseOf returns the lease held in e; a read-only entry can not be leased
+func leaseOf(k string, e *entry) (Lease, error) {
+	if e.readOnly {
+		return Lease{}, ErrReadOnly
+	}
+	l, ok := e.value.(Lease)
+	if !ok {
+		return Lease{}, errors.Wrapf(ErrTypeConflict, "holds a %T, not a lease", e.value)
+	}
+	return l, nil
+}
@@ -0,0 +1,87 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseContention(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	ok, owner, err := kv.AcquireLease("job", "w1", time.Minute)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal("w1", owner)
+
+	ok, owner, err = kv.AcquireLease("job", "w2", time.Minute)
+	assert.NoError(err)
+	assert.False(ok)
+	assert.Equal("w1", owner)
+
+	assert.Equal(ErrNotOwner, errors.Cause(kv.RenewLease("job", "w2", time.Minute)))
+	assert.Equal(ErrNotOwner, errors.Cause(kv.ReleaseLease("job", "w2")))
+
+	// re-acquiring by the owner renews the lease
+	clock.Advance(time.Second * 50)
+	ok, _, _ = kv.AcquireLease("job", "w1", time.Minute)
+	assert.True(ok)
+	meta, _ := kv.GetMeta("job")
+	assert.Equal(time.Minute, meta.Remaining)
+
+	assert.NoError(kv.ReleaseLease("job", "w1"))
+	assert.Equal(ErrNotFound, errors.Cause(kv.ReleaseLease("job", "w1")))
+	ok, owner, _ = kv.AcquireLease("job", "w2", time.Minute)
+	assert.True(ok)
+	assert.Equal("w2", owner)
+	v, _ := kv.Get("job")
+	assert.Equal(Lease{Owner: "w2"}, v)
+
+	kv.Put("value", 1)
+	_, _, err = kv.AcquireLease("value", "w1", time.Minute)
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	_, _, err = kv.AcquireLease("other", "w1", 0)
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestLeaseExpiration(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var dead []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) {
+			dead = append(dead, v.(Lease).Owner)
+		}))
+	defer kv.Stop()
+
+	kv.AcquireLease("job1", "w1", time.Minute)
+	kv.AcquireLease("job2", "w2", time.Minute)
+
+	// renewed just in time
+	clock.Advance(time.Minute)
+	assert.NoError(kv.RenewLease("job1", "w1", time.Minute))
+
+	// renewal racing expiration: the lease expired first
+	clock.Advance(time.Millisecond)
+	err := kv.RenewLease("job2", "w2", time.Minute)
+	assert.Equal(ErrExpired, errors.Cause(err))
+	assert.Equal([]string{"w2"}, dead)
+
+	// so another worker can take it over
+	ok, _, _ := kv.AcquireLease("job2", "w3", time.Minute)
+	assert.True(ok)
+
+	clock.Advance(time.Minute + time.Second)
+	kv.ExpireNow()
+	assert.ElementsMatch([]string{"w2", "w1", "w3"}, dead)
+}
@@ -0,0 +1,70 @@
+package tinykv
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Append appends v to the list ([]interface{}) stored at k and returns the new
+// length of the list. The list gets created on first append, and only then
+// the options are applied; later appends slide the entry, like a Get.
+// If k holds a value that is not a list, ErrTypeConflict is returned.
+func (kv *Store) Append(k string, v interface{}, options ...PutOption) (newLen int, err error) {
+	defer wrapOp(&err, "append", k)
+	kv.active()
+	opt := kv.putOptions(options)
+	if err := opt.validate(); err != nil {
+		return 0, err
+	}
+	if opt.boundTo != nil {
+		return 0, errors.Wrap(ErrInvalidOptions, "BoundTo passed to Append")
+	}
+	if err := kv.checkString([]interface{}(nil)); err != nil {
+		return 0, err
+	}
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e == nil {
+		e = kv.newEntry(k, []interface{}{v}, opt)
+		kv.set(k, e)
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return 1, nil
+	}
+	if e.readOnly {
+		kv.mx.Unlock()
+		return 0, ErrReadOnly
+	}
+	list, ok := e.value.([]interface{})
+	if !ok {
+		kv.mx.Unlock()
+		return 0, errors.Wrapf(ErrTypeConflict, "holds a %T, not a list", e.value)
+	}
+	list = append(list, v)
+	e.value = list
+	kv.slide(e)
+	kv.modified(k, e)
+	kv.mx.Unlock()
+	return len(list), nil
+}
+
+// Drain takes the whole list stored at k out of kv store. If k does not
+// hold a list, or the entry is read-only or protected, nothing is taken.
+func (kv *Store) Drain(k string) ([]interface{}, bool) {
+	kv.active()
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e == nil {
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return nil, false
+	}
+	list, ok := e.value.([]interface{})
+	if ok && (e.readOnly || e.protected) {
+		list, ok = nil, false
+	}
+	if ok {
+		kv.remove(k)
+	}
+	kv.mx.Unlock()
+	return list, ok
+}
@@ -0,0 +1,92 @@
+package tinykv
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := New(-1)
+	defer kv.Stop()
+
+	const (
+		workers   = 16
+		perWorker = 1000
+	)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				_, err := kv.Append("events", w*perWorker+i)
+				assert.NoError(err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	vs, ok := kv.Drain("events")
+	assert.True(ok)
+	assert.Equal(workers*perWorker, len(vs))
+	var got []int
+	for _, v := range vs {
+		got = append(got, v.(int))
+	}
+	sort.Ints(got)
+	for i, v := range got {
+		assert.Equal(i, v)
+	}
+
+	_, ok = kv.Drain("events")
+	assert.False(ok)
+	_, ok = kv.Get("events")
+	assert.False(ok)
+}
+
+func TestAppendTypeConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := New(-1)
+	defer kv.Stop()
+
+	kv.Put("1", 1)
+	_, err := kv.Append("1", 2)
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+
+	_, ok := kv.Drain("1")
+	assert.False(ok)
+	v, ok := kv.Get("1")
+	assert.True(ok)
+	assert.Equal(1, v)
+}
+
+func TestAppendExpiresAsWhole(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := New(time.Millisecond * 5)
+	defer kv.Stop()
+
+	n, err := kv.Append("1", 1, ExpiresAfter(time.Millisecond*30))
+	assert.NoError(err)
+	assert.Equal(1, n)
+	n, err = kv.Append("1", 2, ExpiresAfter(time.Hour))
+	assert.NoError(err)
+	assert.Equal(2, n)
+	checkInvariants(t, kv)
+
+	<-time.After(time.Millisecond * 60)
+	_, ok := kv.Drain("1")
+	assert.False(ok)
+
+	n, err = kv.Append("1", 3)
+	assert.NoError(err)
+	assert.Equal(1, n)
+}
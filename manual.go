@@ -0,0 +1,84 @@
+package tinykv
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ManualJanitor hands the janitor of the store to j: there is no expiration
+// loop, and the store sweeps only when j.Sweep is called (KickJanitor does
+// nothing). Along with Clock, it drives the time based features of the store
+// in simulated time, as the Sim of tinykvtest does. A Janitor serves one
+// store.
+func ManualJanitor(j *Janitor) StoreOption {
+	return func(opt *storeOpt) {
+		opt.manualJanitor = j
+	}
+}
+
+// Janitor is the janitor of a store created with ManualJanitor
+type Janitor struct {
+	mx sync.Mutex
+	kv *Store
+}
+
+// Sweep runs a sweep of the janitor, like the expiration loop does: it
+// removes the expired entries and notifies them, activates the due PutAfter
+// entries, calls the due ExpectWithin and OnSoftExpire functions, and checks
+// OnIdle and ScrubPolicy. It returns once the sweep is done; the
+// notifications are dispatched by then if they are synchronous (see
+// SynchronousNotifications and Executor).
+func (j *Janitor) Sweep() {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	if j.kv == nil {
+		return
+	}
+	interval := j.kv.sweep()
+	if interval <= 0 {
+		interval = j.kv.getExpirationInterval()
+	}
+	j.kv.setNextSweep(interval)
+}
+
+// Next returns the earliest time, on the clock of the store, a Sweep has
+// something to do at: the first entry to expire (or the first PutAfter,
+// ExpectWithin or soft deadline), the end of the idle period of OnIdle, or
+// the next run of ScrubPolicy. It returns false if there is nothing to do,
+// or expiration is paused.
+func (j *Janitor) Next() (at time.Time, ok bool) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	if j.kv == nil {
+		return time.Time{}, false
+	}
+	return j.kv.nextDue()
+}
+
+// nextDue finds the time of the next thing for a sweep to do; the deadlines
+// are passed after their time, not at it
+func (kv *Store) nextDue() (at time.Time, ok bool) {
+	consider := func(t time.Time) {
+		if !ok || t.Before(at) {
+			at, ok = t, true
+		}
+	}
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if kv.paused {
+		return time.Time{}, false
+	}
+	if len(kv.heap) > 0 {
+		consider(kv.heap[0].removeAt().Add(time.Nanosecond))
+	}
+	if kv.onIdle != nil {
+		if last := atomic.LoadInt64(&kv.lastActive); last != kv.idleNotified {
+			consider(time.Unix(0, last).Add(kv.idleFor + time.Nanosecond))
+		}
+	}
+	if kv.scrubPred != nil && kv.scrubEvery > 0 {
+		consider(kv.lastScrub.Add(kv.scrubEvery))
+	}
+	return at, ok
+}
@@ -0,0 +1,81 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManualJanitor(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	j := &Janitor{}
+	expired := make(chan string, 10)
+	kv := NewStore(time.Millisecond,
+		Clock(clock.Now),
+		ManualJanitor(j),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) { expired <- k }))
+	defer kv.Stop()
+
+	_, ok := j.Next()
+	assert.False(ok)
+
+	start := clock.Now()
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	at, ok := j.Next()
+	assert.True(ok)
+	assert.WithinDuration(start.Add(time.Second+time.Nanosecond), at, 0)
+
+	// no loop sweeps it
+	clock.Advance(time.Second * 2)
+	kv.KickJanitor()
+	time.Sleep(time.Millisecond * 20)
+	assert.Len(expired, 0)
+	assert.Equal(int64(0), kv.Stats().Sweeps)
+
+	j.Sweep()
+	assert.Len(expired, 1)
+	assert.Equal(int64(1), kv.Stats().Sweeps)
+	_, ok = j.Next()
+	assert.False(ok)
+	assert.True(kv.Config().ManualJanitor)
+
+	// paused
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	kv.PauseExpiration()
+	_, ok = j.Next()
+	assert.False(ok)
+	kv.ResumeExpiration()
+	_, ok = j.Next()
+	assert.True(ok)
+}
+
+func TestManualJanitorNext(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	j := &Janitor{}
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		ManualJanitor(j),
+		OnIdle(time.Minute, func() {}),
+		ScrubPolicy(func(string, interface{}) bool { return false }, time.Minute*2))
+	defer kv.Stop()
+
+	start := clock.Now()
+	at, ok := j.Next()
+	assert.True(ok)
+	assert.WithinDuration(start.Add(time.Minute+time.Nanosecond), at, 0)
+
+	clock.Advance(time.Minute * 2)
+	j.Sweep()
+	at, _ = j.Next()
+	assert.WithinDuration(start.Add(time.Minute*4), at, 0)
+
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	at, _ = j.Next()
+	assert.WithinDuration(clock.Now().Add(time.Second+time.Nanosecond), at, 0)
+}
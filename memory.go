@@ -0,0 +1,125 @@
+package tinykv
+
+import (
+	"math"
+	"runtime"
+	"time"
+)
+
+// EvictReason is the reason an entry was evicted
+type EvictReason string
+
+// evict reasons
+const (
+	EvictMemoryPressure EvictReason = "memory-pressure"
+)
+
+// OnEvict sets the function for eviction notifications. Evicted entries are
+// not reported to OnExpire.
+func OnEvict(onEvict func(k string, v interface{}, reason EvictReason)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onEvict = onEvict
+	}
+}
+
+// MemoryPressure makes the store check the memory in use every checkEvery,
+// and when it is above highWatermark bytes, evict shedFraction of the entries,
+// those expiring soonest first, then the ones without a timeout. Read-only
+// entries are never evicted. By default, the memory in use is the heap
+// allocation from runtime.ReadMemStats (which stops the world briefly);
+// MemoryGauge sets another gauge.
+func MemoryPressure(checkEvery time.Duration, highWatermark uint64, shedFraction float64) StoreOption {
+	return func(opt *storeOpt) {
+		opt.memoryCheckEvery = checkEvery
+		opt.memoryHighWatermark = highWatermark
+		opt.memoryShedFraction = shedFraction
+	}
+}
+
+// MemoryGauge sets the function that reports the memory in use, in bytes,
+// for MemoryPressure.
+func MemoryGauge(gauge func() uint64) StoreOption {
+	return func(opt *storeOpt) {
+		opt.memoryGauge = gauge
+	}
+}
+
+func heapAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func (kv *Store) memoryLoop() {
+	ticker := time.NewTicker(kv.memoryCheckEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-kv.stop:
+			return
+		case <-ticker.C:
+			if kv.memoryGauge() > kv.memoryHighWatermark {
+				kv.shed(kv.memoryShedFraction)
+			}
+		}
+	}
+}
+
+// shed evicts fraction of the entries
+func (kv *Store) shed(fraction float64) {
+	kv.mx.Lock()
+	n := int(math.Ceil(float64(kv.kv.len()) * fraction))
+	b := kv.newBulkRemoval("memory-pressure", kv.onEvict != nil)
+	b.evicts = true
+	var skipped []*timeout
+	for b.count < n && len(kv.heap) > 0 {
+		to := timeheapPop(&kv.heap)
+		if to.stale {
+			continue
+		}
+		e, _ := kv.kv.get(to.key)
+		if !to.isEntry() || e.readOnly {
+			skipped = append(skipped, to)
+			continue
+		}
+		b.remove(to.key, e)
+	}
+	for _, to := range skipped {
+		timeheapPush(&kv.heap, to)
+	}
+	kv.kv.each(func(k string, e *entry) bool {
+		if b.count >= n {
+			return false
+		}
+		if e.timeout == nil && !e.readOnly {
+			b.remove(k, e)
+		}
+		return true
+	})
+	kv.stats.Evictions += int64(b.count)
+	kv.stats.MemoryPressureSheds++
+	kv.done(b)
+	kv.mx.Unlock()
+	kv.notifyBulkRemoval(b)
+	kv.notifyEvictions(b.removed, EvictMemoryPressure)
+}
+
+func (kv *Store) notifyEvictions(evicted map[string]interface{}, reason EvictReason) {
+	if kv.onEvict == nil || len(evicted) == 0 {
+		return
+	}
+	notify := func() {
+		for k, v := range evicted {
+			k, v := k, v
+			try(func() error {
+				kv.onEvict(k, v, reason)
+				return nil
+			})
+		}
+	}
+	if kv.synchronousNotifications {
+		kv.deliverInline(notify)
+		return
+	}
+	kv.spawn(notify)
+}
@@ -0,0 +1,69 @@
+package tinykv
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryPressure(t *testing.T) {
+	assert := assert.New(t)
+
+	var high int32 // reported once, when set
+	var (
+		mx      sync.Mutex
+		evicted = make(map[string]EvictReason)
+	)
+	kv := NewStore(time.Hour,
+		MemoryPressure(time.Millisecond, 1000, 0.5),
+		MemoryGauge(func() uint64 {
+			if atomic.CompareAndSwapInt32(&high, 1, 0) {
+				return 2000
+			}
+			return 100
+		}),
+		SynchronousNotifications(),
+		OnEvict(func(k string, v interface{}, reason EvictReason) {
+			mx.Lock()
+			defer mx.Unlock()
+			evicted[k] = reason
+		}),
+		Debug())
+	defer kv.Stop()
+
+	for i := 0; i < 4; i++ {
+		kv.Put("ttl"+strconv.Itoa(i), i, ExpiresAfter(time.Minute*time.Duration(i+1)))
+	}
+	for i := 0; i < 4; i++ {
+		kv.Put("permanent"+strconv.Itoa(i), i)
+	}
+	kv.Put("readonly", 0, ReadOnly(), ExpiresAfter(time.Second))
+
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(int64(0), kv.Stats().MemoryPressureSheds)
+
+	atomic.StoreInt32(&high, 1)
+	for kv.Stats().MemoryPressureSheds == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Millisecond * 5)
+
+	stats := kv.Stats()
+	assert.Equal(int64(1), stats.MemoryPressureSheds)
+	assert.Equal(int64(5), stats.Evictions)
+	assert.Equal(4, stats.Entries)
+
+	mx.Lock()
+	defer mx.Unlock()
+	assert.Len(evicted, 5)
+	for i := 0; i < 4; i++ {
+		assert.Equal(EvictMemoryPressure, evicted["ttl"+strconv.Itoa(i)])
+	}
+	_, ok := kv.Get("readonly")
+	assert.True(ok)
+	assert.NoError(kv.CheckInvariants())
+}
@@ -0,0 +1,49 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// Meta is the metadata of an entry
+type Meta struct {
+	ExpiresAt    time.Time     // zero if the entry does not expire
+	Remaining    time.Duration // until ExpiresAt, zero if the entry does not expire
+	ExpiresAfter time.Duration
+	IsSliding    bool
+	SlidesLeft   int // -1 if unlimited
+	ReadOnly     bool
+	Revision     uint64 // incremented on each change of the value, starting from 1
+	Priority     int
+	Seq          uint64 // the sequence of the last write, increasing across the store
+	SoftExpired  bool   // past its soft deadline (see SoftExpiresAfter)
+	Refs         int    // holders (see Retain)
+	Protected    bool
+}
+
+// GetMeta gets the metadata of an entry, without sliding it
+func (kv *Store) GetMeta(k string) (Meta, bool) {
+	kv.active()
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e == nil {
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return Meta{}, false
+	}
+	meta := e.meta(kv.now())
+	kv.mx.Unlock()
+	return meta, true
+}
+
+func (e *entry) meta(now time.Time) Meta {
+	meta := Meta{SlidesLeft: -1, ReadOnly: e.readOnly, Revision: e.revision, Priority: e.priority, Seq: e.seq, Refs: e.refs, Protected: e.protected}
+	meta.SoftExpired = e.softNode != nil && now.After(e.softNode.expiresAt)
+	if to := e.timeout; to != nil {
+		meta.ExpiresAt = to.expiresAt
+		meta.Remaining = to.expiresAt.Sub(now)
+		meta.ExpiresAfter = to.expiresAfter
+		meta.IsSliding = to.sliding()
+		meta.SlidesLeft = to.slidesLeft
+	}
+	return meta
+}
@@ -0,0 +1,133 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMeta(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	_, ok := kv.GetMeta("1")
+	assert.False(ok)
+
+	kv.Put("1", 1)
+	meta, ok := kv.GetMeta("1")
+	assert.True(ok)
+	assert.Equal(Meta{SlidesLeft: -1, Revision: 1, Seq: 1}, meta)
+
+	kv.Put("2", 2, ExpiresAfter(time.Minute), IsSliding(true))
+	clock.Advance(time.Second)
+	meta, ok = kv.GetMeta("2")
+	assert.True(ok)
+	assert.Equal(Meta{
+		ExpiresAt:    clock.Now().Add(time.Minute - time.Second),
+		Remaining:    time.Minute - time.Second,
+		ExpiresAfter: time.Minute,
+		IsSliding:    true,
+		SlidesLeft:   -1,
+		Revision:     1,
+		Seq:          2,
+	}, meta)
+
+	kv.Put("2", 22)
+	kv.Put("2", 222, CAS(func(interface{}, bool) bool { return true }))
+	meta, _ = kv.GetMeta("2")
+	assert.Equal(uint64(3), meta.Revision)
+}
+
+func TestCASMeta(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	// only replace when within 10s of expiring
+	nearExpiry := CASMeta(func(old interface{}, meta Meta, found bool) bool {
+		return !found || meta.Remaining <= time.Second*10
+	})
+
+	assert.NoError(kv.Put("1", 1, nearExpiry, ExpiresAfter(time.Minute)))
+	clock.Advance(time.Second * 30)
+	assert.Equal(ErrCASCond, errors.Cause(kv.Put("1", 2, nearExpiry, ExpiresAfter(time.Minute))))
+	v, _ := kv.Get("1")
+	assert.Equal(1, v)
+
+	clock.Advance(time.Second * 25)
+	assert.NoError(kv.Put("1", 3, nearExpiry, ExpiresAfter(time.Minute)))
+	v, _ = kv.Get("1")
+	assert.Equal(3, v)
+	meta, _ := kv.GetMeta("1")
+	assert.Equal(uint64(2), meta.Revision)
+
+	called := false
+	err := kv.Put("1", 4,
+		CAS(func(interface{}, bool) bool { called = true; return true }),
+		CASMeta(func(interface{}, Meta, bool) bool { called = true; return true }))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.False(called)
+}
+
+func TestExpiresAt(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	deadline := clock.Now().Add(time.Second * 5)
+	kv.Put("1", 1, ExpiresAt(deadline))
+	kv.Put("2", 2, ExpiresAt(deadline), ExpiresAfter(time.Second*10), IsSliding(true))
+	meta, _ := kv.GetMeta("2")
+	assert.Equal(deadline, meta.ExpiresAt)
+
+	clock.Advance(time.Second * 4)
+	_, ok := kv.Get("2")
+	assert.True(ok)
+	clock.Advance(time.Second * 2)
+	_, ok = kv.Get("1")
+	assert.False(ok)
+	_, ok = kv.Get("2")
+	assert.True(ok)
+}
+
+func TestMaxSlides(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	const n = 3
+	kv.Put("1", 1, ExpiresAfter(time.Second*10), IsSliding(true), MaxSlides(n))
+	for i := 0; i < n; i++ {
+		meta, _ := kv.GetMeta("1")
+		assert.Equal(n-i, meta.SlidesLeft)
+		clock.Advance(time.Second * 5)
+		_, ok := kv.Get("1")
+		assert.True(ok)
+		meta, _ = kv.GetMeta("1")
+		assert.Equal(clock.Now().Add(time.Second*10), meta.ExpiresAt)
+	}
+	lastArmed := clock.Now().Add(time.Second * 10)
+
+	// the n+1th access does not extend it
+	clock.Advance(time.Second * 5)
+	_, ok := kv.Get("1")
+	assert.True(ok)
+	meta, _ := kv.GetMeta("1")
+	assert.Equal(0, meta.SlidesLeft)
+	assert.Equal(lastArmed, meta.ExpiresAt)
+
+	clock.Advance(time.Second*5 + time.Millisecond)
+	_, ok = kv.Get("1")
+	assert.False(ok)
+}
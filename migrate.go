@@ -0,0 +1,107 @@
+package tinykv
+
+// EvictMigratedOut is the evict reason of the entries dropped by a migration,
+// see Migrate and MigrateOnRead
+const EvictMigratedOut EvictReason = "migrated-out"
+
+// migrateFunc upgrades the value of an entry: it returns the new value, and
+// false to drop the entry instead
+type migrateFunc func(k string, old interface{}) (new interface{}, keep bool)
+
+// Migrate runs fn on the value of each live entry, for a change of the shape
+// of the values: it replaces the value with the one fn returns, keeping the
+// timeout of the entry, or drops the entry if fn returns false. It returns
+// the number of entries visited. Like ExtendTTL, the keys are scanned under
+// the lock in chunks, so other operations are not blocked for the whole
+// scan: a Get meanwhile sees the old value or the new one. fn is called under
+// the lock, so it must be fast, and must not use the store. Dropped entries
+// are evictions: they are reported to OnEvict with EvictMigratedOut, and
+// counted in Stats.Evictions. Under StringValues, a value fn returns that is
+// not a string is ignored, and counted in Stats.RejectedMigrations: the entry
+// keeps its old value. A migrated entry is not migrated again by
+// MigrateOnRead.
+func (kv *Store) Migrate(fn func(k string, old interface{}) (new interface{}, keep bool)) int {
+	kv.active()
+	b := kv.newBulkRemoval("migrate", kv.onEvict != nil)
+	b.evicts = true
+	keys := kv.keys()
+	visited := 0
+	for len(keys) > 0 {
+		chunk := keys
+		if len(chunk) > reportChunk {
+			chunk = chunk[:reportChunk]
+		}
+		keys = keys[len(chunk):]
+
+		kv.mx.Lock()
+		for _, k := range chunk {
+			e, ok := kv.kv.get(k)
+			if !ok || kv.expired(e) {
+				continue
+			}
+			visited++
+			if !kv.migrate(k, e, fn) {
+				b.remove(k, e)
+			}
+		}
+		kv.mx.Unlock()
+	}
+	if b.count == 0 {
+		return visited
+	}
+	kv.mx.Lock()
+	kv.stats.Evictions += int64(b.count)
+	kv.done(b)
+	kv.mx.Unlock()
+	kv.notifyBulkRemoval(b)
+	kv.notifyEvictions(b.removed, EvictMigratedOut)
+	return visited
+}
+
+// MigrateOnRead makes the store run fn on the value of an entry the first
+// time it is read by Get (GetE, GetString), for a change of the shape of the
+// values without a pass over all the entries, like Migrate: fn runs at most
+// once per entry, and an entry put later is a new one, so fn must return a
+// value of the new shape as it is. An entry fn drops is not found by the
+// read, and is reported to OnEvict with EvictMigratedOut. fn is called under
+// the lock, so it must be fast, and must not use the store.
+func MigrateOnRead(fn func(k string, old interface{}) (new interface{}, keep bool)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.migrateOnRead = fn
+	}
+}
+
+// migrate runs fn on the entry e of k, under the lock, replacing its value
+// in place; it returns false if the entry is to be dropped
+func (kv *Store) migrate(k string, e *entry, fn migrateFunc) bool {
+	e.migrated = true
+	v, keep := fn(k, e.value)
+	if !keep {
+		return false
+	}
+	if kv.checkString(v) != nil {
+		kv.stats.RejectedMigrations++
+		return true
+	}
+	kv.closeReplaced(k, e.value, v)
+	e.value = v
+	if kv.checksumValues {
+		e.checksum, e.hasChecksum = checksum(v)
+	}
+	kv.modified(k, e)
+	return true
+}
+
+// migrateRead runs the MigrateOnRead function on the entry e of k, under the
+// lock, if it was not migrated yet; it returns the value of an entry it
+// dropped, and false
+func (kv *Store) migrateRead(k string, e *entry) (interface{}, bool) {
+	if kv.migrateOnRead == nil || e.migrated || kv.migrate(k, e, kv.migrateOnRead) {
+		return nil, true
+	}
+	kv.evicting = true
+	kv.remove(k)
+	kv.evicting = false
+	kv.stats.Evictions++
+	return e.value, false
+}
@@ -0,0 +1,166 @@
+package tinykv
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type userV1 struct{ Name string }
+
+type userV2 struct {
+	First string
+	Admin bool
+}
+
+func upgradeUser(k string, old interface{}) (interface{}, bool) {
+	switch u := old.(type) {
+	case userV1:
+		if u.Name == "" {
+			return nil, false
+		}
+		return userV2{First: u.Name}, true
+	}
+	return old, true
+}
+
+func TestMigrate(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	evicted := make(map[string]EvictReason)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnEvict(func(k string, v interface{}, reason EvictReason) { evicted[k] = reason }))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("a", userV1{Name: "A"}, ExpiresAfter(time.Minute)))
+	assert.NoError(kv.Put("b", userV1{Name: "B"}))
+	assert.NoError(kv.Put("empty", userV1{}))
+	assert.NoError(kv.Put("expired", userV1{Name: "X"}, ExpiresAfter(time.Second)))
+	assert.NoError(kv.Put("new", userV2{First: "N", Admin: true}))
+	before, _ := kv.GetMeta("a")
+	clock.Advance(time.Second * 2)
+
+	assert.Equal(4, kv.Migrate(upgradeUser))
+
+	v, ok := kv.Get("a")
+	assert.True(ok)
+	assert.Equal(userV2{First: "A"}, v)
+	v, _ = kv.Get("b")
+	assert.Equal(userV2{First: "B"}, v)
+	v, _ = kv.Get("new")
+	assert.Equal(userV2{First: "N", Admin: true}, v)
+	_, ok = kv.Get("empty")
+	assert.False(ok)
+	assert.Equal(map[string]EvictReason{"empty": EvictMigratedOut}, evicted)
+	assert.Equal(int64(1), kv.Stats().Evictions)
+
+	// the timeout is kept
+	after, _ := kv.GetMeta("a")
+	assert.WithinDuration(before.ExpiresAt, after.ExpiresAt, 0)
+	assert.Equal(before.Revision+1, after.Revision)
+	clock.Advance(time.Minute)
+	_, ok = kv.Get("a")
+	assert.False(ok)
+}
+
+func TestMigrateOnRead(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := make(map[string]int)
+	evicted := make(map[string]EvictReason)
+	for _, readOptimized := range []bool{false, true} {
+		for k := range calls {
+			delete(calls, k)
+		}
+		options := []StoreOption{
+			SynchronousNotifications(),
+			OnEvict(func(k string, v interface{}, reason EvictReason) { evicted[k] = reason }),
+			MigrateOnRead(func(k string, old interface{}) (interface{}, bool) {
+				calls[k]++
+				return upgradeUser(k, old)
+			}),
+		}
+		if readOptimized {
+			options = append(options, ReadOptimized())
+		}
+		kv := NewStore(time.Hour, options...)
+		assert.True(kv.Config().MigrateOnRead)
+
+		assert.NoError(kv.Put("a", userV1{Name: "A"}))
+		assert.NoError(kv.Put("empty", userV1{}))
+		for i := 0; i < 3; i++ {
+			v, ok := kv.Get("a")
+			assert.True(ok)
+			assert.Equal(userV2{First: "A"}, v)
+		}
+		assert.Equal(1, calls["a"])
+
+		_, ok := kv.Get("empty")
+		assert.False(ok)
+		assert.Equal(EvictMigratedOut, evicted["empty"])
+		assert.Equal(1, kv.Len())
+
+		// an eager migration marks the entries
+		assert.NoError(kv.Put("b", userV1{Name: "B"}))
+		assert.Equal(2, kv.Migrate(upgradeUser))
+		v, _ := kv.Get("b")
+		assert.Equal(userV2{First: "B"}, v)
+		assert.Equal(0, calls["b"])
+		kv.Stop()
+	}
+}
+
+func TestMigrateConcurrentReads(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, ReadOptimized())
+	defer kv.Stop()
+	const n = 5000
+	for i := 0; i < n; i++ {
+		assert.NoError(kv.Put(strconv.Itoa(i), i))
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := r; ; i = (i + 7) % n {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				v, ok := kv.Get(strconv.Itoa(i))
+				if !ok || (v != i && v != fmt.Sprint("v2-", i)) {
+					errs <- fmt.Errorf("key %d: got %v, %v", i, v, ok)
+					return
+				}
+			}
+		}(r)
+	}
+
+	visited := kv.Migrate(func(k string, old interface{}) (interface{}, bool) {
+		return fmt.Sprint("v2-", old), true
+	})
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(err)
+	}
+	assert.Equal(n, visited)
+	for i := 0; i < n; i++ {
+		v, _ := kv.Get(strconv.Itoa(i))
+		assert.Equal(fmt.Sprint("v2-", i), v)
+	}
+}
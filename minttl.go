@@ -0,0 +1,41 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// MinTTL sets a floor for the timeouts of puts (ExpiresAfter and
+// IdleTimeout): a shorter positive one is clamped up to d, or, with
+// RejectShortTTLs, makes the put fail with ErrInvalidOptions. It protects the
+// expiration machinery from clients churning very short-lived entries.
+// ExpiresAt deadlines, leases and replayed WAL records are not affected.
+func MinTTL(d time.Duration) StoreOption {
+	return func(opt *storeOpt) {
+		opt.minTTL = d
+	}
+}
+
+// RejectShortTTLs makes puts with a timeout below MinTTL fail, instead of
+// clamping it
+func RejectShortTTLs() StoreOption {
+	return func(opt *storeOpt) {
+		opt.rejectShortTTLs = true
+	}
+}
+
+// floorTTL applies MinTTL to opt
+func (kv *Store) floorTTL(opt *putOpt) {
+	if kv.minTTL <= 0 {
+		return
+	}
+	for _, ttl := range []*time.Duration{&opt.expiresAfter, &opt.idleTimeout} {
+		if *ttl <= 0 || *ttl >= kv.minTTL {
+			continue
+		}
+		if kv.rejectShortTTLs {
+			opt.belowMinTTL = true
+			continue
+		}
+		*ttl = kv.minTTL
+	}
+}
@@ -0,0 +1,62 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinTTLClamp(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), MinTTL(time.Second), Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("short", 1, ExpiresAfter(time.Millisecond)))
+	assert.NoError(kv.CAS("cas", 1, func(interface{}, bool) bool { return true }, ExpiresAfter(time.Millisecond)))
+	assert.NoError(kv.Put("idle", 1, IdleTimeout(time.Millisecond)))
+	assert.NoError(kv.Put("long", 1, ExpiresAfter(time.Minute)))
+	assert.NoError(kv.Put("forever", 1))
+
+	for _, k := range []string{"short", "cas"} {
+		meta, _ := kv.GetMeta(k)
+		assert.Equal(time.Second, meta.ExpiresAfter, k)
+		assert.Equal(time.Second, meta.Remaining, k)
+	}
+	meta, _ := kv.GetMeta("long")
+	assert.Equal(time.Minute, meta.ExpiresAfter)
+	meta, _ = kv.GetMeta("forever")
+	assert.True(meta.ExpiresAt.IsZero())
+
+	clock.Advance(time.Millisecond * 500)
+	_, ok := kv.Get("short")
+	assert.True(ok)
+
+	// the heap deadline is the clamped one
+	clock.Advance(time.Second)
+	kv.ExpireNow()
+	assert.ElementsMatch([]string{"forever", "long"}, kv.Keys())
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestMinTTLReject(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, MinTTL(time.Second), RejectShortTTLs())
+	defer kv.Stop()
+
+	err := kv.Put("short", 1, ExpiresAfter(time.Millisecond))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	err = kv.CAS("short", 1, func(interface{}, bool) bool { return true }, IdleTimeout(time.Millisecond))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	_, err = kv.Append("list", 1, ExpiresAfter(time.Millisecond))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.Equal(0, kv.Stats().Entries)
+
+	assert.NoError(kv.Put("exact", 1, ExpiresAfter(time.Second)))
+	assert.NoError(kv.Put("forever", 1))
+	assert.Equal(2, kv.Stats().Entries)
+}
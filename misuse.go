@@ -0,0 +1,134 @@
+package tinykv
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+// Strict makes the store panic on a misuse, for tests: an operation (Get,
+// Put, CAS, Delete, Take and the like) after Stop, or by a synchronous
+// notification (see SynchronousNotifications), on the goroutine that
+// delivers it; a Put with an empty key, or with invalid options (like
+// IsSliding without ExpiresAfter). The panic is a *MisuseError; one in a
+// synchronous notification goes up from the call that delivered it, past the
+// recovery of the panics of the notifications. Without it, a misuse is
+// counted in Stats.Misuses, and the store behaves as documented: it keeps
+// working after Stop and from notifications, takes the empty key like any
+// other, and fails the Put with ErrInvalidOptions. A notification calling
+// into the store is only detected under Strict, since it costs a stack trace
+// per operation.
+func Strict() StoreOption {
+	return func(opt *storeOpt) {
+		opt.strict = true
+	}
+}
+
+// MisuseError is the panic of a misuse of the store, under Strict
+type MisuseError struct {
+	Misuse string
+}
+
+func (e *MisuseError) Error() string { return "tinykv: " + e.Misuse }
+
+// misused reports a misuse: it panics under Strict, and counts it otherwise,
+// so the caller goes on as documented
+func (kv *Store) misused(misuse string) {
+	if kv.strict {
+		err := &MisuseError{Misuse: misuse}
+		kv.notifyingMx.Lock()
+		if n := kv.notifying[goroutineID()]; n != nil {
+			n.misuse = err
+		}
+		kv.notifyingMx.Unlock()
+		panic(err)
+	}
+	atomic.AddInt64(&kv.misuses, 1)
+}
+
+// checkUse checks an operation on the store, from active
+func (kv *Store) checkUse() {
+	select {
+	case <-kv.stop:
+		kv.misused("store used after Stop")
+	default:
+	}
+	if kv.strict && kv.inNotification() {
+		kv.misused("store used by a synchronous notification, on the goroutine that delivers it")
+	}
+}
+
+// checkPut checks a put of k, before its options are validated
+func (kv *Store) checkPut(k string, opt *putOpt) {
+	if k == "" {
+		kv.misused("put with an empty key")
+	}
+	if err := opt.validate(); err != nil {
+		kv.misused("put with " + err.Error())
+	}
+}
+
+// notifying is a goroutine delivering synchronous notifications
+type notifying struct {
+	depth  int
+	misuse *MisuseError // of a notification, recovered by try
+}
+
+// deliverInline runs notify, the delivery of a synchronous notification, on
+// the calling goroutine; under Strict, it records the goroutine, for
+// checkUse, and panics again with a misuse of the notification
+func (kv *Store) deliverInline(notify func()) {
+	if !kv.strict {
+		notify()
+		return
+	}
+	id := goroutineID()
+	kv.notifyingMx.Lock()
+	if kv.notifying == nil {
+		kv.notifying = make(map[uint64]*notifying)
+	}
+	n := kv.notifying[id]
+	if n == nil {
+		n = &notifying{}
+		kv.notifying[id] = n
+	}
+	n.depth++
+	kv.notifyingMx.Unlock()
+	var misuse *MisuseError
+	func() {
+		defer func() {
+			kv.notifyingMx.Lock()
+			misuse = n.misuse
+			if n.depth--; n.depth == 0 {
+				delete(kv.notifying, id)
+			}
+			kv.notifyingMx.Unlock()
+		}()
+		notify()
+	}()
+	if misuse != nil {
+		panic(misuse)
+	}
+}
+
+// inNotification reports if the calling goroutine is delivering a
+// synchronous notification
+func (kv *Store) inNotification() bool {
+	kv.notifyingMx.Lock()
+	defer kv.notifyingMx.Unlock()
+	if len(kv.notifying) == 0 {
+		return false
+	}
+	return kv.notifying[goroutineID()] != nil
+}
+
+// goroutineID returns the id of the calling goroutine, from its stack
+func goroutineID() uint64 {
+	b := make([]byte, 64)
+	b = b[:runtime.Stack(b, false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	b = b[:bytes.IndexByte(b, ' ')]
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
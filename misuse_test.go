@@ -0,0 +1,94 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrict(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Strict())
+	assert.True(kv.Config().Strict)
+	assert.NoError(kv.Put("k", 1))
+
+	assert.PanicsWithError("tinykv: put with an empty key", func() { kv.Put("", 1) })
+	assert.PanicsWithError("tinykv: put with IsSliding without ExpiresAfter: INVALID OPTIONS", func() {
+		kv.Put("k", 1, IsSliding(true))
+	})
+
+	kv.Stop()
+	assert.PanicsWithError("tinykv: store used after Stop", func() { kv.Get("k") })
+	assert.PanicsWithError("tinykv: store used after Stop", func() { kv.Put("k", 2) })
+	assert.PanicsWithError("tinykv: store used after Stop", func() { kv.Delete("k") })
+}
+
+func TestStrictReentrantNotification(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var kv *Store
+	var recovered int
+	kv = NewStore(time.Hour,
+		Strict(),
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) {
+			// a recover in the notification does not hide it
+			defer func() { recover(); recovered++ }()
+			kv.Put(k, v)
+		}))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	clock.Advance(time.Second * 2)
+	assert.PanicsWithError("tinykv: store used by a synchronous notification, on the goroutine that delivers it",
+		func() { kv.ExpireNow() })
+	assert.Equal(1, recovered)
+
+	// from another goroutine, it is fine
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(kv.Put("other", 1))
+	}()
+	<-done
+}
+
+func TestLenientMisuse(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var kv *Store
+	kv = NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) { kv.Put("again", v) }))
+	assert.False(kv.Config().Strict)
+
+	assert.NoError(kv.Put("", 1))
+	v, ok := kv.Get("")
+	assert.True(ok)
+	assert.Equal(1, v)
+	assert.Equal(ErrInvalidOptions, errors.Cause(kv.Put("k", 1, IsSliding(true))))
+	assert.Equal(int64(2), kv.Stats().Misuses)
+
+	// a notification may use the store
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	_, ok = kv.Get("again")
+	assert.True(ok)
+	assert.Equal(int64(2), kv.Stats().Misuses)
+
+	kv.Stop()
+	assert.NoError(kv.Put("k", 2))
+	v, ok = kv.Get("k")
+	assert.True(ok)
+	assert.Equal(2, v)
+	assert.Equal(int64(4), kv.Stats().Misuses)
+}
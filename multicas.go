@@ -0,0 +1,119 @@
+package tinykv
+
+import (
+	"github.com/pkg/errors"
+)
+
+// MaxMultiCAS is the most operations a MultiCAS takes
+const MaxMultiCAS = 16
+
+// CASOp is an operation of MultiCAS: NewValue is put at Key, with Options,
+// if Cond passes (a nil Cond always passes)
+type CASOp struct {
+	Key      string
+	Cond     func(old interface{}, found bool) bool
+	NewValue interface{}
+	Options  []PutOption
+}
+
+// MultiCAS is CAS on up to MaxMultiCAS distinct keys, all or nothing: under
+// the lock, the conditions are called with the current values, in order, and
+// the new values are put only if all of them pass. Otherwise nothing changes,
+// and the error is an *OpError of the first failing key (with ErrCASCond,
+// ErrReadOnly, ErrIndexConflict, ...). Under a UniqueIndex, two new values
+// of the batch cannot claim the same index key either. The options are those
+// of CAS, except BoundTo. Like Append, it is not limited by MaxEntries,
+// MaxCost or quotas.
+func (kv *Store) MultiCAS(ops []CASOp) error {
+	kv.active()
+	opts, err := multiCASOptions(ops, kv.putOptions)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if err := kv.checkString(op.NewValue); err != nil {
+			return opError("multi-cas", op.Key, err)
+		}
+	}
+	kv.mx.Lock()
+	expired := make(map[string]*entry)
+	olds := make([]*entry, len(ops))
+	claimed := make(map[uniqueClaim]string)
+	for i, op := range ops {
+		old, exp := kv.lookup(op.Key)
+		for k, e := range exp {
+			expired[k] = e
+		}
+		err = kv.checkCASOp(op, old, claimed)
+		if err != nil {
+			break
+		}
+		olds[i] = old
+	}
+	if err == nil {
+		for i, op := range ops {
+			e := kv.newEntry(op.Key, kv.copyValue(op.NewValue), opts[i])
+			kv.cas(op.Key, olds[i], e, func(interface{}, bool) bool { return true }, opts[i])
+		}
+		err = kv.pendingError()
+	}
+	kv.mx.Unlock()
+	err = kv.walSync(err)
+	kv.notify(expired)
+	if err != nil || kv.backing == nil {
+		return err
+	}
+	for _, op := range ops {
+		if err := kv.backingStore(op.Key, op.NewValue); err != nil {
+			return opError("multi-cas", op.Key, err)
+		}
+	}
+	return nil
+}
+
+// checkCASOp returns the error of op, for the current entry old, if any; the
+// index keys claimed by the ops before it are in claimed
+func (kv *Store) checkCASOp(op CASOp, old *entry, claimed map[uniqueClaim]string) error {
+	var oldValue interface{}
+	if old != nil {
+		if old.readOnly {
+			return opError("multi-cas", op.Key, ErrReadOnly)
+		}
+		oldValue = old.value
+	}
+	if op.Cond != nil && !op.Cond(oldValue, old != nil) {
+		return opError("multi-cas", op.Key, ErrCASCond)
+	}
+	return opError("multi-cas", op.Key, kv.checkUniqueBatch(op.Key, op.NewValue, claimed))
+}
+
+// multiCASOptions validates ops, and returns their options
+func multiCASOptions(ops []CASOp, putOptions func([]PutOption) *putOpt) ([]*putOpt, error) {
+	if len(ops) > MaxMultiCAS {
+		return nil, opError("multi-cas", "", errors.Wrapf(ErrInvalidOptions, "%d operations, more than MaxMultiCAS", len(ops)))
+	}
+	opts := make([]*putOpt, len(ops))
+	seen := make(map[string]struct{}, len(ops))
+	for i, op := range ops {
+		if _, ok := seen[op.Key]; ok {
+			return nil, opError("multi-cas", op.Key, errors.Wrap(ErrInvalidOptions, "duplicate key"))
+		}
+		seen[op.Key] = struct{}{}
+		opt := putOptions(op.Options)
+		var err error
+		switch {
+		case opt.cas != nil || opt.casMeta != nil:
+			err = errors.Wrap(ErrInvalidOptions, "CAS options passed to MultiCAS")
+		case opt.boundTo != nil:
+			err = errors.Wrap(ErrInvalidOptions, "BoundTo passed to MultiCAS")
+		default:
+			opt.cas = func(interface{}, bool) bool { return true } // for KeepTTL and ResetTTL
+			err = opt.validate()
+		}
+		if err != nil {
+			return nil, opError("multi-cas", op.Key, err)
+		}
+		opts[i] = opt
+	}
+	return opts, nil
+}
@@ -0,0 +1,177 @@
+package tinykv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func absent(old interface{}, found bool) bool { return !found }
+
+func TestMultiCAS(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("cart:1", "open"))
+	err := kv.MultiCAS([]CASOp{
+		{Key: "seat:7", Cond: absent, NewValue: "alice", Options: []PutOption{ExpiresAfter(time.Minute)}},
+		{Key: "token:9", Cond: absent, NewValue: "alice"},
+		{Key: "cart:1", Cond: func(old interface{}, found bool) bool { return old == "open" }, NewValue: "reserved"},
+	})
+	assert.NoError(err)
+	v, _ := kv.Get("seat:7")
+	assert.Equal("alice", v)
+	v, _ = kv.Get("token:9")
+	assert.Equal("alice", v)
+	v, _ = kv.Get("cart:1")
+	assert.Equal("reserved", v)
+	meta, _ := kv.GetMeta("seat:7")
+	assert.Equal(time.Minute, meta.ExpiresAfter)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestMultiCASRollback(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("cart:1", "open"))
+	assert.NoError(kv.Put("token:9", "bob"))
+	err := kv.MultiCAS([]CASOp{
+		{Key: "seat:7", Cond: absent, NewValue: "alice", Options: []PutOption{ExpiresAfter(time.Minute)}},
+		{Key: "cart:1", NewValue: "reserved"},
+		{Key: "token:9", Cond: absent, NewValue: "alice"},
+	})
+	assert.Equal(ErrCASCond, errors.Cause(err))
+	assert.Equal("token:9", err.(*OpError).Key)
+
+	// nothing changed
+	_, ok := kv.Get("seat:7")
+	assert.False(ok)
+	v, _ := kv.Get("cart:1")
+	assert.Equal("open", v)
+	v, _ = kv.Get("token:9")
+	assert.Equal("bob", v)
+	assert.Equal(0, kv.Stats().HeapLen)
+	assert.NoError(kv.CheckInvariants())
+
+	assert.NoError(kv.Put("locked", 1, ReadOnly()))
+	err = kv.MultiCAS([]CASOp{{Key: "seat:7", NewValue: 1}, {Key: "locked", NewValue: 2}})
+	assert.Equal(ErrReadOnly, errors.Cause(err))
+	_, ok = kv.Get("seat:7")
+	assert.False(ok)
+}
+
+func TestMultiCASUniqueIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, UniqueIndex("user", byUser), Debug())
+	defer kv.Stop()
+
+	// two values of the batch claim the same index key
+	err := kv.MultiCAS([]CASOp{
+		{Key: "token:1", NewValue: session{"alice"}},
+		{Key: "token:2", NewValue: session{"alice"}},
+	})
+	assert.Equal(ErrIndexConflict, errors.Cause(err))
+	assert.EqualError(err, `tinykv: multi-cas "token:2": index "user" key "alice" is claimed by "token:1", in the same batch: INDEX CONFLICT`)
+	assert.Empty(kv.Keys())
+	_, _, ok := kv.LookupByIndex("user", "alice")
+	assert.False(ok)
+
+	// or one claimed by an entry
+	assert.NoError(kv.Put("token:3", session{"bob"}))
+	err = kv.MultiCAS([]CASOp{
+		{Key: "token:1", NewValue: session{"alice"}},
+		{Key: "token:2", NewValue: session{"bob"}},
+	})
+	assert.Equal(ErrIndexConflict, errors.Cause(err))
+	assert.Equal([]string{"token:3"}, kv.Keys())
+
+	assert.NoError(kv.MultiCAS([]CASOp{
+		{Key: "token:1", NewValue: session{"alice"}},
+		{Key: "token:2", NewValue: session{"carol"}},
+	}))
+	k, _, _ := kv.LookupByIndex("user", "alice")
+	assert.Equal("token:1", k)
+	k, _, _ = kv.LookupByIndex("user", "carol")
+	assert.Equal("token:2", k)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestMultiCASInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+
+	err := kv.MultiCAS([]CASOp{{Key: "a"}, {Key: "b"}, {Key: "a"}})
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.Equal("a", err.(*OpError).Key)
+	ops := make([]CASOp, MaxMultiCAS+1)
+	for i := range ops {
+		ops[i].Key = fmt.Sprint(i)
+	}
+	assert.Equal(ErrInvalidOptions, errors.Cause(kv.MultiCAS(ops)))
+	err = kv.MultiCAS([]CASOp{{Key: "a", Options: []PutOption{CAS(absent)}}})
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.Equal(0, kv.Len())
+	assert.NoError(kv.MultiCAS(nil))
+}
+
+func TestMultiCASContention(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	// each claimer wants three adjacent seats, of a row of 12
+	const claimers = 10
+	won := make([]bool, claimers)
+	var wg sync.WaitGroup
+	for round := 0; round < 20; round++ {
+		kv.Clear()
+		for i := 0; i < claimers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var ops []CASOp
+				for seat := i; seat < i+3; seat++ {
+					ops = append(ops, CASOp{Key: fmt.Sprintf("seat:%d", seat), Cond: absent, NewValue: i})
+				}
+				err := kv.MultiCAS(ops)
+				won[i] = err == nil
+				if err != nil {
+					assert.Equal(ErrCASCond, errors.Cause(err))
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// a winner got all its seats, a loser none
+		owners := make(map[int]int)
+		kv.Range(func(k string, v interface{}) bool {
+			owners[v.(int)]++
+			return true
+		})
+		winners := 0
+		for i := 0; i < claimers; i++ {
+			if won[i] {
+				winners++
+				assert.Equal(3, owners[i])
+			} else {
+				assert.Equal(0, owners[i])
+			}
+		}
+		assert.True(winners > 0)
+		assert.Equal(winners*3, kv.Len())
+		assert.NoError(kv.CheckInvariants())
+	}
+}
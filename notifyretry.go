@@ -0,0 +1,165 @@
+package tinykv
+
+import (
+	"time"
+)
+
+const (
+	defaultNotifyAttempts = 3
+	defaultNotifyBackoff  = time.Millisecond * 100
+)
+
+// OnExpireE sets a function for expiration notifications that can fail. The
+// first attempt is made like the other expiration notifications (see
+// SynchronousNotifications), so it must be fast. A notification it fails is
+// queued, and tried again (see NotifyRetry) by a goroutine of its own, so the
+// retries hold up neither the sweeps nor the other notifications; one that
+// fails every attempt goes to OnNotifyDeadLetter. The queue is worked off
+// after Stop too.
+func OnExpireE(onExpireE func(k string, v interface{}) error) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onExpireE = onExpireE
+	}
+}
+
+// NotifyRetry sets how many times OnExpireE is tried for an expired entry,
+// 3 by default, and the backoff between the attempts, which doubles after
+// each one
+func NotifyRetry(attempts int, backoff time.Duration) StoreOption {
+	return func(opt *storeOpt) {
+		opt.notifyAttempts = attempts
+		opt.notifyBackoff = backoff
+	}
+}
+
+// OnNotifyDeadLetter sets the function that gets the expired entries
+// OnExpireE failed for, every attempt, with the error of the last one
+func OnNotifyDeadLetter(onDeadLetter func(k string, v interface{}, lastErr error)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onNotifyDeadLetter = onDeadLetter
+	}
+}
+
+// failedNotification is a notification of OnExpireE, waiting for a retry
+type failedNotification struct {
+	key      string
+	value    interface{}
+	attempts int       // made so far
+	next     time.Time // of the next attempt
+	err      error     // of the last attempt
+}
+
+// startNotifyRetry starts the goroutine of the retries, on creation
+func (kv *Store) startNotifyRetry() {
+	if kv.onExpireE == nil {
+		return
+	}
+	if kv.notifyAttempts <= 0 {
+		kv.notifyAttempts = defaultNotifyAttempts
+	}
+	if kv.notifyBackoff <= 0 {
+		kv.notifyBackoff = defaultNotifyBackoff
+	}
+	kv.retryReady = make(chan struct{}, 1)
+	go kv.retryLoop()
+}
+
+// notifyE makes the first attempt of OnExpireE for k
+func (kv *Store) notifyE(k string, v interface{}) {
+	kv.attemptE(&failedNotification{key: k, value: v})
+}
+
+// attemptE makes an attempt of OnExpireE for f; a failure is queued for the
+// next one, or goes to the dead letters after the last one
+func (kv *Store) attemptE(f *failedNotification) {
+	f.err = try(func() error { return kv.onExpireE(f.key, f.value) })
+	f.attempts++
+	if f.err == nil {
+		return
+	}
+	if f.attempts >= kv.notifyAttempts {
+		kv.deadLetter(f)
+		return
+	}
+	f.next = time.Now().Add(kv.notifyBackoff << uint(f.attempts-1))
+	kv.mx.Lock()
+	stopped := kv.retryStopped
+	if !stopped {
+		kv.retries = append(kv.retries, f)
+	}
+	kv.mx.Unlock()
+	if stopped {
+		time.AfterFunc(time.Until(f.next), func() {
+			kv.spawn(func() { kv.attemptE(f) })
+		})
+		return
+	}
+	select {
+	case kv.retryReady <- struct{}{}:
+	default:
+	}
+}
+
+func (kv *Store) deadLetter(f *failedNotification) {
+	if kv.onNotifyDeadLetter == nil {
+		return
+	}
+	try(func() error {
+		kv.onNotifyDeadLetter(f.key, f.value, f.err)
+		return nil
+	})
+}
+
+// retryLoop makes the retries as they are due. After Stop, it works off the
+// queue and exits; later failures are retried by goroutines of their own.
+func (kv *Store) retryLoop() {
+	stop := kv.stop
+	for {
+		kv.mx.Lock()
+		due, next := kv.dueRetries(time.Now())
+		if stop == nil && len(due) == 0 && next.IsZero() {
+			kv.retryStopped = true
+			kv.mx.Unlock()
+			return
+		}
+		kv.mx.Unlock()
+		if len(due) > 0 {
+			for _, f := range due {
+				f := f
+				kv.spawnWait(func() { kv.attemptE(f) })
+			}
+			continue
+		}
+		var wake <-chan time.Time
+		if !next.IsZero() {
+			wake = time.After(time.Until(next))
+		}
+		select {
+		case <-kv.retryReady:
+		case <-wake:
+		case <-stop:
+			stop = nil
+		}
+	}
+}
+
+// dueRetries takes the retries due at now out of the queue, and returns the
+// time of the next one left (zero if none); it must be called under the lock
+func (kv *Store) dueRetries(now time.Time) (due []*failedNotification, next time.Time) {
+	left := kv.retries[:0]
+	for _, f := range kv.retries {
+		if !f.next.After(now) {
+			due = append(due, f)
+			continue
+		}
+		left = append(left, f)
+		if next.IsZero() || f.next.Before(next) {
+			next = f.next
+		}
+	}
+	for i := len(left); i < len(kv.retries); i++ {
+		kv.retries[i] = nil
+	}
+	kv.retries = left
+	return due, next
+}
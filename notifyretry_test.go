@@ -0,0 +1,119 @@
+package tinykv
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnExpireERetries(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var attempts int32
+	succeeded := make(chan string, 1)
+	deadLetters := make(chan string, 1)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		NotifyRetry(3, time.Millisecond),
+		OnExpireE(func(k string, v interface{}) error {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return errors.New("downstream unavailable")
+			}
+			succeeded <- k
+			return nil
+		}),
+		OnNotifyDeadLetter(func(k string, v interface{}, lastErr error) { deadLetters <- k }))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+
+	assert.Equal([]string{"k"}, receiveClosed(t, succeeded, 1))
+	assert.Equal(int32(3), atomic.LoadInt32(&attempts))
+	select {
+	case k := <-deadLetters:
+		t.Fatalf("dead letter for %q", k)
+	case <-time.After(time.Millisecond * 50):
+	}
+	assert.Equal(int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestOnExpireEDeadLetter(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var attempts int32
+	type deadLetter struct {
+		k       string
+		v       interface{}
+		lastErr error
+	}
+	deadLetters := make(chan deadLetter, 10)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		NotifyRetry(4, time.Millisecond),
+		OnExpireE(func(k string, v interface{}) error {
+			n := atomic.AddInt32(&attempts, 1)
+			return errors.Errorf("attempt %d", n)
+		}),
+		OnNotifyDeadLetter(func(k string, v interface{}, lastErr error) {
+			deadLetters <- deadLetter{k, v, lastErr}
+		}))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k1", 1, ExpiresAfter(time.Second)))
+	assert.NoError(kv.Put("k2", 2, ExpiresAfter(time.Second)))
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+
+	got := make(map[string]deadLetter)
+	for len(got) < 2 {
+		select {
+		case dl := <-deadLetters:
+			got[dl.k] = dl
+		case <-time.After(time.Second * 5):
+			t.Fatalf("got %d dead letters, want 2", len(got))
+		}
+	}
+	assert.Equal(1, got["k1"].v)
+	assert.Equal(2, got["k2"].v)
+	assert.Error(got["k1"].lastErr)
+	select {
+	case dl := <-deadLetters:
+		t.Fatalf("another dead letter for %q", dl.k)
+	case <-time.After(time.Millisecond * 50):
+	}
+	assert.Equal(int32(8), atomic.LoadInt32(&attempts))
+}
+
+func TestOnExpireEAfterStop(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var attempts int32
+	deadLetters := make(chan string, 1)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		NotifyRetry(2, time.Millisecond),
+		OnExpireE(func(k string, v interface{}) error {
+			atomic.AddInt32(&attempts, 1)
+			return errors.New("downstream unavailable")
+		}),
+		OnNotifyDeadLetter(func(k string, v interface{}, lastErr error) { deadLetters <- k }))
+
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	kv.Stop()
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+
+	assert.Equal([]string{"k"}, receiveClosed(t, deadLetters, 1))
+	assert.Equal(int32(2), atomic.LoadInt32(&attempts))
+	assert.Equal(2, kv.Config().NotifyAttempts)
+}
@@ -0,0 +1,57 @@
+package tinykv
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Null returns a KV that stores nothing: Put, Delete and Take succeed (CAS
+// conditions are called with found false, and ErrCASCond is returned if they
+// fail) and Get always misses. It can be used when caching is disabled.
+func Null() KV { return nullKV{} }
+
+type nullKV struct{}
+
+func (kv nullKV) CAS(k string, v interface{}, cond func(oldValue interface{}, found bool) bool, options ...PutOption) error {
+	opt := &putOpt{}
+	opt.apply(options)
+	if opt.cas != nil || opt.casMeta != nil {
+		return opError("cas", k, errors.Wrap(ErrInvalidOptions, "CAS options passed to the CAS method"))
+	}
+	return kv.put("cas", k, append(options, CAS(cond)))
+}
+
+func (nullKV) Delete(k string)        {}
+func (nullKV) DeleteE(k string) error { return opError("delete", k, ErrNotFound) }
+
+func (nullKV) Get(k string) (interface{}, bool)   { return nil, false }
+func (nullKV) GetE(k string) (interface{}, error) { return nil, opError("get", k, ErrNotFound) }
+
+func (nullKV) Keys() []string { return nil }
+func (nullKV) Len() int       { return 0 }
+
+func (kv nullKV) Put(k string, v interface{}, options ...PutOption) error {
+	return kv.put("put", k, options)
+}
+
+func (nullKV) put(op, k string, options []PutOption) error {
+	opt := &putOpt{}
+	opt.apply(options)
+	if err := opt.validate(); err != nil {
+		return opError(op, k, err)
+	}
+	switch {
+	case opt.cas != nil && !opt.cas(nil, false):
+		return opError(op, k, ErrCASCond)
+	case opt.casMeta != nil && !opt.casMeta(nil, Meta{}, false):
+		return opError(op, k, ErrCASCond)
+	}
+	return nil
+}
+
+func (nullKV) Range(fn func(k string, v interface{}) bool) {}
+
+func (nullKV) Take(k string) (interface{}, bool)   { return nil, false }
+func (nullKV) TakeE(k string) (interface{}, error) { return nil, opError("take", k, ErrNotFound) }
+func (nullKV) Touch(k string) bool                 { return false }
+
+func (nullKV) Stop() {}
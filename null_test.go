@@ -0,0 +1,78 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNull(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := Null()
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("1", 1, ExpiresAfter(time.Second)))
+	_, ok := kv.Get("1")
+	assert.False(ok)
+	_, err := kv.GetE("1")
+	assert.Equal(ErrNotFound, errors.Cause(err))
+	_, ok = kv.Take("1")
+	assert.False(ok)
+	kv.Delete("1")
+
+	var found []bool
+	cond := CAS(func(old interface{}, ok bool) bool {
+		found = append(found, ok)
+		return ok
+	})
+	assert.Equal(ErrCASCond, errors.Cause(kv.Put("1", 1, cond)))
+	assert.NoError(kv.Put("1", 1, CAS(func(interface{}, bool) bool { return true })))
+	assert.Equal(ErrCASCond, errors.Cause(kv.Put("1", 1, CASMeta(func(interface{}, Meta, bool) bool { return false }))))
+	assert.Equal([]bool{false}, found)
+	err = kv.Put("1", 1, CAS(func(interface{}, bool) bool { return true }),
+		CASMeta(func(interface{}, Meta, bool) bool { return true }))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+
+	assert.False(kv.Touch("1"))
+	assert.Empty(kv.Keys())
+	assert.Equal(0, kv.Len())
+}
+
+func TestFrozen(t *testing.T) {
+	assert := assert.New(t)
+
+	data := map[string]interface{}{"a:1": 1, "a:2": 2, "b:1": "x"}
+	kv := Frozen(data)
+	defer kv.Stop()
+	data["a:3"] = 3 // copied
+
+	v, ok := kv.Get("a:1")
+	assert.True(ok)
+	assert.Equal(1, v)
+	_, ok = kv.Get("a:3")
+	assert.False(ok)
+	assert.Equal([]string{"a:1", "a:2", "b:1"}, kv.Keys())
+	assert.Equal(3, kv.Len())
+
+	called := false
+	err := kv.Put("a:1", 11, CAS(func(interface{}, bool) bool {
+		called = true
+		return true
+	}))
+	assert.Equal(ErrReadOnly, errors.Cause(err))
+	assert.False(called)
+	assert.Equal(ErrReadOnly, errors.Cause(kv.Put("c", 1)))
+	assert.Equal(ErrReadOnly, errors.Cause(kv.DeleteE("a:1")))
+	assert.Equal(ErrNotFound, errors.Cause(kv.DeleteE("c")))
+	_, err = kv.TakeE("a:1")
+	assert.Equal(ErrReadOnly, errors.Cause(err))
+	kv.Delete("a:1")
+	_, ok = kv.Take("a:1")
+	assert.False(ok)
+	_, ok = kv.Get("a:1")
+	assert.True(ok)
+	assert.True(kv.Touch("a:1"))
+}
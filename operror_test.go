@@ -0,0 +1,75 @@
+package tinykv
+
+import (
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpError(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+
+	no := func(interface{}, bool) bool { return false }
+	kv.Put("list", 1)
+	kv.Put("ro", 1, ReadOnly())
+	_, _, _, errWindow := kv.IncrWindow("hits", 0, 1)
+	_, _, _, errCounter := kv.IncrWindow("list", time.Minute, 1)
+	_, errGet := kv.GetE("missing")
+	_, errTake := kv.TakeE("missing")
+	_, errAppend := kv.Append("list", 2)
+	_, _, errLease := kv.AcquireLease("list", "w1", time.Minute)
+
+	cases := []struct {
+		err      error
+		sentinel error
+		msg      string
+	}{
+		{kv.Put("session:42", 1, CAS(no)), ErrCASCond, `tinykv: put "session:42": CAS COND FAILED`},
+		{kv.CAS("session:42", 1, no), ErrCASCond, `tinykv: cas "session:42": CAS COND FAILED`},
+		{kv.Put("ro", 2), ErrReadOnly, `tinykv: put "ro": READ ONLY`},
+		{kv.DeleteE("missing"), ErrNotFound, `tinykv: delete "missing": NOT FOUND`},
+		{errGet, ErrNotFound, `tinykv: get "missing": NOT FOUND`},
+		{errTake, ErrNotFound, `tinykv: take "missing": NOT FOUND`},
+		{errWindow, ErrInvalidWindow, `tinykv: incr-window "hits": INVALID WINDOW`},
+		{errCounter, ErrTypeConflict, `tinykv: incr-window "list": holds a int, not a window counter: TYPE CONFLICT`},
+		{errAppend, ErrTypeConflict, `tinykv: append "list": holds a int, not a list: TYPE CONFLICT`},
+		{errLease, ErrTypeConflict, `tinykv: acquire-lease "list": holds a int, not a lease: TYPE CONFLICT`},
+		{kv.Put("k", 1, ExpiresAfter(-1)), ErrInvalidOptions, `tinykv: put "k": negative ExpiresAfter: INVALID OPTIONS`},
+	}
+	for _, c := range cases {
+		if !assert.Error(c.err) {
+			continue
+		}
+		assert.Equal(c.msg, c.err.Error())
+		assert.True(stderrors.Is(c.err, c.sentinel), c.msg)
+		assert.Equal(c.sentinel, errors.Cause(c.err), c.msg)
+		var opErr *OpError
+		if assert.True(stderrors.As(c.err, &opErr), c.msg) {
+			assert.NotEmpty(opErr.Op)
+			assert.NotEmpty(opErr.Key)
+		}
+	}
+}
+
+func TestOpErrorNullAndFrozen(t *testing.T) {
+	assert := assert.New(t)
+
+	err := Null().Put("k", 1, CAS(func(interface{}, bool) bool { return false }))
+	assert.Equal(`tinykv: put "k": CAS COND FAILED`, err.Error())
+	assert.True(stderrors.Is(err, ErrCASCond))
+	_, err = Null().GetE("k")
+	assert.Equal(`tinykv: get "k": NOT FOUND`, err.Error())
+
+	frozen := Frozen(map[string]interface{}{"k": 1})
+	err = frozen.Put("k", 2)
+	assert.Equal(`tinykv: put "k": READ ONLY`, err.Error())
+	assert.True(stderrors.Is(err, ErrReadOnly))
+	err = frozen.DeleteE("other")
+	assert.Equal(`tinykv: delete "other": NOT FOUND`, err.Error())
+}
@@ -0,0 +1,103 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutOptionsValidation(t *testing.T) {
+	yes := func(interface{}, bool) bool { return true }
+	yesMeta := func(interface{}, Meta, bool) bool { return true }
+	panics := func(*putOpt) { panic("boom") }
+	cases := []struct {
+		options []PutOption
+		err     string
+	}{
+		{[]PutOption{ExpiresAfter(-time.Second)}, "negative ExpiresAfter: INVALID OPTIONS"},
+		{[]PutOption{IdleTimeout(-time.Second)}, "negative IdleTimeout: INVALID OPTIONS"},
+		{[]PutOption{ExpiresAfter(time.Second), IsSliding(true), MaxSlides(-1)}, "negative MaxSlides: INVALID OPTIONS"},
+		{[]PutOption{CAS(yes), CASMeta(yesMeta)}, "both CAS and CASMeta: INVALID OPTIONS"},
+		{[]PutOption{CAS(yes), KeepTTL(), ResetTTL()}, "both KeepTTL and ResetTTL: INVALID OPTIONS"},
+		{[]PutOption{KeepTTL()}, "KeepTTL or ResetTTL without CAS: INVALID OPTIONS"},
+		{[]PutOption{ResetTTL()}, "KeepTTL or ResetTTL without CAS: INVALID OPTIONS"},
+		{[]PutOption{CAS(yes), KeepTTL(), ExpiresAfter(time.Second)}, "KeepTTL along with a new timeout: INVALID OPTIONS"},
+		{[]PutOption{CASMeta(yesMeta), KeepTTL(), IdleTimeout(time.Second)}, "KeepTTL along with a new timeout: INVALID OPTIONS"},
+		{[]PutOption{CAS(yes), KeepTTL(), ExpiresAt(time.Now())}, "KeepTTL along with a new timeout: INVALID OPTIONS"},
+		{[]PutOption{IsSliding(true)}, "IsSliding without ExpiresAfter: INVALID OPTIONS"},
+		{[]PutOption{IsSliding(true), IdleTimeout(time.Second)}, "IsSliding without ExpiresAfter: INVALID OPTIONS"},
+		{[]PutOption{MaxSlides(3)}, "MaxSlides on an entry that does not slide: INVALID OPTIONS"},
+		{[]PutOption{ExpiresAfter(time.Second), MaxSlides(3)}, "MaxSlides on an entry that does not slide: INVALID OPTIONS"},
+		{[]PutOption{ExpiresAfter(time.Second), nil}, "nil PutOption at 1: INVALID OPTIONS"},
+		{[]PutOption{panics, nil}, "PutOption at 0 panicked: boom: INVALID OPTIONS"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			assert := assert.New(t)
+
+			clock := newFakeClock()
+			kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+			defer kv.Stop()
+			kv.Put("k", 1, ExpiresAfter(time.Minute))
+			before, _ := kv.GetMeta("k")
+
+			err := kv.Put("k", 2, c.options...)
+			if assert.Error(err) {
+				assert.Equal(`tinykv: put "k": `+c.err, err.Error())
+			}
+			_, err = kv.Append("list", 1, c.options...)
+			if assert.Error(err) {
+				assert.Equal(`tinykv: append "list": `+c.err, err.Error())
+			}
+			_, err = kv.AddToSet("set", 1, c.options...)
+			if assert.Error(err) {
+				assert.Equal(`tinykv: add-to-set "set": `+c.err, err.Error())
+			}
+
+			v, _ := kv.Get("k")
+			assert.Equal(1, v)
+			after, _ := kv.GetMeta("k")
+			assert.Equal(before, after)
+			assert.Equal([]string{"k"}, kv.Keys())
+			assert.Equal(1, kv.Stats().HeapLen)
+			assert.NoError(kv.CheckInvariants())
+		})
+	}
+}
+
+func TestPutOptionsValid(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, DefaultSliding(true))
+	defer kv.Stop()
+
+	// the store default does not make a Put without a timeout invalid
+	assert.NoError(kv.Put("1", 1))
+	assert.NoError(kv.Put("2", 2, ExpiresAfter(time.Minute), MaxSlides(1)))
+	assert.NoError(kv.Put("3", 3, IdleTimeout(time.Minute), MaxSlides(1)))
+	assert.NoError(kv.Put("4", 4, IsSliding(false)))
+	assert.NoError(kv.CAS("1", 11, func(interface{}, bool) bool { return true }, KeepTTL()))
+}
+
+func TestPutOptionsBroken(t *testing.T) {
+	assert := assert.New(t)
+
+	panics := func(*putOpt) { panic(errors.New("boom")) }
+	for _, kv := range []KV{NewStore(time.Hour), Null()} {
+		for _, options := range [][]PutOption{{nil}, {panics}} {
+			var opErr *OpError
+			err := kv.Put("k", 1, options...)
+			if assert.True(errors.As(err, &opErr), "%v", err) {
+				assert.Equal("put", opErr.Op)
+				assert.Equal(ErrInvalidOptions, errors.Cause(opErr.Err))
+			}
+			err = kv.CAS("k", 1, func(interface{}, bool) bool { return true }, options...)
+			assert.Equal(ErrInvalidOptions, errors.Cause(err))
+		}
+		_, ok := kv.Get("k")
+		assert.False(ok)
+		kv.Stop()
+	}
+}
@@ -0,0 +1,110 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type originated struct {
+	origin, key string
+}
+
+func TestOnExpireWithOrigin(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var got []originated
+	onExpire := func(origin, k string, v interface{}) {
+		got = append(got, originated{origin, k})
+	}
+	// one function serves both stores
+	newStore := func(name string) *Store {
+		return NewStore(time.Hour,
+			Name(name),
+			Clock(clock.Now),
+			SynchronousNotifications(),
+			OnExpireWithOrigin(onExpire))
+	}
+	users, sessions := newStore("users"), newStore("sessions")
+	defer users.Stop()
+	defer sessions.Stop()
+	assert.True(users.Config().OnExpireWithOrigin)
+
+	users.Put("a", 1, ExpiresAfter(time.Second))
+	sessions.Put("b", 2, ExpiresAfter(time.Second))
+	sessions.Put("c", 3, ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+
+	// the sweep
+	users.ExpireNow()
+	assert.Equal([]originated{{"users", "a"}}, got)
+
+	// an expired entry found by Get
+	_, ok := sessions.Get("b")
+	assert.False(ok)
+	assert.Equal([]originated{{"users", "a"}, {"sessions", "b"}}, got)
+
+	sessions.ExpireNow()
+	assert.Equal([]originated{{"users", "a"}, {"sessions", "b"}, {"sessions", "c"}}, got)
+
+	// without a name, the origin is empty
+	got = nil
+	kv := NewStore(time.Hour, Clock(clock.Now), SynchronousNotifications(), OnExpireWithOrigin(onExpire))
+	defer kv.Stop()
+	kv.Put("d", 4, ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	assert.Equal([]originated{{"", "d"}}, got)
+}
+
+func TestEventOrigin(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Name("users"), Clock(clock.Now))
+	defer kv.Stop()
+
+	ch, cancel := kv.WatchPrefix("")
+	defer cancel()
+
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	kv.Put("b", 2, ExpiresAfter(time.Second))
+	kv.Put("c", 3)
+	kv.Put("d", 4)
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	kv.Get("b")
+	kv.Clear()
+
+	events := receive(t, ch, 8)
+	for _, ev := range events {
+		assert.Equal("users", ev.Origin, "%v", ev)
+	}
+	assert.Equal(EventExpire, events[4].Type)
+	assert.Equal(EventDelete, events[7].Type)
+}
+
+func TestExpiredStreamOrigin(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Name("users"), Clock(clock.Now))
+	defer kv.Stop()
+
+	ch, cancel := kv.ExpiredStream(2)
+	defer cancel()
+
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	kv.Put("b", 2, ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	kv.Get("b")
+
+	for _, k := range []string{"a", "b"} {
+		ex := <-ch
+		assert.Equal(k, ex.Key)
+		assert.Equal("users", ex.Origin)
+	}
+}
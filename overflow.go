@@ -0,0 +1,134 @@
+package tinykv
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OverflowStore keeps the entries evicted to make room, see Overflow.
+// deadline is zero for entries that do not expire.
+type OverflowStore interface {
+	Put(k string, v []byte, deadline time.Time) error
+	Get(k string) (v []byte, deadline time.Time, ok bool, err error)
+	Delete(k string) error
+}
+
+// Overflow makes the store spill the entries evicted under MaxEntries or
+// MaxCost to o, encoded using codec, instead of dropping them. On a miss, Get
+// falls back to o, and promotes a hit back into memory with its remaining
+// time (a sliding entry comes back as a plain one); Take and Delete fall back
+// to o too. Overflow entries past their deadline are dropped when read.
+//
+// An entry is in one tier at a time: a write of a key that is not in memory
+// deletes it from o, so Delete of o must be cheap for keys it does not have.
+// Other reads (GetMeta, Keys, Range, CAS conditions, ...) and bulk removals
+// only see the entries in memory. Window counters and entries bound to a
+// parent (BoundTo) are not spilled, and MissFilter is not used.
+//
+// o is called under the lock, like the writer of WAL; errors of spills and
+// deletes are returned by the next Put.
+func Overflow(o OverflowStore, codec ValueCodec) StoreOption {
+	return func(opt *storeOpt) {
+		opt.overflow = o
+		opt.overflowCodec = codec
+	}
+}
+
+// spill writes the evicted entry e of k to the overflow store, under the lock
+func (kv *Store) spill(k string, e *entry) {
+	if kv.overflow == nil || e.bound != nil {
+		return
+	}
+	if _, ok := e.value.(*windowCounter); ok {
+		return
+	}
+	data, err := kv.overflowCodec.Encode(e.value)
+	if err != nil {
+		kv.overflowFailed(errors.Wrapf(err, "encoding value of %q", k))
+		return
+	}
+	var deadline time.Time
+	if e.timeout != nil {
+		deadline = e.timeout.expiresAt
+	}
+	if err := kv.overflow.Put(k, data, deadline); err != nil {
+		kv.overflowFailed(err)
+		return
+	}
+	kv.stats.Spilled++
+}
+
+// overflowGet reads k from the overflow store, under the lock; an expired
+// entry is dropped
+func (kv *Store) overflowGet(k string) (interface{}, time.Time, error) {
+	data, deadline, ok, err := kv.overflow.Get(k)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "overflow")
+	}
+	if !ok {
+		return nil, time.Time{}, ErrNotFound
+	}
+	if !deadline.IsZero() && !kv.now().Before(deadline) {
+		kv.overflowDrop(k)
+		return nil, time.Time{}, ErrExpired
+	}
+	v, err := kv.overflowCodec.Decode(data)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "decoding overflow value of %q", k)
+	}
+	return v, deadline, nil
+}
+
+// unspill gets k from the overflow store and puts it back in memory, under
+// the lock. If there is no room for it, it is served from the overflow store.
+func (kv *Store) unspill(k string) (interface{}, *bulkRemoval, error) {
+	v, deadline, err := kv.overflowGet(k)
+	if err != nil {
+		return nil, nil, err
+	}
+	evicted, err := kv.makeRoom(k, v, true)
+	if err != nil {
+		return v, nil, nil
+	}
+	kv.set(k, kv.newEntry(k, v, &putOpt{expiresAt: deadline}))
+	kv.stats.Unspilled++
+	return kv.copyValue(v), evicted, nil
+}
+
+// overflowTake removes k from the overflow store, under the lock
+func (kv *Store) overflowTake(k string) (interface{}, error) {
+	v, _, err := kv.overflowGet(k)
+	if err != nil {
+		return nil, err
+	}
+	kv.overflowDrop(k)
+	return v, nil
+}
+
+// overflowDrop deletes k from the overflow store, under the lock
+func (kv *Store) overflowDrop(k string) {
+	if kv.overflow == nil {
+		return
+	}
+	if err := kv.overflow.Delete(k); err != nil {
+		kv.overflowFailed(err)
+	}
+}
+
+func (kv *Store) overflowFailed(err error) {
+	if kv.overflowErr == nil {
+		kv.overflowErr = errors.Wrap(err, "overflow")
+	}
+}
+
+// pendingError returns (and clears) the first WAL error, or else the first
+// overflow error, since the last call
+func (kv *Store) pendingError() error {
+	err := kv.walError()
+	if kv.overflowErr != nil && err == nil {
+		err = kv.overflowErr
+	}
+	kv.overflowErr = nil
+	return err
+}
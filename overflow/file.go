@@ -0,0 +1,386 @@
+// Package overflow provides a file backed tinykv.OverflowStore, to keep
+// the entries a store evicts under MaxEntries or MaxCost on local disk.
+//
+// The file is a log of frames, one per Put and Delete. A frame is the length
+// of its body (uvarint), the body, and the CRC-32 (IEEE, big endian) of the
+// body. A body holds the op, the key, and for a put, the value bytes and
+// the absolute deadline (0 if none). The keys, with the place of their last
+// put, are kept in memory; values are read from the file. When more than
+// half of the file is superseded records, it is rewritten (compacted).
+// Writes are not synced: a crash can lose the latest records, which is fine
+// for a cache tier.
+package overflow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dc0d/tinykv"
+	"github.com/pkg/errors"
+)
+
+const (
+	opPut = iota + 1
+	opDelete
+
+	maxFrame = 1 << 28
+
+	// compactMinSize is the size a file must reach before it is compacted
+	compactMinSize = 1 << 20
+)
+
+// errors
+var (
+	ErrCorrupted error = sentinelErr("CORRUPTED OVERFLOW RECORD")
+	ErrClosed    error = sentinelErr("OVERFLOW FILE CLOSED")
+)
+
+var _ tinykv.OverflowStore = (*File)(nil)
+
+// File is an OverflowStore kept in a file. It is safe for concurrent use.
+type File struct {
+	mx      sync.Mutex
+	path    string
+	f       *os.File
+	size    int64
+	garbage int64 // bytes of the frames superseded by later ones
+	index   map[string]record
+	now     func() time.Time
+}
+
+// record is the place of the frame of the last put of a key
+type record struct {
+	offset   int64
+	size     int64
+	deadline time.Time
+}
+
+// Open opens (or creates) the file at path, and loads its keys. A frame cut
+// off or corrupted (by a crash) ends the log: it is truncated there.
+func Open(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	o := &File{path: path, f: f, index: make(map[string]record), now: time.Now}
+	if err := o.load(); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "loading %s", path)
+	}
+	return o, nil
+}
+
+func (o *File) load() error {
+	br := bufio.NewReader(o.f)
+	for {
+		body, size, err := readFrame(br)
+		if err != nil {
+			break
+		}
+		op, k, _, deadline, err := decodeBody(body)
+		if err != nil {
+			break
+		}
+		o.supersede(k)
+		if op == opPut {
+			o.index[k] = record{offset: o.size, size: size, deadline: deadline}
+		} else {
+			o.garbage += size
+		}
+		o.size += size
+	}
+	if err := o.f.Truncate(o.size); err != nil {
+		return err
+	}
+	_, err := o.f.Seek(o.size, io.SeekStart)
+	return err
+}
+
+// supersede accounts for the frame of the last put of k, if any, as garbage
+func (o *File) supersede(k string) {
+	if r, ok := o.index[k]; ok {
+		o.garbage += r.size
+		delete(o.index, k)
+	}
+}
+
+// Put stores v for k, until deadline (if not zero)
+func (o *File) Put(k string, v []byte, deadline time.Time) error {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	if o.f == nil {
+		return ErrClosed
+	}
+	var d int64
+	if !deadline.IsZero() {
+		d = deadline.UnixNano()
+	}
+	body := appendUvarint(nil, opPut)
+	body = appendBytes(body, []byte(k))
+	body = appendBytes(body, v)
+	body = appendVarint(body, d)
+	offset := o.size
+	size, err := o.write(body)
+	if err != nil {
+		return err
+	}
+	o.supersede(k)
+	o.index[k] = record{offset: offset, size: size, deadline: deadline}
+	return o.maybeCompact()
+}
+
+// Get returns the value of k, and its deadline
+func (o *File) Get(k string) ([]byte, time.Time, bool, error) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	if o.f == nil {
+		return nil, time.Time{}, false, ErrClosed
+	}
+	r, ok := o.index[k]
+	if !ok {
+		return nil, time.Time{}, false, nil
+	}
+	frame := make([]byte, r.size)
+	if _, err := o.f.ReadAt(frame, r.offset); err != nil {
+		return nil, time.Time{}, false, err
+	}
+	body, _, err := readFrame(bufio.NewReader(bytes.NewReader(frame)))
+	if err != nil {
+		return nil, time.Time{}, false, errors.Wrapf(err, "reading %q", k)
+	}
+	_, _, v, _, err := decodeBody(body)
+	if err != nil {
+		return nil, time.Time{}, false, errors.Wrapf(err, "reading %q", k)
+	}
+	return v, r.deadline, true, nil
+}
+
+// Delete removes k; it does not touch the file if there is no k
+func (o *File) Delete(k string) error {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	if o.f == nil {
+		return ErrClosed
+	}
+	if _, ok := o.index[k]; !ok {
+		return nil
+	}
+	body := appendUvarint(nil, opDelete)
+	body = appendBytes(body, []byte(k))
+	size, err := o.write(body)
+	if err != nil {
+		return err
+	}
+	o.supersede(k)
+	o.garbage += size
+	return o.maybeCompact()
+}
+
+// Len returns the number of keys, including expired ones not compacted yet
+func (o *File) Len() int {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	return len(o.index)
+}
+
+// Compact rewrites the file with only the entries that did not expire
+func (o *File) Compact() error {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	if o.f == nil {
+		return ErrClosed
+	}
+	return o.compact()
+}
+
+// Close closes the file; the entries are kept in it, for the next Open
+func (o *File) Close() error {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	if o.f == nil {
+		return nil
+	}
+	err := o.f.Close()
+	o.f = nil
+	return err
+}
+
+func (o *File) write(body []byte) (int64, error) {
+	frame := appendFrame(nil, body)
+	n, err := o.f.Write(frame)
+	o.size += int64(n)
+	if err != nil {
+		// a partial frame is cut off by the next Open
+		o.garbage += int64(n)
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+func (o *File) maybeCompact() error {
+	if o.size < compactMinSize || o.garbage*2 < o.size {
+		return nil
+	}
+	return o.compact()
+}
+
+// compact copies the live frames to a new file, that replaces the old one
+func (o *File) compact() error {
+	tmp, err := os.OpenFile(o.path+".compact", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	now := o.now()
+	index := make(map[string]record, len(o.index))
+	bw := bufio.NewWriter(tmp)
+	var size int64
+	for k, r := range o.index {
+		if !r.deadline.IsZero() && !now.Before(r.deadline) {
+			continue
+		}
+		frame := make([]byte, r.size)
+		if _, err = o.f.ReadAt(frame, r.offset); err != nil {
+			break
+		}
+		if _, err = bw.Write(frame); err != nil {
+			break
+		}
+		index[k] = record{offset: size, size: r.size, deadline: r.deadline}
+		size += r.size
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), o.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "compacting")
+	}
+	o.f.Close()
+	o.f = tmp
+	o.size, o.garbage, o.index = size, 0, index
+	_, err = o.f.Seek(size, io.SeekStart)
+	return err
+}
+
+//-----------------------------------------------------------------------------
+
+func decodeBody(body []byte) (op uint64, k string, v []byte, deadline time.Time, err error) {
+	d := decoder{buf: body}
+	op = d.uvarint()
+	k = string(d.bytes())
+	if op == opPut {
+		v = d.bytes()
+		if x := d.varint(); x != 0 {
+			deadline = time.Unix(0, x)
+		}
+	}
+	if d.err == nil && op != opPut && op != opDelete {
+		d.err = errors.Wrapf(ErrCorrupted, "op %d", op)
+	}
+	return op, k, v, deadline, d.err
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+func appendVarint(buf []byte, x int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], x)]...)
+}
+
+func appendBytes(buf, data []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func appendFrame(frame, body []byte) []byte {
+	frame = appendUvarint(frame, uint64(len(body)))
+	frame = append(frame, body...)
+	frame = append(frame, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(frame[len(frame)-4:], crc32.ChecksumIEEE(body))
+	return frame
+}
+
+// readFrame reads a frame, and returns its body and its size
+func readFrame(r *bufio.Reader) ([]byte, int64, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if size > maxFrame {
+		return nil, 0, errors.Wrapf(ErrCorrupted, "frame of %d bytes", size)
+	}
+	frame := make([]byte, size+4)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, 0, err
+	}
+	body := frame[:size]
+	if binary.BigEndian.Uint32(frame[size:]) != crc32.ChecksumIEEE(body) {
+		return nil, 0, errors.Wrap(ErrCorrupted, "checksum mismatch")
+	}
+	return body, int64(len(appendUvarint(nil, size))) + int64(size) + 4, nil
+}
+
+type sentinelErr string
+
+func (v sentinelErr) Error() string { return string(v) }
+
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrCorrupted
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrCorrupted
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+func (d *decoder) bytes() []byte {
+	size := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if size > uint64(len(d.buf)) {
+		d.err = ErrCorrupted
+		return nil
+	}
+	data := append([]byte(nil), d.buf[:size]...)
+	d.buf = d.buf[size:]
+	return data
+}
@@ -0,0 +1,170 @@
+package overflow
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dc0d/tinykv"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type intCodec struct{}
+
+func (intCodec) Encode(v interface{}) ([]byte, error) {
+	return []byte(strconv.Itoa(v.(int))), nil
+}
+
+func (intCodec) Decode(data []byte) (interface{}, error) {
+	return strconv.Atoi(string(data))
+}
+
+func open(t *testing.T, path string) *File {
+	o, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestFile(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "overflow")
+	o := open(t, path)
+	deadline := time.Unix(0, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	assert.NoError(o.Put("a", []byte("A"), deadline))
+	assert.NoError(o.Put("b", []byte("B"), time.Time{}))
+	assert.NoError(o.Put("b", []byte("BB"), time.Time{}))
+	assert.NoError(o.Put("c", []byte("C"), time.Time{}))
+	assert.NoError(o.Delete("c"))
+	assert.NoError(o.Delete("missing"))
+
+	check := func(o *File) {
+		t.Helper()
+		v, d, ok, err := o.Get("a")
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal([]byte("A"), v)
+		assert.True(deadline.Equal(d))
+		v, d, ok, err = o.Get("b")
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal([]byte("BB"), v)
+		assert.True(d.IsZero())
+		_, _, ok, err = o.Get("c")
+		assert.NoError(err)
+		assert.False(ok)
+	}
+	check(o)
+	assert.Equal(2, o.Len())
+	assert.NoError(o.Close())
+	_, _, _, err := o.Get("a")
+	assert.Equal(ErrClosed, err)
+
+	// reopened
+	o = open(t, path)
+	check(o)
+
+	// compacted
+	before, _ := os.Stat(path)
+	assert.NoError(o.Compact())
+	after, _ := os.Stat(path)
+	assert.True(after.Size() < before.Size())
+	check(o)
+	assert.NoError(o.Put("d", []byte("D"), time.Time{}))
+	assert.NoError(o.Close())
+	o = open(t, path)
+	defer o.Close()
+	check(o)
+	assert.Equal(3, o.Len())
+	v, _, _, _ := o.Get("d")
+	assert.Equal([]byte("D"), v)
+}
+
+func TestFileCompactDropsExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	o := open(t, filepath.Join(t.TempDir(), "overflow"))
+	defer o.Close()
+	now := time.Now()
+	o.now = func() time.Time { return now }
+	o.Put("a", []byte("A"), now.Add(time.Second))
+	o.Put("b", []byte("B"), now.Add(time.Hour))
+	now = now.Add(time.Minute)
+	assert.NoError(o.Compact())
+	assert.Equal(1, o.Len())
+	_, _, ok, _ := o.Get("a")
+	assert.False(ok)
+}
+
+func TestFileTruncatedTail(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "overflow")
+	o := open(t, path)
+	o.Put("a", []byte("A"), time.Time{})
+	o.Put("b", []byte("B"), time.Time{})
+	o.Close()
+	info, _ := os.Stat(path)
+	assert.NoError(os.Truncate(path, info.Size()-2))
+
+	o = open(t, path)
+	assert.Equal(1, o.Len())
+	assert.NoError(o.Put("c", []byte("C"), time.Time{}))
+	o.Close()
+	o = open(t, path)
+	defer o.Close()
+	assert.Equal(2, o.Len())
+	v, _, _, _ := o.Get("c")
+	assert.Equal([]byte("C"), v)
+}
+
+func TestFileAutoCompaction(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "overflow")
+	o := open(t, path)
+	defer o.Close()
+	value := make([]byte, 1024)
+	for i := 0; i < 4096; i++ {
+		assert.NoError(o.Put(strconv.Itoa(i%16), value, time.Time{}))
+	}
+	info, _ := os.Stat(path)
+	assert.True(info.Size() < compactMinSize*2, "size %d", info.Size())
+	assert.Equal(16, o.Len())
+}
+
+// TestStore evicts entries of a store to a File, restarts both, and reads
+// the values back
+func TestStore(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "overflow")
+	o := open(t, path)
+	kv := tinykv.NewStore(time.Hour, tinykv.MaxEntries(2), tinykv.Overflow(o, intCodec{}))
+	kv.Put("a", 1, tinykv.ExpiresAfter(time.Hour))
+	kv.Put("b", 2, tinykv.ExpiresAfter(time.Hour*2))
+	kv.Put("c", 3)
+	assert.Equal(2, kv.Len())
+	assert.Equal(1, o.Len())
+	kv.Stop()
+	assert.NoError(o.Close())
+
+	o = open(t, path)
+	defer o.Close()
+	kv = tinykv.NewStore(time.Hour, tinykv.MaxEntries(2), tinykv.Overflow(o, intCodec{}))
+	defer kv.Stop()
+	v, err := kv.GetE("a")
+	assert.NoError(err)
+	assert.Equal(1, v)
+	meta, _ := kv.GetMeta("a")
+	assert.True(meta.Remaining > time.Minute*59)
+	assert.Equal(0, o.Len())
+
+	_, err = kv.GetE("b")
+	assert.Equal(tinykv.ErrNotFound, errors.Cause(err))
+}
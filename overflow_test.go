@@ -0,0 +1,150 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type memOverflow struct {
+	data      map[string][]byte
+	deadlines map[string]time.Time
+	err       error
+}
+
+func newMemOverflow() *memOverflow {
+	return &memOverflow{data: make(map[string][]byte), deadlines: make(map[string]time.Time)}
+}
+
+func (o *memOverflow) Put(k string, v []byte, deadline time.Time) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.data[k], o.deadlines[k] = v, deadline
+	return nil
+}
+
+func (o *memOverflow) Get(k string) ([]byte, time.Time, bool, error) {
+	v, ok := o.data[k]
+	return v, o.deadlines[k], ok, nil
+}
+
+func (o *memOverflow) Delete(k string) error {
+	delete(o.data, k)
+	delete(o.deadlines, k)
+	return nil
+}
+
+func TestOverflow(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	o := newMemOverflow()
+	kv := NewStore(time.Hour, Clock(clock.Now), MaxEntries(2), Overflow(o, intCodec{}), Debug())
+	defer kv.Stop()
+
+	kv.Put("a", 1, ExpiresAfter(time.Minute))
+	kv.Put("b", 2, ExpiresAfter(time.Minute*2))
+	kv.Put("c", 3)
+	assert.Equal(map[string][]byte{"a": []byte("1")}, o.data)
+	assert.Equal(clock.Now().Add(time.Minute), o.deadlines["a"])
+	assert.Equal(2, kv.Len())
+
+	// a is promoted back, b (expiring soonest) is spilled to make room
+	clock.Advance(time.Second * 10)
+	v, ok := kv.Get("a")
+	assert.True(ok)
+	assert.Equal(1, v)
+	meta, _ := kv.GetMeta("a")
+	assert.Equal(time.Second*50, meta.Remaining)
+	assert.Equal(map[string][]byte{"b": []byte("2")}, o.data)
+	assert.Equal(int64(2), kv.Stats().Spilled)
+	assert.Equal(int64(1), kv.Stats().Unspilled)
+
+	// a write of a spilled key drops the spilled value
+	assert.NoError(kv.Put("b", 22, ExpiresAfter(time.Hour)))
+	assert.Equal(map[string][]byte{"a": []byte("1")}, o.data)
+	v, _ = kv.Get("b")
+	assert.Equal(22, v)
+	assert.NoError(kv.CheckInvariants())
+
+	// Take and Delete reach the overflow store
+	v, err := kv.TakeE("a")
+	assert.NoError(err)
+	assert.Equal(1, v)
+	assert.Empty(o.data)
+	kv.Put("d", 4, ExpiresAfter(time.Hour)) // spills b
+	assert.Equal(map[string][]byte{"b": []byte("22")}, o.data)
+	assert.NoError(kv.DeleteE("b"))
+	assert.Empty(o.data)
+	assert.Equal(ErrNotFound, errors.Cause(kv.DeleteE("b")))
+	kv.Put("e", 5) // spills d
+	kv.Delete("d")
+	assert.Empty(o.data)
+	_, err = kv.GetE("d")
+	assert.Equal(ErrNotFound, errors.Cause(err))
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestOverflowExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	o := newMemOverflow()
+	kv := NewStore(time.Hour, Clock(clock.Now), MaxEntries(1), Overflow(o, intCodec{}))
+	defer kv.Stop()
+
+	kv.Put("a", 1, ExpiresAfter(time.Minute))
+	kv.Put("b", 2)
+	assert.Len(o.data, 1)
+
+	clock.Advance(time.Minute)
+	_, err := kv.GetE("a")
+	assert.Equal(ErrExpired, errors.Cause(err))
+	assert.Empty(o.data)
+}
+
+func TestOverflowErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	o := newMemOverflow()
+	kv := NewStore(time.Hour, MaxEntries(1), Overflow(o, intCodec{}))
+	defer kv.Stop()
+
+	// the value is not spilled, and the error is returned by the put
+	// that evicted it
+	kv.Put("a", "A")
+	err := kv.Put("b", 2)
+	assert.Error(err)
+	assert.Contains(err.Error(), "not an int")
+	assert.Empty(o.data)
+	assert.NoError(kv.Put("b", 22))
+
+	o.err = errors.New("DISK FULL")
+	err = kv.Put("c", 3)
+	assert.Contains(err.Error(), "DISK FULL")
+	o.err = nil
+	assert.NoError(kv.Put("d", 4))
+}
+
+func TestOverflowNoRoom(t *testing.T) {
+	assert := assert.New(t)
+
+	o := newMemOverflow()
+	kv := NewStore(time.Hour, MaxEntries(1), Overflow(o, intCodec{}))
+	defer kv.Stop()
+
+	kv.Put("a", 1)
+	kv.Put("b", 2, ReadOnly())
+	assert.Len(o.data, 1)
+
+	// the read-only entry can not be evicted, so a is served from
+	// the overflow store, and stays there
+	v, ok := kv.Get("a")
+	assert.True(ok)
+	assert.Equal(1, v)
+	assert.Len(o.data, 1)
+	assert.Equal(int64(0), kv.Stats().RejectedPuts)
+}
@@ -0,0 +1,57 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// OverwriteWindow makes the store count the writes that replace a value
+// written less than d ago, in Stats.OverwrittenWithinWindow, as a sign of
+// wasted work upstream: like many goroutines putting the same key, where the
+// last one wins. Any write that replaces the value of a live entry counts
+// (Put, CAS and the other writes), not an in-place change, like Append.
+// With OnOverwrite, the function is called for each of them. It costs a
+// timestamp per entry. A d that is not positive turns it off.
+func OverwriteWindow(d time.Duration) StoreOption {
+	return func(opt *storeOpt) {
+		opt.overwriteWindow = d
+	}
+}
+
+// OnOverwrite sets the function that is called with the key, and the age of
+// the value replaced, for each write counted by OverwriteWindow. It is called
+// on its own goroutine (see Executor), so it can use the store.
+func OnOverwrite(fn func(k string, age time.Duration)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onOverwrite = fn
+	}
+}
+
+// countOverwrite counts the write of e in place of old, if old was written
+// within the OverwriteWindow, and records the time of the write; it is
+// called by set, under the lock
+func (kv *Store) countOverwrite(k string, old, e *entry) {
+	if kv.overwriteWindow <= 0 {
+		return
+	}
+	now := kv.now()
+	writtenAt := old.writtenAt
+	e.writtenAt = now
+	if writtenAt.IsZero() || kv.expired(old) {
+		return
+	}
+	age := now.Sub(writtenAt)
+	if age >= kv.overwriteWindow {
+		return
+	}
+	kv.stats.OverwrittenWithinWindow++
+	if kv.onOverwrite == nil {
+		return
+	}
+	fn := kv.onOverwrite
+	kv.spawnLocked(func() {
+		try(func() error {
+			fn(k, age)
+			return nil
+		})
+	})
+}
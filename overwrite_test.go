@@ -0,0 +1,93 @@
+package tinykv
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverwriteWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var (
+		mx   sync.Mutex
+		ages []time.Duration
+		wg   sync.WaitGroup
+	)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		OverwriteWindow(time.Second),
+		OnOverwrite(func(k string, age time.Duration) {
+			defer wg.Done()
+			mx.Lock()
+			defer mx.Unlock()
+			assert.Equal("k", k)
+			ages = append(ages, age)
+		}))
+	defer kv.Stop()
+	assert.Equal(time.Second, kv.Config().OverwriteWindow)
+
+	// a writes, b overwrites it half a second later, and a again two seconds
+	// after that
+	const rounds = 10
+	wg.Add(rounds)
+	turn := [2]chan struct{}{make(chan struct{}), make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < rounds; i++ {
+			assert.NoError(kv.Put("k", "a"))
+			clock.Advance(time.Millisecond * 500)
+			turn[1] <- struct{}{}
+			<-turn[0]
+		}
+		close(done)
+	}()
+	go func() {
+		for i := 0; i < rounds; i++ {
+			<-turn[1]
+			assert.NoError(kv.Put("k", "b"))
+			clock.Advance(time.Second * 2)
+			turn[0] <- struct{}{}
+		}
+	}()
+	<-done
+	wg.Wait()
+
+	assert.Equal(int64(rounds), kv.Stats().OverwrittenWithinWindow)
+	assert.Len(ages, rounds)
+	for _, age := range ages {
+		assert.Equal(time.Millisecond*500, age)
+	}
+}
+
+func TestOverwriteWindowExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), OverwriteWindow(time.Minute))
+	defer kv.Stop()
+
+	// an expired value is not overwritten, nor is a deleted one
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second)))
+	clock.Advance(time.Second * 2)
+	assert.NoError(kv.Put("k", 2))
+	kv.Delete("k")
+	assert.NoError(kv.Put("k", 3))
+	assert.Equal(int64(0), kv.Stats().OverwrittenWithinWindow)
+
+	// a CAS counts, a value as old as the window does not
+	assert.NoError(kv.CAS("k", 4, func(interface{}, bool) bool { return true }))
+	clock.Advance(time.Minute)
+	assert.NoError(kv.Put("k", 5))
+	assert.Equal(int64(1), kv.Stats().OverwrittenWithinWindow)
+
+	// off by default
+	kv2 := NewStore(time.Hour)
+	defer kv2.Stop()
+	assert.NoError(kv2.Put("k", 1))
+	assert.NoError(kv2.Put("k", 2))
+	assert.Equal(int64(0), kv2.Stats().OverwrittenWithinWindow)
+}
@@ -0,0 +1,131 @@
+// Package frame encodes and decodes the frames of the tinykv log (see
+// tinykv.WAL) and snapshots. A frame is the length of its body (uvarint), the
+// body, and the CRC-32 (IEEE, big endian) of the body. A body is a sequence of
+// fields: varints, and byte strings prefixed by their length (uvarint).
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Append appends the frame of body to buf
+func Append(buf, body []byte) []byte {
+	buf = AppendUvarint(buf, uint64(len(body)))
+	buf = append(buf, body...)
+	buf = append(buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], crc32.ChecksumIEEE(body))
+	return buf
+}
+
+// Read reads a frame from r and returns its body. It returns io.EOF if r has
+// no more bytes, and io.ErrUnexpectedEOF for a frame cut off. A body over max
+// bytes, or not matching its checksum, gives an error wrapping corrupted.
+func Read(r *bufio.Reader, max uint64, corrupted error) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > max {
+		return nil, errors.Wrapf(corrupted, "frame of %d bytes", size)
+	}
+	frame := make([]byte, size+4)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	body := frame[:size]
+	if binary.BigEndian.Uint32(frame[size:]) != crc32.ChecksumIEEE(body) {
+		return nil, errors.Wrap(corrupted, "checksum mismatch")
+	}
+	return body, nil
+}
+
+// AppendUvarint appends the field x to buf
+func AppendUvarint(buf []byte, x uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+// AppendVarint appends the field x to buf
+func AppendVarint(buf []byte, x int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], x)]...)
+}
+
+// AppendBytes appends the field data to buf
+func AppendBytes(buf, data []byte) []byte {
+	buf = AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// Decoder reads the fields of a body, in order. After the first malformed
+// field, Err is set (to the error given to NewDecoder) and the next fields
+// are zero.
+type Decoder struct {
+	Err error
+
+	buf       []byte
+	corrupted error
+}
+
+// NewDecoder returns a decoder of body, that fails with corrupted
+func NewDecoder(body []byte, corrupted error) *Decoder {
+	return &Decoder{buf: body, corrupted: corrupted}
+}
+
+// More reports if there are bytes left, for the fields appended to a body by
+// later versions
+func (d *Decoder) More() bool {
+	return d.Err == nil && len(d.buf) > 0
+}
+
+// Uvarint reads a uvarint field
+func (d *Decoder) Uvarint() uint64 {
+	if d.Err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.Err = d.corrupted
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+// Varint reads a varint field
+func (d *Decoder) Varint() int64 {
+	if d.Err != nil {
+		return 0
+	}
+	x, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.Err = d.corrupted
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+// Bytes reads a byte string field. The returned slice shares the memory of
+// the body.
+func (d *Decoder) Bytes() []byte {
+	size := d.Uvarint()
+	if d.Err != nil {
+		return nil
+	}
+	if size > uint64(len(d.buf)) {
+		d.Err = d.corrupted
+		return nil
+	}
+	data := d.buf[:size]
+	d.buf = d.buf[size:]
+	return data
+}
@@ -0,0 +1,88 @@
+package tinykv
+
+// PopSoonest removes and returns the live entry that expires soonest,
+// using the timeout heap. Entries without a timeout, and read-only or
+// protected entries, are never returned.
+func (kv *Store) PopSoonest() (string, interface{}, bool) {
+	kv.active()
+	kv.mx.Lock()
+	var (
+		expired map[string]*entry
+		skipped []*timeout // read-only, protected and graced entries, pending puts and expectations
+	)
+	unlock := func() {
+		for _, to := range skipped {
+			timeheapPush(&kv.heap, to)
+		}
+		kv.mx.Unlock()
+	}
+	for len(kv.heap) > 0 {
+		to := timeheapPop(&kv.heap)
+		if to.stale {
+			continue
+		}
+		if !to.isEntry() {
+			skipped = append(skipped, to)
+			continue
+		}
+		e, _ := kv.kv.get(to.key)
+		if ((e.readOnly || e.protected) && !kv.expired(e)) || kv.inGrace(e) {
+			skipped = append(skipped, to)
+			continue
+		}
+		e.keepOpen = true // it goes to the caller, or is closed once notified
+		kv.remove(to.key)
+		if kv.expired(e) {
+			if expired == nil {
+				expired = make(map[string]*entry)
+			}
+			expired[to.key] = e.captured()
+			continue
+		}
+		unlock()
+		kv.notify(expired)
+		return to.key, e.value, true
+	}
+	unlock()
+	kv.notify(expired)
+	return "", nil, false
+}
+
+// PopLatest removes and returns the live entry that expires last.
+// Entries without a timeout, and read-only or protected entries, are never
+// returned.
+// It scans the whole timeout heap, so it is O(n) on the number of entries
+// with a timeout.
+func (kv *Store) PopLatest() (string, interface{}, bool) {
+	kv.active()
+	kv.mx.Lock()
+	latest := -1
+	for i, to := range kv.heap {
+		if to.stale || !to.isEntry() {
+			continue
+		}
+		if e, _ := kv.kv.get(to.key); e.readOnly || e.protected || kv.inGrace(e) {
+			continue
+		}
+		if latest < 0 || kv.heap[latest].expiresAt.Before(to.expiresAt) {
+			latest = i
+		}
+	}
+	if latest < 0 {
+		kv.mx.Unlock()
+		return "", nil, false
+	}
+	to := timeheapRemove(&kv.heap, latest)
+	e, _ := kv.kv.get(to.key)
+	e.keepOpen = true
+	kv.remove(to.key)
+	if kv.expired(e) {
+		// all the others are expired too, and are left for the sweep
+		expired := map[string]*entry{to.key: e.captured()}
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return "", nil, false
+	}
+	kv.mx.Unlock()
+	return to.key, e.value, true
+}
@@ -0,0 +1,68 @@
+package tinykv
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPopSoonestLatest(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var expired []string
+	kv := NewStore(
+		time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) { expired = append(expired, k) }))
+	defer kv.Stop()
+
+	_, _, ok := kv.PopSoonest()
+	assert.False(ok)
+	_, _, ok = kv.PopLatest()
+	assert.False(ok)
+
+	kv.Put("permanent", 0)
+	for i := 1; i <= 6; i++ {
+		kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Second*time.Duration(i*10)))
+	}
+	kv.Put("3", 33) // no timeout anymore, stale heap node
+	kv.Put("4", 44, ExpiresAfter(time.Second*100))
+
+	k, v, ok := kv.PopSoonest()
+	assert.True(ok)
+	assert.Equal("1", k)
+	assert.Equal(1, v)
+
+	k, v, ok = kv.PopLatest()
+	assert.True(ok)
+	assert.Equal("4", k)
+	assert.Equal(44, v)
+
+	kv.Put("7", 7, ExpiresAfter(time.Second*5))
+	k, _, ok = kv.PopSoonest()
+	assert.True(ok)
+	assert.Equal("7", k)
+	checkInvariants(t, kv)
+
+	clock.Advance(time.Second * 25) // "2" expires
+	k, _, ok = kv.PopSoonest()
+	assert.True(ok)
+	assert.Equal("5", k)
+	assert.Equal([]string{"2"}, expired)
+
+	k, _, ok = kv.PopLatest()
+	assert.True(ok)
+	assert.Equal("6", k)
+
+	_, _, ok = kv.PopSoonest()
+	assert.False(ok)
+	_, ok = kv.Get("permanent")
+	assert.True(ok)
+	_, ok = kv.Get("3")
+	assert.True(ok)
+	checkInvariants(t, kv)
+}
@@ -0,0 +1,34 @@
+package tinykv
+
+import (
+	"sort"
+)
+
+// Priority sets the priority of the entry (zero by default). When several
+// entries expire together, OnExpire and OnExpireDetailed are called in
+// descending order of priority, and then in order of deadline. It is not
+// kept by the WAL and snapshots.
+func Priority(p int) PutOption {
+	return func(opt *putOpt) {
+		opt.priority = p
+	}
+}
+
+// byPriority returns the keys of the expired entries in notification order
+func byPriority(expired map[string]*entry) []string {
+	keys := make([]string, 0, len(expired))
+	for k := range expired {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := expired[keys[i]], expired[keys[j]]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if !a.expiresAt.Equal(b.expiresAt) {
+			return a.expiresAt.Before(b.expiresAt)
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,56 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriority(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var got []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) {
+			got = append(got, k)
+		}))
+	defer kv.Stop()
+
+	kv.Put("blob1", 1, ExpiresAfter(time.Second))
+	kv.Put("lease2", 2, ExpiresAfter(time.Second*3), Priority(10))
+	kv.Put("blob2", 3, ExpiresAfter(time.Second*2))
+	kv.Put("lease1", 4, ExpiresAfter(time.Second*4), Priority(10))
+	kv.Put("low", 5, ExpiresAfter(time.Second), Priority(-1))
+	kv.Put("mid", 6, ExpiresAfter(time.Second*5), Priority(5))
+	meta, _ := kv.GetMeta("mid")
+	assert.Equal(5, meta.Priority)
+
+	clock.Advance(time.Second * 10)
+	kv.ExpireNow()
+	assert.Equal([]string{"lease2", "lease1", "mid", "blob1", "blob2", "low"}, got)
+}
+
+func TestPriorityDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var got []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) {
+			got = append(got, k)
+		}))
+	defer kv.Stop()
+
+	kv.Put("3", 3, ExpiresAfter(time.Second*3))
+	kv.Put("1", 1, ExpiresAfter(time.Second))
+	kv.Put("2", 2, ExpiresAfter(time.Second*2))
+	clock.Advance(time.Second * 10)
+	kv.ExpireNow()
+	assert.Equal([]string{"1", "2", "3"}, got)
+}
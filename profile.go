@@ -0,0 +1,61 @@
+package tinykv
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefineProfile registers options under name, for Profile; defining a name
+// again replaces its options.
+func (kv *Store) DefineProfile(name string, options ...PutOption) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if kv.profiles == nil {
+		kv.profiles = make(map[string][]PutOption)
+	}
+	kv.profiles[name] = append([]PutOption(nil), options...)
+}
+
+// Profile applies the options registered under name by DefineProfile, like a
+// TTL policy defined once per kind of entry. The other options of the Put
+// override them, wherever they are in the list. A name that is not defined
+// fails the Put with ErrInvalidOptions; with more than one Profile, the last
+// one is used. A Profile in the options of a profile is ignored.
+func Profile(name string) PutOption {
+	return func(opt *putOpt) {
+		opt.profile = name
+		opt.hasProfile = true
+	}
+}
+
+// expandProfile applies the options of the profile of opt, with the other
+// options on top
+func (kv *Store) expandProfile(opt *putOpt, options []PutOption) *putOpt {
+	if !opt.hasProfile {
+		return opt
+	}
+	kv.mx.Lock()
+	profile, ok := kv.profiles[opt.profile]
+	kv.mx.Unlock()
+	if !ok {
+		opt.badOption(fmt.Sprintf("unknown Profile %q", opt.profile))
+		return opt
+	}
+	expanded := &putOpt{}
+	expanded.apply(profile)
+	expanded.apply(options)
+	return expanded
+}
+
+// profileNames returns the names of the profiles, sorted, under the lock
+func (kv *Store) profileNames() []string {
+	if len(kv.profiles) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(kv.profiles))
+	for name := range kv.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,63 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfile(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	kv.DefineProfile("session", ExpiresAfter(time.Minute*30), IsSliding(true))
+	kv.DefineProfile("token", ExpiresAfter(time.Minute*5))
+	kv.DefineProfile("flag")
+	assert.Equal([]string{"flag", "session", "token"}, kv.Config().Profiles)
+
+	meta := func(k string) Meta {
+		m, ok := kv.GetMeta(k)
+		assert.True(ok, k)
+		return m
+	}
+
+	assert.NoError(kv.Put("s", 1, Profile("session")))
+	assert.Equal(time.Minute*30, meta("s").ExpiresAfter)
+	assert.True(meta("s").IsSliding)
+	assert.NoError(kv.Put("t", 1, Profile("token")))
+	assert.Equal(time.Minute*5, meta("t").Remaining)
+	assert.False(meta("t").IsSliding)
+	assert.NoError(kv.Put("f", 1, Profile("flag")))
+	assert.True(meta("f").ExpiresAt.IsZero())
+
+	// explicit options override the profile, before or after it
+	assert.NoError(kv.Put("s2", 1, IsSliding(false), Profile("session")))
+	assert.Equal(time.Minute*30, meta("s2").ExpiresAfter)
+	assert.False(meta("s2").IsSliding)
+	assert.NoError(kv.Put("t2", 1, Profile("token"), ExpiresAfter(time.Minute)))
+	assert.Equal(time.Minute, meta("t2").Remaining)
+
+	// the last profile wins
+	assert.NoError(kv.Put("x", 1, Profile("session"), Profile("token")))
+	assert.Equal(time.Minute*5, meta("x").ExpiresAfter)
+	assert.False(meta("x").IsSliding)
+
+	// redefining a profile applies to later puts
+	kv.DefineProfile("token", ExpiresAfter(time.Minute*10))
+	assert.NoError(kv.Put("t3", 1, Profile("token")))
+	assert.Equal(time.Minute*10, meta("t3").Remaining)
+
+	// an unknown profile fails, instead of a permanent entry
+	err := kv.Put("u", 1, Profile("unknown"))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.EqualError(err, `tinykv: put "u": unknown Profile "unknown": INVALID OPTIONS`)
+	_, err = kv.Append("u", 1, Profile("unknown"))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	_, ok := kv.Get("u")
+	assert.False(ok)
+}
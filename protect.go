@@ -0,0 +1,166 @@
+package tinykv
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultRemovalConfirmWindow is how long a token of ConfirmRemoval is valid,
+// without RemovalConfirmWindow
+const defaultRemovalConfirmWindow = time.Minute
+
+// Protected protects the entry against removal by mistake: Delete, Take,
+// ForceDelete, Drain, the Pop methods and the like leave it in place
+// (DeleteE and TakeE fail with ErrProtected), and Clear, DeleteByPrefix and
+// DeleteWhere skip it (see Stats.ProtectedSkips). It is removed by
+// DeleteConfirmed only, with a token of ConfirmRemoval. It still expires as
+// usual. Like ReadOnly, it is of the entry: a Put without it replaces the
+// entry with one that is not protected.
+func Protected() PutOption {
+	return func(opt *putOpt) {
+		opt.protected = true
+	}
+}
+
+// RemovalConfirmWindow sets how long a token of ConfirmRemoval is valid, one
+// minute by default
+func RemovalConfirmWindow(d time.Duration) StoreOption {
+	return func(opt *storeOpt) {
+		opt.removalConfirmWindow = d
+	}
+}
+
+// removalToken is a token of ConfirmRemoval, for an entry
+type removalToken struct {
+	e         *entry
+	token     string
+	expiresAt time.Time
+}
+
+// ConfirmRemoval returns a token for DeleteConfirmed to remove the protected
+// entry for k (see Protected), valid for the RemovalConfirmWindow, once. A new
+// token replaces the previous one. It returns an empty token if there is no
+// protected entry for k.
+func (kv *Store) ConfirmRemoval(k string) string {
+	kv.active()
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	e, ok := kv.kv.get(k)
+	if !ok || !e.protected || kv.expired(e) {
+		return ""
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	token := hex.EncodeToString(b[:])
+	if kv.removalTokens == nil {
+		kv.removalTokens = make(map[string]removalToken)
+	}
+	kv.removalTokens[k] = removalToken{e: e, token: token, expiresAt: kv.now().Add(kv.confirmWindow())}
+	return token
+}
+
+// confirmWindow returns the effective RemovalConfirmWindow
+func (kv *Store) confirmWindow() time.Duration {
+	if kv.removalConfirmWindow <= 0 {
+		return defaultRemovalConfirmWindow
+	}
+	return kv.removalConfirmWindow
+}
+
+// DeleteConfirmed deletes the entry for k, like DeleteE, even if it is
+// protected, given a valid token of ConfirmRemoval for it; otherwise it fails
+// with ErrProtected. A token is valid once, for the entry it was issued for,
+// until the RemovalConfirmWindow ends. An entry that is not protected is
+// deleted whatever the token.
+func (kv *Store) DeleteConfirmed(k, token string) (err error) {
+	defer wrapOp(&err, "delete-confirmed", k)
+	kv.active()
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e == nil {
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return lookupErr(expired)
+	}
+	if e.readOnly {
+		kv.mx.Unlock()
+		return ErrReadOnly
+	}
+	if e.protected {
+		if err := kv.checkRemovalToken(k, e, token); err != nil {
+			kv.mx.Unlock()
+			return err
+		}
+	}
+	kv.remove(k)
+	kv.mx.Unlock()
+	return kv.backingRemove(k)
+}
+
+// checkRemovalToken uses token, to remove e, the entry of k
+func (kv *Store) checkRemovalToken(k string, e *entry, token string) error {
+	t, ok := kv.removalTokens[k]
+	switch {
+	case !ok || t.e != e:
+		return errors.Wrap(ErrProtected, "removal not confirmed")
+	case kv.now().After(t.expiresAt):
+		delete(kv.removalTokens, k)
+		return errors.Wrap(ErrProtected, "removal token expired")
+	case t.token != token:
+		return errors.Wrap(ErrProtected, "wrong removal token")
+	}
+	delete(kv.removalTokens, k)
+	return nil
+}
+
+// isProtected reports if there is a live protected entry for k
+func (kv *Store) isProtected(k string) bool {
+	e, ok := kv.kv.get(k)
+	return ok && e.protected && !kv.expired(e)
+}
+
+// dropRemovalToken drops the token of k, on the removal of its entry
+func (kv *Store) dropRemovalToken(k string) {
+	if len(kv.removalTokens) > 0 {
+		delete(kv.removalTokens, k)
+	}
+}
+
+// skipProtected reports if e is protected, so a bulk removal skips it,
+// counting the skip
+func (kv *Store) skipProtected(e *entry) bool {
+	if !e.protected || kv.expired(e) {
+		return false
+	}
+	kv.stats.ProtectedSkips++
+	return true
+}
+
+// clearUnprotected removes the entries that are not protected, for Clear, if
+// there are protected ones; otherwise it returns nil
+func (kv *Store) clearUnprotected() *bulkRemoval {
+	skipped := 0
+	kv.kv.each(func(k string, _ *entry) bool {
+		if kv.isProtected(k) {
+			skipped++
+		}
+		return true
+	})
+	if skipped == 0 {
+		return nil
+	}
+	b := kv.newBulkRemoval("clear", false)
+	kv.kv.each(func(k string, e *entry) bool {
+		if !kv.isProtected(k) {
+			b.remove(k, e)
+		}
+		return true
+	})
+	kv.stats.ProtectedSkips += int64(skipped)
+	return b
+}
@@ -0,0 +1,151 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtected(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k", 1, Protected()))
+	kv.Delete("k")
+	kv.ForceDelete("k")
+	_, ok := kv.Take("k")
+	assert.False(ok)
+	assert.Equal(ErrProtected, errors.Cause(kv.DeleteE("k")))
+	_, err := kv.TakeE("k")
+	assert.Equal(ErrProtected, errors.Cause(err))
+	meta, ok := kv.GetMeta("k")
+	assert.True(ok)
+	assert.True(meta.Protected)
+
+	// not confirmed
+	assert.Equal(ErrProtected, errors.Cause(kv.DeleteConfirmed("k", "")))
+
+	token := kv.ConfirmRemoval("k")
+	assert.NotEmpty(token)
+	assert.Equal(ErrProtected, errors.Cause(kv.DeleteConfirmed("k", "wrong")))
+	assert.NoError(kv.DeleteConfirmed("k", token))
+	_, ok = kv.Get("k")
+	assert.False(ok)
+	assert.Equal(ErrNotFound, errors.Cause(kv.DeleteConfirmed("k", token)))
+
+	// not protected, nothing to confirm
+	assert.NoError(kv.Put("plain", 1))
+	assert.Empty(kv.ConfirmRemoval("plain"))
+	assert.NoError(kv.DeleteConfirmed("plain", ""))
+}
+
+func TestProtectedTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), RemovalConfirmWindow(time.Second*10))
+	defer kv.Stop()
+
+	// expired
+	assert.NoError(kv.Put("k", 1, Protected()))
+	token := kv.ConfirmRemoval("k")
+	clock.Advance(time.Second * 11)
+	err := kv.DeleteConfirmed("k", token)
+	assert.Equal(ErrProtected, errors.Cause(err))
+	assert.Contains(err.Error(), "expired")
+
+	// replaced by a newer token
+	old := kv.ConfirmRemoval("k")
+	token = kv.ConfirmRemoval("k")
+	assert.NotEqual(old, token)
+	assert.Equal(ErrProtected, errors.Cause(kv.DeleteConfirmed("k", old)))
+
+	// of the entry it was issued for
+	assert.NoError(kv.Put("k", 2, Protected()))
+	assert.Equal(ErrProtected, errors.Cause(kv.DeleteConfirmed("k", token)))
+
+	// once
+	token = kv.ConfirmRemoval("k")
+	assert.NoError(kv.DeleteConfirmed("k", token))
+	assert.NoError(kv.Put("k", 3, Protected()))
+	assert.Equal(ErrProtected, errors.Cause(kv.DeleteConfirmed("k", token)))
+	assert.Equal(time.Second*10, kv.Config().RemovalConfirmWindow)
+}
+
+func TestProtectedExpires(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k", 1, Protected(), ExpiresAfter(time.Second)))
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	_, ok := kv.Get("k")
+	assert.False(ok)
+}
+
+func TestProtectedBulk(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("a1", 1, Protected()))
+	assert.NoError(kv.Put("a2", 2))
+	assert.NoError(kv.Put("b1", 3, Protected()))
+	assert.NoError(kv.Put("b2", 4))
+	assert.NoError(kv.Put("c", 5))
+
+	assert.Equal(1, kv.DeleteByPrefix("a"))
+	assert.Equal(1, kv.DeleteWhere(func(k string, v interface{}) bool { return k[0] == 'b' }))
+	assert.Equal(int64(2), kv.Stats().ProtectedSkips)
+
+	assert.Equal(1, kv.Clear())
+	assert.ElementsMatch([]string{"a1", "b1"}, kv.Keys())
+	assert.Equal(int64(4), kv.Stats().ProtectedSkips)
+	assert.NoError(kv.CheckInvariants())
+
+	// without protected entries, as before
+	assert.NoError(kv.DeleteConfirmed("a1", kv.ConfirmRemoval("a1")))
+	assert.NoError(kv.DeleteConfirmed("b1", kv.ConfirmRemoval("b1")))
+	assert.NoError(kv.Put("c", 5))
+	assert.Equal(1, kv.Clear())
+	assert.Empty(kv.Keys())
+}
+
+func TestProtectedPopAndDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("soonest", 1, Protected(), ExpiresAfter(time.Minute)))
+	assert.NoError(kv.Put("middle", 2, ExpiresAfter(time.Minute*2)))
+	assert.NoError(kv.Put("latest", 3, Protected(), ExpiresAfter(time.Minute*3)))
+	assert.NoError(kv.Put("list", []interface{}{1, 2}, Protected()))
+
+	k, v, ok := kv.PopSoonest()
+	assert.True(ok)
+	assert.Equal("middle", k)
+	assert.Equal(2, v)
+	_, _, ok = kv.PopSoonest()
+	assert.False(ok)
+	_, _, ok = kv.PopLatest()
+	assert.False(ok)
+	_, ok = kv.Drain("list")
+	assert.False(ok)
+	assert.ElementsMatch([]string{"soonest", "latest", "list"}, kv.Keys())
+	assert.NoError(kv.CheckInvariants())
+
+	// a Put without Protected replaces it with one that is not
+	assert.NoError(kv.Put("list", []interface{}{1, 2}))
+	list, ok := kv.Drain("list")
+	assert.True(ok)
+	assert.Equal([]interface{}{1, 2}, list)
+}
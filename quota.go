@@ -0,0 +1,200 @@
+package tinykv
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// QuotaPolicy is what a Put over a Quota does
+type QuotaPolicy int
+
+// quota policies
+const (
+	QuotaReject QuotaPolicy = iota // fails with ErrQuotaExceeded
+	QuotaEvict                     // evicts entries under the same quota
+)
+
+func (p QuotaPolicy) String() string {
+	switch p {
+	case QuotaReject:
+		return "reject"
+	case QuotaEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// Quota limits the entries whose keys start with prefix, in number and in
+// total cost (see CostFunc), like MaxEntries and MaxCost limit the store; a
+// zero limit is no limit. A key counts against the quota of the longest
+// prefix it starts with. A Put (or ForcePut, or CAS) over its quota fails
+// with ErrQuotaExceeded, or evicts entries under the same quota, by
+// OverQuota; TryPut never evicts. Like under MaxEntries, other writes are not
+// limited. See SetQuota, to change the quotas of a running store.
+func Quota(prefix string, maxEntries int, maxCost int64) StoreOption {
+	return func(opt *storeOpt) {
+		opt.quotas = append(opt.quotas, &quota{prefix: prefix, maxEntries: maxEntries, maxCost: maxCost})
+	}
+}
+
+// OverQuota sets what a Put over its Quota does, QuotaReject by default
+func OverQuota(policy QuotaPolicy) StoreOption {
+	return func(opt *storeOpt) {
+		opt.quotaPolicy = policy
+	}
+}
+
+// quota is a Quota, and its usage
+type quota struct {
+	prefix     string
+	maxEntries int
+	maxCost    int64
+	entries    int
+	cost       int64
+}
+
+// SetQuota sets the Quota of prefix, or removes it if both limits are zero.
+// The entries already over it stay; the later puts are limited. It counts
+// the usage of the quotas again, so it is O(n) on the number of entries.
+func (kv *Store) SetQuota(prefix string, maxEntries int, maxCost int64) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	kv.setQuota(&quota{prefix: prefix, maxEntries: maxEntries, maxCost: maxCost})
+	kv.recountQuotas()
+}
+
+func (kv *Store) setQuota(q *quota) {
+	if q.maxEntries <= 0 && q.maxCost <= 0 {
+		delete(kv.quotaByPrefix, q.prefix)
+		return
+	}
+	if kv.quotaByPrefix == nil {
+		kv.quotaByPrefix = make(map[string]*quota)
+	}
+	kv.quotaByPrefix[q.prefix] = q
+}
+
+// QuotaUsage returns the number of entries, and their total cost, under the
+// Quota of prefix; they are zero if there is no such quota
+func (kv *Store) QuotaUsage(prefix string) (entries int, cost int64) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if q, ok := kv.quotaByPrefix[prefix]; ok {
+		return q.entries, q.cost
+	}
+	return 0, 0
+}
+
+// recountQuotas counts the usage of the quotas from the entries, and the
+// cost of the entries, which is not kept without MaxCost or quotas
+func (kv *Store) recountQuotas() {
+	kv.totalCost = 0
+	for _, q := range kv.quotaByPrefix {
+		q.entries, q.cost = 0, 0
+	}
+	kv.kv.each(func(k string, e *entry) bool {
+		e.cost = kv.cost(k, e.value)
+		kv.totalCost += e.cost
+		if q := kv.quotaOf(k); q != nil {
+			q.entries++
+			q.cost += e.cost
+		}
+		return true
+	})
+}
+
+// quotaOf returns the quota of k, nil if there is none
+func (kv *Store) quotaOf(k string) *quota {
+	var found *quota
+	for prefix, q := range kv.quotaByPrefix {
+		if strings.HasPrefix(k, prefix) && (found == nil || len(prefix) > len(found.prefix)) {
+			found = q
+		}
+	}
+	return found
+}
+
+// quotaPrefixes returns the prefixes of the quotas, sorted
+func (kv *Store) quotaPrefixes() []string {
+	if len(kv.quotaByPrefix) == 0 {
+		return nil
+	}
+	prefixes := make([]string, 0, len(kv.quotaByPrefix))
+	for prefix := range kv.quotaByPrefix {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// quotaVictims picks the entries to evict so v fits for k under its quota,
+// by the policy; it returns ErrQuotaExceeded if that is not possible, or
+// evict is false
+func (kv *Store) quotaVictims(k string, v interface{}, evict bool) ([]string, error) {
+	q := kv.quotaOf(k)
+	if q == nil {
+		return nil, nil
+	}
+	var entries int
+	var cost int64
+	old, ok := kv.kv.get(k)
+	if q.maxEntries > 0 && !ok {
+		entries = q.entries + 1 - q.maxEntries
+	}
+	if q.maxCost > 0 {
+		cost = q.cost + kv.cost(k, v) - q.maxCost
+		if ok {
+			cost -= old.cost
+		}
+	}
+	if entries <= 0 && cost <= 0 {
+		return nil, nil
+	}
+	var victims []string
+	if evict && kv.quotaPolicy == QuotaEvict {
+		victims = kv.victims(k, entries, cost, func(victim string) bool {
+			return kv.quotaOf(victim) == q
+		})
+	}
+	if victims == nil {
+		return nil, errors.Wrapf(ErrQuotaExceeded, "quota of %q", q.prefix)
+	}
+	return victims, nil
+}
+
+// countQuota counts a change of the entry of k in its quota: delta entries
+// (1 for a new one, -1 for a removed one) and costDelta
+func (kv *Store) countQuota(k string, delta int, costDelta int64) {
+	if len(kv.quotaByPrefix) == 0 {
+		return
+	}
+	if q := kv.quotaOf(k); q != nil {
+		q.entries += delta
+		q.cost += costDelta
+	}
+}
+
+// checkQuotas checks the usage of the quotas, for CheckInvariants
+func (kv *Store) checkQuotas() error {
+	if len(kv.quotaByPrefix) == 0 {
+		return nil
+	}
+	entries := make(map[*quota]int)
+	costs := make(map[*quota]int64)
+	kv.kv.each(func(k string, e *entry) bool {
+		if q := kv.quotaOf(k); q != nil {
+			entries[q]++
+			costs[q] += kv.cost(k, e.value)
+		}
+		return true
+	})
+	for prefix, q := range kv.quotaByPrefix {
+		if q.entries != entries[q] || q.cost != costs[q] {
+			return errors.Errorf("quota of %q counts %d entries of cost %d, expected %d of cost %d",
+				prefix, q.entries, q.cost, entries[q], costs[q])
+		}
+	}
+	return nil
+}
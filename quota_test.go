@@ -0,0 +1,157 @@
+package tinykv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaReject(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		MaxEntries(10),
+		Quota("a:", 2, 0),
+		Quota("b:", 3, 0),
+		Debug())
+	defer kv.Stop()
+	assert.Equal([]string{"a:", "b:"}, kv.Config().Quotas)
+	assert.Equal(QuotaReject, kv.Config().OverQuota)
+
+	assert.NoError(kv.Put("a:1", 1, ExpiresAfter(time.Minute)))
+	assert.NoError(kv.Put("a:2", 2))
+	err := kv.Put("a:3", 3)
+	assert.Equal(ErrQuotaExceeded, errors.Cause(err))
+	assert.Contains(err.Error(), `quota of "a:"`)
+	assert.NoError(kv.Put("a:2", 20)) // replacing takes no room
+	assert.Equal(int64(1), kv.Stats().RejectedPuts)
+
+	// the other tenant, and the keys without a quota, are not affected
+	for i := 0; i < 3; i++ {
+		assert.NoError(kv.Put(fmt.Sprintf("b:%d", i), i))
+	}
+	assert.NoError(kv.Put("c", 1))
+	entries, _ := kv.QuotaUsage("a:")
+	assert.Equal(2, entries)
+	entries, _ = kv.QuotaUsage("b:")
+	assert.Equal(3, entries)
+	entries, _ = kv.QuotaUsage("c")
+	assert.Equal(0, entries)
+
+	// deletion and expiration free room
+	kv.Delete("a:2")
+	assert.NoError(kv.Put("a:3", 3))
+	clock.Advance(time.Minute * 2)
+	kv.ExpireNow()
+	entries, _ = kv.QuotaUsage("a:")
+	assert.Equal(1, entries)
+	assert.NoError(kv.Put("a:4", 4))
+	assert.NoError(kv.CheckInvariants())
+
+	kv.Clear()
+	entries, _ = kv.QuotaUsage("b:")
+	assert.Equal(0, entries)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestQuotaEvict(t *testing.T) {
+	assert := assert.New(t)
+
+	var evicted []string
+	kv := NewStore(time.Hour,
+		Quota("a:", 2, 0),
+		Quota("b:", 2, 0),
+		OverQuota(QuotaEvict),
+		SynchronousNotifications(),
+		OnEvict(func(k string, v interface{}, reason EvictReason) {
+			evicted = append(evicted, k)
+			assert.Equal(EvictCapacity, reason)
+		}),
+		Debug())
+	defer kv.Stop()
+
+	kv.Put("b:1", 1, ExpiresAfter(time.Second))
+	kv.Put("b:2", 2)
+	kv.Put("a:1", 1, ExpiresAfter(time.Minute))
+	kv.Put("a:2", 2, ExpiresAfter(time.Second*30))
+	assert.NoError(kv.Put("a:3", 3))
+	assert.Equal([]string{"a:2"}, evicted) // the soonest to expire of a:, not b:1
+	assert.NoError(kv.Put("a:4", 4))
+	assert.Equal([]string{"a:2", "a:1"}, evicted)
+	assert.Len(kv.Prefix("b:"), 2)
+
+	// TryPut never evicts
+	assert.Equal(ErrQuotaExceeded, errors.Cause(kv.TryPut("a:5", 5)))
+	// nor are read-only entries evicted
+	kv.Put("b:1", 1, ReadOnly())
+	kv.Put("b:2", 2, ReadOnly())
+	assert.Equal(ErrQuotaExceeded, errors.Cause(kv.Put("b:3", 3)))
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestQuotaCost(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour,
+		CostFunc(func(k string, v interface{}) int64 { return int64(v.(int)) }),
+		Quota("a:", 0, 10),
+		OverQuota(QuotaEvict),
+		Debug())
+	defer kv.Stop()
+
+	kv.Put("a:1", 4)
+	kv.Put("a:2", 4)
+	kv.Put("b:1", 100) // no quota, nor MaxCost
+	entries, cost := kv.QuotaUsage("a:")
+	assert.Equal(2, entries)
+	assert.Equal(int64(8), cost)
+	assert.NoError(kv.Put("a:3", 5))
+	entries, cost = kv.QuotaUsage("a:")
+	assert.Equal(2, entries)
+	assert.Equal(int64(9), cost)
+	assert.Equal(ErrQuotaExceeded, errors.Cause(kv.Put("a:4", 11)))
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestSetQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	for i := 0; i < 3; i++ {
+		kv.Put(fmt.Sprintf("a:%d", i), i)
+		kv.Put(fmt.Sprintf("a:vip:%d", i), i)
+	}
+	// counted when set, against the longest prefix
+	kv.SetQuota("a:", 3, 0)
+	kv.SetQuota("a:vip:", 4, 0)
+	entries, _ := kv.QuotaUsage("a:")
+	assert.Equal(3, entries)
+	entries, _ = kv.QuotaUsage("a:vip:")
+	assert.Equal(3, entries)
+	assert.Equal(ErrQuotaExceeded, errors.Cause(kv.Put("a:3", 3)))
+	assert.NoError(kv.Put("a:vip:3", 3))
+	assert.NoError(kv.CheckInvariants())
+
+	// the entries over a lowered quota stay
+	kv.SetQuota("a:vip:", 2, 0)
+	entries, _ = kv.QuotaUsage("a:vip:")
+	assert.Equal(4, entries)
+	assert.Equal(ErrQuotaExceeded, errors.Cause(kv.Put("a:vip:4", 4)))
+
+	// removed, the a:vip: keys count against a:
+	kv.SetQuota("a:vip:", 0, 0)
+	assert.Equal([]string{"a:"}, kv.Config().Quotas)
+	entries, _ = kv.QuotaUsage("a:")
+	assert.Equal(7, entries)
+	kv.SetQuota("a:", 0, 0)
+	assert.NoError(kv.Put("a:3", 3))
+	assert.Nil(kv.Config().Quotas)
+	assert.NoError(kv.CheckInvariants())
+}
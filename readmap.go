@@ -0,0 +1,108 @@
+package tinykv
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ReadOptimized makes Get (and GetE) of most entries lock free, for read
+// mostly workloads, in the style of sync.Map. The store keeps an immutable
+// read map, swapped atomically, of the entries that can be read without
+// changing them: the ones that are not sliding, are not lists, sets or window
+// counters, and have no checksum (ChecksumValues). A Get of such an entry,
+// that has not expired, only reads the read map; every other Get takes the
+// lock. Changes to an entry invalidate it in the read map, and once the
+// locked Gets outnumber the entries, the read map is rebuilt from the store.
+// The copier of CopyValues may then be called concurrently.
+func ReadOptimized() StoreOption {
+	return func(opt *storeOpt) {
+		opt.readOptimized = true
+	}
+}
+
+// readMap is never modified once published, except for the stale flags
+type readMap map[string]*readEntry
+
+type readEntry struct {
+	value     interface{}
+	expiresAt time.Time // zero if the entry does not expire
+	stale     int32     // set (atomically) when the entry changes
+}
+
+// readGet is the lock free path of Get
+func (kv *Store) readGet(k string) (interface{}, bool) {
+	if !kv.readOptimized || kv.hotKeys != nil || kv.auditRate > 0 {
+		return nil, false
+	}
+	m, _ := kv.read.Load().(readMap)
+	re := m[k]
+	if re == nil || atomic.LoadInt32(&re.stale) != 0 {
+		return nil, false
+	}
+	if !re.expiresAt.IsZero() && !kv.now().Before(re.expiresAt) {
+		return nil, false
+	}
+	return kv.copyValue(re.value), true
+}
+
+// readMiss counts a locked Get, and rebuilds the read map when they outnumber
+// the entries. It must be called under the lock.
+func (kv *Store) readMiss() {
+	if !kv.readOptimized {
+		return
+	}
+	kv.readMisses++
+	if !kv.readDirty || kv.readMisses < kv.kv.len() {
+		return
+	}
+	m := make(readMap, kv.kv.len())
+	kv.kv.each(func(k string, e *entry) bool {
+		if !readable(e) || kv.expired(e) || len(kv.pendings[k]) > 0 || (kv.migrateOnRead != nil && !e.migrated) {
+			return true
+		}
+		re := &readEntry{value: e.value}
+		if e.timeout != nil {
+			re.expiresAt = e.timeout.expiresAt
+		}
+		m[k] = re
+		return true
+	})
+	kv.read.Store(m)
+	kv.readMisses = 0
+	kv.readDirty = false
+	kv.stats.ReadMapPromotions++
+}
+
+// readInvalidate must be called under the lock, on each change of k
+func (kv *Store) readInvalidate(k string) {
+	if !kv.readOptimized {
+		return
+	}
+	kv.readDirty = true
+	m, _ := kv.read.Load().(readMap)
+	if re := m[k]; re != nil {
+		atomic.StoreInt32(&re.stale, 1)
+	}
+}
+
+// readReset drops the read map, it must be called under the lock
+func (kv *Store) readReset() {
+	if !kv.readOptimized {
+		return
+	}
+	kv.read.Store(readMap(nil))
+	kv.readMisses = 0
+	kv.readDirty = true
+}
+
+// readable reports if e can be read without the lock
+func readable(e *entry) bool {
+	if e.hasChecksum || (e.timeout != nil && e.timeout.sliding()) {
+		return false
+	}
+	switch e.value.(type) {
+	case []interface{}, set, *windowCounter:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package tinykv
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// promote makes enough locked Gets for the read map to be rebuilt
+func promote(kv KV, n int) {
+	for i := 0; i <= n; i++ {
+		kv.Get("-missing-")
+	}
+}
+
+func TestReadOptimized(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), ReadOptimized(), Debug())
+	defer kv.Stop()
+	assert.True(kv.Config().ReadOptimized)
+
+	kv.Put("plain", 1)
+	kv.Put("ttl", 2, ExpiresAfter(time.Minute))
+	kv.Put("sliding", 3, ExpiresAfter(time.Minute), IsSliding(true))
+	kv.Append("list", 4)
+	promote(kv, 4)
+	assert.Equal(int64(1), kv.Stats().ReadMapPromotions)
+
+	s := kv
+	m := s.read.Load().(readMap)
+	assert.Len(m, 2)
+	assert.NotNil(m["plain"])
+	assert.NotNil(m["ttl"])
+
+	// changes are seen right away
+	kv.Put("plain", 11)
+	v, _ := kv.Get("plain")
+	assert.Equal(11, v)
+	kv.Append("list", 5)
+	v, _ = kv.Get("list")
+	assert.Equal([]interface{}{4, 5}, v)
+	kv.Delete("ttl")
+	_, ok := kv.Get("ttl")
+	assert.False(ok)
+
+	// sliding entries still slide
+	clock.Advance(time.Second * 50)
+	kv.Get("sliding")
+	clock.Advance(time.Second * 50)
+	v, _ = kv.Get("sliding")
+	assert.Equal(3, v)
+
+	kv.Clear()
+	_, ok = kv.Get("plain")
+	assert.False(ok)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestReadOptimizedExpiration(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var expired []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		ReadOptimized(),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) {
+			expired = append(expired, k)
+		}))
+	defer kv.Stop()
+
+	kv.Put("1", 1, ExpiresAfter(time.Minute))
+	promote(kv, 1)
+	v, ok := kv.Get("1")
+	assert.True(ok)
+	assert.Equal(1, v)
+
+	// an expired entry falls back to the locked path, and is notified there
+	clock.Advance(time.Minute + time.Second)
+	_, ok = kv.Get("1")
+	assert.False(ok)
+	assert.Equal([]string{"1"}, expired)
+}
+
+func TestReadOptimizedRace(t *testing.T) {
+	kv := NewStore(time.Millisecond*10, ReadOptimized(), Debug())
+	defer kv.Stop()
+
+	const (
+		keys    = 16
+		writes  = 2000
+		readers = 8
+	)
+	for i := 0; i < keys; i++ {
+		kv.Put(strconv.Itoa(i), 0)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	errs := make(chan error, readers)
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// values of a key only grow, so a reader never sees one go back
+			last := make([]int, keys)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for i := 0; i < keys; i++ {
+					v, ok := kv.Get(strconv.Itoa(i))
+					if !ok {
+						continue
+					}
+					if v.(int) < last[i] {
+						errs <- fmt.Errorf("key %d went back from %d to %d", i, last[i], v)
+						return
+					}
+					last[i] = v.(int)
+				}
+			}
+		}()
+	}
+
+	for n := 1; n <= writes; n++ {
+		k := strconv.Itoa(n % keys)
+		switch n % 7 {
+		case 0:
+			kv.Put(k, n, ExpiresAfter(time.Second))
+		case 1:
+			kv.Put(k, n, ExpiresAfter(time.Hour), IsSliding(true))
+		default:
+			kv.Put(k, n)
+		}
+		v, ok := kv.Get(k)
+		if !assert.True(t, ok) || !assert.Equal(t, n, v) {
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	assert.NoError(t, kv.CheckInvariants())
+}
+
+//-----------------------------------------------------------------------------
+
+// run with a high -cpu, like -cpu=64
+func BenchmarkGetParallel(b *testing.B) {
+	for _, c := range []struct {
+		name    string
+		options []StoreOption
+	}{
+		{"locked", nil},
+		{"read-optimized", []StoreOption{ReadOptimized()}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			kv := NewStore(time.Minute, c.options...)
+			defer kv.Stop()
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = strconv.Itoa(i)
+				kv.Put(keys[i], i)
+			}
+			promote(kv, len(keys))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					kv.Get(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
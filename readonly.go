@@ -0,0 +1,39 @@
+package tinykv
+
+// ReadOnly makes the entry read-only: Put (including CAS), Delete, Take,
+// and list and set operations on it fail with ErrReadOnly (Delete, Take,
+// Drain and the Pop methods leave it in place), until it expires. Only
+// ForcePut and ForceDelete, meant for administrative code, change it.
+func ReadOnly() PutOption {
+	return func(opt *putOpt) {
+		opt.readOnly = true
+	}
+}
+
+// ForcePut is like Put, but also overwrites read-only entries
+func (kv *Store) ForcePut(k string, v interface{}, options ...PutOption) (err error) {
+	defer wrapOp(&err, "force-put", k)
+	kv.active()
+	return kv.put(k, v, options, true)
+}
+
+// ForceDelete is like Delete, but also deletes read-only entries; protected
+// ones are left in place (see Protected)
+func (kv *Store) ForceDelete(k string) {
+	kv.active()
+	kv.mx.Lock()
+	kv.activateDue(k)
+	if kv.isProtected(k) {
+		kv.mx.Unlock()
+		return
+	}
+	kv.remove(k)
+	kv.mx.Unlock()
+	kv.backingRemoved(k)
+}
+
+// isReadOnly reports if there is a live read-only entry for k
+func (kv *Store) isReadOnly(k string) bool {
+	e, ok := kv.kv.get(k)
+	return ok && e.readOnly && !kv.expired(e)
+}
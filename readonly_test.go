@@ -0,0 +1,81 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("key", "secret", ReadOnly(), ExpiresAfter(time.Minute)))
+	assert.Equal(ErrReadOnly, errors.Cause(kv.Put("key", "other")))
+
+	called := false
+	err := kv.Put("key", "other", CAS(func(interface{}, bool) bool {
+		called = true
+		return true
+	}))
+	assert.Equal(ErrReadOnly, errors.Cause(err))
+	assert.False(called)
+
+	kv.Delete("key")
+	assert.Equal(ErrReadOnly, errors.Cause(kv.DeleteE("key")))
+	_, ok := kv.Take("key")
+	assert.False(ok)
+	_, err = kv.TakeE("key")
+	assert.Equal(ErrReadOnly, errors.Cause(err))
+	_, _, ok = kv.PopSoonest()
+	assert.False(ok)
+	_, _, ok = kv.PopLatest()
+	assert.False(ok)
+	assert.NoError(kv.CheckInvariants())
+
+	v, ok := kv.Get("key")
+	assert.True(ok)
+	assert.Equal("secret", v)
+	meta, _ := kv.GetMeta("key")
+	assert.True(meta.ReadOnly)
+
+	assert.NoError(kv.ForcePut("key", "rotated", ReadOnly()))
+	v, _ = kv.Get("key")
+	assert.Equal("rotated", v)
+	kv.ForceDelete("key")
+	_, ok = kv.Get("key")
+	assert.False(ok)
+
+	// expiration still applies
+	kv.Put("ttl", 1, ReadOnly(), ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+	_, ok = kv.Get("ttl")
+	assert.False(ok)
+	assert.NoError(kv.Put("ttl", 2))
+}
+
+func TestReadOnlyListSet(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := New(time.Hour)
+	defer kv.Stop()
+
+	kv.Put("list", []interface{}{1}, ReadOnly())
+	_, err := kv.Append("list", 2)
+	assert.Equal(ErrReadOnly, errors.Cause(err))
+	_, ok := kv.Drain("list")
+	assert.False(ok)
+
+	kv.Put("set", set{1: {}}, ReadOnly())
+	_, err = kv.AddToSet("set", 2)
+	assert.Equal(ErrReadOnly, errors.Cause(err))
+	_, err = kv.RemoveFromSet("set", 1)
+	assert.Equal(ErrReadOnly, errors.Cause(err))
+	members, _ := kv.SetMembers("set")
+	assert.Equal([]interface{}{1}, members)
+}
@@ -0,0 +1,129 @@
+package tinykv
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RefreshInfo describes a refresh in flight: a load of a key from the
+// Backing, on a miss (see WithBacking)
+type RefreshInfo struct {
+	Key       string
+	StartedAt time.Time
+	Attempts  int // the refreshes of Key in a row, this one included, since the last that did not fail
+}
+
+// ContextBacking is a Backing whose loads can be interrupted: a refresh
+// calls LoadContext instead of Load, with a ctx that CancelRefresh and Stop
+// cancel. The refreshes of a Backing that is not a ContextBacking can be
+// cancelled too, but Load runs to its end, and its result is discarded.
+type ContextBacking interface {
+	Backing
+	LoadContext(ctx context.Context, k string) (v interface{}, ttl time.Duration, ok bool, err error)
+}
+
+// refresh is a refresh in flight, shared by the misses of its key
+type refresh struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	startedAt time.Time
+	attempts  int
+	done      chan struct{}
+	value     interface{}
+	err       error
+}
+
+// Refreshes returns the refreshes in flight, sorted by key
+func (kv *Store) Refreshes() []RefreshInfo {
+	kv.mx.Lock()
+	infos := make([]RefreshInfo, 0, len(kv.refreshes))
+	for k, r := range kv.refreshes {
+		infos = append(infos, RefreshInfo{Key: k, StartedAt: r.startedAt, Attempts: r.attempts})
+	}
+	kv.mx.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos
+}
+
+// CancelRefresh cancels the refresh of k, if there is one in flight, and
+// reports if there was. The misses waiting for it get an error wrapping
+// context.Canceled, and nothing is put.
+func (kv *Store) CancelRefresh(k string) bool {
+	kv.mx.Lock()
+	r, ok := kv.refreshes[k]
+	kv.mx.Unlock()
+	if ok {
+		r.cancel()
+	}
+	return ok
+}
+
+// cancelRefreshes cancels the refreshes in flight, on Stop
+func (kv *Store) cancelRefreshes() {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	for _, r := range kv.refreshes {
+		r.cancel()
+	}
+}
+
+// refreshOnce runs load for k, once for the concurrent calls for k, which
+// all get its result
+func (kv *Store) refreshOnce(k string, load func(ctx context.Context, k string) (interface{}, error)) (interface{}, error) {
+	kv.mx.Lock()
+	if r, ok := kv.refreshes[k]; ok {
+		kv.mx.Unlock()
+		<-r.done
+		return kv.copyValue(r.value), r.err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &refresh{
+		ctx:       ctx,
+		cancel:    cancel,
+		startedAt: kv.now(),
+		attempts:  kv.refreshFailures[k] + 1,
+		done:      make(chan struct{}),
+	}
+	if kv.refreshes == nil {
+		kv.refreshes = make(map[string]*refresh)
+	}
+	kv.refreshes[k] = r
+	kv.mx.Unlock()
+
+	r.value, r.err = load(ctx, k)
+
+	kv.mx.Lock()
+	delete(kv.refreshes, k)
+	switch {
+	case r.err == nil || r.err == ErrNotFound:
+		delete(kv.refreshFailures, k)
+	default:
+		if kv.refreshFailures == nil {
+			kv.refreshFailures = make(map[string]int)
+		}
+		kv.refreshFailures[k] = r.attempts
+	}
+	kv.mx.Unlock()
+	cancel()
+	close(r.done)
+	return kv.copyValue(r.value), r.err
+}
+
+// loadContext loads k from the Backing, with ctx if it is a ContextBacking
+func (kv *Store) loadContext(ctx context.Context, k string) (v interface{}, ttl time.Duration, ok bool, err error) {
+	err = try(func() (err error) {
+		if b, isContext := kv.backing.(ContextBacking); isContext {
+			v, ttl, ok, err = b.LoadContext(ctx, k)
+			return err
+		}
+		v, ttl, ok, err = kv.backing.Load(k)
+		return err
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	return v, ttl, ok, errors.Wrap(err, "backing load")
+}
@@ -0,0 +1,110 @@
+package tinykv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// ctxBacking is a ContextBacking whose loads wait for their ctx to be done
+type ctxBacking struct {
+	*fakeBacking
+	cancelled chan error
+}
+
+func (b *ctxBacking) LoadContext(ctx context.Context, k string) (interface{}, time.Duration, bool, error) {
+	b.record("load-context " + k)
+	<-ctx.Done()
+	b.cancelled <- ctx.Err()
+	return nil, 0, false, ctx.Err()
+}
+
+// refreshing waits for the refreshes of the keys to be in flight
+func refreshing(t *testing.T, kv *Store, keys ...string) []RefreshInfo {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if infos := kv.Refreshes(); len(infos) == len(keys) {
+			return infos
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("refreshes of %v not in flight: %v", keys, kv.Refreshes())
+	return nil
+}
+
+func getAsync(kv *Store, k string) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		_, err := kv.GetE(k)
+		errs <- err
+	}()
+	return errs
+}
+
+func TestCancelRefresh(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	b := &ctxBacking{fakeBacking: newFakeBacking(), cancelled: make(chan error, 1)}
+	b.m["a"] = 1
+	kv := NewStore(time.Hour, Clock(clock.Now), WithBacking(b, WriteThrough))
+	defer kv.Stop()
+	assert.False(kv.CancelRefresh("a"))
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		errs := getAsync(kv, "a")
+		infos := refreshing(t, kv, "a")
+		assert.Equal([]RefreshInfo{{Key: "a", StartedAt: clock.Now(), Attempts: attempt}}, infos)
+
+		assert.True(kv.CancelRefresh("a"))
+		assert.Equal(context.Canceled, <-b.cancelled)
+		assert.Equal(context.Canceled, errors.Cause(<-errs))
+		assert.Empty(kv.Refreshes())
+		assert.Empty(kv.Keys())
+	}
+	assert.Equal([]string{"load-context a", "load-context a"}, b.recorded())
+	assert.Equal(int64(0), kv.Stats().BackingLoads)
+}
+
+func TestCancelRefreshOfLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	// Load cannot be interrupted, its result is discarded
+	b := newFakeBacking()
+	b.m["a"] = 1
+	b.loading = make(chan struct{})
+	kv := NewStore(time.Hour, WithBacking(b, WriteThrough))
+	defer kv.Stop()
+
+	errs := getAsync(kv, "a")
+	refreshing(t, kv, "a")
+	assert.True(kv.CancelRefresh("a"))
+	close(b.loading)
+	assert.Equal(context.Canceled, errors.Cause(<-errs))
+	assert.Empty(kv.Keys())
+
+	// the next miss loads it
+	v, err := kv.GetE("a")
+	assert.NoError(err)
+	assert.Equal(1, v)
+}
+
+func TestStopCancelsRefreshes(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &ctxBacking{fakeBacking: newFakeBacking(), cancelled: make(chan error, 2)}
+	kv := NewStore(time.Hour, WithBacking(b, WriteThrough))
+
+	errsA, errsB := getAsync(kv, "a"), getAsync(kv, "b")
+	infos := refreshing(t, kv, "a", "b")
+	assert.Equal("a", infos[0].Key)
+	assert.Equal("b", infos[1].Key)
+
+	kv.Stop()
+	assert.Equal(context.Canceled, errors.Cause(<-errsA))
+	assert.Equal(context.Canceled, errors.Cause(<-errsB))
+	assert.Empty(kv.Refreshes())
+}
@@ -0,0 +1,79 @@
+package tinykv
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var registry = struct {
+	mx     sync.Mutex
+	stores map[string]KV
+}{stores: make(map[string]KV)}
+
+// Register registers kv under name, so it can be found using Lookup.
+// If another store is registered under name, ErrRegistered is returned.
+func Register(name string, kv KV) error {
+	registry.mx.Lock()
+	defer registry.mx.Unlock()
+	if old, ok := registry.stores[name]; ok && old != kv {
+		return errors.Wrapf(ErrRegistered, "store %q", name)
+	}
+	registry.stores[name] = kv
+	return nil
+}
+
+// Deregister removes the store registered under name
+func Deregister(name string) {
+	registry.mx.Lock()
+	defer registry.mx.Unlock()
+	delete(registry.stores, name)
+}
+
+// deregister removes kv, if it is still the store registered under name
+func deregister(name string, kv KV) {
+	registry.mx.Lock()
+	defer registry.mx.Unlock()
+	if registry.stores[name] == kv {
+		delete(registry.stores, name)
+	}
+}
+
+// Lookup returns the store registered under name
+func Lookup(name string) (KV, bool) {
+	registry.mx.Lock()
+	defer registry.mx.Unlock()
+	kv, ok := registry.stores[name]
+	return kv, ok
+}
+
+// Stores returns the names of the registered stores, sorted
+func Stores() []string {
+	registry.mx.Lock()
+	defer registry.mx.Unlock()
+	names := make([]string, 0, len(registry.stores))
+	for name := range registry.stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//-----------------------------------------------------------------------------
+
+// Name sets the name of the store
+func Name(name string) StoreOption {
+	return func(opt *storeOpt) {
+		opt.name = name
+	}
+}
+
+// RegisterGlobally registers the store under its name (see Name) on creation,
+// and deregisters it on Stop. If the name is taken, the store is not
+// registered (Config().Registered is false).
+func RegisterGlobally() StoreOption {
+	return func(opt *storeOpt) {
+		opt.registerGlobally = true
+	}
+}
@@ -0,0 +1,54 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	kv1 := New(time.Hour)
+	defer kv1.Stop()
+	kv2 := New(time.Hour)
+	defer kv2.Stop()
+
+	assert.NoError(Register("test-registry", kv1))
+	defer Deregister("test-registry")
+	assert.NoError(Register("test-registry", kv1))
+	assert.Equal(ErrRegistered, errors.Cause(Register("test-registry", kv2)))
+
+	kv, ok := Lookup("test-registry")
+	assert.True(ok)
+	assert.Equal(kv1, kv)
+	assert.Contains(Stores(), "test-registry")
+
+	Deregister("test-registry")
+	_, ok = Lookup("test-registry")
+	assert.False(ok)
+}
+
+func TestRegisterGlobally(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Name("test-global"), RegisterGlobally())
+	found, ok := Lookup("test-global")
+	assert.True(ok)
+	assert.Equal(kv, found)
+	assert.Equal("test-global", kv.Config().Name)
+	assert.True(kv.Config().Registered)
+
+	taken := NewStore(time.Hour, Name("test-global"), RegisterGlobally())
+	assert.False(taken.Config().Registered)
+	taken.Stop()
+	_, ok = Lookup("test-global")
+	assert.True(ok)
+
+	kv.Stop()
+	_, ok = Lookup("test-global")
+	assert.False(ok)
+	assert.NotContains(Stores(), "test-global")
+}
@@ -0,0 +1,98 @@
+package tinykv
+
+import (
+	"strings"
+)
+
+// PrefixStats is the usage of a group of keys, in a Report
+type PrefixStats struct {
+	Entries   int
+	Bytes     int64 // approximate, see CostFunc
+	WithTTL   int
+	Permanent int
+}
+
+const reportChunk = 1024
+
+// Report groups the entries by the first depth segments of their keys,
+// separated by delimiter (keys with fewer segments are their own group), and
+// returns the usage of each group. The entries are scanned under the lock,
+// in chunks, so other operations are not blocked for the whole scan.
+func (kv *Store) Report(delimiter string, depth int) map[string]PrefixStats {
+	keys := kv.keys()
+	report := make(map[string]PrefixStats)
+	for len(keys) > 0 {
+		chunk := keys
+		if len(chunk) > reportChunk {
+			chunk = chunk[:reportChunk]
+		}
+		keys = keys[len(chunk):]
+
+		kv.mx.Lock()
+		for _, k := range chunk {
+			e, ok := kv.kv.get(k)
+			if !ok || kv.expired(e) {
+				continue
+			}
+			group := prefixOf(k, delimiter, depth)
+			stats := report[group]
+			stats.Entries++
+			stats.Bytes += kv.cost(k, e.value)
+			if e.timeout != nil {
+				stats.WithTTL++
+			} else {
+				stats.Permanent++
+			}
+			report[group] = stats
+		}
+		kv.mx.Unlock()
+	}
+	return report
+}
+
+func prefixOf(k, delimiter string, depth int) string {
+	if depth <= 0 {
+		return ""
+	}
+	if delimiter == "" {
+		return k
+	}
+	end := -len(delimiter)
+	for i := 0; i < depth; i++ {
+		n := strings.Index(k[end+len(delimiter):], delimiter)
+		if n < 0 {
+			return k
+		}
+		end += len(delimiter) + n
+	}
+	return k[:end]
+}
+
+//-----------------------------------------------------------------------------
+
+// CostFunc sets the function used to approximate the size of an entry, in
+// bytes. By default, it is the length of the key, plus the length of the
+// value for strings and byte slices.
+func CostFunc(costFunc func(k string, v interface{}) int64) StoreOption {
+	return func(opt *storeOpt) {
+		opt.costFunc = costFunc
+	}
+}
+
+func (kv *Store) cost(k string, v interface{}) int64 {
+	if kv.costFunc != nil {
+		return kv.costFunc(k, v)
+	}
+	return defaultCost(k, v)
+}
+
+func defaultCost(k string, v interface{}) int64 {
+	cost := int64(len(k))
+	switch v := v.(type) {
+	case string:
+		cost += int64(len(v))
+	case []byte:
+		cost += int64(len(v))
+	}
+	return cost
+}
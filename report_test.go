@@ -0,0 +1,53 @@
+package tinykv
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, CostFunc(func(k string, v interface{}) int64 {
+		return int64(v.(int))
+	}))
+	defer kv.Stop()
+
+	kv.Put("a:x", 10)
+	kv.Put("a:y", 20, ExpiresAfter(time.Minute))
+	kv.Put("b:z", 5)
+
+	assert.Equal(map[string]PrefixStats{
+		"a": {Entries: 2, Bytes: 30, WithTTL: 1, Permanent: 1},
+		"b": {Entries: 1, Bytes: 5, Permanent: 1},
+	}, kv.Report(":", 1))
+	assert.Equal(map[string]PrefixStats{
+		"": {Entries: 3, Bytes: 35, WithTTL: 1, Permanent: 2},
+	}, kv.Report(":", 0))
+}
+
+func TestReportDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := New(time.Hour)
+	defer kv.Stop()
+
+	for i := 0; i < reportChunk*2+1; i++ {
+		kv.Put("svc::user::"+strconv.Itoa(i), "")
+	}
+	kv.Put("svc::session::1", "abc")
+	kv.Put("svc", "")
+
+	report := kv.Report("::", 2)
+	assert.Len(report, 3)
+	assert.Equal(reportChunk*2+1, report["svc::user"].Entries)
+	assert.Equal(PrefixStats{Entries: 1, Bytes: int64(len("svc::session::1abc")), Permanent: 1}, report["svc::session"])
+	assert.Equal(1, report["svc"].Entries)
+
+	assert.Equal("a:b", prefixOf("a:b:c", ":", 2))
+	assert.Equal("a:b:c", prefixOf("a:b:c", ":", 3))
+	assert.Equal("a", prefixOf("a", ":", 1))
+}
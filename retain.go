@@ -0,0 +1,175 @@
+package tinykv
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxRetainAge limits how long a removed entry is held for its holders (see
+// Retain): d after its removal, the janitor finishes it as if its last holder
+// called Release, and counts it in Stats.OverdueReleases. It is a safety
+// valve against holders that never Release.
+func MaxRetainAge(d time.Duration) StoreOption {
+	return func(opt *storeOpt) {
+		opt.maxRetainAge = d
+	}
+}
+
+// Retain adds a holder of the entry for k, so expiring or deleting it does
+// not destroy its value under the holder. The entry is removed from view as
+// usual (Get and the like miss it, a Put creates a new one), but it is held:
+// its value stays available to the holders through GetRetained, and its
+// notifications (OnExpire, watches, CloseOnRemoval, ArchiveOnExpire, ...)
+// wait until its last holder calls Release. The count is of the key: it
+// carries over a Put of a new value, which replaces the old one as usual.
+// Retain does not slide the entry. GetMeta reports the count, as Refs.
+func (kv *Store) Retain(k string) (err error) {
+	defer wrapOp(&err, "retain", k)
+	kv.active()
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e == nil {
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return lookupErr(expired)
+	}
+	if e.refs == 0 {
+		kv.retainedKeys++
+	}
+	e.refs++
+	kv.mx.Unlock()
+	return nil
+}
+
+// Release drops a holder of k (see Retain): one of the oldest removed entry
+// held for k, if any, otherwise one of the entry for k. A removed entry is
+// finished when its last holder is gone: its notifications are sent then.
+// ErrNotRetained is returned if k has no holder.
+func (kv *Store) Release(k string) (err error) {
+	defer wrapOp(&err, "release", k)
+	kv.active()
+	kv.mx.Lock()
+	if held := kv.held[k]; len(held) > 0 {
+		e := held[0]
+		e.refs--
+		var expired map[string]*entry
+		if e.refs == 0 {
+			expired = kv.finish(k, e)
+		}
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return nil
+	}
+	e, ok := kv.kv.get(k)
+	if !ok || e.refs == 0 {
+		kv.mx.Unlock()
+		return ErrNotRetained
+	}
+	e.refs--
+	if e.refs == 0 {
+		kv.retainedKeys--
+	}
+	kv.mx.Unlock()
+	return nil
+}
+
+// GetRetained gets the value of k for its holders (see Retain): that of the
+// oldest removed entry held for k, if any, otherwise that of the entry for k,
+// if it is retained. It does not slide the entry.
+func (kv *Store) GetRetained(k string) (interface{}, bool) {
+	kv.active()
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if held := kv.held[k]; len(held) > 0 {
+		return kv.copyValue(held[0].value), true
+	}
+	if e, ok := kv.kv.get(k); ok && e.refs > 0 {
+		return kv.copyValue(e.value), true
+	}
+	return nil, false
+}
+
+// hold keeps e, the retained entry of k being removed, for its holders. The
+// timeout of an entry that is not expired is dropped, so it is not reported
+// as expired when it is finished.
+func (kv *Store) hold(k string, e *entry) {
+	kv.retainedKeys--
+	e.heldAt = kv.now()
+	if !kv.expired(e) {
+		e.timeout = nil
+	}
+	if kv.held == nil {
+		kv.held = make(map[string][]*entry)
+	}
+	kv.held[k] = append(kv.held[k], e)
+}
+
+// finish ends the removal of e, a held entry of k without holders anymore;
+// if e expired, it is returned captured, for notification
+func (kv *Store) finish(k string, e *entry) map[string]*entry {
+	held := kv.held[k]
+	for i, h := range held {
+		if h == e {
+			held = append(held[:i], held[i+1:]...)
+			break
+		}
+	}
+	if len(held) == 0 {
+		delete(kv.held, k)
+	} else {
+		kv.held[k] = held
+	}
+	kv.emitRemoved(k, e)
+	kv.archive(k, e)
+	if e.timeout != nil {
+		e.keepOpen = true // until notified
+		return map[string]*entry{k: e.captured()}
+	}
+	if !e.keepOpen {
+		kv.closeRemoved(k, e.value)
+	}
+	return nil
+}
+
+// finishOverdue finishes the entries held longer than MaxRetainAge, adding
+// the expired ones to expired; it must be called under the lock. An entry
+// of a key already in expired waits for the next sweep.
+func (kv *Store) finishOverdue(now time.Time, expired map[string]*entry) {
+	if kv.maxRetainAge <= 0 || len(kv.held) == 0 {
+		return
+	}
+	for k, held := range kv.held {
+		e := held[0]
+		if _, ok := expired[k]; ok || now.Sub(e.heldAt) <= kv.maxRetainAge {
+			continue
+		}
+		e.refs = 0
+		kv.stats.OverdueReleases++
+		for k, e := range kv.finish(k, e) {
+			expired[k] = e
+		}
+	}
+}
+
+// checkRetained verifies the counts of holders, for CheckInvariants
+func (kv *Store) checkRetained() error {
+	retained := 0
+	kv.kv.each(func(_ string, e *entry) bool {
+		if e.refs > 0 {
+			retained++
+		}
+		return true
+	})
+	if retained != kv.retainedKeys {
+		return errors.Errorf("%d entries are retained, expected %d", kv.retainedKeys, retained)
+	}
+	for k, held := range kv.held {
+		for _, e := range held {
+			if e.refs <= 0 {
+				return errors.Errorf("a held entry of %q has no holders", k)
+			}
+		}
+	}
+	return nil
+}
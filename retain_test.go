@@ -0,0 +1,158 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetainExpire(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var expired []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		Debug(),
+		OnExpire(func(k string, v interface{}) { expired = append(expired, k) }))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Minute)))
+	assert.NoError(kv.Retain("k"))
+	assert.NoError(kv.Retain("k"))
+	meta, _ := kv.GetMeta("k")
+	assert.Equal(2, meta.Refs)
+
+	// condemned: gone for Get, still there for the holders
+	clock.Advance(time.Minute * 2)
+	kv.ExpireNow()
+	assert.Empty(expired)
+	_, ok := kv.Get("k")
+	assert.False(ok)
+	assert.Equal(0, kv.Len())
+	v, ok := kv.GetRetained("k")
+	assert.True(ok)
+	assert.Equal(1, v)
+	assert.Equal(ErrNotFound, errors.Cause(kv.Retain("k")))
+	assert.NoError(kv.CheckInvariants())
+
+	// a new entry does not disturb the holders
+	assert.NoError(kv.Put("k", 2))
+	v, _ = kv.GetRetained("k")
+	assert.Equal(1, v)
+
+	assert.NoError(kv.Release("k"))
+	assert.Empty(expired)
+	assert.NoError(kv.Release("k"))
+	assert.Equal([]string{"k"}, expired)
+	_, ok = kv.GetRetained("k")
+	assert.False(ok)
+	assert.Equal(ErrNotRetained, errors.Cause(kv.Release("k")))
+	v, _ = kv.Get("k")
+	assert.Equal(2, v)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestRetainDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	closed := make(chan string, 10)
+	kv := NewStore(time.Hour, CloseOnRemoval(), Debug())
+	defer kv.Stop()
+	watch, cancel := kv.WatchPrefix("")
+	defer cancel()
+
+	assert.NoError(kv.Put("k", &fakeCloser{name: "k", closed: closed}))
+	assert.NoError(kv.Retain("k"))
+	kv.Delete("k")
+	_, ok := kv.Get("k")
+	assert.False(ok)
+	_, ok = kv.GetRetained("k")
+	assert.True(ok)
+	assert.Equal(EventPut, (<-watch).Type)
+	select {
+	case name := <-closed:
+		t.Fatalf("%s closed while retained", name)
+	case ev := <-watch:
+		t.Fatalf("%v event while retained", ev.Type)
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	assert.NoError(kv.Release("k"))
+	assert.Equal([]string{"k"}, receiveClosed(t, closed, 1))
+	assert.Equal(EventDelete, (<-watch).Type)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestRetainCarriesOver(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, Debug())
+	defer kv.Stop()
+
+	assert.Equal(ErrNotFound, errors.Cause(kv.Retain("k")))
+	assert.Equal(ErrNotRetained, errors.Cause(kv.Release("k")))
+
+	assert.NoError(kv.Put("k", 1))
+	assert.NoError(kv.Retain("k"))
+	assert.NoError(kv.Put("k", 2))
+	meta, _ := kv.GetMeta("k")
+	assert.Equal(1, meta.Refs)
+	v, ok := kv.GetRetained("k")
+	assert.True(ok)
+	assert.Equal(2, v)
+
+	assert.NoError(kv.Release("k"))
+	meta, _ = kv.GetMeta("k")
+	assert.Equal(0, meta.Refs)
+	_, ok = kv.GetRetained("k")
+	assert.False(ok)
+
+	assert.NoError(kv.Retain("k"))
+	kv.Clear()
+	v, ok = kv.GetRetained("k")
+	assert.True(ok)
+	assert.Equal(2, v)
+	assert.NoError(kv.CheckInvariants())
+	assert.NoError(kv.Release("k"))
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestMaxRetainAge(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var expired []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		Debug(),
+		MaxRetainAge(time.Minute),
+		OnExpire(func(k string, v interface{}) { expired = append(expired, k) }))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("leaked", 1, ExpiresAfter(time.Second)))
+	assert.NoError(kv.Retain("leaked"))
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	assert.Empty(expired)
+
+	clock.Advance(time.Minute)
+	kv.ExpireNow()
+	assert.Empty(expired)
+	_, ok := kv.GetRetained("leaked")
+	assert.True(ok)
+
+	// the holder never releases it
+	clock.Advance(time.Second)
+	kv.ExpireNow()
+	assert.Equal([]string{"leaked"}, expired)
+	_, ok = kv.GetRetained("leaked")
+	assert.False(ok)
+	assert.Equal(int64(1), kv.Stats().OverdueReleases)
+	assert.Equal(ErrNotRetained, errors.Cause(kv.Release("leaked")))
+	assert.NoError(kv.CheckInvariants())
+}
@@ -0,0 +1,63 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// EvictScrubbed is the evict reason of the entries removed by ScrubPolicy
+const EvictScrubbed EvictReason = "scrubbed"
+
+// ScrubPolicy makes the janitor remove the entries matching pred, every
+// every, for hygiene: like empty values written by buggy callers, keys of an
+// old format, or values older than an age. The entries are scanned after a
+// sweep, under the lock in chunks, like ExtendTTL, so other operations are
+// not blocked for the whole scan; pred is called under the lock, so it must be
+// fast, and must not use the store. Scrubbed entries are evictions: they are
+// reported to OnEvict with EvictScrubbed (not to OnExpire), and counted in
+// Stats.Scrubbed and Stats.Evictions. Read-only entries are never scrubbed.
+// The first scrub happens every after the store is created.
+func ScrubPolicy(pred func(k string, v interface{}) bool, every time.Duration) StoreOption {
+	return func(opt *storeOpt) {
+		opt.scrubPred = pred
+		opt.scrubEvery = every
+	}
+}
+
+// scrub removes the entries matching the ScrubPolicy, if due; it is called
+// by the janitor only
+func (kv *Store) scrub() {
+	if kv.scrubPred == nil || kv.now().Sub(kv.lastScrub) < kv.scrubEvery {
+		return
+	}
+	kv.lastScrub = kv.now()
+	b := kv.newBulkRemoval("scrub", kv.onEvict != nil)
+	b.evicts = true
+	keys := kv.keys()
+	for len(keys) > 0 {
+		chunk := keys
+		if len(chunk) > reportChunk {
+			chunk = chunk[:reportChunk]
+		}
+		keys = keys[len(chunk):]
+
+		kv.mx.Lock()
+		for _, k := range chunk {
+			e, ok := kv.kv.get(k)
+			if !ok || e.readOnly || kv.expired(e) || !kv.scrubPred(k, e.value) {
+				continue
+			}
+			b.remove(k, e)
+		}
+		kv.mx.Unlock()
+	}
+	if b.count == 0 {
+		return
+	}
+	kv.mx.Lock()
+	kv.stats.Scrubbed += int64(b.count)
+	kv.stats.Evictions += int64(b.count)
+	kv.done(b)
+	kv.mx.Unlock()
+	kv.notifyBulkRemoval(b)
+	kv.notifyEvictions(b.removed, EvictScrubbed)
+}
@@ -0,0 +1,77 @@
+package tinykv
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrubPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	isJunk := func(k string, v interface{}) bool {
+		switch v := v.(type) {
+		case nil:
+			return true
+		case string:
+			return v == ""
+		case []int:
+			return len(v) == 0
+		}
+		return false
+	}
+	clock := newFakeClock()
+	var mx sync.Mutex
+	evicted := make(map[string]EvictReason)
+	kv := NewStore(time.Millisecond,
+		Clock(clock.Now),
+		ScrubPolicy(isJunk, time.Minute),
+		SynchronousNotifications(),
+		OnEvict(func(k string, v interface{}, reason EvictReason) {
+			mx.Lock()
+			defer mx.Unlock()
+			evicted[k] = reason
+		}))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("empty", ""))
+	assert.NoError(kv.Put("nil", nil))
+	assert.NoError(kv.Put("nil-slice", []int(nil)))
+	assert.NoError(kv.Put("short-lived", "", ExpiresAfter(time.Hour)))
+	assert.NoError(kv.Put("read-only", "", ReadOnly()))
+	assert.NoError(kv.Put("name", "tinykv"))
+	assert.NoError(kv.Put("ids", []int{1}))
+
+	// not due yet
+	assert.NoError(kv.AwaitSweep(context.Background(), 2))
+	assert.Len(kv.Keys(), 7)
+
+	clock.Advance(time.Minute)
+	assert.NoError(kv.AwaitSweep(context.Background(), 2))
+	assert.ElementsMatch([]string{"read-only", "name", "ids"}, kv.Keys())
+	mx.Lock()
+	assert.Equal(map[string]EvictReason{
+		"empty":       EvictScrubbed,
+		"nil":         EvictScrubbed,
+		"nil-slice":   EvictScrubbed,
+		"short-lived": EvictScrubbed,
+	}, evicted)
+	mx.Unlock()
+	stats := kv.Stats()
+	assert.Equal(int64(4), stats.Scrubbed)
+	assert.Equal(int64(4), stats.Evictions)
+
+	// on the cadence only
+	assert.NoError(kv.Put("empty", ""))
+	assert.NoError(kv.AwaitSweep(context.Background(), 2))
+	_, ok := kv.Get("empty")
+	assert.True(ok)
+	clock.Advance(time.Minute)
+	assert.NoError(kv.AwaitSweep(context.Background(), 2))
+	_, ok = kv.Get("empty")
+	assert.False(ok)
+	assert.Equal(time.Minute, kv.Config().ScrubEvery)
+}
@@ -0,0 +1,50 @@
+package tinykv
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SeenRecently checks and marks k in one step, for deduplication: if there
+// is no entry for k, it puts one that expires after window and returns true;
+// otherwise it returns false, leaving the entry as it is, so the window is
+// measured from the first sight of k.
+func (kv *Store) SeenRecently(k string, window time.Duration) (firstSeen bool, err error) {
+	defer wrapOp(&err, "seen-recently", k)
+	kv.active()
+	return kv.seen(k, window, false)
+}
+
+// SeenRecentlySliding is like SeenRecently, but the entry slides on each
+// sight, so the window is measured from the last sight of k.
+func (kv *Store) SeenRecentlySliding(k string, window time.Duration) (firstSeen bool, err error) {
+	defer wrapOp(&err, "seen-recently", k)
+	kv.active()
+	return kv.seen(k, window, true)
+}
+
+func (kv *Store) seen(k string, window time.Duration, sliding bool) (bool, error) {
+	if window <= 0 {
+		return false, errors.Wrap(ErrInvalidOptions, "non-positive window")
+	}
+	if err := kv.checkString(struct{}{}); err != nil {
+		return false, err
+	}
+	opt := putOpt{expiresAfter: window, isSliding: sliding, hasIsSliding: true}
+	kv.floorTTL(&opt)
+	if err := opt.validate(); err != nil {
+		return false, err
+	}
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e != nil {
+		kv.slide(e)
+		kv.mx.Unlock()
+		return false, nil
+	}
+	kv.set(k, kv.newEntry(k, struct{}{}, &opt))
+	kv.mx.Unlock()
+	kv.notify(expired)
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package tinykv
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeenRecently(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	first, err := kv.SeenRecently("msg-1", time.Minute)
+	assert.NoError(err)
+	assert.True(first)
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second * 20)
+		first, _ = kv.SeenRecently("msg-1", time.Minute)
+		assert.False(first, "%d", i)
+	}
+	// measured from the first sight, though seen since
+	clock.Advance(time.Nanosecond)
+	first, _ = kv.SeenRecently("msg-1", time.Minute)
+	assert.True(first)
+
+	_, err = kv.SeenRecently("msg-2", 0)
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestSeenRecentlySliding(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	first, err := kv.SeenRecentlySliding("msg-1", time.Minute)
+	assert.NoError(err)
+	assert.True(first)
+	// measured from the last sight
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second * 50)
+		first, _ = kv.SeenRecentlySliding("msg-1", time.Minute)
+		assert.False(first, "%d", i)
+	}
+	clock.Advance(time.Minute + time.Nanosecond)
+	first, _ = kv.SeenRecentlySliding("msg-1", time.Minute)
+	assert.True(first)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestSeenRecentlyRace(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+
+	for _, seen := range []func(k string, window time.Duration) (bool, error){kv.SeenRecently, kv.SeenRecentlySliding} {
+		var (
+			wg    sync.WaitGroup
+			start = make(chan struct{})
+			first int64
+		)
+		kv.Clear()
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if ok, _ := seen("msg", time.Minute); ok {
+					atomic.AddInt64(&first, 1)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		assert.Equal(int64(1), first)
+	}
+}
+
+func TestSeenRecentlyAllocs(t *testing.T) {
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+	kv.SeenRecently("msg", time.Minute)
+	allocs := testing.AllocsPerRun(100, func() {
+		kv.SeenRecently("msg", time.Minute)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
@@ -0,0 +1,77 @@
+package tinykv
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeq(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+
+	ch, cancel := kv.WatchPrefix("")
+	defer cancel()
+
+	kv.Put("1", 1)
+	kv.Put("2", 2)
+	kv.Put("1", 11)
+	kv.Append("3", 3)
+	kv.Append("3", 33)
+	kv.Delete("2")
+
+	meta, _ := kv.GetMeta("1")
+	assert.Equal(uint64(3), meta.Seq)
+	meta, _ = kv.GetMeta("3")
+	assert.Equal(uint64(5), meta.Seq)
+
+	var seqs []uint64
+	for _, ev := range receive(t, ch, 6) {
+		seqs = append(seqs, ev.Seq)
+	}
+	assert.Equal([]uint64{1, 2, 3, 4, 5, 2}, seqs) // the delete carries the seq of the removed value
+}
+
+func TestSeqConcurrentWriters(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+
+	const writers, writes = 8, 500
+	seqs := make([][]uint64, writers)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			k := strconv.Itoa(w)
+			for i := 0; i < writes; i++ {
+				kv.Put(k, i)
+				meta, _ := kv.GetMeta(k)
+				seqs[w] = append(seqs[w], meta.Seq)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool)
+	for _, s := range seqs {
+		for i, seq := range s {
+			if i > 0 {
+				assert.True(seq > s[i-1], "seq %d after %d", seq, s[i-1])
+			}
+			assert.False(seen[seq], "seq %d is repeated", seq)
+			seen[seq] = true
+		}
+	}
+	assert.Len(seen, writers*writes)
+	for _, seq := range []uint64{1, writers * writes} {
+		assert.True(seen[seq], "seq %d is missing", seq)
+	}
+}
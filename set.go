@@ -0,0 +1,108 @@
+package tinykv
+
+import (
+	"github.com/pkg/errors"
+)
+
+type set = map[interface{}]struct{}
+
+// AddToSet adds member to the set stored at k. The set gets created on first
+// add, and only then the options are applied; later adds slide the entry.
+// If k holds a value that is not a set, ErrTypeConflict is returned.
+func (kv *Store) AddToSet(k string, member interface{}, options ...PutOption) (added bool, err error) {
+	defer wrapOp(&err, "add-to-set", k)
+	kv.active()
+	opt := kv.putOptions(options)
+	if err := opt.validate(); err != nil {
+		return false, err
+	}
+	if opt.boundTo != nil {
+		return false, errors.Wrap(ErrInvalidOptions, "BoundTo passed to AddToSet")
+	}
+	if err := kv.checkString(set(nil)); err != nil {
+		return false, err
+	}
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e == nil {
+		e = kv.newEntry(k, set{member: {}}, opt)
+		kv.set(k, e)
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return true, nil
+	}
+	if e.readOnly {
+		kv.mx.Unlock()
+		return false, ErrReadOnly
+	}
+	members, ok := e.value.(set)
+	if !ok {
+		kv.mx.Unlock()
+		return false, errors.Wrapf(ErrTypeConflict, "holds a %T, not a set", e.value)
+	}
+	_, found := members[member]
+	members[member] = struct{}{}
+	kv.slide(e)
+	if !found {
+		kv.modified(k, e)
+	}
+	kv.mx.Unlock()
+	return !found, nil
+}
+
+// RemoveFromSet removes member from the set stored at k. When the last
+// member is removed, the entry is deleted.
+func (kv *Store) RemoveFromSet(k string, member interface{}) (removed bool, err error) {
+	defer wrapOp(&err, "remove-from-set", k)
+	kv.active()
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e == nil {
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return false, nil
+	}
+	if e.readOnly {
+		kv.mx.Unlock()
+		return false, ErrReadOnly
+	}
+	members, ok := e.value.(set)
+	if !ok {
+		kv.mx.Unlock()
+		return false, errors.Wrapf(ErrTypeConflict, "holds a %T, not a set", e.value)
+	}
+	_, found := members[member]
+	delete(members, member)
+	if len(members) == 0 {
+		kv.remove(k)
+	} else if found {
+		kv.modified(k, e)
+	}
+	kv.mx.Unlock()
+	return found, nil
+}
+
+// SetMembers returns a copy of the members of the set stored at k,
+// and slides it, like a Get.
+func (kv *Store) SetMembers(k string) ([]interface{}, bool) {
+	kv.active()
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e == nil {
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return nil, false
+	}
+	members, ok := e.value.(set)
+	if !ok {
+		kv.mx.Unlock()
+		return nil, false
+	}
+	res := make([]interface{}, 0, len(members))
+	for m := range members {
+		res = append(res, m)
+	}
+	kv.slide(e)
+	kv.mx.Unlock()
+	return res, true
+}
@@ -0,0 +1,96 @@
+package tinykv
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetConcurrentAddRemove(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := New(-1)
+	defer kv.Stop()
+
+	const n = 1000
+	var added, removed int64
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				ok, err := kv.AddToSet("room", i)
+				assert.NoError(err)
+				if ok {
+					atomic.AddInt64(&added, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(int64(n), added)
+
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i += 2 {
+				ok, err := kv.RemoveFromSet("room", i)
+				assert.NoError(err)
+				if ok {
+					atomic.AddInt64(&removed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(int64(n/2), removed)
+
+	members, ok := kv.SetMembers("room")
+	assert.True(ok)
+	assert.Equal(n/2, len(members))
+	for _, m := range members {
+		assert.Equal(1, m.(int)%2)
+	}
+}
+
+func TestSetLifecycle(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := New(time.Millisecond * 5)
+	defer kv.Stop()
+
+	ok, err := kv.RemoveFromSet("room", "a")
+	assert.NoError(err)
+	assert.False(ok)
+
+	ok, err = kv.AddToSet("room", "a", ExpiresAfter(time.Millisecond*30))
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = kv.AddToSet("room", "a")
+	assert.NoError(err)
+	assert.False(ok)
+
+	ok, err = kv.RemoveFromSet("room", "a")
+	assert.NoError(err)
+	assert.True(ok)
+	_, ok = kv.SetMembers("room")
+	assert.False(ok)
+
+	kv.AddToSet("room", "b", ExpiresAfter(time.Millisecond*30))
+	<-time.After(time.Millisecond * 60)
+	_, ok = kv.SetMembers("room")
+	assert.False(ok)
+	checkInvariants(t, kv)
+
+	kv.Put("other", 1)
+	_, err = kv.AddToSet("other", "a")
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	_, err = kv.RemoveFromSet("other", "a")
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+}
@@ -0,0 +1,75 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSlidingAccess pins down which reads count as an access of a sliding
+// entry, see IsSliding
+func TestSlidingAccess(t *testing.T) {
+	yes := func(interface{}, bool) bool { return true }
+	cases := []struct {
+		name   string
+		read   func(kv *Store)
+		slides bool
+	}{
+		{"Get", func(kv *Store) { kv.Get("k") }, true},
+		{"GetE", func(kv *Store) { kv.GetE("k") }, true},
+		{"GetGraced", func(kv *Store) { kv.GetGraced("k") }, true},
+		{"Touch", func(kv *Store) { kv.Touch("k") }, true},
+		{"SetMembers", func(kv *Store) { kv.SetMembers("set") }, true},
+		{"CAS", func(kv *Store) { kv.CAS("k", 2, yes, KeepTTL()) }, true},
+		{"Append", func(kv *Store) { kv.Append("list", 2) }, true},
+		{"AddToSet", func(kv *Store) { kv.AddToSet("set", 2) }, true},
+		{"GetMeta", func(kv *Store) { kv.GetMeta("k") }, false},
+		{"Keys", func(kv *Store) { kv.Keys() }, false},
+		{"Prefix", func(kv *Store) { kv.Prefix("") }, false},
+		{"Range", func(kv *Store) { kv.Range(func(string, interface{}) bool { return true }) }, false},
+		{"RangeMeta", func(kv *Store) { kv.RangeMeta(func(string, interface{}, Meta) bool { return true }) }, false},
+		{"ExpirationHistogram", func(kv *Store) { kv.ExpirationHistogram(time.Second, time.Minute) }, false},
+		{"Report", func(kv *Store) { kv.Report(":", 1) }, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			clock := newFakeClock()
+			kv := NewStore(time.Hour, Clock(clock.Now))
+			defer kv.Stop()
+
+			sliding := []PutOption{ExpiresAfter(time.Second * 10), IsSliding(true)}
+			kv.Put("k", 1, sliding...)
+			kv.Append("list", 1, sliding...)
+			kv.AddToSet("set", 1, sliding...)
+			deadlines := func() map[string]time.Time {
+				m := make(map[string]time.Time)
+				for _, k := range []string{"k", "list", "set"} {
+					meta, _ := kv.GetMeta(k)
+					m[k] = meta.ExpiresAt
+				}
+				return m
+			}
+			before := deadlines()
+
+			clock.Advance(time.Second * 5)
+			c.read(kv)
+			after := deadlines()
+			slid := 0
+			for k, deadline := range after {
+				if deadline.Equal(before[k]) {
+					continue
+				}
+				assert.True(deadline.Equal(clock.Now().Add(time.Second*10)), "%s: %v", k, deadline)
+				slid++
+			}
+			if c.slides {
+				assert.Equal(1, slid)
+			} else {
+				assert.Equal(0, slid)
+			}
+		})
+	}
+}
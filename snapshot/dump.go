@@ -0,0 +1,162 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	"github.com/dc0d/tinykv"
+	"github.com/pkg/errors"
+)
+
+// defaultMaxValueBytes is how many bytes of a value DumpText shows in hex,
+// without DumpOptions.MaxValueBytes
+const defaultMaxValueBytes = 64
+
+// DumpOptions are the options of DumpText
+type DumpOptions struct {
+	// Codec renders the values; without it, or if it fails on a value, the
+	// value is shown in hex
+	Codec tinykv.ValueCodec
+	// Prefix lists only the keys with this prefix
+	Prefix string
+	// ExpiringWithin lists only the entries that expire within this
+	// duration from the time of the dump, expired ones included; zero lists
+	// all the entries
+	ExpiringWithin time.Duration
+	// Now is the time of the dump, time.Now() if zero
+	Now time.Time
+	// MaxValueBytes is how many bytes of a value are shown in hex, 64 if
+	// zero
+	MaxValueBytes int
+}
+
+// DumpText reads a snapshot from r and writes a listing of its entries to w,
+// one per line: the key, the time to live left at the time of the dump, the
+// flags and the value, in columns, between a header and a trailer line (that
+// start with #). Keys are quoted, in ASCII. The time left is the time the
+// entry had left at Save, less the time since. A snapshot that is truncated
+// or corrupted is listed up to the damage, the trailer says why it stops,
+// and the error is returned.
+func DumpText(r io.Reader, w io.Writer, opts DumpOptions) error {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	if opts.MaxValueBytes <= 0 {
+		opts.MaxValueBytes = defaultMaxValueBytes
+	}
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	savedAt := h.StoreTime
+	if savedAt.IsZero() {
+		savedAt = h.CreatedAt
+	}
+	elapsed := opts.Now.Sub(h.CreatedAt)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# snapshot %q, version %d, %d entries, created %s\n",
+		h.Name, h.Version, h.Count, h.CreatedAt.UTC().Format(time.RFC3339))
+	tw := tabwriter.NewWriter(bw, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTTL\tFLAGS\tVALUE")
+
+	listed, n := 0, 0
+	var readErr error
+	for ; n < h.Count; n++ {
+		e, err := readEntry(br)
+		if err != nil {
+			readErr = errors.Wrapf(err, "read %d of %d entries", n, h.Count)
+			break
+		}
+		if !strings.HasPrefix(e.key, opts.Prefix) {
+			continue
+		}
+		var left time.Duration
+		if e.deadline != 0 {
+			left = time.Unix(0, e.deadline).Sub(savedAt) - elapsed
+		}
+		if opts.ExpiringWithin > 0 && (e.deadline == 0 || left > opts.ExpiringWithin) {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			strconv.QuoteToASCII(e.key), dumpTTL(e.deadline, left), dumpFlags(e.flags), dumpValue(e.value, opts))
+		listed++
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if readErr != nil {
+		fmt.Fprintf(bw, "# %d of %d entries listed, stopped: %v\n", listed, h.Count, readErr)
+	} else {
+		fmt.Fprintf(bw, "# %d of %d entries listed\n", listed, h.Count)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return readErr
+}
+
+// dumpTTL renders the time left of an entry
+func dumpTTL(deadline int64, left time.Duration) string {
+	switch {
+	case deadline == 0:
+		return "-"
+	case left <= 0:
+		return "expired"
+	}
+	return left.String()
+}
+
+// dumpFlags renders the flags of an entry
+func dumpFlags(flags uint64) string {
+	var names []string
+	if flags&flagSliding != 0 {
+		names = append(names, "sliding")
+	}
+	if flags&flagReadOnly != 0 {
+		names = append(names, "read-only")
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, ",")
+}
+
+// dumpValue renders a value by the codec, or in hex, cut at MaxValueBytes;
+// strings, and values that render to more than printable ASCII, are quoted,
+// in ASCII
+func dumpValue(data []byte, opts DumpOptions) string {
+	if opts.Codec != nil {
+		if v, err := opts.Codec.Decode(data); err == nil {
+			if s, ok := v.(string); ok {
+				return strconv.QuoteToASCII(s)
+			}
+			s := fmt.Sprintf("%v", v)
+			if strings.IndexFunc(s, notPrintableASCII) >= 0 {
+				return strconv.QuoteToASCII(s)
+			}
+			return s
+		}
+	}
+	shown := data
+	if len(shown) > opts.MaxValueBytes {
+		shown = shown[:opts.MaxValueBytes]
+	}
+	s := "hex:" + hex.EncodeToString(shown)
+	if len(shown) < len(data) {
+		s += "..."
+	}
+	return fmt.Sprintf("%s (%d bytes)", s, len(data))
+}
+
+func notPrintableASCII(r rune) bool {
+	return r > unicode.MaxASCII || !unicode.IsPrint(r)
+}
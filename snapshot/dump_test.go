@@ -0,0 +1,108 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dc0d/tinykv/pkg/frame"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// stringCodec is a codec of string values
+type stringCodec struct{}
+
+func (stringCodec) Encode(v interface{}) ([]byte, error)    { return []byte(v.(string)), nil }
+func (stringCodec) Decode(data []byte) (interface{}, error) { return string(data), nil }
+
+// dumpSnapshot is a snapshot of fixed times, so its dump is too
+func dumpSnapshot(t *testing.T) []byte {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []rawEntry{
+		{key: "config/mode", value: []byte("7")},
+		{key: "session/a", value: []byte("1"), deadline: created.Add(time.Second * 30).UnixNano()},
+		{key: "session/b", value: []byte("2"), deadline: created.Add(time.Minute * 5).UnixNano(),
+			expiresAfter: time.Minute * 5, flags: flagSliding},
+		{key: "session/c", value: []byte("3"), deadline: created.Add(time.Second * 5).UnixNano()},
+		{key: "blob", value: bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef}, 20), flags: flagReadOnly},
+		{key: "tab\tkey", value: []byte("x\ty")},
+	}
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	bw.WriteString(magic)
+	var header []byte
+	header = frame.AppendUvarint(header, Version)
+	header = frame.AppendBytes(header, []byte("dump"))
+	header = frame.AppendUvarint(header, uint64(len(entries)))
+	header = frame.AppendVarint(header, created.UnixNano())
+	header = frame.AppendVarint(header, created.UnixNano())
+	assert.NoError(t, writeFrame(bw, header))
+	for _, e := range entries {
+		var body []byte
+		body = frame.AppendBytes(body, []byte(e.key))
+		body = frame.AppendBytes(body, e.value)
+		body = frame.AppendVarint(body, e.deadline)
+		body = frame.AppendVarint(body, int64(e.expiresAfter))
+		body = frame.AppendUvarint(body, e.flags)
+		assert.NoError(t, writeFrame(bw, body))
+	}
+	assert.NoError(t, bw.Flush())
+	return buf.Bytes()
+}
+
+func TestDumpText(t *testing.T) {
+	data := dumpSnapshot(t)
+	now := time.Date(2020, 1, 1, 0, 0, 10, 0, time.UTC)
+
+	cases := []struct {
+		golden string
+		data   []byte
+		opts   DumpOptions
+		err    error
+	}{
+		{"all", data, DumpOptions{Codec: intCodec{}, Now: now}, nil},
+		{"strings", data, DumpOptions{Codec: stringCodec{}, Now: now}, nil},
+		{"no-codec", data, DumpOptions{Now: now, MaxValueBytes: 8}, nil},
+		{"prefix", data, DumpOptions{Codec: intCodec{}, Now: now, Prefix: "session/"}, nil},
+		{"expiring", data, DumpOptions{Codec: intCodec{}, Now: now, ExpiringWithin: time.Minute}, nil},
+		{"truncated", data[:len(data)-20], DumpOptions{Codec: intCodec{}, Now: now}, ErrTruncated},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.golden, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var out bytes.Buffer
+			err := DumpText(bytes.NewReader(c.data), &out, c.opts)
+			assert.Equal(c.err, errors.Cause(err))
+
+			path := filepath.Join("testdata", "dump-"+c.golden+".golden")
+			if *update {
+				assert.NoError(ioutil.WriteFile(path, out.Bytes(), 0644))
+			}
+			expected, err := ioutil.ReadFile(path)
+			assert.NoError(err)
+			assert.Equal(string(expected), out.String())
+		})
+	}
+}
+
+func TestDumpTextInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	assert.Equal(ErrInvalidFormat, DumpText(bytes.NewReader([]byte("NOPE")), &out, DumpOptions{}))
+	assert.Empty(out.String())
+
+	// saved by a store
+	c := &clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	assert.NoError(DumpText(bytes.NewReader(save(t, c)), &out, DumpOptions{Codec: intCodec{}}))
+	assert.Contains(out.String(), "# 3 of 3 entries listed")
+}
@@ -0,0 +1,283 @@
+// Package snapshot saves and loads the entries of a tinykv store, in a
+// versioned binary format. Values are converted to bytes by a
+// tinykv.ValueCodec.
+//
+// A snapshot starts with the magic bytes "TKVS", followed by a header frame
+// and one frame per entry. A frame is the length of its body (uvarint), the
+// body, and the CRC-32 (IEEE, big endian) of the body. The header body holds
+// the format version, the store name, the entry count, the creation time and
+// the time on the clock of the store.
+// An entry body holds the key, the value bytes, the absolute deadline, the
+// duration it slides by and the flags. Fields are only ever appended to a
+// body, so bytes after the known fields are ignored.
+package snapshot
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/dc0d/tinykv"
+	"github.com/dc0d/tinykv/pkg/frame"
+	"github.com/pkg/errors"
+)
+
+// Version is the format version written by Save
+const Version = 1
+
+const (
+	magic    = "TKVS"
+	maxFrame = 1 << 28
+
+	flagSliding  = 1 << 0
+	flagReadOnly = 1 << 1
+)
+
+// errors
+var (
+	ErrInvalidFormat      error = sentinelErr("INVALID SNAPSHOT FORMAT")
+	ErrUnsupportedVersion error = sentinelErr("UNSUPPORTED SNAPSHOT VERSION")
+	ErrCorrupted          error = sentinelErr("CORRUPTED SNAPSHOT")
+	ErrTruncated          error = sentinelErr("TRUNCATED SNAPSHOT")
+)
+
+// Header is the header of a snapshot
+type Header struct {
+	Version   int
+	Name      string
+	Count     int
+	CreatedAt time.Time
+	StoreTime time.Time // on the clock of the store, zero if no entry expires
+}
+
+// RestoreMode is how Load re-arms the timeouts of the entries
+type RestoreMode int
+
+// restore modes
+const (
+	// AbsoluteDeadlines keeps the deadlines of the snapshot, so the time
+	// between Save and Load counts against the entries
+	AbsoluteDeadlines RestoreMode = iota
+	// RemainingBudget gives each entry the time it had left at Save, from
+	// the time of Load, so the time between them does not count
+	RemainingBudget
+)
+
+type item struct {
+	key   string
+	value interface{}
+	meta  tinykv.Meta
+}
+
+// Save writes all the entries of kv to w, in key order, so the entries of
+// two snapshots of an unchanged store are byte for byte the same (the header
+// differs by CreatedAt)
+func Save(w io.Writer, kv *tinykv.Store, name string, codec tinykv.ValueCodec) error {
+	var items []item
+	kv.RangeMeta(func(k string, v interface{}, meta tinykv.Meta) bool {
+		items = append(items, item{k, v, meta})
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+	var storeTime int64
+	for _, it := range items {
+		if !it.meta.ExpiresAt.IsZero() {
+			storeTime = it.meta.ExpiresAt.Add(-it.meta.Remaining).UnixNano()
+			break
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(magic); err != nil {
+		return err
+	}
+	var body []byte
+	body = frame.AppendUvarint(body, Version)
+	body = frame.AppendBytes(body, []byte(name))
+	body = frame.AppendUvarint(body, uint64(len(items)))
+	body = frame.AppendVarint(body, time.Now().UnixNano())
+	body = frame.AppendVarint(body, storeTime)
+	if err := writeFrame(bw, body); err != nil {
+		return err
+	}
+	for _, it := range items {
+		value, err := codec.Encode(it.value)
+		if err != nil {
+			return errors.Wrapf(err, "encoding value of %q", it.key)
+		}
+		var deadline int64
+		if !it.meta.ExpiresAt.IsZero() {
+			deadline = it.meta.ExpiresAt.UnixNano()
+		}
+		var flags uint64
+		if it.meta.IsSliding {
+			flags |= flagSliding
+		}
+		if it.meta.ReadOnly {
+			flags |= flagReadOnly
+		}
+		body = body[:0]
+		body = frame.AppendBytes(body, []byte(it.key))
+		body = frame.AppendBytes(body, value)
+		body = frame.AppendVarint(body, deadline)
+		body = frame.AppendVarint(body, int64(it.meta.ExpiresAfter))
+		body = frame.AppendUvarint(body, flags)
+		if err := writeFrame(bw, body); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Load puts the entries of the snapshot read from r into kv, with their
+// original deadlines, and returns the header and the number of entries
+// recovered. If the snapshot is truncated or corrupted, the entries before
+// that point are still loaded, and an error wrapping ErrTruncated
+// or ErrCorrupted is returned.
+func Load(r io.Reader, kv *tinykv.Store, codec tinykv.ValueCodec) (Header, int, error) {
+	return LoadMode(r, kv, codec, AbsoluteDeadlines)
+}
+
+// LoadMode is like Load, re-arming the timeouts by mode. With
+// RemainingBudget, the time left is measured from Header.StoreTime (or
+// CreatedAt, for snapshots that do not have it).
+func LoadMode(r io.Reader, kv *tinykv.Store, codec tinykv.ValueCodec, mode RestoreMode) (Header, int, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return h, 0, err
+	}
+	savedAt := h.StoreTime
+	if savedAt.IsZero() {
+		savedAt = h.CreatedAt
+	}
+	var rearm rearming
+
+	n := 0
+	for ; n < h.Count; n++ {
+		e, err := readEntry(br)
+		if err != nil {
+			return h, n, errors.Wrapf(err, "recovered %d of %d entries", n, h.Count)
+		}
+		key := e.key
+		v, err := codec.Decode(e.value)
+		if err != nil {
+			return h, n, errors.Wrapf(err, "decoding value of %q, recovered %d of %d entries", key, n, h.Count)
+		}
+		var options []tinykv.PutOption
+		if e.deadline != 0 {
+			expiresAt := time.Unix(0, e.deadline)
+			if mode == RemainingBudget {
+				expiresAt = rearm.at(kv, expiresAt.Sub(savedAt))
+			}
+			options = append(options,
+				tinykv.ExpiresAt(expiresAt),
+				tinykv.ExpiresAfter(e.expiresAfter),
+				tinykv.IsSliding(e.flags&flagSliding != 0))
+		}
+		if e.flags&flagReadOnly != 0 {
+			options = append(options, tinykv.ReadOnly())
+		}
+		if err := kv.ForcePut(key, v, options...); err != nil {
+			return h, n, errors.Wrapf(err, "putting %q, recovered %d of %d entries", key, n, h.Count)
+		}
+	}
+	return h, n, nil
+}
+
+// readHeader reads the magic bytes and the header frame
+func readHeader(br *bufio.Reader) (Header, error) {
+	var h Header
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, m); err != nil || string(m) != magic {
+		return h, ErrInvalidFormat
+	}
+	body, err := readFrame(br)
+	if err != nil {
+		return h, errors.Wrap(err, "reading header")
+	}
+	d := frame.NewDecoder(body, ErrCorrupted)
+	h.Version = int(d.Uvarint())
+	if d.Err == nil && h.Version > Version {
+		return h, errors.Wrapf(ErrUnsupportedVersion, "version %d, supported up to %d", h.Version, Version)
+	}
+	h.Name = string(d.Bytes())
+	h.Count = int(d.Uvarint())
+	h.CreatedAt = time.Unix(0, d.Varint())
+	if d.More() {
+		if t := d.Varint(); t != 0 {
+			h.StoreTime = time.Unix(0, t)
+		}
+	}
+	if d.Err != nil {
+		return h, errors.Wrap(d.Err, "reading header")
+	}
+	return h, nil
+}
+
+// rawEntry is an entry as the snapshot holds it, its value not decoded
+type rawEntry struct {
+	key          string
+	value        []byte
+	deadline     int64 // unix nanoseconds, 0 if the entry does not expire
+	expiresAfter time.Duration
+	flags        uint64
+}
+
+// readEntry reads the frame of an entry
+func readEntry(br *bufio.Reader) (rawEntry, error) {
+	var e rawEntry
+	body, err := readFrame(br)
+	if err != nil {
+		return e, err
+	}
+	d := frame.NewDecoder(body, ErrCorrupted)
+	e.key = string(d.Bytes())
+	e.value = d.Bytes()
+	e.deadline = d.Varint()
+	e.expiresAfter = time.Duration(d.Varint())
+	e.flags = d.Uvarint()
+	return e, d.Err
+}
+
+// rearming finds the new deadlines, under RemainingBudget
+type rearming struct {
+	now time.Time // on the clock of the store
+}
+
+// at returns the deadline of an entry with remaining time left. The clock of
+// the store is read once, for the first entry, so all the entries of the
+// snapshot are re-armed from the same time.
+func (r *rearming) at(kv *tinykv.Store, remaining time.Duration) time.Time {
+	if remaining <= 0 {
+		remaining = time.Nanosecond
+	}
+	if r.now.IsZero() {
+		r.now = kv.Now()
+	}
+	return r.now.Add(remaining)
+}
+
+//-----------------------------------------------------------------------------
+
+func writeFrame(w *bufio.Writer, body []byte) error {
+	_, err := w.Write(frame.Append(nil, body))
+	return err
+}
+
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	body, err := frame.Read(r, maxFrame, ErrCorrupted)
+	return body, truncated(err)
+}
+
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+type sentinelErr string
+
+func (v sentinelErr) Error() string { return string(v) }
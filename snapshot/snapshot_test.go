@@ -0,0 +1,250 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dc0d/tinykv"
+	"github.com/dc0d/tinykv/pkg/frame"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type intCodec struct{}
+
+func (intCodec) Encode(v interface{}) ([]byte, error) {
+	return []byte(strconv.Itoa(v.(int))), nil
+}
+
+func (intCodec) Decode(data []byte) (interface{}, error) {
+	return strconv.Atoi(string(data))
+}
+
+type clock struct {
+	mx  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newStore(c *clock) *tinykv.Store {
+	return tinykv.NewStore(time.Hour, tinykv.Clock(c.Now))
+}
+
+func save(t *testing.T, c *clock) []byte {
+	kv := newStore(c)
+	defer kv.Stop()
+	kv.Put("permanent", 1)
+	kv.Put("ttl", 2, tinykv.ExpiresAfter(time.Second*10))
+	kv.Put("sliding", 3, tinykv.ExpiresAfter(time.Second*10), tinykv.IsSliding(true))
+	var buf bytes.Buffer
+	assert.NoError(t, Save(&buf, kv, "test", intCodec{}))
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	data := save(t, c)
+
+	kv := newStore(c)
+	defer kv.Stop()
+	h, n, err := Load(bytes.NewReader(data), kv, intCodec{})
+	assert.NoError(err)
+	assert.Equal(3, n)
+	assert.Equal(Version, h.Version)
+	assert.Equal("test", h.Name)
+	assert.Equal(3, h.Count)
+	assert.False(h.CreatedAt.IsZero())
+
+	meta, ok := kv.GetMeta("permanent")
+	assert.True(ok)
+	assert.True(meta.ExpiresAt.IsZero())
+	meta, _ = kv.GetMeta("ttl")
+	assert.True(c.Now().Add(time.Second * 10).Equal(meta.ExpiresAt))
+	assert.False(meta.IsSliding)
+	meta, _ = kv.GetMeta("sliding")
+	assert.True(meta.IsSliding)
+	assert.Equal(time.Second*10, meta.ExpiresAfter)
+
+	// deadlines are absolute
+	c.Advance(time.Second * 8)
+	v, ok := kv.Get("sliding")
+	assert.True(ok)
+	assert.Equal(3, v)
+	c.Advance(time.Second * 3)
+	_, ok = kv.Get("ttl")
+	assert.False(ok)
+	_, ok = kv.Get("sliding")
+	assert.True(ok)
+	v, ok = kv.Get("permanent")
+	assert.True(ok)
+	assert.Equal(1, v)
+}
+
+func TestTruncated(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	data := save(t, c)
+
+	recovered := 0
+	for cut := 1; cut < len(data); cut++ {
+		kv := newStore(c)
+		_, n, err := Load(bytes.NewReader(data[:cut]), kv, intCodec{})
+		assert.Equal(n, len(kv.Keys()))
+		kv.Stop()
+		assert.Error(err)
+		assert.True(n >= recovered)
+		if n > 0 {
+			assert.Equal(ErrTruncated, errors.Cause(err))
+		}
+		recovered = n
+	}
+	assert.Equal(2, recovered)
+}
+
+func TestCorrupted(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	data := save(t, c)
+	data[len(data)-6] ^= 0xff
+
+	kv := newStore(c)
+	defer kv.Stop()
+	_, n, err := Load(bytes.NewReader(data), kv, intCodec{})
+	assert.Equal(ErrCorrupted, errors.Cause(err))
+	assert.Equal(2, n)
+
+	_, _, err = Load(bytes.NewReader([]byte("NOPE")), kv, intCodec{})
+	assert.Equal(ErrInvalidFormat, err)
+}
+
+func TestFutureVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	var body []byte
+	body = frame.AppendUvarint(body, Version+1)
+	body = frame.AppendBytes(body, []byte("future"))
+	data := frame.Append([]byte(magic), body)
+
+	kv := tinykv.New(time.Hour)
+	defer kv.Stop()
+	h, n, err := Load(bytes.NewReader(data), kv, intCodec{})
+	assert.Equal(ErrUnsupportedVersion, errors.Cause(err))
+	assert.Equal(Version+1, h.Version)
+	assert.Equal(0, n)
+}
+
+func TestSaveIsStable(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	kv := newStore(c)
+	defer kv.Stop()
+	for i := 0; i < 100; i++ {
+		kv.Put(strconv.Itoa(i), i, tinykv.ExpiresAfter(time.Minute))
+	}
+
+	// the entries, after the magic bytes and the header frame
+	entries := func() []byte {
+		var buf bytes.Buffer
+		assert.NoError(Save(&buf, kv, "test", intCodec{}))
+		r := bufio.NewReader(bytes.NewReader(buf.Bytes()[len(magic):]))
+		_, err := readFrame(r)
+		assert.NoError(err)
+		var rest bytes.Buffer
+		_, err = rest.ReadFrom(r)
+		assert.NoError(err)
+		return rest.Bytes()
+	}
+	first := entries()
+	c.Advance(time.Second)
+	assert.Equal(first, entries())
+}
+
+func TestRestoreModes(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		mode RestoreMode
+		left time.Duration // of the 6s left at Save, after 3s of downtime
+	}{
+		{AbsoluteDeadlines, time.Second * 3},
+		{RemainingBudget, time.Second * 6},
+	} {
+		assert := assert.New(t)
+
+		c := &clock{now: start}
+		kv := newStore(c)
+		kv.Put("permanent", 1)
+		kv.Put("ttl", 2, tinykv.ExpiresAfter(time.Second*10))
+		kv.Put("sliding", 3, tinykv.ExpiresAfter(time.Second*10), tinykv.IsSliding(true))
+		c.Advance(time.Second * 4)
+		var buf bytes.Buffer
+		assert.NoError(Save(&buf, kv, "test", intCodec{}))
+		kv.Stop()
+
+		// the downtime
+		c.Advance(time.Second * 3)
+
+		kv = newStore(c)
+		h, n, err := LoadMode(bytes.NewReader(buf.Bytes()), kv, intCodec{}, tc.mode)
+		assert.NoError(err)
+		assert.Equal(3, n)
+		assert.True(start.Add(time.Second*4).Equal(h.StoreTime), "%v", h.StoreTime)
+
+		meta, _ := kv.GetMeta("permanent")
+		assert.True(meta.ExpiresAt.IsZero())
+		meta, _ = kv.GetMeta("ttl")
+		assert.Equal(tc.left, meta.Remaining, "mode %d", tc.mode)
+		assert.False(meta.IsSliding)
+		// each entry is written once, by Load
+		assert.Equal(uint64(1), meta.Revision, "mode %d", tc.mode)
+		meta, _ = kv.GetMeta("sliding")
+		assert.Equal(tc.left, meta.Remaining, "mode %d", tc.mode)
+		assert.True(meta.IsSliding)
+		assert.Equal(time.Second*10, meta.ExpiresAfter)
+
+		c.Advance(tc.left)
+		_, ok := kv.Get("ttl")
+		assert.True(ok)
+		c.Advance(time.Nanosecond)
+		_, ok = kv.Get("ttl")
+		assert.False(ok)
+		kv.Stop()
+	}
+}
+
+func TestStoreTime(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	kv := newStore(c)
+	defer kv.Stop()
+	kv.Put("permanent", 1)
+	var buf bytes.Buffer
+	assert.NoError(Save(&buf, kv, "test", intCodec{}))
+
+	// without entries that expire, the store time is unknown, and the
+	// remaining budgets would be measured from CreatedAt
+	h, n, err := LoadMode(bytes.NewReader(buf.Bytes()), kv, intCodec{}, RemainingBudget)
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.True(h.StoreTime.IsZero())
+}
@@ -0,0 +1,63 @@
+package tinykv
+
+import (
+	"time"
+)
+
+// SoftExpiresAfter sets a soft deadline for the entry, d after it is put,
+// before its (hard) timeout: when the soft deadline passes, the entry stays
+// available as usual, GetMeta reports it as SoftExpired, and the OnSoftExpire
+// function is called once, by the janitor (or ExpireNow). It does not slide,
+// and it is not kept by snapshots nor the WAL. It can be used to refresh a
+// value before it is gone.
+func SoftExpiresAfter(d time.Duration) PutOption {
+	return func(opt *putOpt) {
+		opt.softAfter = d
+	}
+}
+
+// OnSoftExpire sets the function that is called when the soft deadline of an
+// entry (see SoftExpiresAfter) passes, with the value it has then. It is
+// called like expiration notifications (see SynchronousNotifications), so it
+// must be fast.
+func OnSoftExpire(onSoftExpire func(k string, v interface{})) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onSoftExpire = onSoftExpire
+	}
+}
+
+// softDeadline is the heap node of a SoftExpiresAfter
+type softDeadline struct {
+	value interface{} // captured under the lock, when due
+}
+
+// newSoftDeadline pushes the soft deadline of e to the heap
+func (kv *Store) newSoftDeadline(k string, e *entry, now time.Time, d time.Duration) {
+	e.softNode = &timeout{
+		expiresAt:  now.Add(d),
+		key:        k,
+		index:      -1,
+		slidesLeft: -1,
+		soft:       &softDeadline{},
+	}
+	timeheapPush(&kv.heap, e.softNode)
+}
+
+// dropSoft marks the soft deadline of e (if any) as stale
+func (e *entry) dropSoft() {
+	if e.softNode != nil {
+		e.softNode.stale = true
+	}
+}
+
+// softExpired takes out the soft deadline to, which passed; it reports if
+// to is still the soft deadline of its entry, to be notified
+func (kv *Store) softExpired(to *timeout) bool {
+	to.stale = true
+	e, ok := kv.kv.get(to.key)
+	if !ok || e.softNode != to {
+		return false
+	}
+	to.soft.value = e.value
+	return kv.onSoftExpire != nil
+}
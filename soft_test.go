@@ -0,0 +1,115 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftExpiresAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var soft, expired []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		Debug(),
+		OnSoftExpire(func(k string, v interface{}) { soft = append(soft, k+"="+v.(string)) }),
+		OnExpire(func(k string, v interface{}) { expired = append(expired, k) }))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k", "v", SoftExpiresAfter(time.Minute), ExpiresAfter(time.Minute*3)))
+	assert.NoError(kv.CheckInvariants())
+	meta, ok := kv.GetMeta("k")
+	assert.True(ok)
+	assert.False(meta.SoftExpired)
+
+	clock.Advance(time.Minute)
+	kv.ExpireNow()
+	assert.Empty(soft)
+
+	// soft expired: notified once, still available
+	clock.Advance(time.Millisecond)
+	kv.ExpireNow()
+	assert.Equal([]string{"k=v"}, soft)
+	meta, ok = kv.GetMeta("k")
+	assert.True(ok)
+	assert.True(meta.SoftExpired)
+	v, ok := kv.Get("k")
+	assert.True(ok)
+	assert.Equal("v", v)
+	assert.Equal(1, kv.Len())
+	assert.NoError(kv.CheckInvariants())
+
+	clock.Advance(time.Minute)
+	kv.ExpireNow()
+	assert.Len(soft, 1)
+	assert.Empty(expired)
+	_, ok = kv.Get("k")
+	assert.True(ok)
+
+	// removed at the hard deadline, as usual
+	clock.Advance(time.Minute)
+	kv.ExpireNow()
+	assert.Equal([]string{"k"}, expired)
+	_, ok = kv.Get("k")
+	assert.False(ok)
+	assert.Len(soft, 1)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestSoftExpiresAfterReplaced(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var soft []string
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		Debug(),
+		OnSoftExpire(func(k string, v interface{}) { soft = append(soft, k+"="+v.(string)) }))
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("replaced", "1", SoftExpiresAfter(time.Minute)))
+	assert.NoError(kv.Put("deleted", "1", SoftExpiresAfter(time.Minute)))
+	assert.NoError(kv.Put("kept", "1", SoftExpiresAfter(time.Minute)))
+	clock.Advance(time.Second * 30)
+	assert.NoError(kv.Put("replaced", "2", SoftExpiresAfter(time.Minute)))
+	kv.Delete("deleted")
+	assert.NoError(kv.CheckInvariants())
+
+	// the soft deadline of the old value is gone with it
+	clock.Advance(time.Second * 31)
+	kv.ExpireNow()
+	assert.Equal([]string{"kept=1"}, soft)
+
+	assert.NoError(kv.Put("cleared", "1", SoftExpiresAfter(time.Minute)))
+	kv.Clear()
+	assert.NoError(kv.Put("replaced", "2", SoftExpiresAfter(time.Second*30)))
+	assert.NoError(kv.Put("cas", "1", SoftExpiresAfter(time.Minute)))
+	assert.NoError(kv.CAS("cas", "2", func(interface{}, bool) bool { return true }, KeepTTL()))
+	assert.NoError(kv.CheckInvariants())
+	clock.Advance(time.Second * 31)
+	kv.ExpireNow()
+	assert.Equal([]string{"kept=1", "replaced=2"}, soft)
+	clock.Advance(time.Second * 30)
+	kv.ExpireNow()
+	assert.Equal([]string{"kept=1", "replaced=2", "cas=2"}, soft)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestSoftExpiresAfterInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+
+	err := kv.Put("k", 1, SoftExpiresAfter(-time.Second))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	err = kv.Put("k", 1, SoftExpiresAfter(time.Minute), ExpiresAfter(time.Minute))
+	assert.Equal(ErrInvalidOptions, errors.Cause(err))
+	assert.NoError(kv.Put("k", 1, SoftExpiresAfter(time.Minute)))
+}
@@ -0,0 +1,61 @@
+package tinykv
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats are the counters of a store
+type Stats struct {
+	Entries                 int
+	Evictions               int64         // entries evicted, for any reason
+	MemoryPressureSheds     int64         // times entries were shed because of memory pressure
+	BulkRemovals            int64         // bulk removal calls (Clear, DeleteByPrefix, ...)
+	BulkRemoved             int64         // entries removed by bulk removals
+	ExpirationLagMax        time.Duration // max time between the deadline and the removal of an expired entry
+	ExpirationLagSum        time.Duration
+	ExpirationLagCount      int64
+	MapPeak                 int // entries the map grew to, which it still holds memory for
+	HeapLen                 int // timeout heap nodes, including stale ones
+	HeapCap                 int
+	Compactions             int64
+	ReadMapPromotions       int64 // times the read map of ReadOptimized was rebuilt
+	RejectedPuts            int64 // puts that failed with ErrFull, ErrQuotaExceeded or ErrIndexConflict
+	RejectedMigrations      int64 // values of Migrate or MigrateOnRead that were not strings, under StringValues
+	RemainingEntries        int   // under MaxEntries, -1 without it
+	RemainingCost           int64 // under MaxCost, -1 without it
+	Spilled                 int64 // evicted entries written to the Overflow store
+	Unspilled               int64 // entries put back in memory from the Overflow store
+	ExpiredDropped          int64 // expired entries an ExpiredStream had no room for
+	Sweeps                  int64 // completed sweeps of the expiration loop
+	BackingLoads            int64 // entries loaded from the Backing on a miss
+	ArchiveDropped          int64 // removed entries the buffer of ArchiveOnExpire had no room for
+	OverdueReleases         int64 // held entries finished by MaxRetainAge, without their Release
+	Scrubbed                int64 // entries removed by ScrubPolicy
+	ProtectedSkips          int64 // protected entries left in place by Clear, DeleteByPrefix and DeleteWhere
+	SuspendBacklog          int   // expired entries waiting for the resume of SuspendNotifications
+	SuspendDropped          int64 // expired entries the backlog of SuspendNotifications had no room for
+	Misuses                 int64 // misuses of the store, see Strict
+	OverwrittenWithinWindow int64 // values replaced within the OverwriteWindow of their write
+}
+
+// Stats returns the current counters of the store
+func (kv *Store) Stats() Stats {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	stats := kv.stats
+	stats.Entries = kv.kv.len()
+	stats.MapPeak = kv.mapPeak
+	stats.HeapLen = len(kv.heap)
+	stats.HeapCap = cap(kv.heap)
+	stats.SuspendBacklog = kv.suspendedLen
+	stats.Misuses = atomic.LoadInt64(&kv.misuses)
+	stats.RemainingEntries, stats.RemainingCost = -1, -1
+	if kv.maxEntries > 0 {
+		stats.RemainingEntries = kv.maxEntries - kv.kv.len()
+	}
+	if kv.maxCost > 0 {
+		stats.RemainingCost = kv.maxCost - kv.totalCost
+	}
+	return stats
+}
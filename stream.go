@@ -0,0 +1,169 @@
+package tinykv
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Expired is an entry removed by expiration, as delivered by ExpiredStream;
+// Origin is the name of the store (see Name)
+type Expired struct {
+	Key       string
+	Value     interface{}
+	Deadline  time.Time
+	RemovedAt time.Time
+	Origin    string
+}
+
+// DropSlowExpiredStream makes the store drop the expired entries an
+// ExpiredStream has no room for (counted in Stats.ExpiredDropped), instead
+// of waiting for its consumer.
+func DropSlowExpiredStream() StoreOption {
+	return func(opt *storeOpt) {
+		opt.dropSlowExpiredStream = true
+	}
+}
+
+// StreamAndCallbacks keeps the expiration notifications (OnExpire,
+// OnExpireBatch and OnExpireDetailed) going while an ExpiredStream is
+// attached; by default, the stream replaces them.
+func StreamAndCallbacks() StoreOption {
+	return func(opt *storeOpt) {
+		opt.streamAndCallbacks = true
+	}
+}
+
+// ExpiredStream returns a channel, with room for buffer entries, that gets
+// the expired entries in the order of their deadlines, so the store can be
+// used as a delay queue. Entries are sent by the goroutine that expired them
+// (the sweep, or an operation that found an expired entry), which waits when
+// the channel is full, holding up expiration, unless DropSlowExpiredStream is
+// set. Stop closes the channel; the entries in its buffer can still be
+// received.
+func (kv *Store) ExpiredStream(buffer int) (<-chan Expired, CancelFunc) {
+	kv.active()
+	if buffer < 0 {
+		buffer = 0
+	}
+	s := &expiredStream{ch: make(chan Expired, buffer), done: make(chan struct{})}
+	kv.mx.Lock()
+	select {
+	case <-kv.stop:
+		kv.mx.Unlock()
+		s.cancel()
+		return s.ch, func() {}
+	default:
+	}
+	kv.streams = append(kv.streams[:len(kv.streams):len(kv.streams)], s)
+	kv.mx.Unlock()
+	cancel := func() {
+		kv.mx.Lock()
+		for i, other := range kv.streams {
+			if other == s {
+				kv.streams = append(kv.streams[:i:i], kv.streams[i+1:]...)
+				break
+			}
+		}
+		kv.mx.Unlock()
+		s.cancel()
+	}
+	return s.ch, cancel
+}
+
+// streamExpired sends the expired entries to the streams, and reports if
+// the streams replace the other notifications
+func (kv *Store) streamExpired(expired map[string]*entry, removedAt time.Time) bool {
+	kv.mx.Lock()
+	streams := kv.streams
+	kv.mx.Unlock()
+	if len(streams) == 0 {
+		return false
+	}
+	keys := make([]string, 0, len(expired))
+	for k := range expired {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := expired[keys[i]], expired[keys[j]]
+		if !a.expiresAt.Equal(b.expiresAt) {
+			return a.expiresAt.Before(b.expiresAt)
+		}
+		return keys[i] < keys[j]
+	})
+
+	// one batch at a time, so batches do not interleave
+	kv.streamMx.Lock()
+	var dropped int64
+	for _, s := range streams {
+		for _, k := range keys {
+			e := expired[k]
+			ev := Expired{Key: k, Value: e.value, Deadline: e.expiresAt, RemovedAt: removedAt, Origin: kv.name}
+			if !s.send(ev, kv.stop, kv.dropSlowExpiredStream) {
+				dropped++
+			}
+		}
+	}
+	kv.streamMx.Unlock()
+	if dropped > 0 {
+		kv.mx.Lock()
+		kv.stats.ExpiredDropped += dropped
+		kv.mx.Unlock()
+	}
+	return !kv.streamAndCallbacks
+}
+
+// closeStreams closes all the streams, on Stop
+func (kv *Store) closeStreams() {
+	kv.mx.Lock()
+	streams := kv.streams
+	kv.streams = nil
+	kv.mx.Unlock()
+	for _, s := range streams {
+		s.cancel()
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+type expiredStream struct {
+	ch     chan Expired
+	done   chan struct{}
+	mx     sync.Mutex
+	closed bool
+	once   sync.Once
+}
+
+// send sends ev, and reports false if it was dropped, because the channel
+// is full and drop is set
+func (s *expiredStream) send(ev Expired, stop <-chan struct{}, drop bool) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.closed {
+		return true
+	}
+	if drop {
+		select {
+		case s.ch <- ev:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case s.ch <- ev:
+	case <-s.done:
+	case <-stop:
+	}
+	return true
+}
+
+func (s *expiredStream) cancel() {
+	s.once.Do(func() {
+		close(s.done)
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		s.closed = true
+		close(s.ch)
+	})
+}
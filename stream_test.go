@@ -0,0 +1,181 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveExpired(t *testing.T, ch <-chan Expired, n int) []string {
+	var keys []string
+	for len(keys) < n {
+		select {
+		case ex := <-ch:
+			keys = append(keys, ex.Key)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d expired entries of %d: %v", len(keys), n, keys)
+		}
+	}
+	return keys
+}
+
+func TestExpiredStreamOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	ch, cancel := kv.ExpiredStream(16)
+	defer cancel()
+
+	start := clock.Now()
+	kv.Put("c", 3, ExpiresAfter(time.Second*3))
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	kv.Put("e", 5, ExpiresAfter(time.Second*5))
+	kv.Put("b", 2, ExpiresAfter(time.Second*2))
+	kv.Put("d", 4, ExpiresAfter(time.Second*4))
+	kv.Put("f", 6) // does not expire
+
+	clock.Advance(time.Second*3 + time.Millisecond)
+	kv.ExpireNow()
+	clock.Advance(time.Second * 3)
+	kv.ExpireNow()
+
+	var got []Expired
+	for len(got) < 5 {
+		got = append(got, <-ch)
+	}
+	for i, ex := range got {
+		assert.Equal(string(rune('a'+i)), ex.Key)
+		assert.Equal(i+1, ex.Value)
+		assert.Equal(start.Add(time.Second*time.Duration(i+1)), ex.Deadline)
+	}
+	assert.Equal(start.Add(time.Second*3+time.Millisecond), got[2].RemovedAt)
+	assert.Equal(start.Add(time.Second*6+time.Millisecond), got[3].RemovedAt)
+	assert.Len(ch, 0)
+}
+
+func TestExpiredStreamReplacesCallbacks(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, supplement := range []bool{false, true} {
+		clock := newFakeClock()
+		var notified []string
+		options := []StoreOption{
+			Clock(clock.Now),
+			SynchronousNotifications(),
+			OnExpire(func(k string, v interface{}) { notified = append(notified, k) }),
+		}
+		if supplement {
+			options = append(options, StreamAndCallbacks())
+		}
+		kv := NewStore(time.Hour, options...)
+		ch, cancel := kv.ExpiredStream(1)
+
+		kv.Put("a", 1, ExpiresAfter(time.Second))
+		clock.Advance(time.Second * 2)
+		kv.ExpireNow()
+		assert.Equal([]string{"a"}, receiveExpired(t, ch, 1))
+		if supplement {
+			assert.Equal([]string{"a"}, notified)
+		} else {
+			assert.Empty(notified)
+		}
+
+		// without a stream, callbacks are back
+		cancel()
+		kv.Put("b", 2, ExpiresAfter(time.Second))
+		clock.Advance(time.Second * 2)
+		kv.ExpireNow()
+		assert.Contains(notified, "b")
+		kv.Stop()
+	}
+}
+
+func TestExpiredStreamBlocks(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	ch, cancel := kv.ExpiredStream(1)
+	defer cancel()
+
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	kv.Put("b", 2, ExpiresAfter(time.Second*2))
+	clock.Advance(time.Second * 3)
+	done := make(chan struct{})
+	go func() {
+		kv.ExpireNow()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expiration did not wait for the consumer")
+	case <-time.After(time.Millisecond * 50):
+	}
+	assert.Equal([]string{"a", "b"}, receiveExpired(t, ch, 2))
+	<-done
+	assert.Equal(int64(0), kv.Stats().ExpiredDropped)
+}
+
+func TestExpiredStreamDrops(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), DropSlowExpiredStream())
+	defer kv.Stop()
+
+	ch, cancel := kv.ExpiredStream(1)
+	defer cancel()
+
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	kv.Put("b", 2, ExpiresAfter(time.Second*2))
+	kv.Put("c", 3, ExpiresAfter(time.Second*3))
+	clock.Advance(time.Second * 4)
+	kv.ExpireNow()
+	assert.Equal([]string{"a"}, receiveExpired(t, ch, 1))
+	assert.Equal(int64(2), kv.Stats().ExpiredDropped)
+	assert.True(kv.Config().DropSlowExpiredStream)
+}
+
+func TestExpiredStreamStop(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+
+	ch, cancel := kv.ExpiredStream(2)
+	blocked, _ := kv.ExpiredStream(0) // nobody reads it
+
+	kv.Put("a", 1, ExpiresAfter(time.Second))
+	kv.Put("b", 2, ExpiresAfter(time.Second*2))
+	clock.Advance(time.Second * 3)
+	done := make(chan struct{})
+	go func() {
+		kv.ExpireNow()
+		close(done)
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	// Stop releases the expiration waiting on the unread stream, and the
+	// buffered entries can still be received
+	kv.Stop()
+	<-done
+	var keys []string
+	for ex := range ch {
+		keys = append(keys, ex.Key)
+	}
+	assert.Equal([]string{"a", "b"}, keys)
+	_, ok := <-blocked
+	assert.False(ok)
+	cancel()
+
+	ch, cancel = kv.ExpiredStream(1)
+	_, ok = <-ch
+	assert.False(ok)
+	cancel()
+}
@@ -0,0 +1,46 @@
+package tinykv
+
+import (
+	"github.com/pkg/errors"
+)
+
+// StringValues makes the store take strings only: Put, CAS, TryPut,
+// ForcePut, PutAfter and MultiCAS of a value of another type fail with
+// ErrTypeConflict, as do the operations that keep values of their own (like
+// Append, AddToSet, AcquireLease, IncrWindow or SeenRecently); a value that
+// Migrate or MigrateOnRead returns that is not a string is ignored (see
+// Stats.RejectedMigrations). The strings are held unboxed, unless WithKeyMap
+// is given, and are read back with GetString.
+func StringValues() StoreOption {
+	return func(opt *storeOpt) {
+		opt.stringValues = true
+	}
+}
+
+// checkString returns ErrTypeConflict if v is not a string, under
+// StringValues
+func (kv *Store) checkString(v interface{}) error {
+	if !kv.stringValues {
+		return nil
+	}
+	if _, ok := v.(string); !ok {
+		return errors.Wrapf(ErrTypeConflict, "a %T, not a string, under StringValues", v)
+	}
+	return nil
+}
+
+// GetString is Get, for a string value: it returns false if there is no
+// entry for k, or if its value is not a string. It does not allocate on a
+// hit, so a hot read of strings costs no garbage; under StringValues, it
+// reads the string unboxed.
+func (kv *Store) GetString(k string) (string, bool) {
+	v, s, unboxed, err := kv.getValue(k, true)
+	if err != nil {
+		return "", false
+	}
+	if unboxed {
+		return s, true
+	}
+	s, ok := v.(string)
+	return s, ok
+}
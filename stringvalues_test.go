@@ -0,0 +1,127 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringValues(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, StringValues())
+	defer kv.Stop()
+	assert.True(kv.Config().StringValues)
+
+	assert.NoError(kv.Put("k", "V"))
+	s, ok := kv.GetString("k")
+	assert.True(ok)
+	assert.Equal("V", s)
+
+	err := kv.Put("k", 1)
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	assert.EqualError(err, `tinykv: put "k": a int, not a string, under StringValues: TYPE CONFLICT`)
+	assert.Equal(ErrTypeConflict, errors.Cause(kv.CAS("k", []byte("V"), func(interface{}, bool) bool { return true })))
+	assert.Equal(ErrTypeConflict, errors.Cause(kv.TryPut("other", 1)))
+	assert.Equal(ErrTypeConflict, errors.Cause(kv.ForcePut("k", 1)))
+	s, _ = kv.GetString("k")
+	assert.Equal("V", s)
+
+	_, ok = kv.GetString("missing")
+	assert.False(ok)
+}
+
+func TestStringValuesWritePaths(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, StringValues())
+	defer kv.Stop()
+	assert.NoError(kv.Put("k", "V"))
+
+	_, err := kv.PutAfter("later", 1, time.Minute)
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	err = kv.MultiCAS([]CASOp{{Key: "a", NewValue: "A"}, {Key: "b", NewValue: 1}})
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	assert.EqualError(err, `tinykv: multi-cas "b": a int, not a string, under StringValues: TYPE CONFLICT`)
+	_, err = kv.Append("list", "V")
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	_, err = kv.AddToSet("set", "V")
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	_, _, err = kv.AcquireLease("lease", "owner", time.Minute)
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	_, _, _, err = kv.IncrWindow("window", time.Minute, 10)
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	_, err = kv.SeenRecently("seen", time.Minute)
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+	assert.Equal([]string{"k"}, kv.Keys())
+
+	// a migration to a value that is not a string is ignored, and counted
+	assert.Equal(1, kv.Migrate(func(k string, old interface{}) (interface{}, bool) { return 1, true }))
+	s, ok := kv.GetString("k")
+	assert.True(ok)
+	assert.Equal("V", s)
+	assert.Equal(int64(1), kv.Stats().RejectedMigrations)
+}
+
+func TestStringValuesUnboxed(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, StringValues())
+	defer kv.Stop()
+	_, ok := kv.kv.(stringKeyMap)
+	assert.True(ok)
+
+	// the unboxed strings follow the changes in place
+	assert.NoError(kv.Put("k", "V1"))
+	assert.NoError(kv.Put("k", "V2"))
+	s, _ := kv.GetString("k")
+	assert.Equal("V2", s)
+	assert.NoError(kv.CAS("k", "V3", func(old interface{}, found bool) bool { return old == "V2" }))
+	s, _ = kv.GetString("k")
+	assert.Equal("V3", s)
+	kv.Migrate(func(k string, old interface{}) (interface{}, bool) { return old.(string) + "!", true })
+	s, _ = kv.GetString("k")
+	assert.Equal("V3!", s)
+	v, _ := kv.Get("k")
+	assert.Equal("V3!", v)
+
+	kv.Clear()
+	_, ok = kv.kv.(stringKeyMap)
+	assert.True(ok)
+	_, ok = kv.GetString("k")
+	assert.False(ok)
+}
+
+func TestGetStringNotString(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k", 1))
+	s, ok := kv.GetString("k")
+	assert.False(ok)
+	assert.Equal("", s)
+}
+
+func TestGetStringAllocs(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, options := range map[string][]StoreOption{
+		"store":          nil,
+		"string values":  {StringValues()},
+		"read-optimized": {StringValues(), ReadOptimized()},
+	} {
+		kv := NewStore(time.Hour, options...)
+		assert.NoError(kv.Put("k", "V"))
+		assert.NoError(kv.Put("sliding", "V", ExpiresAfter(time.Minute), IsSliding(true)))
+
+		for _, k := range []string{"k", "sliding", "missing"} {
+			allocs := testing.AllocsPerRun(100, func() { kv.GetString(k) })
+			assert.Equal(float64(0), allocs, "%s, %s", name, k)
+		}
+		kv.Stop()
+	}
+}
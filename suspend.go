@@ -0,0 +1,103 @@
+package tinykv
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultSuspendBacklog is the size of the backlog of SuspendNotifications,
+// without SuspendBacklog
+const defaultSuspendBacklog = 10000
+
+// SuspendBacklog sets the number of expired entries the backlog of
+// SuspendNotifications holds, 10000 by default
+func SuspendBacklog(n int) StoreOption {
+	return func(opt *storeOpt) {
+		opt.suspendBacklog = n
+	}
+}
+
+// suspendedBatch is a batch of expired entries, waiting for the resume of
+// SuspendNotifications
+type suspendedBatch struct {
+	expired   map[string]*entry
+	removedAt time.Time
+}
+
+// SuspendNotifications suspends the expiration notifications (the callbacks
+// and the ExpiredStreams), like while loading entries that may be expired
+// already, before the handlers are ready. Expired entries are still removed,
+// but their notifications wait in a backlog (see SuspendBacklog); those it
+// has no room for are dropped, and counted in Stats.SuspendDropped. The
+// returned resume delivers the backlog, in order, before returning, or
+// discards it, by deliverBacklog; calling it again does nothing. Suspensions
+// nest: the notifications resume with the last one, and its deliverBacklog
+// decides. AwaitExpiration waits for the resume.
+func (kv *Store) SuspendNotifications() (resume func(deliverBacklog bool)) {
+	kv.mx.Lock()
+	kv.suspends++
+	kv.mx.Unlock()
+	var once sync.Once
+	return func(deliverBacklog bool) {
+		once.Do(func() { kv.resume(deliverBacklog) })
+	}
+}
+
+// suspend queues expired in the backlog, if the notifications are suspended,
+// and reports if they are
+func (kv *Store) suspend(expired map[string]*entry, removedAt time.Time) bool {
+	kv.mx.Lock()
+	if kv.suspends == 0 {
+		kv.mx.Unlock()
+		return false
+	}
+	limit := kv.suspendBacklog
+	if limit <= 0 {
+		limit = defaultSuspendBacklog
+	}
+	var dropped map[string]*entry
+	if room := limit - kv.suspendedLen; room < len(expired) {
+		kept := make(map[string]*entry, room)
+		dropped = make(map[string]*entry, len(expired)-room)
+		for _, k := range byPriority(expired) {
+			if len(kept) < room {
+				kept[k] = expired[k]
+			} else {
+				dropped[k] = expired[k]
+			}
+		}
+		expired = kept
+		kv.stats.SuspendDropped += int64(len(dropped))
+	}
+	if len(expired) > 0 {
+		kv.suspended = append(kv.suspended, suspendedBatch{expired: expired, removedAt: removedAt})
+		kv.suspendedLen += len(expired)
+	}
+	kv.mx.Unlock()
+	if len(dropped) > 0 {
+		kv.dispatched(dropped)
+	}
+	return true
+}
+
+// resume ends a suspension of the notifications; the last one delivers the
+// backlog, or discards it
+func (kv *Store) resume(deliverBacklog bool) {
+	kv.mx.Lock()
+	kv.suspends--
+	if kv.suspends > 0 {
+		kv.mx.Unlock()
+		return
+	}
+	backlog := kv.suspended
+	kv.suspended = nil
+	kv.suspendedLen = 0
+	kv.mx.Unlock()
+	for _, b := range backlog {
+		if deliverBacklog {
+			kv.deliverExpired(b.expired, b.removedAt, true)
+		} else {
+			kv.dispatched(b.expired)
+		}
+	}
+}
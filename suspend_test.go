@@ -0,0 +1,97 @@
+package tinykv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuspendNotifications(t *testing.T) {
+	for _, deliver := range []bool{false, true} {
+		deliver := deliver
+		t.Run(fmt.Sprint("deliver=", deliver), func(t *testing.T) {
+			assert := assert.New(t)
+
+			clock := newFakeClock()
+			var mx sync.Mutex
+			var notified []string
+			kv := NewStore(time.Hour,
+				Clock(clock.Now),
+				SynchronousNotifications(),
+				OnExpire(func(k string, v interface{}) {
+					mx.Lock()
+					defer mx.Unlock()
+					notified = append(notified, k)
+				}))
+			defer kv.Stop()
+
+			resume := kv.SuspendNotifications()
+			for i := 0; i < 5; i++ {
+				assert.NoError(kv.Put(fmt.Sprint("k", i), i, ExpiresAfter(time.Second*time.Duration(i)+time.Millisecond*500)))
+			}
+			// removed, not notified
+			for i := 0; i < 5; i++ {
+				clock.Advance(time.Second)
+				kv.ExpireNow()
+			}
+			assert.Empty(kv.Keys())
+			assert.Equal(5, kv.Stats().SuspendBacklog)
+			mx.Lock()
+			assert.Empty(notified)
+			mx.Unlock()
+
+			resume(deliver)
+			resume(!deliver) // does nothing
+			assert.Equal(0, kv.Stats().SuspendBacklog)
+			mx.Lock()
+			if deliver {
+				assert.Equal([]string{"k0", "k1", "k2", "k3", "k4"}, notified)
+			} else {
+				assert.Empty(notified)
+			}
+			mx.Unlock()
+
+			// notified as usual after
+			assert.NoError(kv.Put("after", 1, ExpiresAfter(time.Second)))
+			clock.Advance(time.Second * 2)
+			kv.ExpireNow()
+			mx.Lock()
+			assert.Contains(notified, "after")
+			mx.Unlock()
+		})
+	}
+}
+
+func TestSuspendNotificationsBacklog(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	notified := make(chan string, 10)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		SuspendBacklog(3),
+		SynchronousNotifications(),
+		OnExpire(func(k string, v interface{}) { notified <- k }))
+	defer kv.Stop()
+
+	outer := kv.SuspendNotifications()
+	inner := kv.SuspendNotifications()
+	for i := 0; i < 5; i++ {
+		assert.NoError(kv.Put(fmt.Sprint("k", i), i, ExpiresAfter(time.Second)))
+	}
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	stats := kv.Stats()
+	assert.Equal(3, stats.SuspendBacklog)
+	assert.Equal(int64(2), stats.SuspendDropped)
+
+	// the last resume decides
+	inner(false)
+	assert.Len(notified, 0)
+	outer(true)
+	assert.Len(notified, 3)
+	assert.Equal(3, kv.Config().SuspendBacklog)
+}
@@ -0,0 +1,141 @@
+package tinykv
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Swappable is a KV that delegates to an inner store, which Swap replaces
+// atomically, like for a blue/green rebuild of a cache: the operations after
+// a Swap go to the new store, while those in flight complete on the old one.
+// It costs one atomic load per operation. It has the operations of KV; the
+// others (like Append, or Stats) are on the inner store, see Current.
+type Swappable struct {
+	inner             atomic.Value // swapped
+	mx                sync.Mutex   // one Swap at a time
+	stopPreviousAfter time.Duration
+	stopPrevious      bool
+}
+
+// swapped holds the inner store, as atomic.Value needs one concrete type
+type swapped struct {
+	kv KV
+}
+
+// SwappableOption is an option of NewSwappable
+type SwappableOption func(*Swappable)
+
+// StopPreviousAfter makes Swap stop the store it swaps out, grace later, so
+// the operations in flight on it can complete first
+func StopPreviousAfter(grace time.Duration) SwappableOption {
+	return func(s *Swappable) {
+		s.stopPreviousAfter = grace
+		s.stopPrevious = true
+	}
+}
+
+// NewSwappable creates a Swappable, delegating to initial
+func NewSwappable(initial KV, options ...SwappableOption) *Swappable {
+	s := &Swappable{}
+	for _, opt := range options {
+		opt(s)
+	}
+	s.inner.Store(swapped{initial})
+	return s
+}
+
+// Swap makes next the inner store, and returns the previous one; a nil next
+// is ignored. With StopPreviousAfter, the previous store is stopped after the
+// grace period, so next must not be the previous store itself.
+func (s *Swappable) Swap(next KV) (previous KV) {
+	if next == nil {
+		return nil
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	previous = s.current()
+	s.inner.Store(swapped{next})
+	if s.stopPrevious {
+		time.AfterFunc(s.stopPreviousAfter, previous.Stop)
+	}
+	return previous
+}
+
+// Current returns the inner store
+func (s *Swappable) Current() KV {
+	return s.current()
+}
+
+func (s *Swappable) current() KV {
+	return s.inner.Load().(swapped).kv
+}
+
+// Stop stops the inner store; a store swapped out is stopped by
+// StopPreviousAfter, or by the caller of Swap.
+func (s *Swappable) Stop() {
+	s.current().Stop()
+}
+
+//-----------------------------------------------------------------------------
+
+// CAS is a CAS on the current inner store
+func (s *Swappable) CAS(k string, v interface{}, cond func(oldValue interface{}, found bool) bool, options ...PutOption) error {
+	return s.current().CAS(k, v, cond, options...)
+}
+
+// Delete deletes k from the current inner store
+func (s *Swappable) Delete(k string) {
+	s.current().Delete(k)
+}
+
+// DeleteE is a DeleteE on the current inner store
+func (s *Swappable) DeleteE(k string) error {
+	return s.current().DeleteE(k)
+}
+
+// Get gets k from the current inner store
+func (s *Swappable) Get(k string) (v interface{}, ok bool) {
+	return s.current().Get(k)
+}
+
+// GetE is a GetE on the current inner store
+func (s *Swappable) GetE(k string) (v interface{}, err error) {
+	return s.current().GetE(k)
+}
+
+// Keys returns the keys of the current inner store
+func (s *Swappable) Keys() []string {
+	return s.current().Keys()
+}
+
+// Len returns the number of entries of the current inner store
+func (s *Swappable) Len() int {
+	return s.current().Len()
+}
+
+// Put puts an entry in the current inner store
+func (s *Swappable) Put(k string, v interface{}, options ...PutOption) error {
+	return s.current().Put(k, v, options...)
+}
+
+// Range ranges over the current inner store; a Swap during Range does not
+// change the store it goes over
+func (s *Swappable) Range(fn func(k string, v interface{}) bool) {
+	s.current().Range(fn)
+}
+
+// Take takes k out of the current inner store
+func (s *Swappable) Take(k string) (v interface{}, ok bool) {
+	return s.current().Take(k)
+}
+
+// TakeE is a TakeE on the current inner store
+func (s *Swappable) TakeE(k string) (v interface{}, err error) {
+	return s.current().TakeE(k)
+}
+
+// Touch touches k in the current inner store
+func (s *Swappable) Touch(k string) bool {
+	return s.current().Touch(k)
+}
@@ -0,0 +1,112 @@
+package tinykv
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwappable(t *testing.T) {
+	assert := assert.New(t)
+
+	const keys = 100
+	build := func(gen int) KV {
+		kv := NewStore(time.Hour)
+		for i := 0; i < keys; i++ {
+			kv.Put(strconv.Itoa(i), gen)
+		}
+		return kv
+	}
+	s := NewSwappable(build(0))
+	defer s.Stop()
+
+	var (
+		wg     sync.WaitGroup
+		done   = make(chan struct{})
+		reads  int64
+		failed int64
+	)
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			last := 0
+			for i := r; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				v, ok := s.Get(strconv.Itoa(i % keys))
+				// a reader never misses, nor goes back a generation
+				if !ok || v.(int) < last {
+					atomic.AddInt64(&failed, 1)
+					continue
+				}
+				last = v.(int)
+				atomic.AddInt64(&reads, 1)
+			}
+		}(r)
+	}
+
+	var previous []KV
+	for gen := 1; gen <= 20; gen++ {
+		next := build(gen)
+		previous = append(previous, s.Swap(next))
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+	for _, kv := range previous {
+		kv.Stop()
+	}
+
+	assert.Equal(int64(0), atomic.LoadInt64(&failed))
+	assert.True(atomic.LoadInt64(&reads) > 0)
+	v, _ := s.Get("0")
+	assert.Equal(20, v)
+	assert.Nil(s.Swap(nil))
+	assert.Equal(keys, s.Current().Len())
+}
+
+func TestSwappableStopsPrevious(t *testing.T) {
+	assert := assert.New(t)
+
+	blue, green := NewStore(time.Hour), NewStore(time.Hour)
+	s := NewSwappable(blue, StopPreviousAfter(time.Millisecond*10))
+	defer s.Stop()
+
+	assert.True(s.Swap(green) == blue)
+	assert.NoError(blue.Healthy()) // in flight operations can complete
+	deadline := time.Now().Add(time.Second)
+	for blue.Healthy() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Error(blue.Healthy())
+	assert.NoError(green.Healthy())
+}
+
+func BenchmarkSwappableGet(b *testing.B) {
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+	kv.Put("k", 1)
+	s := NewSwappable(kv)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Get("k")
+	}
+}
+
+func BenchmarkStoreGet(b *testing.B) {
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+	kv.Put("k", 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kv.Get("k")
+	}
+}
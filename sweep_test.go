@@ -0,0 +1,243 @@
+package tinykv
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepInterleavesWriters(t *testing.T) {
+	if testing.Short() {
+		t.Skip("fills the store with 500k entries")
+	}
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	const n = 500000
+	for i := 0; i < n; i++ {
+		kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Minute))
+	}
+	clock.Advance(time.Minute + time.Second)
+
+	var (
+		wg         sync.WaitGroup
+		done       = make(chan struct{})
+		maxLatency time.Duration
+		puts       int
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			start := time.Now()
+			kv.Put("writer:"+strconv.Itoa(i%100), i, ExpiresAfter(time.Hour))
+			if d := time.Since(start); d > maxLatency {
+				maxLatency = d
+			}
+			puts++
+		}
+	}()
+
+	start := time.Now()
+	kv.ExpireNow()
+	sweep := time.Since(start)
+	close(done)
+	wg.Wait()
+
+	assert.Equal(100, kv.Stats().Entries)
+	t.Logf("sweep of %d entries took %v, %d puts meanwhile, max put latency %v", n, sweep, puts, maxLatency)
+	assert.True(maxLatency < sweep/4, "max put latency %v, sweep %v", maxLatency, sweep)
+}
+
+func TestSweepSparesRewrittenEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), Debug())
+	defer kv.Stop()
+
+	kv.Put("put", 1, ExpiresAfter(time.Minute))
+	kv.Put("touched", 1, ExpiresAfter(time.Minute), IsSliding(true))
+	kv.Put("expired", 1, ExpiresAfter(time.Minute))
+	clock.Advance(time.Minute + time.Second)
+	now := kv.now()
+
+	var missing []*timeout
+	condemned, more := kv.claim(now, &missing)
+	assert.False(more)
+	assert.Len(condemned, 3)
+	assert.NoError(kv.CheckInvariants())
+
+	// between the critical sections of the sweep
+	kv.Put("put", 2)
+	kv.mx.Lock()
+	touched, _ := kv.kv.get("touched")
+	touched.timeout.slide(now.Add(time.Second))
+	kv.mx.Unlock()
+
+	expired := make(map[string]*entry)
+	kv.execute(condemned, now, expired)
+	assert.Len(expired, 1)
+	assert.NotNil(expired["expired"])
+	assert.ElementsMatch([]string{"put", "touched"}, kv.Keys())
+	assert.NoError(kv.CheckInvariants())
+
+	// the one that kept its timeout got it back in the heap
+	clock.Advance(time.Minute * 2)
+	kv.ExpireNow()
+	assert.Equal([]string{"put"}, kv.Keys())
+	assert.NoError(kv.CheckInvariants())
+}
+
+// tagged is a value that carries the deadline it was put with
+type tagged struct {
+	deadline time.Time
+	n        int
+}
+
+func TestExpirationReportsConsistentValues(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var (
+		mismatches []string
+		mx         sync.Mutex
+		notified   int64
+	)
+	check := func(path, k string, v interface{}, deadline, removedAt time.Time) {
+		atomic.AddInt64(&notified, 1)
+		tag := v.(tagged)
+		if tag.deadline.Equal(deadline) && removedAt.After(deadline) {
+			return
+		}
+		mx.Lock()
+		defer mx.Unlock()
+		mismatches = append(mismatches, fmt.Sprintf("%s %s: %v, deadline %v, removed at %v", path, k, tag, deadline, removedAt))
+	}
+	kv := NewStore(time.Millisecond,
+		Clock(clock.Now),
+		StreamAndCallbacks(),
+		OnExpireDetailed(func(k string, v interface{}, deadline, removedAt time.Time) {
+			check("callback", k, v, deadline, removedAt)
+		}))
+	defer kv.Stop()
+	stream, cancel := kv.ExpiredStream(1024)
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		for ex := range stream {
+			check("stream", ex.Key, ex.Value, ex.Deadline, ex.RemovedAt)
+		}
+	}()
+
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				k := strconv.Itoa(i % 64)
+				deadline := clock.Now().Add(time.Duration(i%5+1) * time.Millisecond)
+				v := tagged{deadline, w*1000000 + i}
+				switch i % 4 {
+				case 0:
+					kv.Put(k, v, ExpiresAt(deadline))
+				case 1:
+					kv.CAS(k, v, func(interface{}, bool) bool { return true }, ExpiresAt(deadline))
+				case 2:
+					kv.Get(k) // finds expired entries
+				case 3:
+					kv.PopSoonest()
+				}
+			}
+		}(w)
+	}
+	// until enough expirations were reported, by every path
+	deadline := time.Now().Add(time.Second * 5)
+	for i := 0; atomic.LoadInt64(&notified) < 1000 && time.Now().Before(deadline); i++ {
+		clock.Advance(time.Millisecond * 3)
+		if i%10 == 0 {
+			kv.ExpireNow()
+		}
+		time.Sleep(time.Microsecond * 50)
+	}
+	close(done)
+	wg.Wait()
+	cancel()
+	<-streamDone
+
+	assert.True(atomic.LoadInt64(&notified) > 0)
+	mx.Lock()
+	defer mx.Unlock()
+	assert.Empty(mismatches)
+}
+
+func TestSweepNeverRemovesRacingPut(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	const (
+		ttl  = time.Millisecond
+		keys = 256
+	)
+	kv := NewStore(time.Millisecond)
+	defer kv.Stop()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				kv.ExpireNow()
+			}
+		}
+	}()
+
+	puts, checked := 0, 0
+	for deadline := time.Now().Add(time.Second * 3); time.Now().Before(deadline); puts++ {
+		k := strconv.Itoa(puts % keys)
+		start := time.Now()
+		if err := kv.Put(k, puts, ExpiresAfter(ttl)); err != nil {
+			t.Fatal(err)
+		}
+		v, ok := kv.Get(k)
+		if time.Since(start) >= ttl { // it may have expired by its deadline
+			continue
+		}
+		checked++
+		if !ok || v != puts {
+			t.Fatalf("put %d: got %v, %v right after the put", puts, v, ok)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	t.Logf("%d puts, %d checked", puts, checked)
+}
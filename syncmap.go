@@ -0,0 +1,80 @@
+package tinykv
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// MapAdapter has the methods of a sync.Map, over a KV, so code written
+// against a sync.Map gets the timeouts of the store by replacing it, see
+// AsSyncMap. The keys must be strings: other keys panic, like the keys a
+// sync.Map can not compare. A sync.Map can not fail a store, so a Put that
+// fails (like with ErrFull, under MaxEntries) is dropped: Store does nothing,
+// and LoadOrStore returns value, not loaded, as if it was stored.
+type MapAdapter struct {
+	kv          KV
+	defaultOpts []PutOption
+}
+
+// AsSyncMap returns a MapAdapter over kv; defaultOpts apply to every value
+// stored, like a timeout
+func AsSyncMap(kv KV, defaultOpts ...PutOption) *MapAdapter {
+	return &MapAdapter{kv: kv, defaultOpts: defaultOpts}
+}
+
+func mapKey(key interface{}) string {
+	k, ok := key.(string)
+	if !ok {
+		panic(fmt.Sprintf("tinykv: MapAdapter key of type %T, not a string", key))
+	}
+	return k
+}
+
+// Load returns the value stored for key, or nil; ok reports if it was found
+func (m *MapAdapter) Load(key interface{}) (value interface{}, ok bool) {
+	return m.kv.Get(mapKey(key))
+}
+
+// Store sets the value for key
+func (m *MapAdapter) Store(key, value interface{}) {
+	m.kv.Put(mapKey(key), value, m.defaultOpts...)
+}
+
+// LoadOrStore returns the value for key, if present; otherwise it stores and
+// returns value. loaded is true if the value was loaded. It is atomic, with
+// a CAS on the store.
+func (m *MapAdapter) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	err := m.kv.CAS(mapKey(key), value, func(old interface{}, found bool) bool {
+		if found {
+			actual, loaded = old, true
+			return false
+		}
+		return true
+	}, m.defaultOpts...)
+	if loaded && errors.Cause(err) == ErrCASCond {
+		return actual, true
+	}
+	return value, false
+}
+
+// LoadAndDelete deletes the value for key, returning the previous value if
+// any; loaded reports if the key was present. It is a Take on the store.
+func (m *MapAdapter) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	return m.kv.Take(mapKey(key))
+}
+
+// Delete deletes the value for key
+func (m *MapAdapter) Delete(key interface{}) {
+	m.kv.Delete(mapKey(key))
+}
+
+// Range calls f for each key and value, until f returns false. Like for a
+// sync.Map, it is not a consistent snapshot: each key is visited once, but a
+// value stored or deleted during Range (by f too, which can use the map) may
+// be seen or not. See KV.Range.
+func (m *MapAdapter) Range(f func(key, value interface{}) bool) {
+	m.kv.Range(func(k string, v interface{}) bool {
+		return f(k, v)
+	})
+}
@@ -0,0 +1,200 @@
+package tinykv
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mapOps are the methods of both a sync.Map and a MapAdapter
+type mapOps interface {
+	Load(key interface{}) (interface{}, bool)
+	Store(key, value interface{})
+	LoadOrStore(key, value interface{}) (interface{}, bool)
+	LoadAndDelete(key interface{}) (interface{}, bool)
+	Delete(key interface{})
+	Range(f func(key, value interface{}) bool)
+}
+
+var (
+	_ mapOps = (*sync.Map)(nil)
+	_ mapOps = (*MapAdapter)(nil)
+)
+
+func mapContents(m mapOps) map[interface{}]interface{} {
+	contents := make(map[interface{}]interface{})
+	m.Range(func(k, v interface{}) bool {
+		contents[k] = v
+		return true
+	})
+	return contents
+}
+
+func TestMapAdapterMatchesSyncMap(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, options := range map[string][]StoreOption{
+		"store":   nil,
+		"indexed": {Indexed()},
+	} {
+		kv := NewStore(time.Hour, options...)
+		var want sync.Map
+		got := AsSyncMap(kv)
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 2000; i++ {
+			k, v := strconv.Itoa(rnd.Intn(20)), rnd.Intn(1000)
+			switch rnd.Intn(5) {
+			case 0:
+				wv, wok := want.Load(k)
+				gv, gok := got.Load(k)
+				assert.Equal(wok, gok, "%s: load %s", name, k)
+				assert.Equal(wv, gv, "%s: load %s", name, k)
+			case 1:
+				want.Store(k, v)
+				got.Store(k, v)
+			case 2:
+				wv, wok := want.LoadOrStore(k, v)
+				gv, gok := got.LoadOrStore(k, v)
+				assert.Equal(wok, gok, "%s: load-or-store %s", name, k)
+				assert.Equal(wv, gv, "%s: load-or-store %s", name, k)
+			case 3:
+				wv, wok := want.LoadAndDelete(k)
+				gv, gok := got.LoadAndDelete(k)
+				assert.Equal(wok, gok, "%s: load-and-delete %s", name, k)
+				assert.Equal(wv, gv, "%s: load-and-delete %s", name, k)
+			case 4:
+				want.Delete(k)
+				got.Delete(k)
+			}
+		}
+		assert.Equal(mapContents(&want), mapContents(got), name)
+		kv.Stop()
+	}
+}
+
+func TestMapAdapterDefaultOpts(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+	m := AsSyncMap(kv, ExpiresAfter(time.Second))
+
+	m.Store("a", 1)
+	actual, loaded := m.LoadOrStore("b", 2)
+	assert.False(loaded)
+	assert.Equal(2, actual)
+	meta, _ := kv.GetMeta("a")
+	assert.Equal(time.Second, meta.ExpiresAfter)
+	meta, _ = kv.GetMeta("b")
+	assert.Equal(time.Second, meta.ExpiresAfter)
+
+	clock.Advance(time.Second * 2)
+	_, ok := m.Load("a")
+	assert.False(ok)
+	actual, loaded = m.LoadOrStore("b", 3)
+	assert.False(loaded)
+	assert.Equal(3, actual)
+	assert.Equal(map[interface{}]interface{}{"b": 3}, mapContents(m))
+}
+
+func TestMapAdapterAtomic(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+	m := AsSyncMap(kv)
+
+	const n = 16
+	var wg sync.WaitGroup
+	stored := make(chan interface{}, n)
+	actuals := make(chan interface{}, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			actual, loaded := m.LoadOrStore("k", i)
+			if !loaded {
+				stored <- i
+			}
+			actuals <- actual
+		}(i)
+	}
+	wg.Wait()
+	close(stored)
+	close(actuals)
+	assert.Len(stored, 1)
+	winner := <-stored
+	for actual := range actuals {
+		assert.Equal(winner, actual)
+	}
+
+	deleted := make(chan interface{}, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, loaded := m.LoadAndDelete("k"); loaded {
+				deleted <- v
+			}
+		}()
+	}
+	wg.Wait()
+	close(deleted)
+	assert.Len(deleted, 1)
+	assert.Equal(winner, <-deleted)
+}
+
+func TestMapAdapterRange(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, options := range map[string][]StoreOption{
+		"store":   nil,
+		"indexed": {Indexed()},
+	} {
+		kv := NewStore(time.Hour, options...)
+		m := AsSyncMap(kv)
+		for i := 0; i < 10; i++ {
+			m.Store(strconv.Itoa(i), i)
+		}
+
+		// f can use the map; each key is visited once, whatever it changes
+		visited := make(map[interface{}]int)
+		m.Range(func(k, v interface{}) bool {
+			visited[k]++
+			m.Delete(k)
+			m.Store(k.(string)+"-new", v)
+			m.Store("9", -1)
+			return true
+		})
+		assert.Len(visited, 10, name)
+		for k, n := range visited {
+			assert.Equal(1, n, "%s: %v", name, k)
+		}
+
+		// it stops when f returns false
+		calls := 0
+		m.Range(func(k, v interface{}) bool {
+			calls++
+			return false
+		})
+		assert.Equal(1, calls, name)
+		kv.Stop()
+	}
+}
+
+func TestMapAdapterKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+	m := AsSyncMap(kv)
+
+	assert.PanicsWithValue("tinykv: MapAdapter key of type int, not a string", func() { m.Store(1, 1) })
+	assert.PanicsWithValue("tinykv: MapAdapter key of type int, not a string", func() { m.Load(1) })
+	assert.Equal(0, kv.Len())
+}
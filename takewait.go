@@ -0,0 +1,169 @@
+package tinykv
+
+import (
+	"context"
+)
+
+// HandoffPolicy is how a Put reaches the callers of TakeOrWait waiting for
+// its key
+type HandoffPolicy int
+
+// handoff policies
+const (
+	HandoffDirect        HandoffPolicy = iota // the value goes to the waiter, and is never stored
+	HandoffStoreThenTake                      // the entry is stored, then the waiter takes it
+)
+
+func (p HandoffPolicy) String() string {
+	switch p {
+	case HandoffDirect:
+		return "direct"
+	case HandoffStoreThenTake:
+		return "store-then-take"
+	}
+	return "unknown"
+}
+
+// TakeHandoff sets how a Put reaches the callers of TakeOrWait, HandoffDirect
+// by default. Under HandoffDirect, a Put (or ForcePut, TryPut) of a missing
+// key with waiters hands its value to the oldest one and returns: the entry
+// is never stored, so Get never sees it, and it is neither sent to watchers,
+// the WAL or the Backing, nor counted against MaxEntries or quotas. The other
+// writes (CAS, Append, PutAfter activations, ...) store the entry, which the
+// oldest waiter then takes, as under HandoffStoreThenTake.
+func TakeHandoff(policy HandoffPolicy) StoreOption {
+	return func(opt *storeOpt) {
+		opt.takeHandoff = policy
+	}
+}
+
+// handoff is what a waiter of TakeOrWait receives
+type handoff struct {
+	value  interface{}
+	stored bool // the entry was stored, for the waiter to take
+}
+
+// takeWaiter is a caller of TakeOrWait
+type takeWaiter struct {
+	ready chan handoff // buffered, so the sender never blocks
+}
+
+// TakeOrWait takes the entry for k like TakeE, or, if there is none, waits
+// until one is put, and takes it. The callers waiting for a key are served one
+// entry each, oldest first: a Put serves a single waiter (see TakeHandoff).
+// It returns the error of ctx if it is done first, and ErrStopped if the store
+// is stopped.
+func (kv *Store) TakeOrWait(ctx context.Context, k string) (v interface{}, err error) {
+	defer wrapOp(&err, "take-or-wait", k)
+	front := false // a waiter woken for nothing keeps its place
+	for {
+		v, err = kv.take(k)
+		if err != ErrNotFound && err != ErrExpired {
+			return v, err
+		}
+		w, parked := kv.parkTaker(k, front)
+		if !parked {
+			continue // put meanwhile
+		}
+		select {
+		case h := <-w.ready:
+			if !h.stored {
+				return h.value, nil
+			}
+		case <-ctx.Done():
+			return kv.unparkTaker(k, w, ctx.Err())
+		case <-kv.stop:
+			return kv.unparkTaker(k, w, ErrStopped)
+		}
+		front = true
+	}
+}
+
+// parkTaker adds a waiter for k, at the front of the queue or at its back,
+// unless k was put meanwhile
+func (kv *Store) parkTaker(k string, front bool) (*takeWaiter, bool) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if e, ok := kv.kv.get(k); ok && !kv.expired(e) {
+		return nil, false
+	}
+	w := &takeWaiter{ready: make(chan handoff, 1)}
+	if kv.takeWaiters == nil {
+		kv.takeWaiters = make(map[string][]*takeWaiter)
+	}
+	if front {
+		kv.takeWaiters[k] = append([]*takeWaiter{w}, kv.takeWaiters[k]...)
+	} else {
+		kv.takeWaiters[k] = append(kv.takeWaiters[k], w)
+	}
+	return w, true
+}
+
+// unparkTaker drops w, a waiter for k giving up with err. If w was served
+// meanwhile, a handed off value is returned anyway, so it is not lost, and a
+// stored entry is passed on to the next waiter.
+func (kv *Store) unparkTaker(k string, w *takeWaiter, err error) (interface{}, error) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	waiters := kv.takeWaiters[k]
+	for i, other := range waiters {
+		if other == w {
+			kv.setTakers(k, append(waiters[:i], waiters[i+1:]...))
+			return nil, err
+		}
+	}
+	h := <-w.ready
+	if !h.stored {
+		return h.value, nil
+	}
+	kv.wakeTaker(k)
+	return nil, err
+}
+
+// popTaker takes the oldest waiter for k out of the queue, if any
+func (kv *Store) popTaker(k string) *takeWaiter {
+	waiters := kv.takeWaiters[k]
+	if len(waiters) == 0 {
+		return nil
+	}
+	kv.setTakers(k, waiters[1:])
+	return waiters[0]
+}
+
+func (kv *Store) setTakers(k string, waiters []*takeWaiter) {
+	if len(waiters) == 0 {
+		delete(kv.takeWaiters, k)
+		return
+	}
+	kv.takeWaiters[k] = waiters
+}
+
+// handOff hands v to the oldest waiter for k, under HandoffDirect, if k is
+// missing; it reports if it did
+func (kv *Store) handOff(k string, v interface{}, opt *putOpt) bool {
+	if kv.takeHandoff != HandoffDirect || opt.cas != nil || opt.casMeta != nil || opt.boundTo != nil {
+		return false
+	}
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if len(kv.takeWaiters[k]) == 0 {
+		return false
+	}
+	kv.activateDue(k)
+	if e, ok := kv.kv.get(k); ok && !kv.expired(e) {
+		return false
+	}
+	kv.popTaker(k).ready <- handoff{value: kv.copyValue(v)}
+	return true
+}
+
+// wakeTaker tells the oldest waiter for k, if any, to take the stored entry;
+// it must be called under the lock
+func (kv *Store) wakeTaker(k string) {
+	if len(kv.takeWaiters) == 0 {
+		return
+	}
+	if w := kv.popTaker(k); w != nil {
+		w.ready <- handoff{stored: true}
+	}
+}
@@ -0,0 +1,140 @@
+package tinykv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// parkTakers starts n callers of TakeOrWait for k, one at a time, so they
+// are queued in order, and returns the values they take, by caller
+func parkTakers(t *testing.T, kv *Store, ctx context.Context, k string, n int) []chan interface{} {
+	kv.mx.Lock()
+	before := len(kv.takeWaiters[k])
+	kv.mx.Unlock()
+	taken := make([]chan interface{}, n)
+	for i := range taken {
+		ch := make(chan interface{}, 1)
+		taken[i] = ch
+		go func() {
+			v, err := kv.TakeOrWait(ctx, k)
+			if err != nil {
+				v = err
+			}
+			ch <- v
+		}()
+		deadline := time.Now().Add(time.Second * 5)
+		for {
+			kv.mx.Lock()
+			parked := len(kv.takeWaiters[k])
+			kv.mx.Unlock()
+			if parked == before+i+1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("TakeOrWait not parked")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return taken
+}
+
+func testTakeOrWaitFIFO(t *testing.T, policy HandoffPolicy) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, TakeHandoff(policy), Debug())
+	defer kv.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	taken := parkTakers(t, kv, ctx, "job", 10)
+	for i := range taken {
+		assert.NoError(kv.Put("job", i))
+		assert.Equal(i, <-taken[i])
+		// exactly one winner
+		for _, ch := range taken[i+1:] {
+			select {
+			case v := <-ch:
+				t.Fatalf("%v taken twice", v)
+			default:
+			}
+		}
+		_, ok := kv.Get("job")
+		assert.False(ok)
+	}
+	assert.Empty(kv.takeWaiters)
+	assert.Equal(policy, kv.Config().TakeHandoff)
+}
+
+func TestTakeOrWaitDirect(t *testing.T) {
+	testTakeOrWaitFIFO(t, HandoffDirect)
+}
+
+func TestTakeOrWaitStoreThenTake(t *testing.T) {
+	testTakeOrWaitFIFO(t, HandoffStoreThenTake)
+}
+
+func TestTakeOrWaitNeverVisible(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+	events, stop := kv.WatchPrefix("job")
+	defer stop()
+
+	taken := parkTakers(t, kv, context.Background(), "job", 1)
+	assert.NoError(kv.Put("job", 1))
+	assert.Equal(1, <-taken[0])
+	select {
+	case ev := <-events:
+		t.Fatalf("handed off value seen by a watcher: %v", ev)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	// the other writes store the entry, for the waiter to take
+	taken = parkTakers(t, kv, context.Background(), "job", 1)
+	_, err := kv.Append("job", 2)
+	assert.NoError(err)
+	assert.Equal([]interface{}{2}, <-taken[0])
+	_, ok := kv.Get("job")
+	assert.False(ok)
+}
+
+func TestTakeOrWaitPresent(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("job", 1))
+	v, err := kv.TakeOrWait(context.Background(), "job")
+	assert.NoError(err)
+	assert.Equal(1, v)
+	_, ok := kv.Get("job")
+	assert.False(ok)
+}
+
+func TestTakeOrWaitCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := parkTakers(t, kv, ctx, "job", 2)
+	waiting := parkTakers(t, kv, context.Background(), "job", 1)
+	cancel()
+	for _, ch := range cancelled {
+		assert.Equal(context.Canceled, errors.Cause((<-ch).(error)))
+	}
+
+	// the cancelled waiters are gone, the next Put goes to the one left
+	assert.NoError(kv.Put("job", 1))
+	assert.Equal(1, <-waiting[0])
+
+	waiting = parkTakers(t, kv, context.Background(), "job", 1)
+	kv.Stop()
+	assert.Equal(ErrStopped, errors.Cause((<-waiting[0]).(error)))
+}
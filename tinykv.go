@@ -2,8 +2,13 @@ package tinykv
 
 import (
 	"fmt"
+	"io"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 //-----------------------------------------------------------------------------
@@ -12,39 +17,92 @@ type timeout struct {
 	expiresAt    time.Time
 	expiresAfter time.Duration
 	isSliding    bool
+	idleAfter    time.Duration
+	deadline     time.Time // absolute end of life, when idleAfter is set
 	key          string
+	index        int  // in the heap
+	stale        bool // the entry is gone or has another timeout
+	slidesLeft   int  // -1 means unlimited
+	grace        time.Duration
+	// set if the node is not a timeout, but the activation of a PutAfter
+	pending *pendingPut
+	// set if the node is not a timeout, but the deadline of an ExpectWithin
+	expect *expectation
+	// set if the node is not a timeout, but the soft deadline of an entry
+	soft *softDeadline
 }
 
 func newTimeout(
+	now time.Time,
 	key string,
 	expiresAfter time.Duration,
-	isSliding bool) *timeout {
-	return &timeout{
-		expiresAt:    time.Now().Add(expiresAfter),
+	isSliding bool,
+	idleAfter time.Duration) *timeout {
+	to := &timeout{
+		expiresAt:    now.Add(expiresAfter),
 		expiresAfter: expiresAfter,
 		isSliding:    isSliding,
+		idleAfter:    idleAfter,
 		key:          key,
+		index:        -1,
+		slidesLeft:   -1,
+	}
+	if idleAfter > 0 {
+		if expiresAfter > 0 {
+			to.deadline = to.expiresAt
+		}
+		to.expiresAt = to.capped(now.Add(idleAfter))
 	}
+	return to
 }
 
-func (to *timeout) slide() {
+func (to *timeout) slide(now time.Time) {
 	if to == nil {
 		return
 	}
+	if to.slidesLeft == 0 {
+		return
+	}
+	if to.slidesLeft > 0 && to.sliding() {
+		to.slidesLeft--
+	}
+	if to.idleAfter > 0 {
+		to.expiresAt = to.capped(now.Add(to.idleAfter))
+		return
+	}
 	if !to.isSliding {
 		return
 	}
 	if to.expiresAfter <= 0 {
 		return
 	}
-	to.expiresAt = time.Now().Add(to.expiresAfter)
+	to.expiresAt = now.Add(to.expiresAfter)
+}
+
+// isEntry reports if the node is the timeout of an entry, and not the
+// activation of a PutAfter, the deadline of an ExpectWithin or a soft deadline
+func (to *timeout) isEntry() bool {
+	return to.pending == nil && to.expect == nil && to.soft == nil
+}
+
+// sliding reports if the deadline moves on access
+func (to *timeout) sliding() bool {
+	return to.idleAfter > 0 || (to.isSliding && to.expiresAfter > 0)
 }
 
-func (to *timeout) expired() bool {
+// capped returns the earlier of t and the absolute deadline (if any)
+func (to *timeout) capped(t time.Time) time.Time {
+	if !to.deadline.IsZero() && to.deadline.Before(t) {
+		return to.deadline
+	}
+	return t
+}
+
+func (to *timeout) expired(now time.Time) bool {
 	if to == nil {
 		return false
 	}
-	return time.Now().After(to.expiresAt)
+	return now.After(to.expiresAt)
 }
 
 //-----------------------------------------------------------------------------
@@ -53,13 +111,21 @@ func (to *timeout) expired() bool {
 type th []*timeout
 
 func (h th) Len() int           { return len(h) }
-func (h th) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
-func (h th) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
-func (h *th) Push(x tohVal)     { *h = append(*h, x) }
+func (h th) Less(i, j int) bool { return h[i].removeAt().Before(h[j].removeAt()) }
+func (h th) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *th) Push(x tohVal) {
+	x.index = len(*h)
+	*h = append(*h, x)
+}
 func (h *th) Pop() tohVal {
 	old := *h
 	n := len(old)
 	x := old[n-1]
+	x.index = -1
 	*h = old[0 : n-1]
 	return x
 }
@@ -68,26 +134,95 @@ func (h *th) Pop() tohVal {
 
 type entry struct {
 	*timeout
-	value interface{}
+	value       interface{}
+	checksum    uint32
+	hasChecksum bool
+	readOnly    bool
+	protected   bool
+	revision    uint64
+	priority    int
+	bound       *binding
+	cost        int64 // only under MaxCost
+	condemned   bool  // claimed by a sweep, its timeout out of the heap
+	seq         uint64
+	keepOpen    bool      // its removal does not close the value (see CloseOnRemoval)
+	createdAt   time.Time // only under ArchiveOnExpire
+	softNode    *timeout  // the soft deadline, under SoftExpiresAfter
+	refs        int       // holders, see Retain
+	heldAt      time.Time // its removal, while held for its holders
+	history     *history  // under History
+	migrated    bool      // by Migrate or MigrateOnRead
+	writtenAt   time.Time // only under OverwriteWindow
 }
 
 //-----------------------------------------------------------------------------
 
-// KV is a registry for values (like/is a concurrent map) with timeout and sliding timeout
+var (
+	_ KV            = (*Store)(nil)
+	_ StatsProvider = (*Store)(nil)
+	_ KV            = nullKV{}
+	_ KV            = frozenKV{}
+	_ KV            = (*Swappable)(nil)
+)
+
+// KV is a registry for values (like/is a concurrent map) with timeout and
+// sliding timeout. It has the core operations on the entries, that the other
+// implementations (like Null, Frozen, Swappable or Decorate) and the wrappers
+// of callers provide too; the rest are on *Store.
 type KV interface {
+	CAS(k string, v interface{}, cond func(oldValue interface{}, found bool) bool, options ...PutOption) error
 	Delete(k string)
+	DeleteE(k string) error
 	Get(k string) (v interface{}, ok bool)
+	GetE(k string) (v interface{}, err error)
+	Keys() []string
+	Len() int
 	Put(k string, v interface{}, options ...PutOption) error
+	Range(fn func(k string, v interface{}) bool)
 	Take(k string) (v interface{}, ok bool)
+	TakeE(k string) (v interface{}, err error)
+	Touch(k string) bool
 	Stop()
 }
 
+// StatsProvider is the optional interface of a KV that reports Stats, like
+// *Store; a caller given a KV type-asserts it, and a wrapper can forward it.
+type StatsProvider interface {
+	Stats() Stats
+}
+
 //-----------------------------------------------------------------------------
 
 type putOpt struct {
 	expiresAfter time.Duration
 	isSliding    bool
+	hasIsSliding bool
 	cas          func(interface{}, bool) bool
+	casMeta      func(interface{}, Meta, bool) bool
+	keepTTL      bool
+	resetTTL     bool
+	idleTimeout  time.Duration
+	maxSlides    int
+	hasMaxSlides bool
+	expiresAt    time.Time
+	readOnly     bool
+	protected    bool
+	priority     int
+	activatedAt  time.Time // of a PutAfter, instead of now
+	boundTo      *parentRef
+	binding      *binding // resolved boundTo
+	noEvict      bool     // of TryPut
+	belowMinTTL  bool
+	boundary     Boundary // of ExpiresAtNext
+	boundaryLoc  *time.Location
+	grace        time.Duration
+	invalid      string // a nil or panicking option
+	loaded       bool   // from the Backing, not to be stored back
+	profile      string
+	hasProfile   bool
+	softAfter    time.Duration
+	historyDepth int
+	hasHistory   bool
 }
 
 // PutOption extra options for put
@@ -100,241 +235,1473 @@ func ExpiresAfter(expiresAfter time.Duration) PutOption {
 	}
 }
 
-// IsSliding sets if the entry would get expired in a sliding manner
+// ExpiresAt entry will expire at this point in time. For a sliding entry,
+// ExpiresAfter is still needed, as the duration it slides by. It is meant
+// for restoring entries, with their original deadlines.
+func ExpiresAt(expiresAt time.Time) PutOption {
+	return func(opt *putOpt) {
+		opt.expiresAt = expiresAt
+		opt.boundary = 0
+	}
+}
+
+// IsSliding sets if the entry would get expired in a sliding manner. An
+// access slides the entry: Get, GetE, GetGraced (of a live entry), Touch,
+// SetMembers, and a successful CAS, Append or AddToSet. Inspecting it does
+// not: GetMeta, Keys, Prefix, Range, RangeMeta (so snapshot.Save),
+// ExpirationHistogram and Report. Take, Drain and the Pop methods remove it.
+// The same goes for IdleTimeout.
 func IsSliding(isSliding bool) PutOption {
 	return func(opt *putOpt) {
 		opt.isSliding = isSliding
+		opt.hasIsSliding = true
+	}
+}
+
+// IdleTimeout entry will expire if not accessed (by Get or Touch) for this
+// time. Combined with ExpiresAfter, the entry expires at whichever comes
+// first; the ExpiresAfter deadline then does not slide.
+func IdleTimeout(idleTimeout time.Duration) PutOption {
+	return func(opt *putOpt) {
+		opt.idleTimeout = idleTimeout
+	}
+}
+
+// MaxSlides limits the number of times a sliding entry (or one with an idle
+// timeout) can slide. After that, the entry expires at its last deadline.
+func MaxSlides(n int) PutOption {
+	return func(opt *putOpt) {
+		opt.maxSlides = n
+		opt.hasMaxSlides = true
 	}
 }
 
-// CAS for performing a compare and swap
+// CAS for performing a compare and swap. Put(k, v, CAS(cond), options...)
+// behaves exactly like the CAS method, CAS(k, v, cond, options...).
+//
+// Deprecated: use the CAS method.
 func CAS(cas func(oldValue interface{}, found bool) bool) PutOption {
 	return func(opt *putOpt) {
 		opt.cas = cas
 	}
 }
 
+// KeepTTL makes a CAS keep the timeout of the current entry. It can not be
+// used along with options that set a timeout, nor without CAS.
+func KeepTTL() PutOption {
+	return func(opt *putOpt) {
+		opt.keepTTL = true
+	}
+}
+
+// ResetTTL makes a CAS replace the timeout of the current entry with the one
+// set by the options, or none (the entry will not expire). It can not be used
+// without CAS; a plain Put always does that.
+func ResetTTL() PutOption {
+	return func(opt *putOpt) {
+		opt.resetTTL = true
+	}
+}
+
+// CASMeta is like CAS, but the condition also gets the metadata of the
+// current entry (zero if not found). It can not be used along with CAS.
+func CASMeta(cas func(oldValue interface{}, meta Meta, found bool) bool) PutOption {
+	return func(opt *putOpt) {
+		opt.casMeta = cas
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+type storeOpt struct {
+	onExpire                 func(k string, v interface{})
+	onExpireBatch            func(shard int, expired map[string]interface{})
+	onExpireDetailed         func(k string, v interface{}, deadline, removedAt time.Time)
+	onExpireWithOrigin       func(origin, k string, v interface{})
+	synchronousNotifications bool
+	now                      func() time.Time
+	missFilterEntries        int
+	missFilterFPRate         float64
+	debug                    bool
+	customClock              bool
+	checksumValues           bool
+	onCorruption             func(k string)
+	coarseClockResolution    time.Duration
+	defaultSliding           bool
+	indexed                  bool
+	costFunc                 func(k string, v interface{}) int64
+	name                     string
+	registerGlobally         bool
+	onEvict                  func(k string, v interface{}, reason EvictReason)
+	memoryCheckEvery         time.Duration
+	memoryHighWatermark      uint64
+	memoryShedFraction       float64
+	memoryGauge              func() uint64
+	onBulkRemoval            func(op string, count int, sampleKeys []string)
+	perKeyBulkNotifications  bool
+	copyValues               func(interface{}) interface{}
+	wal                      io.Writer
+	walCodec                 ValueCodec
+	walMaxBytes              int64
+	walRotate                func(old io.Writer) io.Writer
+	readOptimized            bool
+	onPanic                  func(err error)
+	hotKeysK                 int
+	maxEntries               int
+	maxCost                  int64
+	minTTL                   time.Duration
+	rejectShortTTLs          bool
+	overflow                 OverflowStore
+	overflowCodec            ValueCodec
+	dropSlowExpiredStream    bool
+	streamAndCallbacks       bool
+	cardinalityWindow        time.Duration
+	cardinalityThreshold     int
+	onCardinalityAlarm       func(newKeys int)
+	backing                  Backing
+	writePolicy              WritePolicy
+	writeBehindBuffer        int
+	onBackingError           func(op, k string, err error)
+	sortedIteration          bool
+	onSweep                  func(shard, expired int, took time.Duration)
+	closeOnRemoval           bool
+	onCloseError             func(k string, err error)
+	quotas                   []*quota
+	quotaPolicy              QuotaPolicy
+	archiveSink              func(batch []ArchivedEntry) error
+	archiveReasons           []RemovalReason
+	archiveAttempts          int
+	archiveBackoff           time.Duration
+	archiveBuffer            int
+	onArchiveError           func(batch []ArchivedEntry, err error)
+	onSoftExpire             func(k string, v interface{})
+	onExpireE                func(k string, v interface{}) error
+	notifyAttempts           int
+	notifyBackoff            time.Duration
+	onNotifyDeadLetter       func(k string, v interface{}, lastErr error)
+	maxRetainAge             time.Duration
+	uniqueIndexes            []*uniqueIndex
+	uniquePolicy             UniquePolicy
+	idleFor                  time.Duration
+	onIdle                   func()
+	auditRate                float64
+	takeHandoff              HandoffPolicy
+	scrubPred                func(k string, v interface{}) bool
+	scrubEvery               time.Duration
+	executor                 func(task func())
+	removalConfirmWindow     time.Duration
+	suspendBacklog           int
+	manualJanitor            *Janitor
+	keyMapFactory            func() KeyMap
+	writeRateThreshold       int
+	writeRateWindow          time.Duration
+	onWriteRateAlarm         func(k string, writes int)
+	strict                   bool
+	stringValues             bool
+	migrateOnRead            migrateFunc
+	lagBudget                time.Duration
+	lagBacklog               int
+	onExpirationLag          func(worstLag time.Duration, backlog int)
+	overwriteWindow          time.Duration
+	onOverwrite              func(k string, age time.Duration)
+}
+
+// StoreOption extra options for the store
+type StoreOption func(*storeOpt)
+
+// OnExpire sets the function for expiration notifications (must be fast)
+func OnExpire(onExpire func(k string, v interface{})) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onExpire = onExpire
+	}
+}
+
+// OnExpireBatch sets the function for expiration notifications, that receives
+// all the entries expired together (by a sweep) in one call. The store is not
+// sharded, so shard is always 0.
+func OnExpireBatch(onExpireBatch func(shard int, expired map[string]interface{})) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onExpireBatch = onExpireBatch
+	}
+}
+
+// OnExpireDetailed sets the function for expiration notifications, that
+// also receives the deadline of the entry and the time it was actually
+// removed; the difference is the expiration lag.
+func OnExpireDetailed(onExpireDetailed func(k string, v interface{}, deadline, removedAt time.Time)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onExpireDetailed = onExpireDetailed
+	}
+}
+
+// OnExpireWithOrigin sets the function for expiration notifications, that
+// also receives the name of the store (see Name), so one function can serve
+// many stores
+func OnExpireWithOrigin(onExpireWithOrigin func(origin, k string, v interface{})) StoreOption {
+	return func(opt *storeOpt) {
+		opt.onExpireWithOrigin = onExpireWithOrigin
+	}
+}
+
+// SynchronousNotifications makes expiration notifications run inline, in the
+// goroutine that expired the entries (after the lock is released), instead of
+// a new goroutine. When ExpireNow returns, all notifications are delivered.
+// The onExpire function must be fast, since it delays the expiration loop.
+func SynchronousNotifications() StoreOption {
+	return func(opt *storeOpt) {
+		opt.synchronousNotifications = true
+	}
+}
+
+// Clock sets the function used for getting the current time, instead of time.Now
+// (mostly for tests). The expiration loop still runs on a real timer.
+func Clock(now func() time.Time) StoreOption {
+	return func(opt *storeOpt) {
+		opt.now = now
+	}
+}
+
+// MissFilter puts a bloom filter in front of Get, sized for expectedEntries
+// with the false positive rate fpRate, so Get returns a miss without taking
+// the lock, for keys that were never put.
+func MissFilter(expectedEntries int, fpRate float64) StoreOption {
+	return func(opt *storeOpt) {
+		opt.missFilterEntries = expectedEntries
+		opt.missFilterFPRate = fpRate
+	}
+}
+
+// DefaultSliding sets if entries are sliding, when IsSliding is not
+// provided to Put
+func DefaultSliding(isSliding bool) StoreOption {
+	return func(opt *storeOpt) {
+		opt.defaultSliding = isSliding
+	}
+}
+
+// Debug enables debugging facilities, like CheckInvariants
+func Debug() StoreOption {
+	return func(opt *storeOpt) {
+		opt.debug = true
+	}
+}
+
 //-----------------------------------------------------------------------------
 
-// store is a registry for values (like/is a concurrent map) with timeout and sliding timeout
-type store struct {
-	onExpire func(k string, v interface{})
+// Store is the KV of New and NewStore, a registry for values (like/is a
+// concurrent map) with timeout and sliding timeout. Besides the operations of
+// KV, it has those of lists, sets, leases, windows and the like, and the
+// methods to inspect and administer it.
+type Store struct {
+	seq        uint64 // the last write sequence; first, for 64-bit atomic alignment
+	lastActive int64  // of the last operation, in UnixNano, under OnIdle
+
+	storeOpt
 
 	stop               chan struct{}
 	stopOnce           sync.Once
+	intervalChanged    chan struct{}
+	kick               chan struct{}
+	paused             bool
+	lastSweep          time.Time
+	lastSweepDuration  time.Duration
+	lastTick           time.Time // of the expiration loop, even while paused
+	nextSweep          time.Time
 	expirationInterval time.Duration
 	mx                 sync.Mutex
-	kv                 map[string]*entry
+	kv                 keyMap
 	heap               th
+	preciseNow         func() time.Time
+	index              *keyIndex
+	walWritten         int64 // under walMx
+	walErr             error
+	walBuf             []byte     // records not written yet
+	walMx              sync.Mutex // one write of the log at a time, taken before mx
+	walReady           chan struct{}
+	walReplaying       bool
+	overflowErr        error
+	streams            []*expiredStream // copy on write
+	streamMx           sync.Mutex       // one batch of expired entries at a time
+	stats              Stats
+	mapPeak            int                 // entries, since the map was created
+	mapGen             int                 // incremented when the map is replaced
+	compacting         map[string]struct{} // keys changed during a Compact
+	filter             atomic.Value        // *bloom
+	filterDirty        int
+	read               atomic.Value // readMap
+	readMisses         int
+	readDirty          bool
+	hotKeys            *hotKeys
+	pendings           map[string][]*timeout // of PutAfter, by key
+	expectations       map[string][]*timeout // of ExpectWithin, by key
+	watchers           *watchRegistry
+	events             []queuedEvent
+	eventsReady        chan struct{}
+	dispatchOnce       sync.Once
+	bindings           map[parentRef]*binding
+	totalCost          int64 // of the entries, under MaxCost
+	cardinality        cardinalityWindow
+	swept              chan struct{} // closed and replaced on each sweep
+	expirationWaiters  map[string][]chan struct{}
+	profiles           map[string][]PutOption // of DefineProfile
+	writeBehind        chan backingWrite
+	writeBehindDone    chan struct{}
+	writeBehindMx      sync.RWMutex // writeBehindClosed, and sends to writeBehind
+	writeBehindClosed  bool
+	refreshes          map[string]*refresh // in flight, by key
+	refreshFailures    map[string]int      // the failed refreshes in a row, by key
+	closing            []closingValue      // under CloseOnRemoval
+	closingStopped     bool                // the dispatcher is gone
+	quotaByPrefix      map[string]*quota
+	archived           []ArchivedEntry // waiting for delivery
+	archiveReady       chan struct{}
+	archiveDone        chan struct{}
+	archiveStopped     bool // the goroutine of the archive is gone
+	evicting           bool // removals are evictions, for the archive
+	retries            []*failedNotification
+	retryReady         chan struct{}
+	retryStopped       bool                // the goroutine of the retries is gone
+	held               map[string][]*entry // removed, but retained, by key
+	retainedKeys       int                 // entries with holders
+	uniqueByName       map[string]*uniqueIndex
+	idleNotified       int64 // the lastActive OnIdle was called for
+	audit              ttlAudit
+	takeWaiters        map[string][]*takeWaiter // of TakeOrWait, by key
+	lastScrub          time.Time
+	removalTokens      map[string]removalToken // of ConfirmRemoval, by key
+	suspends           int                     // of SuspendNotifications, not resumed
+	suspended          []suspendedBatch
+	suspendedLen       int // expired entries in suspended
+	writeRate          writeRate
+	misuses            int64                 // atomic, of misuses without Strict
+	notifyingMx        sync.Mutex            // for notifying, without the lock
+	notifying          map[uint64]*notifying // by goroutine, under Strict
+	worstLag           time.Duration         // since the last check of OnExpirationLag
+	lagAlerted         time.Time             // the last call of OnExpirationLag
 }
 
-// New creates a new *store, onExpire is for notification (must be fast).
-func New(expirationInterval time.Duration, onExpire ...func(k string, v interface{})) KV {
+// New creates a new *Store, onExpire is for notification (must be fast).
+func New(expirationInterval time.Duration, onExpire ...func(k string, v interface{})) *Store {
+	var options []StoreOption
+	if len(onExpire) > 0 && onExpire[0] != nil {
+		options = append(options, OnExpire(onExpire[0]))
+	}
+	return NewStore(expirationInterval, options...)
+}
+
+// DefaultExpirationInterval is the expiration interval of a store created
+// with a non-positive one. It is not a default timeout: an entry put without
+// one never expires.
+const DefaultExpirationInterval = time.Second * 20
+
+// NewStore creates a new *Store, with provided options. A non-positive
+// expirationInterval means DefaultExpirationInterval.
+func NewStore(expirationInterval time.Duration, options ...StoreOption) *Store {
 	if expirationInterval <= 0 {
-		expirationInterval = time.Second * 20
+		expirationInterval = DefaultExpirationInterval
 	}
-	res := &store{
+	res := &Store{
 		stop:               make(chan struct{}),
-		kv:                 make(map[string]*entry),
+		intervalChanged:    make(chan struct{}, 1),
+		kick:               make(chan struct{}, 1),
+		eventsReady:        make(chan struct{}, 1),
+		walReady:           make(chan struct{}, 1),
+		swept:              make(chan struct{}),
 		expirationInterval: expirationInterval,
 		heap:               th{},
 	}
-	if len(onExpire) > 0 && onExpire[0] != nil {
-		res.onExpire = onExpire[0]
+	for _, opt := range options {
+		opt(&res.storeOpt)
+	}
+	res.kv = res.newKeyMap(0)
+	res.customClock = res.now != nil
+	if res.now == nil {
+		res.now = time.Now
+	}
+	res.preciseNow = res.now
+	if res.coarseClockResolution > 0 {
+		c := newCoarseClock(res.preciseNow)
+		res.now = c.Now
+		go c.run(res.coarseClockResolution, res.stop)
+	}
+	if res.indexed {
+		res.index = newKeyIndex()
+	}
+	if res.hotKeysK > 0 {
+		res.hotKeys = newHotKeys(res.hotKeysK)
+	}
+	for _, q := range res.quotas {
+		res.setQuota(q)
+	}
+	for _, ix := range res.uniqueIndexes {
+		if res.uniqueByName == nil {
+			res.uniqueByName = make(map[string]*uniqueIndex)
+		}
+		ix.reset()
+		res.uniqueByName[ix.name] = ix
+	}
+	if res.missFilterEntries > 0 {
+		res.filter.Store(newBloom(res.missFilterEntries, res.missFilterFPRate))
+	}
+	res.nextSweep = res.preciseNow().Add(expirationInterval)
+	res.lastTick = res.preciseNow()
+	res.lastScrub = res.now()
+	res.active()
+	if res.manualJanitor != nil {
+		res.manualJanitor.kv = res
+	} else {
+		go res.expireLoop()
+	}
+	if res.walCodec != nil {
+		go res.walLoop()
+	}
+	res.startBacking()
+	res.startArchive()
+	res.startNotifyRetry()
+	if res.closeOnRemoval {
+		res.dispatchOnce.Do(func() { go res.dispatchLoop() })
+	}
+	if res.memoryCheckEvery > 0 {
+		if res.memoryGauge == nil {
+			res.memoryGauge = heapAlloc
+		}
+		go res.memoryLoop()
+	}
+	if res.registerGlobally {
+		_ = Register(res.name, res)
 	}
-	go res.expireLoop()
 	return res
 }
 
 // Stop stops the goroutine
-func (kv *store) Stop() {
-	kv.stopOnce.Do(func() { close(kv.stop) })
+func (kv *Store) Stop() {
+	kv.stopOnce.Do(func() {
+		close(kv.stop)
+		kv.dropAllPending()
+		kv.dropAllExpectations()
+		kv.closeStreams()
+		kv.unbindAll()
+		kv.stopBacking()
+		kv.cancelRefreshes()
+		kv.stopArchive()
+		if kv.walCodec != nil {
+			kv.walFlush()
+		}
+		if kv.registerGlobally {
+			deregister(kv.name, kv)
+		}
+	})
 }
 
-// Delete deletes an entry
-func (kv *store) Delete(k string) {
+// Touch slides the entry (if it is sliding or has an idle timeout), like a Get
+func (kv *Store) Touch(k string) bool {
+	kv.active()
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e != nil {
+		kv.slide(e)
+	}
+	kv.mx.Unlock()
+	kv.notify(expired)
+	return e != nil
+}
+
+// SetExpirationInterval changes the expiration interval; it takes effect
+// immediately, without waiting for the current interval to pass.
+func (kv *Store) SetExpirationInterval(d time.Duration) error {
+	if d <= 0 {
+		return ErrInvalidInterval
+	}
+	kv.mx.Lock()
+	kv.expirationInterval = d
+	kv.mx.Unlock()
+	select {
+	case kv.intervalChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// PauseExpiration stops expiring entries, both by the expiration loop and
+// lazily (by Get, Take, etc), until ResumeExpiration is called.
+// Entries past their deadline remain readable, and sliding entries still slide.
+func (kv *Store) PauseExpiration() {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	kv.paused = true
+}
+
+// ResumeExpiration resumes expiring entries, and sweeps the overdue ones promptly.
+func (kv *Store) ResumeExpiration() {
+	kv.mx.Lock()
+	kv.paused = false
+	kv.mx.Unlock()
+	kv.KickJanitor()
+}
+
+func (kv *Store) getExpirationInterval() time.Duration {
 	kv.mx.Lock()
 	defer kv.mx.Unlock()
-	delete(kv.kv, k)
+	return kv.expirationInterval
+}
+
+// Delete deletes an entry
+func (kv *Store) Delete(k string) {
+	kv.active()
+	kv.mx.Lock()
+	kv.activateDue(k)
+	if kv.isReadOnly(k) || kv.isProtected(k) {
+		kv.mx.Unlock()
+		return
+	}
+	if _, ok := kv.kv.get(k); !ok {
+		kv.overflowDrop(k)
+	}
+	kv.remove(k)
+	kv.mx.Unlock()
+	kv.backingRemoved(k)
+}
+
+// DeleteE deletes an entry; it returns ErrNotFound if there is no entry
+// for k, and ErrExpired if the entry was expired (and not yet swept).
+func (kv *Store) DeleteE(k string) (err error) {
+	defer wrapOp(&err, "delete", k)
+	kv.active()
+	err = kv.deleteE(k)
+	if err == ErrReadOnly || err == ErrProtected {
+		return err
+	}
+	if backingErr := kv.backingRemove(k); backingErr != nil {
+		return backingErr
+	}
+	return err
+}
+
+func (kv *Store) deleteE(k string) error {
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e != nil && e.readOnly {
+		kv.mx.Unlock()
+		return ErrReadOnly
+	}
+	if e != nil && e.protected {
+		kv.mx.Unlock()
+		return ErrProtected
+	}
+	if e == nil && expired == nil && kv.overflow != nil {
+		_, err := kv.overflowTake(k)
+		kv.mx.Unlock()
+		return err
+	}
+	if e != nil {
+		kv.remove(k)
+	}
+	kv.mx.Unlock()
+	kv.notify(expired)
+	if e == nil {
+		return lookupErr(expired)
+	}
+	return nil
 }
 
 // Get gets an entry from KV store
 // and if a sliding timeout is set, it will be slided
-func (kv *store) Get(k string) (interface{}, bool) {
+func (kv *Store) Get(k string) (interface{}, bool) {
+	v, err := kv.get(k)
+	return v, err == nil
+}
+
+// GetE is like Get, but returns ErrNotFound if there is no entry for k,
+// and ErrExpired if the entry was expired (and not yet swept).
+func (kv *Store) GetE(k string) (v interface{}, err error) {
+	v, err = kv.get(k)
+	return v, opError("get", k, err)
+}
+
+func (kv *Store) get(k string) (interface{}, error) {
+	v, _, _, err := kv.getValue(k, false)
+	return v, err
+}
+
+// getValue is get; with unbox, if the entry is in a stringKeyMap, it returns
+// its string as s, and unboxed true, instead of v
+func (kv *Store) getValue(k string, unbox bool) (v interface{}, s string, unboxed bool, err error) {
+	kv.active()
+	if v, ok := kv.readGet(k); ok {
+		return v, "", false, nil
+	}
+	if kv.overflow == nil && kv.backing == nil && kv.filterMiss(k) {
+		return nil, "", false, ErrNotFound
+	}
 	kv.mx.Lock()
-	defer kv.mx.Unlock()
+	kv.readMiss()
+	e, expired := kv.lookup(k)
+	if e == nil && expired == nil && kv.overflow != nil {
+		v, evicted, err := kv.unspill(k)
+		kv.mx.Unlock()
+		kv.notifyCapacityEvictions(evicted)
+		if err == ErrNotFound && kv.backing != nil {
+			v, err = kv.load(k)
+		}
+		return v, "", false, err
+	}
+	if e == nil {
+		kv.mx.Unlock()
+		kv.notify(expired)
+		if kv.backing != nil {
+			v, err = kv.load(k)
+			return v, "", false, err
+		}
+		return nil, "", false, lookupErr(expired)
+	}
+	if !kv.verify(k, e) {
+		kv.mx.Unlock()
+		kv.notifyCorruption(k)
+		return nil, "", false, ErrCorrupted
+	}
+	if v, ok := kv.migrateRead(k, e); !ok {
+		kv.mx.Unlock()
+		kv.notifyEvictions(map[string]interface{}{k: v}, EvictMigratedOut)
+		return nil, "", false, ErrNotFound
+	}
+	kv.slide(e)
+	kv.hotKeys.hit(k)
+	kv.auditRead(e)
+	if m, ok := kv.kv.(stringKeyMap); ok && unbox {
+		s = m[k].s
+		kv.mx.Unlock()
+		return nil, s, true, nil
+	}
+	v = kv.copyValue(e.value)
+	kv.mx.Unlock()
+	return v, "", false, nil
+}
 
-	e, ok := kv.kv[k]
+// Put puts an entry inside kv store with provided options
+func (kv *Store) Put(k string, v interface{}, options ...PutOption) (err error) {
+	defer wrapOp(&err, "put", k)
+	kv.active()
+	return kv.put(k, v, options, false)
+}
+
+// CAS puts an entry if cond, called with the current value (and if it was
+// found), returns true; otherwise ErrCASCond is returned. On success, the
+// entry slides, like a Get. If the entry exists, its timeout is replaced when
+// the options set one, and kept otherwise; KeepTTL and ResetTTL change that.
+func (kv *Store) CAS(k string, v interface{}, cond func(oldValue interface{}, found bool) bool, options ...PutOption) (err error) {
+	defer wrapOp(&err, "cas", k)
+	kv.active()
+	opt := kv.putOptions(options)
+	if opt.cas != nil || opt.casMeta != nil {
+		return errors.Wrap(ErrInvalidOptions, "CAS options passed to the CAS method")
+	}
+	opt.cas = cond
+	return kv.putWith(k, v, opt, false)
+}
+
+func (kv *Store) put(k string, v interface{}, options []PutOption, force bool) error {
+	return kv.putWith(k, v, kv.putOptions(options), force)
+}
+
+func (kv *Store) putWith(k string, v interface{}, opt *putOpt, force bool) error {
+	if !opt.loaded {
+		kv.checkPut(k, opt)
+	}
+	if err := opt.validate(); err != nil {
+		return err
+	}
+	if err := kv.checkString(v); err != nil {
+		return err
+	}
+	if kv.handOff(k, v, opt) {
+		return nil
+	}
+	if kv.backing != nil && !opt.loaded {
+		opt.loaded = true
+		if err := kv.putWith(k, v, opt, force); err != nil {
+			return err
+		}
+		return kv.backingStore(k, v)
+	}
+	if opt.boundTo != nil {
+		return kv.putBound(k, v, opt, force)
+	}
+	kv.mx.Lock()
+	if opt.binding != nil && opt.binding.done {
+		kv.mx.Unlock()
+		return errBindingDone
+	}
+	kv.activateDue(k)
+	if !force && kv.isReadOnly(k) {
+		kv.mx.Unlock()
+		return ErrReadOnly
+	}
+	e := kv.newEntry(k, kv.copyValue(v), opt)
+	if opt.cas == nil && opt.casMeta == nil {
+		var evicted *bulkRemoval
+		err := kv.checkUnique(k, e.value)
+		if err == nil {
+			evicted, err = kv.makeRoom(k, e.value, !opt.noEvict)
+		}
+		if err != nil {
+			if e.timeout != nil {
+				e.timeout.stale = true
+			}
+			e.dropSoft()
+			kv.stats.RejectedPuts++
+			kv.mx.Unlock()
+			return err
+		}
+		kv.set(k, e)
+		err = kv.pendingError()
+		kv.mx.Unlock()
+		err = kv.walSync(err)
+		kv.notifyCapacityEvictions(evicted)
+		return err
+	}
+	old, expired := kv.lookup(k)
+	cond := opt.cas
+	if opt.casMeta != nil {
+		var meta Meta
+		if old != nil {
+			meta = old.meta(kv.now())
+		}
+		cond = func(v interface{}, found bool) bool {
+			return opt.casMeta(v, meta, found)
+		}
+	}
+	var evicted *bulkRemoval
+	var roomErr error
+	if kv.maxEntries > 0 || kv.maxCost > 0 || len(kv.quotaByPrefix) > 0 || len(kv.uniqueByName) > 0 {
+		casCond := cond
+		cond = func(v interface{}, found bool) bool {
+			if !casCond(v, found) {
+				return false
+			}
+			if roomErr = kv.checkUnique(k, e.value); roomErr != nil {
+				return false
+			}
+			evicted, roomErr = kv.makeRoom(k, e.value, !opt.noEvict)
+			return roomErr == nil
+		}
+	}
+	err := kv.cas(k, old, e, cond, opt)
+	if roomErr != nil {
+		err = roomErr
+		kv.stats.RejectedPuts++
+	}
+	if err == nil {
+		err = kv.pendingError()
+	}
+	kv.mx.Unlock()
+	err = kv.walSync(err)
+	kv.notify(expired)
+	kv.notifyCapacityEvictions(evicted)
+	return err
+}
+
+// set puts e in the map, marking the timeout of the replaced entry as stale
+func (kv *Store) set(k string, e *entry) {
+	old, ok := kv.kv.get(k)
+	if ok && old.timeout != nil && old.timeout != e.timeout {
+		old.timeout.stale = true
+	}
+	if ok && old.softNode != e.softNode {
+		old.dropSoft()
+	}
+	if ok && old.bound != e.bound {
+		old.unbind(k)
+	}
+	if e.bound != nil {
+		e.bound.keys[k] = struct{}{}
+	}
+	var oldCost int64
+	if ok {
+		oldCost = old.cost
+		e.refs = old.refs
+	}
 	if !ok {
-		return nil, ok
+		kv.overflowDrop(k)
+		kv.countNewKey(k)
+		kv.countQuota(k, 1, 0)
 	}
-	e.slide()
-	if e.expired() {
-		go notifyExpirations(map[string]interface{}{k: e.value}, kv.onExpire)
-		delete(kv.kv, k)
-		return nil, false
+	if ok && old != e {
+		kv.closeReplaced(k, old.value, e.value)
+		kv.auditRemoved(old)
+	}
+	kv.auditTrack(k, e)
+	kv.countWrite(k)
+	if ok {
+		kv.countOverwrite(k, old, e)
+	} else if kv.overwriteWindow > 0 {
+		e.writtenAt = kv.now()
+	}
+	kv.account(k, e, oldCost)
+	switch {
+	case old == e:
+		e.revision++
+	case ok:
+		e.revision = old.revision + 1
+	default:
+		e.revision = 1
+	}
+	e.seq = atomic.AddUint64(&kv.seq, 1)
+	if ok && old != e && !kv.expired(old) {
+		e.history = carryHistory(old.history, e.history)
 	}
-	return e.value, ok
+	kv.recordHistory(e)
+	kv.kv.set(k, e)
+	if kv.kv.len() > kv.mapPeak {
+		kv.mapPeak = kv.kv.len()
+	}
+	kv.index.add(k, e.timeout != nil && e.timeout.grace > 0)
+	kv.changed(k)
+	kv.readInvalidate(k)
+	kv.walPut(k, e)
+	kv.emit(EventPut, k, e)
+	kv.fulfill(k)
+	kv.indexUnique(k, e)
+	kv.wakeTaker(k)
 }
 
-// Put puts an entry inside kv store with provided options
-func (kv *store) Put(k string, v interface{}, options ...PutOption) error {
+// modified records an in-place change of the value of e
+func (kv *Store) modified(k string, e *entry) {
+	if m, ok := kv.kv.(stringKeyMap); ok {
+		m.set(k, e)
+	}
+	e.revision++
+	e.seq = atomic.AddUint64(&kv.seq, 1)
+	kv.account(k, e, e.cost)
+	kv.readInvalidate(k)
+	kv.walPut(k, e)
+	kv.emit(EventPut, k, e)
+	kv.fulfill(k)
+	kv.indexUnique(k, e)
+}
+
+// putOptions applies the options, on top of the store defaults
+func (kv *Store) putOptions(options []PutOption) *putOpt {
 	opt := &putOpt{}
-	for _, v := range options {
-		v(opt)
+	opt.apply(options)
+	opt = kv.expandProfile(opt, options)
+	if !opt.hasIsSliding {
+		opt.isSliding = kv.defaultSliding
 	}
+	kv.resolveBoundary(opt)
+	kv.floorTTL(opt)
+	return opt
+}
+
+// apply applies the options. A nil option, or one that panics, would crash
+// the caller; the first one is recorded instead, for validate to report.
+func (opt *putOpt) apply(options []PutOption) {
+	for i, o := range options {
+		if o == nil {
+			opt.badOption(fmt.Sprintf("nil PutOption at %d", i))
+			continue
+		}
+		opt.applyOne(i, o)
+	}
+}
+
+func (opt *putOpt) applyOne(i int, o PutOption) {
+	defer func() {
+		if e := recover(); e != nil {
+			opt.badOption(fmt.Sprintf("PutOption at %d panicked: %v", i, e))
+		}
+	}()
+	o(opt)
+}
+
+func (opt *putOpt) badOption(problem string) {
+	if opt.invalid == "" {
+		opt.invalid = problem
+	}
+}
+
+// validate returns ErrInvalidOptions for contradictory or meaningless
+// options, which would otherwise be silently ignored
+func (opt *putOpt) validate() error {
+	var problem string
+	switch {
+	case opt.invalid != "":
+		problem = opt.invalid
+	case opt.expiresAfter < 0:
+		problem = "negative ExpiresAfter"
+	case opt.idleTimeout < 0:
+		problem = "negative IdleTimeout"
+	case opt.hasMaxSlides && opt.maxSlides < 0:
+		problem = "negative MaxSlides"
+	case opt.cas != nil && opt.casMeta != nil:
+		problem = "both CAS and CASMeta"
+	case opt.belowMinTTL:
+		problem = "a timeout below MinTTL"
+	case opt.keepTTL && opt.resetTTL:
+		problem = "both KeepTTL and ResetTTL"
+	case (opt.keepTTL || opt.resetTTL) && opt.cas == nil && opt.casMeta == nil:
+		problem = "KeepTTL or ResetTTL without CAS"
+	case opt.keepTTL && (opt.expiresAfter > 0 || opt.idleTimeout > 0 || !opt.expiresAt.IsZero()):
+		problem = "KeepTTL along with a new timeout"
+	case opt.hasIsSliding && opt.isSliding && opt.expiresAfter == 0:
+		problem = "IsSliding without ExpiresAfter"
+	case opt.hasMaxSlides && !(opt.isSliding && opt.expiresAfter > 0) && opt.idleTimeout <= 0:
+		problem = "MaxSlides on an entry that does not slide"
+	case opt.boundTo != nil && opt.boundTo.kv == nil:
+		problem = "BoundTo a nil parent"
+	case opt.boundary != 0 && !opt.boundary.valid():
+		problem = "an unknown Boundary"
+	case opt.boundary != 0 && opt.boundaryLoc == nil:
+		problem = "ExpiresAtNext without a location"
+	case opt.grace < 0:
+		problem = "negative Grace"
+	case opt.softAfter < 0:
+		problem = "negative SoftExpiresAfter"
+	case opt.softAfter > 0 && opt.expiresAfter > 0 && opt.softAfter >= opt.expiresAfter:
+		problem = "SoftExpiresAfter not before ExpiresAfter"
+	case opt.hasHistory && (opt.historyDepth < 1 || opt.historyDepth > MaxHistoryDepth):
+		problem = "History depth out of range"
+	default:
+		return nil
+	}
+	return errors.Wrap(ErrInvalidOptions, problem)
+}
+
+func (kv *Store) newEntry(k string, v interface{}, opt *putOpt) *entry {
 	e := &entry{
-		value: v,
+		value:     v,
+		readOnly:  opt.readOnly,
+		protected: opt.protected,
+		priority:  opt.priority,
+		bound:     opt.binding,
 	}
-	kv.mx.Lock()
-	defer kv.mx.Unlock()
-	if opt.expiresAfter > 0 {
-		e.timeout = newTimeout(k, opt.expiresAfter, opt.isSliding)
+	if opt.hasHistory {
+		e.history = newHistory(opt.historyDepth)
+	}
+	if kv.checksumValues {
+		e.checksum, e.hasChecksum = checksum(v)
+	}
+	if kv.archiveSink != nil {
+		e.createdAt = kv.now()
+	}
+	kv.filterAdd(k)
+	now := kv.now()
+	if !opt.activatedAt.IsZero() {
+		now = opt.activatedAt
+	}
+	if opt.softAfter > 0 {
+		kv.newSoftDeadline(k, e, now, opt.softAfter)
+	}
+	if opt.expiresAfter > 0 || opt.idleTimeout > 0 || !opt.expiresAt.IsZero() {
+		e.timeout = newTimeout(now, k, opt.expiresAfter, opt.isSliding, opt.idleTimeout)
+		if !opt.expiresAt.IsZero() {
+			if opt.idleTimeout > 0 {
+				e.timeout.deadline = opt.expiresAt
+				e.timeout.expiresAt = e.timeout.capped(now.Add(opt.idleTimeout))
+			} else {
+				e.timeout.expiresAt = opt.expiresAt
+			}
+		}
+		if opt.hasMaxSlides && opt.maxSlides >= 0 {
+			e.timeout.slidesLeft = opt.maxSlides
+		}
+		e.timeout.grace = opt.grace
 		timeheapPush(&kv.heap, e.timeout)
 	}
-	if opt.cas != nil {
-		return kv.cas(k, e, opt.cas)
+	return e
+}
+
+// slide slides the timeout of e (if it is sliding) and fixes its place in the heap
+func (kv *Store) slide(e *entry) {
+	if e.timeout == nil {
+		return
+	}
+	e.timeout.slide(kv.now())
+	to := e.timeout
+	if to.index < 0 || to.index >= len(kv.heap) || kv.heap[to.index] != to {
+		return
 	}
-	kv.kv[k] = e
-	return nil
+	timeheapFix(&kv.heap, to.index)
 }
 
-func (kv *store) cas(k string, e *entry, casFunc func(interface{}, bool) bool) error {
-	old, ok := kv.kv[k]
+// remove deletes the entry for k
+func (kv *Store) remove(k string) {
+	e, ok := kv.kv.get(k)
+	if ok && e.timeout != nil {
+		e.timeout.stale = true
+	}
+	if ok {
+		e.dropSoft()
+		kv.auditRemoved(e)
+		if e.refs > 0 {
+			kv.hold(k, e)
+		} else {
+			kv.emitRemoved(k, e)
+			kv.archive(k, e)
+			if !e.keepOpen {
+				kv.closeRemoved(k, e.value)
+			}
+		}
+		e.unbind(k)
+		kv.totalCost -= e.cost
+		kv.countQuota(k, -1, -e.cost)
+		kv.unindexUnique(k)
+		kv.dropRemovalToken(k)
+	}
+	kv.kv.delete(k)
+	kv.changed(k)
+	kv.filterRemoved()
+	kv.index.remove(k)
+	kv.readInvalidate(k)
+	kv.walDelete(k)
+}
+
+// captured returns a copy of e and its timeout, taken under the lock when e
+// is removed as expired. The notifications read the copy, so the key, value
+// and deadline they report are those the entry had when it expired, whatever
+// happens to e later.
+func (e *entry) captured() *entry {
+	c := *e
+	if e.timeout != nil {
+		to := *e.timeout
+		c.timeout = &to
+	}
+	return &c
+}
+
+// expired reports if e is expired; while expiration is paused, nothing expires
+func (kv *Store) expired(e *entry) bool {
+	return !kv.paused && e.expired(kv.now())
+}
+
+// lookup finds the live entry for k. An expired entry gets deleted and
+// returned in expired, for notification (after releasing the lock).
+func (kv *Store) lookup(k string) (e *entry, expired map[string]*entry) {
+	kv.activateDue(k)
+	e, ok := kv.kv.get(k)
+	if !ok {
+		return nil, nil
+	}
+	if kv.expired(e) {
+		if kv.inGrace(e) {
+			return nil, nil
+		}
+		if e.refs > 0 { // held, notified on its release
+			kv.remove(k)
+			return nil, map[string]*entry{}
+		}
+		e.keepOpen = true // until notified
+		kv.remove(k)
+		return nil, map[string]*entry{k: e.captured()}
+	}
+	return e, nil
+}
+
+// lookupErr is the error for a failed lookup
+func lookupErr(expired map[string]*entry) error {
+	if expired != nil {
+		return ErrExpired
+	}
+	return ErrNotFound
+}
+
+func (kv *Store) cas(k string, old, e *entry, casFunc func(interface{}, bool) bool, opt *putOpt) error {
+	ok := old != nil
 	var oldValue interface{}
-	if ok && old != nil {
+	if ok {
 		oldValue = old.value
 	}
 	if !casFunc(oldValue, ok) {
+		if e.timeout != nil {
+			e.timeout.stale = true
+		}
+		e.dropSoft()
 		return ErrCASCond
 	}
-	if ok && old != nil {
-		if e.timeout != nil {
+	if ok {
+		switch {
+		case opt.keepTTL:
+			if e.timeout != nil {
+				e.timeout.stale = true
+			}
+			e.dropSoft()
+		case opt.resetTTL || e.timeout != nil:
+			if old.timeout != nil {
+				old.timeout.stale = true
+			}
 			old.timeout = e.timeout
 		}
+		if !opt.keepTTL && (opt.resetTTL || e.timeout != nil || e.softNode != nil) {
+			old.dropSoft()
+			old.softNode = e.softNode
+		}
+		kv.closeReplaced(k, old.value, e.value)
 		old.value = e.value
+		old.checksum, old.hasChecksum = e.checksum, e.hasChecksum
+		old.readOnly = e.readOnly
+		old.protected = e.protected
+		old.priority = e.priority
+		old.history = carryHistory(old.history, e.history)
+		if old.bound != e.bound {
+			old.unbind(k)
+			old.bound = e.bound
+		}
 		e = old
 	}
-	e.slide()
-	kv.kv[k] = e
+	kv.slide(e)
+	kv.set(k, e)
 	return nil
 }
 
 // Take takes an entry out of kv store
-func (kv *store) Take(k string) (interface{}, bool) {
+func (kv *Store) Take(k string) (interface{}, bool) {
+	v, err := kv.take(k)
+	return v, err == nil
+}
+
+// TakeE is like Take, but returns ErrNotFound if there is no entry for k,
+// and ErrExpired if the entry was expired (and not yet swept).
+func (kv *Store) TakeE(k string) (v interface{}, err error) {
+	v, err = kv.take(k)
+	return v, opError("take", k, err)
+}
+
+func (kv *Store) take(k string) (interface{}, error) {
+	kv.active()
 	kv.mx.Lock()
-	defer kv.mx.Unlock()
-	e, ok := kv.kv[k]
-	if ok {
-		delete(kv.kv, k)
-		return e.value, ok
+	e, expired := kv.lookup(k)
+	if e != nil && e.readOnly {
+		kv.mx.Unlock()
+		return nil, ErrReadOnly
+	}
+	if e != nil && e.protected {
+		kv.mx.Unlock()
+		return nil, ErrProtected
+	}
+	if e == nil && expired == nil && kv.overflow != nil {
+		v, err := kv.overflowTake(k)
+		kv.mx.Unlock()
+		return v, err
+	}
+	if e != nil {
+		e.keepOpen = true // it goes to the caller
+		kv.remove(k)
+	}
+	kv.mx.Unlock()
+	kv.notify(expired)
+	if e == nil {
+		return nil, lookupErr(expired)
+	}
+	kv.backingRemoved(k)
+	if !e.verify() {
+		kv.notifyCorruption(k)
+		return nil, ErrCorrupted
 	}
-	return nil, ok
+	return e.value, nil
 }
 
 //-----------------------------------------------------------------------------
 
-func (kv *store) expireLoop() {
-	interval := kv.expirationInterval
+func (kv *Store) expireLoop() {
+	interval := kv.getExpirationInterval()
 	expireTime := time.NewTimer(interval)
 	for {
 		select {
 		case <-kv.stop:
 			return
+		case <-kv.kick:
+			kv.sweep()
+		case <-kv.intervalChanged:
+			interval = kv.getExpirationInterval()
+			if !expireTime.Stop() {
+				select {
+				case <-expireTime.C:
+				default:
+				}
+			}
+			expireTime.Reset(interval)
+			kv.setNextSweep(interval)
 		case <-expireTime.C:
-			v := kv.expireFunc()
+			v := kv.sweep()
 			if v < 0 {
 				v = -1 * v
 			}
-			if v > 0 && v <= kv.expirationInterval {
+			if v > 0 && v <= kv.getExpirationInterval() {
 				interval = (2*interval + v) / 3 // good enough history
 			}
 			if interval <= 0 {
 				interval = time.Millisecond
 			}
 			expireTime.Reset(interval)
+			kv.setNextSweep(interval)
 		}
 	}
 }
 
-func (kv *store) expireFunc() time.Duration {
+// ExpireNow runs the expiration process immediately (unless expiration is paused)
+func (kv *Store) ExpireNow() {
+	_, expired, due := kv.expireFunc()
+	kv.notify(expired)
+	kv.notifyDue(due)
+}
+
+// expireFunc removes the expired entries, and takes out the expectations
+// (ExpectWithin) and soft deadlines that passed, for notification. It works in
+// chunks of sweepChunk due heap nodes, each one in two short critical
+// sections (claim, then execute), so writers interleave with a long sweep.
+func (kv *Store) expireFunc() (time.Duration, map[string]*entry, []*timeout) {
 	kv.mx.Lock()
-	defer kv.mx.Unlock()
+	if kv.paused {
+		kv.mx.Unlock()
+		return 0, nil, nil
+	}
+	start := kv.preciseNow()
+	now := kv.now()
+	kv.mx.Unlock()
 
+	expired := make(map[string]*entry)
+	var due []*timeout
+	for {
+		condemned, more := kv.claim(now, &due)
+		kv.execute(condemned, now, expired)
+		if !more {
+			break
+		}
+	}
+
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
 	var interval time.Duration
-	if len(kv.heap) == 0 {
-		return interval
+	if len(kv.heap) > 0 {
+		next := kv.heap[0]
+		interval = next.removeAt().Sub(kv.preciseNow())
+		if interval < 0 {
+			interval = next.expiresAfter
+		}
 	}
-	expired := make(map[string]interface{})
-	c := -1
-	for {
+	kv.finishOverdue(now, expired)
+	kv.lastSweep = start
+	kv.lastSweepDuration = kv.preciseNow().Sub(start)
+	return interval, expired, due
+}
+
+// sweepChunk is the number of due heap nodes a sweep handles per critical
+// section
+const sweepChunk = 256
+
+// condemnedEntry is an entry claimed by a sweep, with its timeout out of the
+// heap; it is removed only if it did not change meanwhile
+type condemnedEntry struct {
+	key      string
+	e        *entry
+	to       *timeout
+	revision uint64
+}
+
+// claim pops up to sweepChunk due heap nodes, under the lock: the entries
+// they expire are returned (and marked) as condemned, pending puts are
+// activated, and expectations and soft deadlines are added to due, for
+// notification. more reports if there may be more due nodes.
+func (kv *Store) claim(now time.Time, due *[]*timeout) (condemned []condemnedEntry, more bool) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	if kv.paused {
+		return nil, false
+	}
+	for n := 0; n < sweepChunk; n++ {
 		if len(kv.heap) == 0 {
-			break
+			return condemned, false
 		}
-		c++
-		if c >= len(kv.heap) {
-			break
+		next := kv.heap[0]
+		if !next.stale && !next.due(now) {
+			return condemned, false
 		}
-		last := kv.heap[0]
-		entry, ok := kv.kv[last.key]
-		if !ok {
+		switch {
+		case next.stale:
 			timeheapPop(&kv.heap)
-			continue
-		}
-		if !last.expired() {
-			interval = last.expiresAt.Sub(time.Now())
-			if interval < 0 {
-				interval = last.expiresAfter
+		case next.pending != nil:
+			kv.activate(next)
+		case next.expect != nil:
+			timeheapPop(&kv.heap)
+			kv.missed(next)
+			*due = append(*due, next)
+		case next.soft != nil:
+			timeheapPop(&kv.heap)
+			if kv.softExpired(next) {
+				*due = append(*due, next)
 			}
-			break
-		}
-		last = timeheapPop(&kv.heap)
-		if ok {
-			expired[last.key] = entry.value
+		default:
+			timeheapPop(&kv.heap)
+			e, ok := kv.kv.get(next.key)
+			if !ok || e.timeout != next {
+				continue
+			}
+			e.condemned = true
+			kv.index.condemn(next.key)
+			condemned = append(condemned, condemnedEntry{key: next.key, e: e, to: next, revision: e.revision})
 		}
 	}
-REVAL:
-	for k := range expired {
-		newVal, ok := kv.kv[k]
-		if !ok ||
-			newVal.timeout == nil ||
-			!newVal.expired() {
-			delete(expired, k)
-			goto REVAL
-		}
-		delete(kv.kv, k)
+	return condemned, true
+}
+
+// execute removes the condemned entries that are still the same (not put
+// again, nor slid) and expired, under the lock; the others get their
+// timeouts back in the heap. A write between claim and execute wins: the
+// entry it put is another instance, or the same one (CAS) at another
+// revision, so it is never removed, nor notified, by the sweep.
+func (kv *Store) execute(condemned []condemnedEntry, now time.Time, expired map[string]*entry) {
+	if len(condemned) == 0 {
+		return
 	}
-	go notifyExpirations(expired, kv.onExpire)
-	if interval == 0 && len(kv.heap) > 0 {
-		last := kv.heap[0]
-		interval = last.expiresAt.Sub(time.Now())
-		if interval < 0 {
-			interval = last.expiresAfter
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	i := 0
+	// a panic, from a writer or hook called by remove, leaves the entries
+	// not yet removed without their timeouts; they go back to the heap
+	defer func() {
+		for ; i < len(condemned); i++ {
+			kv.reprieve(condemned[i])
+		}
+	}()
+	for ; i < len(condemned); i++ {
+		c := condemned[i]
+		e, ok := kv.kv.get(c.key)
+		if !kv.paused && ok && e == c.e && e.revision == c.revision && e.timeout == c.to && c.to.due(now) {
+			e.condemned = false
+			if e.refs == 0 { // a held entry is notified on its release
+				e.keepOpen = true // until notified
+				expired[c.key] = e.captured()
+			}
+			kv.remove(c.key)
+			continue
 		}
+		kv.reprieve(c)
+	}
+}
+
+// reprieve puts the timeout of a condemned entry back in the heap, if it is
+// still the timeout of the entry
+func (kv *Store) reprieve(c condemnedEntry) {
+	c.e.condemned = false
+	kv.index.acquit(c.key)
+	if e, ok := kv.kv.get(c.key); ok && e.timeout == c.to && !c.to.stale && c.to.index < 0 {
+		timeheapPush(&kv.heap, c.to)
 	}
-	return interval
 }
 
-func notifyExpirations(
-	expired map[string]interface{},
-	onExpire func(k string, v interface{})) {
-	if onExpire == nil {
+func (kv *Store) notify(expired map[string]*entry) {
+	if len(expired) == 0 {
+		return
+	}
+	removedAt := kv.now()
+	kv.recordLag(expired, removedAt)
+	if kv.suspend(expired, removedAt) {
 		return
 	}
-	for k, v := range expired {
-		k, v := k, v
+	kv.deliverExpired(expired, removedAt, kv.synchronousNotifications)
+}
+
+// deliverExpired sends the notifications of expired, in this goroutine if inline
+func (kv *Store) deliverExpired(expired map[string]*entry, removedAt time.Time, inline bool) {
+	if kv.streamExpired(expired, removedAt) {
+		kv.dispatched(expired)
+		return
+	}
+	if kv.onExpire == nil && kv.onExpireBatch == nil && kv.onExpireDetailed == nil && kv.onExpireWithOrigin == nil && kv.onExpireE == nil {
+		kv.dispatched(expired)
+		return
+	}
+	if inline {
+		defer kv.dispatched(expired)
+		kv.deliverInline(func() { kv.notifyExpirations(expired, removedAt) })
+		return
+	}
+	kv.spawn(func() {
+		kv.notifyExpirations(expired, removedAt)
+		kv.dispatched(expired)
+	})
+}
+
+func (kv *Store) notifyExpirations(expired map[string]*entry, removedAt time.Time) {
+	if kv.onExpireBatch != nil {
+		batch := make(map[string]interface{}, len(expired))
+		for k, e := range expired {
+			batch[k] = e.value
+		}
 		try(func() error {
-			onExpire(k, v)
+			kv.onExpireBatch(0, batch)
 			return nil
 		})
 	}
+	for _, k := range byPriority(expired) {
+		k, e := k, expired[k]
+		if kv.onExpire != nil {
+			try(func() error {
+				kv.onExpire(k, e.value)
+				return nil
+			})
+		}
+		if kv.onExpireDetailed != nil {
+			try(func() error {
+				kv.onExpireDetailed(k, e.value, e.expiresAt, removedAt)
+				return nil
+			})
+		}
+		if kv.onExpireWithOrigin != nil {
+			try(func() error {
+				kv.onExpireWithOrigin(kv.name, k, e.value)
+				return nil
+			})
+		}
+		if kv.onExpireE != nil {
+			kv.notifyE(k, e.value)
+		}
+	}
+}
+
+// recordLag records the expiration lag of the expired entries in the stats
+func (kv *Store) recordLag(expired map[string]*entry, removedAt time.Time) {
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	for _, e := range expired {
+		lag := removedAt.Sub(e.removeAt())
+		if lag < 0 {
+			lag = 0
+		}
+		if lag > kv.stats.ExpirationLagMax {
+			kv.stats.ExpirationLagMax = lag
+		}
+		if lag > kv.worstLag {
+			kv.worstLag = lag
+		}
+		kv.stats.ExpirationLagSum += lag
+		kv.stats.ExpirationLagCount++
+	}
 }
 
 //-----------------------------------------------------------------------------
 
 // errors
 var (
-	ErrCASCond = errorf("CAS COND FAILED")
+	ErrCASCond         = errorf("CAS COND FAILED")
+	ErrTypeConflict    = errorf("TYPE CONFLICT")
+	ErrInvalidWindow   = errorf("INVALID WINDOW")
+	ErrNotDebug        = errorf("NOT IN DEBUG MODE")
+	ErrInvalidInterval = errorf("INVALID INTERVAL")
+	ErrNotFound        = errorf("NOT FOUND")
+	ErrExpired         = errorf("EXPIRED")
+	ErrCorrupted       = errorf("CORRUPTED")
+	ErrNoWAL           = errorf("NO WAL")
+	ErrReadOnly        = errorf("READ ONLY")
+	ErrRegistered      = errorf("ALREADY REGISTERED")
+	ErrInvalidOptions  = errorf("INVALID OPTIONS")
+	ErrNotOwner        = errorf("NOT OWNER")
+	ErrUnhealthy       = errorf("UNHEALTHY")
+	ErrFull            = errorf("FULL")
+	ErrStopped         = errorf("STOPPED")
+	ErrQuotaExceeded   = errorf("QUOTA EXCEEDED")
+	ErrNotRetained     = errorf("NOT RETAINED")
+	ErrIndexConflict   = errorf("INDEX CONFLICT")
+	ErrProtected       = errorf("PROTECTED")
 )
 
 //-----------------------------------------------------------------------------
@@ -346,4 +1713,36 @@ func errorf(format string, a ...interface{}) error {
 	return sentinelErr(fmt.Sprintf(format, a...))
 }
 
+// OpError is the error returned by the operations on a key (Put, CAS, GetE,
+// Append, ...): it records the operation and the key, along with the cause,
+// Err. errors.Is, and errors.Cause of github.com/pkg/errors, see through it.
+type OpError struct {
+	Op  string // like "put", "cas", "add-to-set"
+	Key string
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return "tinykv: " + e.Op + " " + strconv.Quote(e.Key) + ": " + e.Err.Error()
+}
+
+// Unwrap returns Err
+func (e *OpError) Unwrap() error { return e.Err }
+
+// Cause returns Err, for errors.Cause
+func (e *OpError) Cause() error { return e.Err }
+
+// opError wraps err, if any, in an OpError
+func opError(op, k string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OpError{Op: op, Key: k, Err: err}
+}
+
+// wrapOp is deferred by the operations on a key, to wrap their error
+func wrapOp(err *error, op, k string) {
+	*err = opError(op, k, *err)
+}
+
 //-----------------------------------------------------------------------------
@@ -1,9 +1,12 @@
 package tinykv
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -35,7 +38,7 @@ func TestTimeoutHeap(t *testing.T) {
 	assert.Equal(0, len(h))
 }
 
-var _ KV = &store{}
+var _ KV = &Store{}
 
 func TestGetPut(t *testing.T) {
 	assert := assert.New(t)
@@ -51,11 +54,13 @@ func TestGetPut(t *testing.T) {
 	v, ok = rg.Get("2")
 	assert.True(ok)
 	assert.Equal(2, v)
+	checkInvariants(t, rg)
 	<-time.After(time.Millisecond * 100)
 
 	v, ok = rg.Get("2")
 	assert.False(ok)
 	assert.NotEqual(2, v)
+	checkInvariants(t, rg)
 }
 
 func TestTimeout(t *testing.T) {
@@ -122,6 +127,7 @@ func Test02(t *testing.T) {
 	v, ok = rg.Get("1")
 	assert.False(ok)
 	assert.NotEqual(1, v)
+	checkInvariants(t, rg)
 }
 
 func Test03(t *testing.T) {
@@ -137,7 +143,9 @@ func Test03(t *testing.T) {
 	putAt = time.Now()
 	kv.Put("1", 1, ExpiresAfter(time.Millisecond*10))
 
-	<-time.After(time.Millisecond * 100)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(kv.AwaitExpiration(ctx, "1"))
 	assert.WithinDuration(putAt, putAt.Add(<-elapsed), time.Millisecond*60)
 }
 
@@ -149,13 +157,15 @@ func Test04(t *testing.T) {
 			t.Fatal(k, v)
 		})
 
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 	err := kv.Put("1", 1, ExpiresAfter(time.Millisecond*10000))
 	assert.NoError(err)
-	<-time.After(time.Millisecond * 50)
+	assert.NoError(kv.AwaitSweep(ctx, 2))
 	kv.Delete("1")
 	kv.Delete("1")
 
-	<-time.After(time.Millisecond * 100)
+	assert.NoError(kv.AwaitSweep(ctx, 2))
 	_, ok := kv.Get("1")
 	assert.False(ok)
 }
@@ -179,7 +189,14 @@ func Test05(t *testing.T) {
 				time.Millisecond*time.Duration(rnd.Intn(10)+1)))
 	}
 
-	<-time.After(time.Millisecond * 100)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for kv.Len() > 0 {
+		if err := kv.AwaitSweep(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkInvariants(t, kv)
 	for i := 0; i < N; i++ {
 		k := fmt.Sprintf("%d", i)
 		_, ok := kv.Get(k)
@@ -195,7 +212,9 @@ func Test06(t *testing.T) {
 			t.Fail()
 		})
 
-	err := kv.Put("1", 1, ExpiresAfter(10*time.Millisecond), IsSliding(true))
+	// a Get no longer slides an entry that is already overdue, so the
+	// timeout leaves room for the scheduler to run the loop late
+	err := kv.Put("1", 1, ExpiresAfter(30*time.Millisecond), IsSliding(true))
 	assert.NoError(err)
 
 	for i := 0; i < 100; i++ {
@@ -205,7 +224,7 @@ func Test06(t *testing.T) {
 	}
 	kv.Delete("1")
 
-	<-time.After(time.Millisecond * 30)
+	<-time.After(time.Millisecond * 50)
 
 	_, ok := kv.Get("1")
 	assert.False(ok)
@@ -267,6 +286,7 @@ func Test09IgnoreTimeoutParamsOnCAS(t *testing.T) {
 	assert.NoError(err)
 
 	<-time.After(time.Millisecond * 12)
+	checkInvariants(t, kv)
 	_, ok = kv.Get(key)
 	assert.False(ok)
 }
@@ -277,20 +297,24 @@ func Test10(t *testing.T) {
 	key := "QQG"
 
 	kv := New(time.Millisecond)
+	// as in Test06, the waits leave room in the timeout, for a Get that is
+	// late finds the entry expired
 	err := kv.Put(
 		key, "G",
 		CAS(func(interface{}, bool) bool { return true }),
 		IsSliding(true),
-		ExpiresAfter(time.Millisecond*15))
+		ExpiresAfter(time.Millisecond*30))
 	assert.NoError(err)
 
-	<-time.After(time.Millisecond * 12)
+	<-time.After(time.Millisecond * 20)
+	checkInvariants(t, kv)
 
 	v, ok := kv.Get(key)
 	assert.True(ok)
 	assert.Equal("G", v)
 
-	<-time.After(time.Millisecond * 12)
+	<-time.After(time.Millisecond * 20)
+	checkInvariants(t, kv)
 
 	err = kv.Put(key, "OK",
 		CAS(func(currentValue interface{}, found bool) bool {
@@ -300,7 +324,8 @@ func Test10(t *testing.T) {
 		}))
 	assert.NoError(err)
 
-	<-time.After(time.Millisecond * 12)
+	<-time.After(time.Millisecond * 20)
+	checkInvariants(t, kv)
 
 	_, ok = kv.Get(key)
 	assert.True(ok)
@@ -378,6 +403,7 @@ func Test13(t *testing.T) {
 	v, ok := kv.Get("1")
 	assert.False(ok)
 	assert.Equal(nil, v)
+	checkInvariants(t, kv)
 
 	v = <-got
 	assert.Equal(123, v)
@@ -451,6 +477,7 @@ func TestCASOldFound(t *testing.T) {
 		}))
 	assert.NoError(err)
 	kv.Delete(key)
+	checkInvariants(t, kv)
 	err = kv.Put(
 		key, value,
 		CAS(func(old interface{}, found bool) bool {
@@ -462,6 +489,7 @@ func TestCASOldFound(t *testing.T) {
 	v, ok := kv.Take(key)
 	assert.True(ok)
 	assert.Equal(value, v)
+	checkInvariants(t, kv)
 	err = kv.Put(
 		key, value,
 		CAS(func(old interface{}, found bool) bool {
@@ -472,6 +500,312 @@ func TestCASOldFound(t *testing.T) {
 	assert.NoError(err)
 }
 
+func TestSynchronousNotifications(t *testing.T) {
+	assert := assert.New(t)
+
+	var cnt int64
+	kv := NewStore(
+		time.Hour,
+		OnExpire(func(k string, v interface{}) {
+			atomic.AddInt64(&cnt, 1)
+		}),
+		SynchronousNotifications())
+	defer kv.Stop()
+
+	for i := 0; i < 10; i++ {
+		kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Millisecond))
+	}
+	<-time.After(time.Millisecond * 5)
+
+	kv.ExpireNow()
+	assert.Equal(int64(10), atomic.LoadInt64(&cnt))
+
+	kv.Put("1", 1, ExpiresAfter(time.Millisecond))
+	<-time.After(time.Millisecond * 5)
+	_, ok := kv.Get("1")
+	assert.False(ok)
+	assert.Equal(int64(11), atomic.LoadInt64(&cnt))
+}
+
+type fakeClock struct {
+	mx  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestClock(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	kv.Put("1", 1, ExpiresAfter(time.Minute))
+	clock.Advance(time.Second * 59)
+	_, ok := kv.Get("1")
+	assert.True(ok)
+	clock.Advance(time.Second * 2)
+	_, ok = kv.Get("1")
+	assert.False(ok)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	kv.Put("1", 1, ExpiresAfter(time.Hour), IdleTimeout(time.Minute*5))
+	for i := 0; i < 14; i++ {
+		clock.Advance(time.Minute * 4)
+		_, ok := kv.Get("1")
+		assert.True(ok, i)
+	}
+	// 56 minutes passed, constant access keeps it idle-fresh
+	clock.Advance(time.Minute * 4)
+	assert.True(kv.Touch("1"))
+	clock.Advance(time.Minute*4 + time.Second)
+	_, ok := kv.Get("1")
+	assert.False(ok)
+
+	kv.Put("2", 2, IdleTimeout(time.Minute*5))
+	kv.Put("3", 3, ExpiresAfter(time.Hour), IdleTimeout(time.Minute*5))
+	clock.Advance(time.Minute * 3)
+	assert.True(kv.Touch("3"))
+	clock.Advance(time.Minute*2 + time.Second)
+	kv.ExpireNow()
+	_, ok = kv.Get("2")
+	assert.False(ok)
+	_, ok = kv.Get("3")
+	assert.True(ok)
+	checkInvariants(t, kv)
+}
+
+func TestSlideFixesHeap(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	kv.Put("1", 1, ExpiresAfter(time.Minute), IsSliding(true))
+	kv.Put("2", 2, ExpiresAfter(time.Second*100))
+	clock.Advance(time.Second * 50)
+	kv.Get("1")
+
+	s := kv
+	assert.Equal("2", s.heap[0].key)
+	checkInvariants(t, kv)
+	for i, to := range s.heap {
+		assert.Equal(i, to.index)
+	}
+
+	clock.Advance(time.Second * 51)
+	kv.ExpireNow()
+	_, ok := kv.Get("2")
+	assert.False(ok)
+	_, ok = kv.Get("1")
+	assert.True(ok)
+}
+
+func TestSetExpirationInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	expired := make(chan string, 1)
+	kv := NewStore(
+		time.Second*10,
+		Clock(clock.Now),
+		OnExpire(func(k string, v interface{}) { expired <- k }))
+	defer kv.Stop()
+
+	assert.Equal(ErrInvalidInterval, kv.SetExpirationInterval(0))
+	assert.Equal(time.Second*10, kv.Config().ExpirationInterval)
+
+	kv.Put("1", 1, ExpiresAfter(time.Second))
+	clock.Advance(time.Second * 2)
+
+	assert.NoError(kv.SetExpirationInterval(time.Millisecond * 10))
+	assert.Equal(time.Millisecond*10, kv.Config().ExpirationInterval)
+	select {
+	case k := <-expired:
+		assert.Equal("1", k)
+	case <-time.After(time.Millisecond * 500):
+		assert.Fail("no sweep after changing the interval")
+	}
+}
+
+func TestPauseExpiration(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	expired := make(chan string, 1)
+	kv := NewStore(
+		time.Hour,
+		Clock(clock.Now),
+		OnExpire(func(k string, v interface{}) { expired <- k }))
+	defer kv.Stop()
+
+	kv.Put("1", 1, ExpiresAfter(time.Millisecond*10))
+	kv.Put("2", 2, ExpiresAfter(time.Millisecond*10), IsSliding(true))
+	kv.PauseExpiration()
+	assert.True(kv.Config().ExpirationPaused)
+	clock.Advance(time.Second)
+
+	kv.ExpireNow()
+	v, ok := kv.Get("1")
+	assert.True(ok)
+	assert.Equal(1, v)
+	_, ok = kv.Get("2") // slides while paused
+	assert.True(ok)
+
+	kv.ResumeExpiration()
+	assert.False(kv.Config().ExpirationPaused)
+	select {
+	case k := <-expired:
+		assert.Equal("1", k)
+	case <-time.After(time.Millisecond * 500):
+		assert.Fail("overdue entries not swept on resume")
+	}
+	_, ok = kv.Get("1")
+	assert.False(ok)
+	_, ok = kv.Get("2")
+	assert.True(ok)
+}
+
+func TestErrNotFoundAndErrExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	_, err := kv.GetE("1")
+	assert.True(errors.Is(err, ErrNotFound))
+	_, err = kv.TakeE("1")
+	assert.True(errors.Is(err, ErrNotFound))
+	assert.True(errors.Is(kv.DeleteE("1"), ErrNotFound))
+
+	kv.Put("1", 1)
+	v, err := kv.GetE("1")
+	assert.NoError(err)
+	assert.Equal(1, v)
+	assert.NoError(kv.DeleteE("1"))
+	assert.True(errors.Is(kv.DeleteE("1"), ErrNotFound))
+
+	kv.Put("1", 1)
+	v, err = kv.TakeE("1")
+	assert.NoError(err)
+	assert.Equal(1, v)
+	_, err = kv.TakeE("1")
+	assert.True(errors.Is(err, ErrNotFound))
+
+	for _, op := range []func() error{
+		func() error { _, err := kv.GetE("2"); return err },
+		func() error { _, err := kv.TakeE("2"); return err },
+		func() error { return kv.DeleteE("2") },
+	} {
+		kv.Put("2", 2, ExpiresAfter(time.Second))
+		clock.Advance(time.Second * 2)
+		assert.True(errors.Is(op(), ErrExpired))
+		assert.True(errors.Is(op(), ErrNotFound))
+	}
+}
+
+func TestOnExpireBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var (
+		calls  int
+		shards []int
+		got    = make(map[string]interface{})
+	)
+	kv := NewStore(
+		time.Hour,
+		Clock(clock.Now),
+		SynchronousNotifications(),
+		OnExpireBatch(func(shard int, expired map[string]interface{}) {
+			calls++
+			shards = append(shards, shard)
+			for k, v := range expired {
+				got[k] = v
+			}
+		}))
+	defer kv.Stop()
+
+	for i := 0; i < 10; i++ {
+		kv.Put(strconv.Itoa(i), i, ExpiresAfter(time.Second))
+	}
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+
+	assert.Equal(1, calls)
+	assert.Equal([]int{0}, shards)
+	assert.Equal(10, len(got))
+	for i := 0; i < 10; i++ {
+		assert.Equal(i, got[strconv.Itoa(i)])
+	}
+}
+
+func TestDefaultSliding(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		store    []StoreOption
+		put      []PutOption
+		expected bool
+	}{
+		{nil, nil, false},
+		{nil, []PutOption{IsSliding(true)}, true},
+		{nil, []PutOption{IsSliding(false)}, false},
+		{[]StoreOption{DefaultSliding(true)}, nil, true},
+		{[]StoreOption{DefaultSliding(true)}, []PutOption{IsSliding(true)}, true},
+		{[]StoreOption{DefaultSliding(true)}, []PutOption{IsSliding(false)}, false},
+		{[]StoreOption{DefaultSliding(false)}, nil, false},
+		{[]StoreOption{DefaultSliding(false)}, []PutOption{IsSliding(true)}, true},
+		{[]StoreOption{DefaultSliding(false)}, []PutOption{IsSliding(false)}, false},
+	}
+	for i, c := range cases {
+		kv := NewStore(time.Hour, c.store...)
+		options := append([]PutOption{ExpiresAfter(time.Minute)}, c.put...)
+
+		kv.Put("put", 1, options...)
+		meta, _ := kv.GetMeta("put")
+		assert.Equal(c.expected, meta.IsSliding, i)
+
+		cas := CAS(func(interface{}, bool) bool { return true })
+		kv.Put("cas", 1, append(options, cas)...)
+		meta, _ = kv.GetMeta("cas")
+		assert.Equal(c.expected, meta.IsSliding, i)
+
+		kv.Put("cas", 2, append(options, cas)...)
+		meta, _ = kv.GetMeta("cas")
+		assert.Equal(c.expected, meta.IsSliding, i)
+
+		kv.Append("append", 1, options...)
+		meta, _ = kv.GetMeta("append")
+		assert.Equal(c.expected, meta.IsSliding, i)
+		kv.Stop()
+	}
+}
+
 func ExampleNew() {
 	key := "KEY"
 	value := "VALUE"
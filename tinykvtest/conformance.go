@@ -0,0 +1,239 @@
+package tinykvtest
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/dc0d/tinykv"
+)
+
+// Conformance runs the contract every tinykv.KV must honor, whatever its
+// semantics (a store, Null, Frozen, or a wrapper): misses are reported
+// with tinykv.ErrNotFound, the error forms return *tinykv.OpError, failed
+// writes change nothing, the listing methods agree with each other,
+// and Stop can be called more than once. The methods of a *tinykv.Store
+// beyond KV (like GetMeta, Prefix or Stats) are held to it too, if the KV
+// has them. newKV is called once per case, and may return a KV with entries
+// in it.
+func Conformance(t *testing.T, newKV func() tinykv.KV) {
+	t.Helper()
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, kv tinykv.KV)
+	}{
+		{"misses", conformMisses},
+		{"listing", conformListing},
+		{"put", conformPut},
+		{"failed-writes", conformFailedWrites},
+		{"delete", conformDelete},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			kv := newKV()
+			defer kv.Stop()
+			c.fn(t, kv)
+		})
+	}
+	t.Run("stop", func(t *testing.T) {
+		kv := newKV()
+		kv.Stop()
+		kv.Stop()
+	})
+}
+
+// the methods beyond KV that Conformance checks, if a KV has them
+type (
+	metaGetter interface {
+		GetMeta(k string) (meta tinykv.Meta, ok bool)
+	}
+	drainer interface {
+		Drain(k string) (vs []interface{}, ok bool)
+	}
+	setLister interface {
+		SetMembers(k string) (members []interface{}, ok bool)
+	}
+	prefixLister interface {
+		Prefix(prefix string) []string
+	}
+	windowCounter interface {
+		IncrWindow(k string, window time.Duration, limit int64) (count int64, allowed bool, retryAfter time.Duration, err error)
+	}
+)
+
+const (
+	conformMissing = "tinykvtest/missing"
+	conformKey     = "tinykvtest/key"
+)
+
+// checkOpError fails if err is not an *OpError of op on k wrapping want
+// (any error, if want is nil)
+func checkOpError(t *testing.T, err error, op, k string, want error) {
+	t.Helper()
+	var opErr *tinykv.OpError
+	switch {
+	case err == nil:
+		t.Fatalf("%s %q: expected an error", op, k)
+	case !errors.As(err, &opErr):
+		t.Fatalf("%s %q: %v is not an *OpError", op, k, err)
+	case opErr.Op != op || opErr.Key != k:
+		t.Fatalf("%s %q: the error is about %s %q", op, k, opErr.Op, opErr.Key)
+	case want != nil && !errors.Is(err, want):
+		t.Fatalf("%s %q: %v, expected %v", op, k, err, want)
+	}
+}
+
+func conformMisses(t *testing.T, kv tinykv.KV) {
+	if _, ok := kv.Get(conformMissing); ok {
+		t.Fatalf("Get of a missing key found it")
+	}
+	_, err := kv.GetE(conformMissing)
+	checkOpError(t, err, "get", conformMissing, tinykv.ErrNotFound)
+	_, err = kv.TakeE(conformMissing)
+	checkOpError(t, err, "take", conformMissing, tinykv.ErrNotFound)
+	checkOpError(t, kv.DeleteE(conformMissing), "delete", conformMissing, tinykv.ErrNotFound)
+	if _, ok := kv.Take(conformMissing); ok {
+		t.Fatalf("Take of a missing key found it")
+	}
+	if kv.Touch(conformMissing) {
+		t.Fatalf("Touch of a missing key found it")
+	}
+	if m, ok := kv.(metaGetter); ok {
+		if _, ok := m.GetMeta(conformMissing); ok {
+			t.Fatalf("GetMeta of a missing key found it")
+		}
+	}
+	if d, ok := kv.(drainer); ok {
+		if _, ok := d.Drain(conformMissing); ok {
+			t.Fatalf("Drain of a missing key found it")
+		}
+	}
+	if s, ok := kv.(setLister); ok {
+		if _, ok := s.SetMembers(conformMissing); ok {
+			t.Fatalf("SetMembers of a missing key found it")
+		}
+	}
+}
+
+func conformListing(t *testing.T, kv tinykv.KV) {
+	keys := append([]string(nil), kv.Keys()...)
+	sort.Strings(keys)
+	if kv.Len() != len(keys) {
+		t.Fatalf("Len is %d, Keys has %d keys", kv.Len(), len(keys))
+	}
+	if s, ok := kv.(tinykv.StatsProvider); ok && s.Stats().Entries != len(keys) {
+		t.Fatalf("Stats has %d entries, Keys has %d keys", s.Stats().Entries, len(keys))
+	}
+	if p, ok := kv.(prefixLister); ok {
+		prefixed := append([]string(nil), p.Prefix("")...)
+		sort.Strings(prefixed)
+		if !equalKeys(keys, prefixed) {
+			t.Fatalf("Prefix(\"\") is %v, Keys is %v", prefixed, keys)
+		}
+	}
+	var ranged []string
+	kv.Range(func(k string, v interface{}) bool {
+		ranged = append(ranged, k)
+		got, ok := kv.Get(k)
+		if !ok || !reflect.DeepEqual(got, v) {
+			t.Fatalf("Range visited %q with %v, Get returns %v, %v", k, v, got, ok)
+		}
+		return true
+	})
+	sort.Strings(ranged)
+	if !equalKeys(keys, ranged) {
+		t.Fatalf("Range visited %v, Keys is %v", ranged, keys)
+	}
+	visits := 0
+	kv.Range(func(string, interface{}) bool {
+		visits++
+		return false
+	})
+	if visits > 1 {
+		t.Fatalf("Range went on after fn returned false")
+	}
+}
+
+func conformPut(t *testing.T, kv tinykv.KV) {
+	before, found := kv.Get(conformKey)
+	err := kv.Put(conformKey, "VALUE", tinykv.ExpiresAfter(time.Hour))
+	if err != nil {
+		checkOpError(t, err, "put", conformKey, nil)
+		if got, ok := kv.Get(conformKey); ok != found || !reflect.DeepEqual(got, before) {
+			t.Fatalf("a failed Put changed the value to %v", got)
+		}
+		return
+	}
+	// a KV may drop what it is given (like Null), but must not return
+	// anything else
+	if got, ok := kv.Get(conformKey); ok && got != "VALUE" {
+		t.Fatalf("Get returns %v after Put of VALUE", got)
+	}
+	if got, err := kv.GetE(conformKey); err == nil && got != "VALUE" {
+		t.Fatalf("GetE returns %v after Put of VALUE", got)
+	}
+}
+
+func conformFailedWrites(t *testing.T, kv tinykv.KV) {
+	kv.Put(conformKey, "VALUE")
+	before, found := kv.Get(conformKey)
+	unchanged := func(op string) {
+		t.Helper()
+		if got, ok := kv.Get(conformKey); ok != found || !reflect.DeepEqual(got, before) {
+			t.Fatalf("a failed %s changed the value to %v, %v", op, got, ok)
+		}
+	}
+
+	err := kv.CAS(conformKey, "OTHER", func(interface{}, bool) bool { return false })
+	checkOpError(t, err, "cas", conformKey, nil)
+	unchanged("cas")
+
+	err = kv.Put(conformKey, "OTHER", tinykv.CAS(func(interface{}, bool) bool { return false }))
+	checkOpError(t, err, "put", conformKey, nil)
+	unchanged("put")
+
+	err = kv.Put(conformKey, "OTHER", tinykv.ExpiresAfter(-time.Second))
+	checkOpError(t, err, "put", conformKey, nil)
+	unchanged("put")
+
+	if w, ok := kv.(windowCounter); ok {
+		_, _, _, err = w.IncrWindow(conformKey, 0, 1)
+		checkOpError(t, err, "incr-window", conformKey, tinykv.ErrInvalidWindow)
+		unchanged("incr-window")
+	}
+}
+
+func conformDelete(t *testing.T, kv tinykv.KV) {
+	if err := kv.Put(conformKey, "VALUE"); err != nil {
+		checkOpError(t, err, "put", conformKey, nil)
+	}
+	if _, ok := kv.Get(conformKey); !ok {
+		return // nothing to delete, or read-only
+	}
+	if err := kv.DeleteE(conformKey); err != nil {
+		checkOpError(t, err, "delete", conformKey, nil)
+		if _, ok := kv.Get(conformKey); !ok {
+			t.Fatalf("a failed DeleteE removed the entry")
+		}
+		return
+	}
+	if _, ok := kv.Get(conformKey); ok {
+		t.Fatalf("Get finds the entry after DeleteE")
+	}
+	checkOpError(t, kv.DeleteE(conformKey), "delete", conformKey, tinykv.ErrNotFound)
+}
+
+func equalKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package tinykvtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dc0d/tinykv"
+)
+
+// Sim runs a store in simulated time, see Simulated
+type Sim struct {
+	mx      sync.Mutex
+	clock   *Clock
+	janitor *tinykv.Janitor
+}
+
+// Simulated creates a store that runs in simulated time, driven by the
+// returned Sim, with the given options. Its clock is a Clock (see NewClock),
+// its janitor is manual (see tinykv.ManualJanitor), and its notifications
+// are synchronous and run inline (see tinykv.SynchronousNotifications and
+// tinykv.Executor), so Sim.Advance runs whatever happens in the store in
+// the time it advances by, before it returns. The options must not set the
+// Clock or the Executor. Features that wait on the wall clock (the backoff of
+// NotifyRetry and ArchiveRetry, MemoryPressure, CoarseClock) are not
+// simulated.
+func Simulated(options ...tinykv.StoreOption) (*tinykv.Store, *Sim) {
+	s := &Sim{
+		clock:   NewClock(),
+		janitor: &tinykv.Janitor{},
+	}
+	options = append(options,
+		tinykv.Clock(s.clock.Now),
+		tinykv.ManualJanitor(s.janitor),
+		tinykv.SynchronousNotifications(),
+		tinykv.Executor(func(task func()) { task() }))
+	return tinykv.NewStore(0, options...), s
+}
+
+// Now returns the simulated time
+func (s *Sim) Now() time.Time {
+	return s.clock.Now()
+}
+
+// Advance moves the simulated time forward by d, running the sweeps of the
+// janitor at the time of each thing it has to do (expirations and their
+// notifications, PutAfter activations, ExpectWithin and soft deadlines,
+// OnIdle, ScrubPolicy), in order, and a last one at the end of d. Things due
+// at the same time run in the same sweep.
+func (s *Sim) Advance(d time.Duration) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	end := s.clock.Now().Add(d)
+	var last time.Time
+	for {
+		at, ok := s.janitor.Next()
+		if !ok || at.After(end) {
+			break
+		}
+		now := s.clock.Now()
+		if !last.IsZero() && !at.After(last) {
+			break // stuck; the store did not move on since the last sweep
+		}
+		if at.After(now) {
+			s.clock.Advance(at.Sub(now))
+		}
+		s.janitor.Sweep()
+		last = s.clock.Now()
+	}
+	s.clock.Advance(end.Sub(s.clock.Now()))
+	s.janitor.Sweep()
+}
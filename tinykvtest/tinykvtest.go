@@ -0,0 +1,290 @@
+// Package tinykvtest provides helpers for testing tinykv stores, and
+// wrappers around them, against a reference model.
+package tinykvtest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dc0d/tinykv"
+)
+
+//-----------------------------------------------------------------------------
+
+// Clock is a manual clock, to be injected into a store using tinykv.Clock(c.Now)
+type Clock struct {
+	mx  sync.Mutex
+	now time.Time
+}
+
+// NewClock creates a new *Clock, set at a fixed point in time
+func NewClock() *Clock {
+	return &Clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// Now returns the current time of the clock
+func (c *Clock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward
+func (c *Clock) Advance(d time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.now = c.now.Add(d)
+}
+
+//-----------------------------------------------------------------------------
+
+// Exercise runs a random sequence of Put/Get/Delete/Take/CAS operations
+// (with random timeouts) against the KV created by newKV, and asserts
+// that it behaves like a reference model. If the KV has ExpireNow and
+// CheckInvariants (like a *tinykv.Store), they are used too. Time is the
+// wall clock, so entries too close to their deadline are not asserted on.
+func Exercise(t *testing.T, newKV func() tinykv.KV, ops int, seed int64) {
+	t.Helper()
+	d := &driver{
+		t:      t,
+		seed:   seed,
+		now:    time.Now,
+		margin: time.Millisecond * 15,
+		ttls: []time.Duration{
+			time.Millisecond * 30,
+			time.Millisecond * 60,
+			time.Millisecond * 120,
+		},
+		advance: func(d time.Duration) { time.Sleep(d / 10) },
+	}
+	d.run(newKV(), ops)
+}
+
+// ExerciseClock is like Exercise, but time is driven by clock,
+// which must be the clock of the KV created by newKV. All assertions
+// are exact.
+func ExerciseClock(t *testing.T, clock *Clock, newKV func() tinykv.KV, ops int, seed int64) {
+	t.Helper()
+	d := &driver{
+		t:    t,
+		seed: seed,
+		now:  clock.Now,
+		ttls: []time.Duration{
+			time.Second,
+			time.Second * 3,
+			time.Second * 10,
+		},
+		advance: clock.Advance,
+	}
+	d.run(newKV(), ops)
+}
+
+//-----------------------------------------------------------------------------
+
+type modelEntry struct {
+	value    interface{}
+	deadline time.Time
+	ttl      time.Duration
+	sliding  bool
+}
+
+// expirer is a KV that expires its entries on demand, like a *tinykv.Store
+type expirer interface {
+	ExpireNow()
+}
+
+// checker is a KV that checks its own invariants, like a *tinykv.Store
+type checker interface {
+	CheckInvariants() error
+}
+
+type driver struct {
+	t       *testing.T
+	seed    int64
+	now     func() time.Time
+	margin  time.Duration
+	ttls    []time.Duration
+	advance func(time.Duration)
+
+	kv    tinykv.KV
+	rnd   *rand.Rand
+	model map[string]*modelEntry
+	op    int
+}
+
+func (d *driver) run(kv tinykv.KV, ops int) {
+	d.t.Helper()
+	defer kv.Stop()
+	d.kv = kv
+	d.rnd = rand.New(rand.NewSource(d.seed))
+	d.model = make(map[string]*modelEntry)
+	for d.op = 0; d.op < ops; d.op++ {
+		k := fmt.Sprintf("k%d", d.rnd.Intn(8))
+		switch d.rnd.Intn(8) {
+		case 0, 1:
+			d.put(k)
+		case 2, 3:
+			d.get(k)
+		case 4:
+			d.delete(k)
+		case 5:
+			d.take(k)
+		case 6:
+			d.cas(k)
+		case 7:
+			d.advance(time.Duration(d.rnd.Int63n(int64(d.ttls[0]))))
+			if e, ok := kv.(expirer); d.rnd.Intn(4) == 0 && ok {
+				e.ExpireNow()
+			}
+		}
+		d.checkInvariants()
+	}
+	for i := 0; i < 8; i++ {
+		d.get(fmt.Sprintf("k%d", i))
+	}
+}
+
+func (d *driver) fatalf(format string, args ...interface{}) {
+	d.t.Helper()
+	d.t.Fatalf("seed %d, op %d: %s", d.seed, d.op, fmt.Sprintf(format, args...))
+}
+
+func (d *driver) checkInvariants() {
+	d.t.Helper()
+	c, ok := d.kv.(checker)
+	if !ok {
+		return
+	}
+	if err := c.CheckInvariants(); err != nil && err != tinykv.ErrNotDebug {
+		d.fatalf("invariants: %v", err)
+	}
+}
+
+// alive reports if the model entry is alive; certain is false when
+// the entry is too close to its deadline to tell.
+func (d *driver) alive(e *modelEntry) (alive, certain bool) {
+	if e.deadline.IsZero() {
+		return true, true
+	}
+	diff := e.deadline.Sub(d.now())
+	switch {
+	case d.margin == 0:
+		return diff >= 0, true
+	case diff > d.margin:
+		return true, true
+	case diff < -d.margin:
+		return false, true
+	}
+	return false, false
+}
+
+func (d *driver) newEntry(v interface{}) (*modelEntry, []tinykv.PutOption) {
+	e := &modelEntry{value: v}
+	if d.rnd.Intn(3) == 0 {
+		return e, nil
+	}
+	e.ttl = d.ttls[d.rnd.Intn(len(d.ttls))]
+	e.sliding = d.rnd.Intn(2) == 0
+	e.deadline = d.now().Add(e.ttl)
+	return e, []tinykv.PutOption{tinykv.ExpiresAfter(e.ttl), tinykv.IsSliding(e.sliding)}
+}
+
+func (d *driver) slide(e *modelEntry) {
+	if e.sliding {
+		e.deadline = d.now().Add(e.ttl)
+	}
+}
+
+// observe checks a read of k against the model and returns the live model entry
+func (d *driver) observe(k string, v interface{}, found bool) *modelEntry {
+	d.t.Helper()
+	e, ok := d.model[k]
+	if !ok {
+		if found {
+			d.fatalf("%q: found %v, expected no entry", k, v)
+		}
+		return nil
+	}
+	alive, certain := d.alive(e)
+	if certain && alive != found {
+		d.fatalf("%q: found = %v, expected %v", k, found, alive)
+	}
+	if !found {
+		delete(d.model, k)
+		return nil
+	}
+	if v != e.value {
+		d.fatalf("%q: got %v, expected %v", k, v, e.value)
+	}
+	return e
+}
+
+func (d *driver) put(k string) {
+	e, options := d.newEntry(d.op)
+	if err := d.kv.Put(k, e.value, options...); err != nil {
+		d.fatalf("put %q: %v", k, err)
+	}
+	d.model[k] = e
+}
+
+func (d *driver) get(k string) {
+	d.t.Helper()
+	v, found := d.kv.Get(k)
+	if e := d.observe(k, v, found); e != nil {
+		d.slide(e)
+	}
+}
+
+func (d *driver) delete(k string) {
+	d.kv.Delete(k)
+	delete(d.model, k)
+}
+
+func (d *driver) take(k string) {
+	d.t.Helper()
+	v, found := d.kv.Take(k)
+	d.observe(k, v, found)
+	delete(d.model, k)
+}
+
+func (d *driver) cas(k string) {
+	d.t.Helper()
+	decision := d.rnd.Intn(2) == 0
+	var (
+		called   bool
+		oldValue interface{}
+		oldFound bool
+	)
+	cond := tinykv.CAS(func(old interface{}, found bool) bool {
+		called, oldValue, oldFound = true, old, found
+		return decision
+	})
+	e, options := d.newEntry(d.op)
+	err := d.kv.Put(k, e.value, append(options, cond)...)
+	if !called {
+		d.fatalf("cas %q: condition not called", k)
+	}
+	old := d.observe(k, oldValue, oldFound)
+	if !decision {
+		if !errors.Is(err, tinykv.ErrCASCond) {
+			d.fatalf("cas %q: got error %v, expected %v", k, err, tinykv.ErrCASCond)
+		}
+		return
+	}
+	if err != nil {
+		d.fatalf("cas %q: %v", k, err)
+	}
+	if old == nil {
+		d.model[k] = e
+		return
+	}
+	old.value = e.value
+	if e.ttl > 0 {
+		old.ttl, old.sliding, old.deadline = e.ttl, e.sliding, e.deadline
+	}
+	d.slide(old)
+}
@@ -0,0 +1,149 @@
+package tinykvtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/dc0d/tinykv"
+)
+
+var shardedKeyMap = tinykv.WithKeyMap(func() tinykv.KeyMap { return tinykv.NewShardedKeyMap(8) })
+
+func TestExercise(t *testing.T) {
+	Exercise(t, func() tinykv.KV {
+		return tinykv.NewStore(time.Millisecond*5, tinykv.Debug())
+	}, 300, 1)
+}
+
+func TestExerciseClock(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		clock := NewClock()
+		ExerciseClock(t, clock, func() tinykv.KV {
+			options := []tinykv.StoreOption{tinykv.Clock(clock.Now), tinykv.Debug()}
+			if seed%2 == 1 {
+				options = append(options, tinykv.Indexed())
+			}
+			if seed%3 == 0 {
+				options = append(options, tinykv.ReadOptimized())
+			}
+			if seed%4 == 0 {
+				options = append(options, shardedKeyMap)
+			}
+			return tinykv.NewStore(time.Hour, options...)
+		}, 2000, seed)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	t.Run("store", func(t *testing.T) {
+		Conformance(t, func() tinykv.KV { return tinykv.NewStore(time.Hour, tinykv.Debug()) })
+	})
+	t.Run("indexed", func(t *testing.T) {
+		Conformance(t, func() tinykv.KV {
+			kv := tinykv.NewStore(time.Hour, tinykv.Indexed(), tinykv.ReadOptimized())
+			kv.Put("a", 1)
+			kv.Put("b", []byte("B"))
+			return kv
+		})
+	})
+	t.Run("new", func(t *testing.T) {
+		Conformance(t, func() tinykv.KV { return tinykv.New(time.Hour) })
+	})
+	t.Run("sharded", func(t *testing.T) {
+		Conformance(t, func() tinykv.KV { return tinykv.NewStore(time.Hour, tinykv.Debug(), shardedKeyMap) })
+	})
+	t.Run("swappable", func(t *testing.T) {
+		Conformance(t, func() tinykv.KV {
+			s := tinykv.NewSwappable(tinykv.NewStore(time.Hour), tinykv.StopPreviousAfter(0))
+			next := tinykv.NewStore(time.Hour, tinykv.Debug())
+			next.Put("a", 1)
+			s.Swap(next)
+			return s
+		})
+	})
+	t.Run("decorated", func(t *testing.T) {
+		Conformance(t, func() tinykv.KV {
+			return tinykv.Decorate(tinykv.NewStore(time.Hour, tinykv.Debug()),
+				tinykv.LogOps(log.New(ioutil.Discard, "", 0)))
+		})
+	})
+	t.Run("prefixed", func(t *testing.T) {
+		Conformance(t, func() tinykv.KV {
+			base := tinykv.NewStore(time.Hour, tinykv.Debug())
+			base.Put("a", 1)
+			base.Put("other/a", 1)
+			return tinykv.Decorate(base, tinykv.PrefixKeys("tenant/"))
+		})
+	})
+	t.Run("null", func(t *testing.T) {
+		Conformance(t, tinykv.Null)
+	})
+	t.Run("frozen", func(t *testing.T) {
+		Conformance(t, func() tinykv.KV {
+			return tinykv.Frozen(map[string]interface{}{
+				"a":              1,
+				"b":              []byte("B"),
+				"tinykvtest/key": "FROZEN",
+			})
+		})
+	})
+}
+
+func TestSimulated(t *testing.T) {
+	var events []string
+	record := func(event string, s *Sim) {
+		events = append(events, fmt.Sprintf("%v %s", s.Now().Format("15:04:05"), event))
+	}
+	var s *Sim
+	var kv *tinykv.Store
+	kv, s = Simulated(
+		tinykv.OnExpire(func(k string, v interface{}) { record("expired "+k, s) }),
+		tinykv.OnIdle(time.Hour, func() { record("idle", s) }))
+	defer kv.Stop()
+
+	kv.Put("b", 1, tinykv.ExpiresAfter(time.Minute*2))
+	kv.Put("a", 1, tinykv.ExpiresAfter(time.Minute))
+	kv.PutAfter("later", 1, time.Minute*90, tinykv.ExpiresAfter(time.Minute))
+	kv.ExpectWithin("missing", time.Minute*3, func(k string) { record("missing "+k, s) })
+
+	s.Advance(time.Hour * 3)
+	expected := []string{
+		"00:01:00 expired a",
+		"00:02:00 expired b",
+		"00:03:00 missing missing",
+		"01:00:00 idle",
+		"01:31:00 expired later",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Fatalf("got %q, expected %q", events, expected)
+	}
+	if want := NewClock().Now().Add(time.Hour * 3); !s.Now().Equal(want) {
+		t.Fatalf("at %v, expected %v", s.Now(), want)
+	}
+}
+
+func TestSimulatedSlidingSessions(t *testing.T) {
+	expired := 0
+	kv, s := Simulated(tinykv.OnExpire(func(k string, v interface{}) { expired++ }))
+	defer kv.Stop()
+
+	// a session used every 10 minutes for a day lives on, one left for a
+	// day does not
+	kv.Put("active", 1, tinykv.ExpiresAfter(time.Minute*30), tinykv.IsSliding(true))
+	kv.Put("abandoned", 1, tinykv.ExpiresAfter(time.Minute*30), tinykv.IsSliding(true))
+	for i := 0; i < 6*24; i++ {
+		s.Advance(time.Minute * 10)
+		if _, ok := kv.Get("active"); !ok {
+			t.Fatalf("active session expired after %v", time.Duration(i+1)*time.Minute*10)
+		}
+	}
+	if _, ok := kv.Get("abandoned"); ok || expired != 1 {
+		t.Fatalf("abandoned session found = %v, %d expired", ok, expired)
+	}
+	if sweeps := kv.Stats().Sweeps; sweeps < 6*24 {
+		t.Fatalf("%d sweeps", sweeps)
+	}
+}
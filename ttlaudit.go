@@ -0,0 +1,150 @@
+package tinykv
+
+import (
+	"sort"
+	"time"
+)
+
+// auditGaps is the number of the latest gaps AuditReport computes the
+// percentiles over
+const auditGaps = 4096
+
+// auditBuckets is the resolution of the sample rate
+const auditBuckets = 1 << 16
+
+// AuditReport is the outcome of the expired entries TTLAudit sampled
+type AuditReport struct {
+	Expired      int64   // sampled entries that expired
+	NeverRead    int64   // of them, the ones never read
+	NeverReadPct float64 // NeverRead, as a percentage of Expired
+	Reads        int64   // of them all
+	// between the last read and the deadline, of the entries read, over the
+	// latest 4096 of them
+	GapP50   time.Duration
+	GapP95   time.Duration
+	Tracking int // sampled entries, not expired yet
+}
+
+// TTLAudit samples sampleRate (0 to 1) of the entries with a timeout, by a
+// hash of their keys, and records their reads (Get, GetE and GetGraced), to
+// tell if their timeouts fit the way they are used: AuditReport reports
+// how many expired without being read, and how long before their deadlines
+// the others were read last. Only the sampled entries take memory. It
+// disables the lock free Gets of ReadOptimized.
+func TTLAudit(sampleRate float64) StoreOption {
+	return func(opt *storeOpt) {
+		opt.auditRate = sampleRate
+	}
+}
+
+// auditRecord is the reads of a sampled entry
+type auditRecord struct {
+	reads    int64
+	lastRead time.Time
+}
+
+// ttlAudit is the state of TTLAudit
+type ttlAudit struct {
+	tracked   map[*entry]*auditRecord
+	expired   int64
+	neverRead int64
+	reads     int64
+	gaps      []time.Duration // ring of the latest auditGaps
+	nextGap   int
+}
+
+// sampled reports if the entries of k are sampled, by the low bits of its
+// hash, which are mixed the best
+func (kv *Store) sampled(k string) bool {
+	if kv.auditRate >= 1 {
+		return true
+	}
+	h, _ := bloomHash(k)
+	return float64(h%auditBuckets) < kv.auditRate*auditBuckets
+}
+
+// auditTrack starts tracking e, if it is sampled and not tracked yet; it
+// must be called under the lock
+func (kv *Store) auditTrack(k string, e *entry) {
+	if kv.auditRate <= 0 || e.timeout == nil || !kv.sampled(k) {
+		return
+	}
+	if kv.audit.tracked == nil {
+		kv.audit.tracked = make(map[*entry]*auditRecord)
+	}
+	if _, ok := kv.audit.tracked[e]; !ok {
+		kv.audit.tracked[e] = &auditRecord{}
+	}
+}
+
+// auditRead records a read of e; it must be called under the lock
+func (kv *Store) auditRead(e *entry) {
+	if kv.audit.tracked == nil {
+		return
+	}
+	if r := kv.audit.tracked[e]; r != nil {
+		r.reads++
+		r.lastRead = kv.now()
+	}
+}
+
+// auditRemoved stops tracking e, and records its outcome if it expired; it
+// must be called under the lock
+func (kv *Store) auditRemoved(e *entry) {
+	if kv.audit.tracked == nil {
+		return
+	}
+	r := kv.audit.tracked[e]
+	if r == nil {
+		return
+	}
+	delete(kv.audit.tracked, e)
+	if e.timeout == nil || !kv.expired(e) {
+		return
+	}
+	a := &kv.audit
+	a.expired++
+	a.reads += r.reads
+	if r.reads == 0 {
+		a.neverRead++
+		return
+	}
+	gap := e.timeout.expiresAt.Sub(r.lastRead)
+	if len(a.gaps) < auditGaps {
+		a.gaps = append(a.gaps, gap)
+		return
+	}
+	a.gaps[a.nextGap] = gap
+	a.nextGap = (a.nextGap + 1) % auditGaps
+}
+
+// AuditReport reports on the entries sampled by TTLAudit; it is zero
+// without it
+func (kv *Store) AuditReport() AuditReport {
+	kv.mx.Lock()
+	a := kv.audit
+	gaps := append([]time.Duration(nil), a.gaps...)
+	kv.mx.Unlock()
+	report := AuditReport{
+		Expired:   a.expired,
+		NeverRead: a.neverRead,
+		Reads:     a.reads,
+		Tracking:  len(a.tracked),
+	}
+	if a.expired > 0 {
+		report.NeverReadPct = float64(a.neverRead) * 100 / float64(a.expired)
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	report.GapP50 = percentile(gaps, 0.50)
+	report.GapP95 = percentile(gaps, 0.95)
+	return report
+}
+
+// percentile returns the q quantile of sorted
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q * float64(len(sorted)-1))
+	return sorted[i]
+}
@@ -0,0 +1,117 @@
+package tinykv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLAudit(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), TTLAudit(1), Debug())
+	defer kv.Stop()
+
+	for _, k := range []string{"never", "early", "mid", "late", "twice", "deleted"} {
+		assert.NoError(kv.Put(k, k, ExpiresAfter(time.Second*10)))
+	}
+	assert.NoError(kv.Put("no-ttl", 1))
+
+	read := func(keys ...string) {
+		for _, k := range keys {
+			_, ok := kv.Get(k)
+			assert.True(ok, k)
+		}
+	}
+	clock.Advance(time.Second)
+	read("early", "twice", "no-ttl")
+	clock.Advance(time.Second * 2)
+	read("mid")
+	clock.Advance(time.Second * 3)
+	read("twice")
+	kv.Delete("deleted")
+	clock.Advance(time.Second * 3)
+	read("late")
+	assert.Equal(5, kv.AuditReport().Tracking)
+
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+
+	// gaps to the deadline at 10s: early 9s, mid 7s, twice 4s, late 1s
+	assert.Equal(AuditReport{
+		Expired:      5,
+		NeverRead:    1,
+		NeverReadPct: 20,
+		Reads:        5,
+		GapP50:       time.Second * 4,
+		GapP95:       time.Second * 7,
+	}, kv.AuditReport())
+	assert.Equal(1.0, kv.Config().TTLAuditRate)
+}
+
+func TestTTLAuditReplaced(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), TTLAudit(1), ReadOptimized())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Second*10)))
+	_, _ = kv.Get("k")
+	// a new entry starts over, the replaced one is not reported
+	assert.NoError(kv.Put("k", 2, ExpiresAfter(time.Second*10)))
+	clock.Advance(time.Second * 4)
+	_, _ = kv.Get("k")
+	_, _ = kv.Get("k")
+	assert.Equal(1, kv.AuditReport().Tracking)
+
+	clock.Advance(time.Second * 7)
+	kv.ExpireNow()
+	assert.Equal(AuditReport{
+		Expired: 1,
+		Reads:   2,
+		GapP50:  time.Second * 6,
+		GapP95:  time.Second * 6,
+	}, kv.AuditReport())
+
+	assert.NoError(kv.Put("k", 3, ExpiresAfter(time.Second*10)))
+	kv.Clear()
+	assert.Equal(0, kv.AuditReport().Tracking)
+}
+
+func TestTTLAuditSampleRate(t *testing.T) {
+	assert := assert.New(t)
+
+	sampled := func(rate float64) map[string]bool {
+		kv := NewStore(time.Hour, TTLAudit(rate))
+		defer kv.Stop()
+		for i := 0; i < 1000; i++ {
+			assert.NoError(kv.Put(fmt.Sprint(i), i, ExpiresAfter(time.Hour)))
+		}
+		kv.mx.Lock()
+		defer kv.mx.Unlock()
+		keys := make(map[string]bool)
+		kv.kv.each(func(k string, e *entry) bool {
+			if _, ok := kv.audit.tracked[e]; ok {
+				keys[k] = true
+			}
+			return true
+		})
+		return keys
+	}
+
+	assert.Len(sampled(0), 0)
+	assert.Len(sampled(1), 1000)
+	half := sampled(0.5)
+	assert.InDelta(500, len(half), 100)
+	// by key, so the same on every store
+	assert.Equal(half, sampled(0.5))
+
+	kv := NewStore(time.Hour)
+	defer kv.Stop()
+	assert.NoError(kv.Put("k", 1, ExpiresAfter(time.Millisecond)))
+	assert.Equal(AuditReport{}, kv.AuditReport())
+}
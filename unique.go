@@ -0,0 +1,216 @@
+package tinykv
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// UniquePolicy is what a Put does, when the index key of its value is
+// claimed by another entry (see UniqueIndex)
+type UniquePolicy int
+
+// unique policies
+const (
+	UniqueReject  UniquePolicy = iota // fails with ErrIndexConflict
+	UniqueReplace                     // deletes the other entry
+)
+
+func (p UniquePolicy) String() string {
+	switch p {
+	case UniqueReject:
+		return "reject"
+	case UniqueReplace:
+		return "replace"
+	}
+	return "unknown"
+}
+
+// UniqueIndex adds a unique index on the values, by name: extract returns
+// the index key of a value, if it has one. No two entries have values with
+// the same index key: a Put (or ForcePut, TryPut, CAS) of a value whose index
+// key is claimed by another entry fails with ErrIndexConflict, or deletes the
+// other entry, by UniqueConflict. The other writes (Append, AddToSet, the
+// activation of a PutAfter, ...) cannot fail, so they delete the other entry.
+// The index drops an entry on its removal, whatever the way. extract is called
+// under the lock, so it must be fast, and must not use the store. See
+// LookupByIndex.
+func UniqueIndex(name string, extract func(v interface{}) (indexKey string, ok bool)) StoreOption {
+	return func(opt *storeOpt) {
+		opt.uniqueIndexes = append(opt.uniqueIndexes, &uniqueIndex{name: name, extract: extract})
+	}
+}
+
+// UniqueConflict sets what a Put does on a conflict in a UniqueIndex,
+// UniqueReject by default
+func UniqueConflict(policy UniquePolicy) StoreOption {
+	return func(opt *storeOpt) {
+		opt.uniquePolicy = policy
+	}
+}
+
+// uniqueIndex is a UniqueIndex, and its keys
+type uniqueIndex struct {
+	name    string
+	extract func(v interface{}) (string, bool)
+	owners  map[string]string // entry keys, by index key
+	claims  map[string]string // index keys, by entry key
+}
+
+func (ix *uniqueIndex) reset() {
+	ix.owners = make(map[string]string)
+	ix.claims = make(map[string]string)
+}
+
+// release drops the claim of k
+func (ix *uniqueIndex) release(k string) {
+	ik, ok := ix.claims[k]
+	if !ok {
+		return
+	}
+	delete(ix.claims, k)
+	if ix.owners[ik] == k {
+		delete(ix.owners, ik)
+	}
+}
+
+// LookupByIndex finds the entry whose value has indexKey, in the UniqueIndex
+// name, and returns its key and value. Like Get, it slides the entry.
+func (kv *Store) LookupByIndex(name, indexKey string) (string, interface{}, bool) {
+	kv.active()
+	kv.mx.Lock()
+	ix, ok := kv.uniqueByName[name]
+	if !ok {
+		kv.mx.Unlock()
+		return "", nil, false
+	}
+	k, ok := ix.owners[indexKey]
+	if !ok {
+		kv.mx.Unlock()
+		return "", nil, false
+	}
+	e, expired := kv.lookup(k)
+	if e == nil {
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return "", nil, false
+	}
+	kv.slide(e)
+	v := kv.copyValue(e.value)
+	kv.mx.Unlock()
+	return k, v, true
+}
+
+// checkUnique returns ErrIndexConflict if an index key of v is claimed by
+// another live entry than that of k, under UniqueReject
+func (kv *Store) checkUnique(k string, v interface{}) error {
+	if len(kv.uniqueByName) == 0 || kv.uniquePolicy != UniqueReject {
+		return nil
+	}
+	for name, ix := range kv.uniqueByName {
+		ik, ok := ix.extract(v)
+		if !ok {
+			continue
+		}
+		if owner, claimed := ix.owners[ik]; claimed && owner != k && kv.liveOwner(owner) {
+			return errors.Wrapf(ErrIndexConflict, "index %q key %q is claimed by %q", name, ik, owner)
+		}
+	}
+	return nil
+}
+
+// uniqueClaim is an index key of a UniqueIndex, by the name of the index
+type uniqueClaim struct {
+	index, key string
+}
+
+// checkUniqueBatch is checkUnique for a value of a batch of writes (like
+// MultiCAS): it also returns ErrIndexConflict if an index key of v is claimed
+// by an earlier value of the batch, and adds the index keys of v to claimed
+func (kv *Store) checkUniqueBatch(k string, v interface{}, claimed map[uniqueClaim]string) error {
+	if err := kv.checkUnique(k, v); err != nil {
+		return err
+	}
+	if len(kv.uniqueByName) == 0 || kv.uniquePolicy != UniqueReject {
+		return nil
+	}
+	for name, ix := range kv.uniqueByName {
+		ik, ok := ix.extract(v)
+		if !ok {
+			continue
+		}
+		c := uniqueClaim{name, ik}
+		if other, ok := claimed[c]; ok {
+			return errors.Wrapf(ErrIndexConflict, "index %q key %q is claimed by %q, in the same batch", name, ik, other)
+		}
+		claimed[c] = k
+	}
+	return nil
+}
+
+// liveOwner reports if the entry of k is not expired, so its claims hold
+func (kv *Store) liveOwner(k string) bool {
+	e, ok := kv.kv.get(k)
+	return ok && !kv.expired(e)
+}
+
+// indexUnique claims the index keys of the value of e, for k, deleting the
+// live entries that claimed them before
+func (kv *Store) indexUnique(k string, e *entry) {
+	for _, ix := range kv.uniqueByName {
+		ik, ok := ix.extract(e.value)
+		if old, had := ix.claims[k]; had && (!ok || old != ik) {
+			ix.release(k)
+		}
+		if !ok {
+			continue
+		}
+		if owner, claimed := ix.owners[ik]; claimed && owner != k {
+			if kv.liveOwner(owner) {
+				kv.remove(owner)
+			} else {
+				ix.release(owner) // swept later
+			}
+		}
+		ix.owners[ik] = k
+		ix.claims[k] = ik
+	}
+}
+
+// unindexUnique drops the claims of k, on its removal
+func (kv *Store) unindexUnique(k string) {
+	for _, ix := range kv.uniqueByName {
+		ix.release(k)
+	}
+}
+
+// uniqueNames returns the names of the unique indexes, sorted
+func (kv *Store) uniqueNames() []string {
+	if len(kv.uniqueByName) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(kv.uniqueByName))
+	for name := range kv.uniqueByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkUniqueIndexes verifies the unique indexes, for CheckInvariants
+func (kv *Store) checkUniqueIndexes() error {
+	for name, ix := range kv.uniqueByName {
+		if len(ix.owners) != len(ix.claims) {
+			return errors.Errorf("index %q has %d owners and %d claims", name, len(ix.owners), len(ix.claims))
+		}
+		for k, ik := range ix.claims {
+			if ix.owners[ik] != k {
+				return errors.Errorf("index %q key %q is claimed by %q, but owned by %q", name, ik, k, ix.owners[ik])
+			}
+			if _, ok := kv.kv.get(k); !ok {
+				return errors.Errorf("index %q key %q is claimed by %q, which has no entry", name, ik, k)
+			}
+		}
+	}
+	return nil
+}
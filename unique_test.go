@@ -0,0 +1,133 @@
+package tinykv
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type session struct {
+	user string
+}
+
+// byUser is the index key of a session; other values have none
+func byUser(v interface{}) (string, bool) {
+	s, ok := v.(session)
+	if !ok || s.user == "" {
+		return "", false
+	}
+	return s.user, true
+}
+
+func TestUniqueIndexReject(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, UniqueIndex("user", byUser), Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("token:1", session{"alice"}))
+	assert.NoError(kv.Put("token:2", session{"bob"}))
+	k, v, ok := kv.LookupByIndex("user", "alice")
+	assert.True(ok)
+	assert.Equal("token:1", k)
+	assert.Equal(session{"alice"}, v)
+
+	err := kv.Put("token:3", session{"alice"})
+	assert.Equal(ErrIndexConflict, errors.Cause(err))
+	assert.True(strings.Contains(err.Error(), "token:1"))
+	_, ok = kv.Get("token:3")
+	assert.False(ok)
+	err = kv.CAS("token:3", session{"alice"}, func(interface{}, bool) bool { return true })
+	assert.Equal(ErrIndexConflict, errors.Cause(err))
+	assert.Equal(int64(2), kv.Stats().RejectedPuts)
+
+	// the same entry may put its own index key again, or move to another
+	assert.NoError(kv.Put("token:1", session{"alice"}))
+	assert.NoError(kv.Put("token:1", session{"carol"}))
+	_, _, ok = kv.LookupByIndex("user", "alice")
+	assert.False(ok)
+	k, _, _ = kv.LookupByIndex("user", "carol")
+	assert.Equal("token:1", k)
+	assert.NoError(kv.Put("token:3", session{"alice"}))
+
+	_, _, ok = kv.LookupByIndex("no such index", "alice")
+	assert.False(ok)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestUniqueIndexReplace(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour, UniqueIndex("user", byUser), UniqueConflict(UniqueReplace), Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("token:1", session{"alice"}))
+	assert.NoError(kv.Put("token:2", session{"alice"}))
+	_, ok := kv.Get("token:1")
+	assert.False(ok)
+	k, _, ok := kv.LookupByIndex("user", "alice")
+	assert.True(ok)
+	assert.Equal("token:2", k)
+	assert.Equal(1, kv.Len())
+
+	// writes that cannot fail replace too
+	_, err := kv.Append("list", session{"alice"})
+	assert.NoError(err)
+	assert.NoError(kv.Put("token:3", session{"alice"}, ExpiresAfter(time.Minute)))
+	_, ok = kv.Get("token:2")
+	assert.False(ok)
+	assert.Equal(UniqueReplace, kv.Config().UniqueConflict)
+	assert.Equal([]string{"user"}, kv.Config().UniqueIndexes)
+	assert.NoError(kv.CheckInvariants())
+}
+
+func TestUniqueIndexCleaning(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now), UniqueIndex("user", byUser), Debug())
+	defer kv.Stop()
+
+	assert.NoError(kv.Put("token:1", session{"alice"}, ExpiresAfter(time.Minute)))
+	assert.NoError(kv.Put("token:2", session{"bob"}))
+	assert.NoError(kv.Put("token:3", session{"carol"}))
+
+	// expiration
+	clock.Advance(time.Minute * 2)
+	kv.ExpireNow()
+	_, _, ok := kv.LookupByIndex("user", "alice")
+	assert.False(ok)
+	assert.NoError(kv.Put("token:4", session{"alice"}))
+
+	// an expired entry, not yet swept, does not hold its index key
+	assert.NoError(kv.Put("token:5", session{"dave"}, ExpiresAfter(time.Minute)))
+	clock.Advance(time.Minute * 2)
+	assert.NoError(kv.Put("token:6", session{"dave"}))
+	kv.ExpireNow()
+	k, _, _ := kv.LookupByIndex("user", "dave")
+	assert.Equal("token:6", k)
+
+	// delete, take and a value without index key
+	kv.Delete("token:2")
+	_, _, ok = kv.LookupByIndex("user", "bob")
+	assert.False(ok)
+	_, ok = kv.Take("token:3")
+	assert.True(ok)
+	_, _, ok = kv.LookupByIndex("user", "carol")
+	assert.False(ok)
+	assert.NoError(kv.Put("token:4", "revoked"))
+	_, _, ok = kv.LookupByIndex("user", "alice")
+	assert.False(ok)
+	assert.NoError(kv.Put("anonymous:1", session{}))
+	assert.NoError(kv.Put("anonymous:2", session{}))
+	assert.NoError(kv.CheckInvariants())
+
+	kv.Clear()
+	_, _, ok = kv.LookupByIndex("user", "dave")
+	assert.False(ok)
+	assert.NoError(kv.Put("token:7", session{"dave"}))
+	assert.NoError(kv.CheckInvariants())
+}
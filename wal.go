@@ -0,0 +1,288 @@
+package tinykv
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/dc0d/tinykv/pkg/frame"
+	"github.com/pkg/errors"
+)
+
+// WAL makes the store append a record to w for each mutation: puts, deletes
+// (including takes and expirations) and clears; values are encoded using
+// codec. Changes to lists and sets are logged as puts of the whole value.
+// Slides, and window counters (IncrWindow), are not logged. Records are
+// buffered under the lock, and written to w outside it, in order: by Put
+// before it returns, and by a goroutine of the store for the other
+// mutations. Write errors are returned by the next Put. The log can be
+// replayed using ReplayWAL.
+func WAL(w io.Writer, codec ValueCodec) StoreOption {
+	return func(opt *storeOpt) {
+		opt.wal = w
+		opt.walCodec = codec
+	}
+}
+
+// WALRotation makes the store call rotate when more than maxBytes have been
+// written to the log, and use the returned writer from then on. rotate is
+// called outside the lock, but while writing the log, so it must not write to
+// the store (reads are fine). A way to use it, is to
+// return a new log and then (in another goroutine) save a snapshot and delete
+// the old log; as later records win, replaying the new log on top of the
+// snapshot gives the right state.
+func WALRotation(maxBytes int64, rotate func(old io.Writer) io.Writer) StoreOption {
+	return func(opt *storeOpt) {
+		opt.walMaxBytes = maxBytes
+		opt.walRotate = rotate
+	}
+}
+
+const (
+	walOpPut = iota + 1
+	walOpDelete
+	walOpClear
+
+	walFlagSliding   = 1 << 0
+	walFlagReadOnly  = 1 << 1
+	walFlagProtected = 1 << 2
+	walMaxRecord     = 1 << 28
+)
+
+// ReplayWAL applies the records of a log written by the WAL option, in order,
+// so later records win; entries already expired are dropped. Sliding entries
+// get a fresh window, since slides are not logged. A record cut off at the end
+// of the log (a crash while writing it) is ignored. The store must be created
+// with the WAL option, for the codec, otherwise ErrNoWAL is returned.
+// Replayed records are not logged again. The entries are put as the store
+// accepted them when they were logged: MaxEntries, MaxCost, Quota and
+// UniqueIndex are not checked (nor is anything evicted for them), and
+// read-only entries are replaced; only StringValues is checked again.
+func (kv *Store) ReplayWAL(r io.Reader) error {
+	if kv.walCodec == nil {
+		return ErrNoWAL
+	}
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	kv.walReplaying = true
+	defer func() { kv.walReplaying = false }()
+
+	br := bufio.NewReader(r)
+	for n := 0; ; n++ {
+		body, err := frame.Read(br, walMaxRecord, ErrCorrupted)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "record %d", n)
+		}
+		if err := kv.replay(body); err != nil {
+			return errors.Wrapf(err, "record %d", n)
+		}
+	}
+}
+
+func (kv *Store) replay(body []byte) error {
+	if len(body) == 0 {
+		return ErrCorrupted
+	}
+	op := body[0]
+	if op == walOpClear {
+		kv.clear()
+		return nil
+	}
+	d := frame.NewDecoder(body[1:], ErrCorrupted)
+	k := string(d.Bytes())
+	if op == walOpDelete {
+		if d.Err != nil {
+			return d.Err
+		}
+		if _, ok := kv.kv.get(k); ok {
+			kv.remove(k)
+		}
+		return nil
+	}
+	if op != walOpPut {
+		return errors.Wrapf(ErrCorrupted, "unknown op %d", op)
+	}
+	data := d.Bytes()
+	deadline := d.Varint()
+	expiresAfter := time.Duration(d.Varint())
+	flags := d.Uvarint()
+	if d.Err != nil {
+		return d.Err
+	}
+	v, err := kv.walCodec.Decode(data)
+	if err != nil {
+		return errors.Wrapf(err, "decoding value of %q", k)
+	}
+	if err := kv.checkString(v); err != nil {
+		return errors.Wrapf(err, "value of %q", k)
+	}
+	opt := &putOpt{readOnly: flags&walFlagReadOnly != 0, protected: flags&walFlagProtected != 0}
+	switch {
+	case flags&walFlagSliding != 0:
+		opt.expiresAfter = expiresAfter
+		opt.isSliding = true
+	case deadline != 0:
+		opt.expiresAt = time.Unix(0, deadline)
+		opt.expiresAfter = expiresAfter
+		if !opt.expiresAt.After(kv.now()) {
+			if _, ok := kv.kv.get(k); ok {
+				kv.remove(k)
+			}
+			return nil
+		}
+	}
+	kv.set(k, kv.newEntry(k, v, opt))
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+func (kv *Store) walPut(k string, e *entry) {
+	if !kv.walOn() {
+		return
+	}
+	if _, ok := e.value.(*windowCounter); ok {
+		return
+	}
+	data, err := kv.walCodec.Encode(e.value)
+	if err != nil {
+		kv.walFailed(errors.Wrapf(err, "encoding value of %q", k))
+		return
+	}
+	meta := e.meta(kv.now())
+	var deadline int64
+	if !meta.ExpiresAt.IsZero() {
+		deadline = meta.ExpiresAt.UnixNano()
+	}
+	var flags uint64
+	if meta.IsSliding {
+		flags |= walFlagSliding
+	}
+	if meta.ReadOnly {
+		flags |= walFlagReadOnly
+	}
+	if meta.Protected {
+		flags |= walFlagProtected
+	}
+	body := []byte{walOpPut}
+	body = frame.AppendBytes(body, []byte(k))
+	body = frame.AppendBytes(body, data)
+	body = frame.AppendVarint(body, deadline)
+	body = frame.AppendVarint(body, int64(meta.ExpiresAfter))
+	body = frame.AppendUvarint(body, flags)
+	kv.walWrite(body)
+}
+
+func (kv *Store) walDelete(k string) {
+	if !kv.walOn() {
+		return
+	}
+	kv.walWrite(frame.AppendBytes([]byte{walOpDelete}, []byte(k)))
+}
+
+func (kv *Store) walClear() {
+	if !kv.walOn() {
+		return
+	}
+	kv.walWrite([]byte{walOpClear})
+}
+
+// walOn reports if mutations are logged, under the lock
+func (kv *Store) walOn() bool {
+	return kv.walCodec != nil && !kv.walReplaying
+}
+
+// walWrite buffers the record of body, under the lock, and wakes up walLoop
+func (kv *Store) walWrite(body []byte) {
+	kv.walBuf = frame.Append(kv.walBuf, body)
+	select {
+	case kv.walReady <- struct{}{}:
+	default:
+	}
+}
+
+// walFlush writes the buffered records to the log, outside the lock; walMx
+// keeps the writes in the order of the records. A write error is returned by
+// the next Put.
+func (kv *Store) walFlush() {
+	kv.walMx.Lock()
+	defer kv.walMx.Unlock()
+	kv.mx.Lock()
+	buf := kv.walBuf
+	kv.walBuf = nil
+	kv.mx.Unlock()
+	if len(buf) == 0 {
+		return
+	}
+	n, err := kv.wal.Write(buf)
+	kv.walWritten += int64(n)
+	if err != nil {
+		kv.mx.Lock()
+		kv.walFailed(err)
+		kv.mx.Unlock()
+	}
+	if kv.walRotate != nil && kv.walMaxBytes > 0 && kv.walWritten > kv.walMaxBytes {
+		kv.wal = kv.walRotate(kv.wal)
+		kv.walWritten = 0
+	}
+}
+
+// walSync writes the records of a Put (and the ones before) to the log, and
+// returns err, or else the first WAL error since the last call
+func (kv *Store) walSync(err error) error {
+	if kv.walCodec == nil {
+		return err
+	}
+	kv.walFlush()
+	if err != nil {
+		return err
+	}
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	return kv.walError()
+}
+
+// walLoop writes the records of the mutations other than puts, soon after
+// they are made. A panic of the writer is recovered, reported to the OnPanic
+// hook, and returned by the next Put.
+func (kv *Store) walLoop() {
+	for {
+		select {
+		case <-kv.stop:
+			return
+		case <-kv.walReady:
+		}
+		err := try(func() error {
+			kv.walFlush()
+			return nil
+		})
+		if err == nil {
+			continue
+		}
+		kv.mx.Lock()
+		kv.walFailed(err)
+		kv.mx.Unlock()
+		if kv.onPanic != nil {
+			try(func() error {
+				kv.onPanic(err)
+				return nil
+			})
+		}
+	}
+}
+
+func (kv *Store) walFailed(err error) {
+	if kv.walErr == nil {
+		kv.walErr = err
+	}
+}
+
+// walError returns (and clears) the first WAL error since the last call
+func (kv *Store) walError() error {
+	err := kv.walErr
+	kv.walErr = nil
+	return err
+}
@@ -0,0 +1,198 @@
+package tinykv
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type intCodec struct{}
+
+func (intCodec) Encode(v interface{}) ([]byte, error) {
+	n, ok := v.(int)
+	if !ok {
+		return nil, errors.Errorf("not an int: %T", v)
+	}
+	return []byte(strconv.Itoa(n)), nil
+}
+
+func (intCodec) Decode(data []byte) (interface{}, error) {
+	return strconv.Atoi(string(data))
+}
+
+func TestWALReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	var log bytes.Buffer
+	kv := NewStore(time.Hour, Clock(clock.Now), WAL(&log, intCodec{}))
+	kv.Put("1", 1)
+	kv.Put("2", 2, ExpiresAfter(time.Second*10))
+	kv.Put("3", 3, ExpiresAfter(time.Second*10), IsSliding(true))
+	kv.Put("4", 4, ExpiresAfter(time.Second))
+	kv.Put("1", 11)
+	kv.Put("5", 5)
+	kv.Delete("5")
+	kv.Take("6")
+	kv.Put("6", 6)
+	kv.Take("6")
+	clock.Advance(time.Second * 2)
+	kv.ExpireNow()
+	kv.Stop()
+
+	replayed := NewStore(time.Hour, Clock(clock.Now), WAL(io.Discard, intCodec{}), Debug())
+	defer replayed.Stop()
+	assert.NoError(replayed.ReplayWAL(bytes.NewReader(log.Bytes())))
+	assert.NoError(replayed.CheckInvariants())
+
+	got := make(map[string]interface{})
+	replayed.Range(func(k string, v interface{}) bool {
+		got[k] = v
+		return true
+	})
+	assert.Equal(map[string]interface{}{"1": 11, "2": 2, "3": 3}, got)
+	meta, _ := replayed.GetMeta("2")
+	assert.True(clock.Now().Add(time.Second * 8).Equal(meta.ExpiresAt))
+	meta, _ = replayed.GetMeta("3")
+	assert.True(meta.IsSliding)
+	assert.Equal(clock.Now().Add(time.Second*10), meta.ExpiresAt)
+
+	// expired since logged
+	clock.Advance(time.Second * 9)
+	again := NewStore(time.Hour, Clock(clock.Now), WAL(io.Discard, intCodec{}))
+	defer again.Stop()
+	assert.NoError(again.ReplayWAL(bytes.NewReader(log.Bytes())))
+	_, ok := again.Get("2")
+	assert.False(ok)
+	_, ok = again.Get("1")
+	assert.True(ok)
+}
+
+func TestWALReplayCutOff(t *testing.T) {
+	assert := assert.New(t)
+
+	var log bytes.Buffer
+	kv := NewStore(time.Hour, WAL(&log, intCodec{}))
+	var ends []int
+	for i := 0; i < 5; i++ {
+		kv.Put(strconv.Itoa(i), i)
+		ends = append(ends, log.Len())
+	}
+	kv.Stop()
+
+	for cut := 0; cut < log.Len(); cut++ {
+		replayed := NewStore(time.Hour, WAL(io.Discard, intCodec{}), Debug())
+		assert.NoError(replayed.ReplayWAL(bytes.NewReader(log.Bytes()[:cut])))
+		complete := 0
+		for _, end := range ends {
+			if end <= cut {
+				complete++
+			}
+		}
+		assert.Len(replayed.Keys(), complete)
+		assert.NoError(replayed.CheckInvariants())
+		replayed.Stop()
+	}
+
+	data := append([]byte(nil), log.Bytes()...)
+	data[ends[1]-2] ^= 0xff
+	replayed := NewStore(time.Hour, WAL(io.Discard, intCodec{}))
+	defer replayed.Stop()
+	err := replayed.ReplayWAL(bytes.NewReader(data))
+	assert.Equal(ErrCorrupted, errors.Cause(err))
+	assert.Len(replayed.Keys(), 1)
+}
+
+func TestWALRotationAndErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	var logs []*bytes.Buffer
+	logs = append(logs, &bytes.Buffer{})
+	kv := NewStore(time.Hour,
+		WAL(logs[0], intCodec{}),
+		WALRotation(64, func(old io.Writer) io.Writer {
+			assert.Equal(logs[len(logs)-1], old)
+			logs = append(logs, &bytes.Buffer{})
+			return logs[len(logs)-1]
+		}))
+	defer kv.Stop()
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(kv.Put(strconv.Itoa(i), i))
+	}
+	assert.True(len(logs) > 2)
+	for _, l := range logs[:len(logs)-1] {
+		assert.True(l.Len() > 64)
+	}
+	assert.True(kv.Config().WAL)
+
+	assert.Error(kv.Put("s", "not an int"))
+	assert.NoError(kv.Put("s", 1))
+
+	noWAL := New(time.Hour)
+	defer noWAL.Stop()
+	assert.Equal(ErrNoWAL, noWAL.ReplayWAL(bytes.NewReader(nil)))
+}
+
+// blockingWriter blocks its writes while armed, until released
+type blockingWriter struct {
+	mx      sync.Mutex
+	armed   bool
+	blocked chan struct{}
+	release chan struct{}
+	log     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.mx.Lock()
+	armed := w.armed
+	w.armed = false
+	w.mx.Unlock()
+	if armed {
+		close(w.blocked)
+		<-w.release
+	}
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return w.log.Write(p)
+}
+
+func TestWALWritesOutsideTheLock(t *testing.T) {
+	assert := assert.New(t)
+
+	w := &blockingWriter{blocked: make(chan struct{}), release: make(chan struct{})}
+	kv := NewStore(time.Hour, WAL(w, intCodec{}))
+	defer kv.Stop()
+	assert.NoError(kv.Put("1", 1))
+	assert.NoError(kv.Put("2", 2))
+
+	w.mx.Lock()
+	w.armed = true
+	w.mx.Unlock()
+	kv.Delete("1")
+	<-w.blocked
+
+	// the store is not locked while the log is written
+	v, ok := kv.Get("2")
+	assert.True(ok)
+	assert.Equal(2, v)
+	assert.Equal([]string{"2"}, kv.Keys())
+	kv.Delete("2")
+
+	close(w.release)
+	assert.NoError(kv.Put("3", 3))
+	kv.Stop()
+
+	replayed := NewStore(time.Hour, WAL(io.Discard, intCodec{}))
+	defer replayed.Stop()
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	assert.NoError(replayed.ReplayWAL(bytes.NewReader(w.log.Bytes())))
+	assert.Equal([]string{"3"}, replayed.Keys())
+}
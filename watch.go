@@ -0,0 +1,272 @@
+package tinykv
+
+import (
+	"path"
+	"sort"
+	"sync"
+)
+
+// EventType is the kind of change of a watched key
+type EventType int
+
+// event types
+const (
+	EventPut EventType = iota + 1
+	EventDelete
+	EventExpire
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	}
+	return "unknown"
+}
+
+// Event is a change of a watched key: Value is the new value for EventPut,
+// and the removed one for EventDelete and EventExpire. In-place changes of
+// lists and sets are put events too, and Clear sends a delete event for
+// each entry. Seq is the write sequence of the value (see Meta.Seq), and
+// Origin is the name of the store (see Name).
+type Event struct {
+	Type   EventType
+	Key    string
+	Value  interface{}
+	Seq    uint64
+	Origin string
+}
+
+// CancelFunc stops a watch and closes its channel
+type CancelFunc func()
+
+// watchBuffer is the capacity of the channel of a watch
+const watchBuffer = 64
+
+// WatchPrefix returns a channel of the events of the keys starting with
+// prefix, in the order of the changes. Events are delivered by one goroutine,
+// for all the watches, so a watch must be read promptly (or canceled): when
+// its channel is full, the others wait. Matching a key costs a map lookup per
+// distinct length of the watched prefixes, whatever the number of watches.
+// The channel is closed on cancel, and when the store is stopped.
+func (kv *Store) WatchPrefix(prefix string) (<-chan Event, CancelFunc) {
+	kv.active()
+	return kv.watch(&watcher{prefix: prefix})
+}
+
+// WatchPattern is like WatchPrefix, for the keys matching pattern (the
+// syntax of path.Match, where * does not match /). Each pattern is matched
+// against each changed key, so it costs O(n) on the number of pattern
+// watches.
+func (kv *Store) WatchPattern(pattern string) (<-chan Event, CancelFunc) {
+	kv.active()
+	return kv.watch(&watcher{pattern: pattern, isPattern: true})
+}
+
+func (kv *Store) watch(w *watcher) (<-chan Event, CancelFunc) {
+	w.ch = make(chan Event, watchBuffer)
+	w.done = make(chan struct{})
+	select {
+	case <-kv.stop:
+		w.cancel()
+		return w.ch, func() {}
+	default:
+	}
+	kv.dispatchOnce.Do(func() { go kv.dispatchLoop() })
+	kv.mx.Lock()
+	kv.watchers = kv.watchers.with(w)
+	kv.mx.Unlock()
+	cancel := func() {
+		kv.mx.Lock()
+		kv.watchers = kv.watchers.without(w)
+		kv.mx.Unlock()
+		w.cancel()
+	}
+	return w.ch, cancel
+}
+
+// emit queues an event, it must be called under the lock
+func (kv *Store) emit(t EventType, k string, e *entry) {
+	if kv.watchers == nil {
+		return
+	}
+	kv.events = append(kv.events, queuedEvent{
+		Event:    Event{Type: t, Key: k, Value: e.value, Seq: e.seq, Origin: kv.name},
+		watchers: kv.watchers,
+	})
+	select {
+	case kv.eventsReady <- struct{}{}:
+	default:
+	}
+}
+
+// emitRemoved queues the event of the removal of e
+func (kv *Store) emitRemoved(k string, e *entry) {
+	if kv.watchers == nil {
+		return
+	}
+	t := EventDelete
+	if kv.expired(e) {
+		t = EventExpire
+	}
+	kv.emit(t, k, e)
+}
+
+func (kv *Store) dispatchLoop() {
+	for {
+		select {
+		case <-kv.stop:
+			kv.mx.Lock()
+			watchers := kv.watchers
+			kv.watchers = nil
+			kv.events = nil
+			kv.mx.Unlock()
+			watchers.each(func(w *watcher) { w.cancel() })
+			kv.closeQueued(true)
+			return
+		case <-kv.eventsReady:
+		}
+		kv.mx.Lock()
+		events := kv.events
+		kv.events = nil
+		kv.mx.Unlock()
+		for _, ev := range events {
+			ev.watchers.match(ev.Key, func(w *watcher) {
+				w.send(ev.Event, kv.stop)
+			})
+		}
+		kv.closeQueued(false)
+	}
+}
+
+// queuedEvent is an event, with the watches registered when it happened
+type queuedEvent struct {
+	Event
+	watchers *watchRegistry
+}
+
+//-----------------------------------------------------------------------------
+
+type watcher struct {
+	prefix    string
+	pattern   string
+	isPattern bool
+	ch        chan Event
+	done      chan struct{}
+	mx        sync.Mutex
+	closed    bool
+	once      sync.Once
+}
+
+func (w *watcher) send(ev Event, stop <-chan struct{}) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.ch <- ev:
+	case <-w.done:
+	case <-stop:
+	}
+}
+
+func (w *watcher) cancel() {
+	w.once.Do(func() {
+		close(w.done)
+		w.mx.Lock()
+		defer w.mx.Unlock()
+		w.closed = true
+		close(w.ch)
+	})
+}
+
+// watchRegistry is an immutable set of watches; nil means none
+type watchRegistry struct {
+	prefixes map[string][]*watcher
+	lengths  []int // distinct lengths of the prefixes, sorted
+	patterns []*watcher
+}
+
+func (r *watchRegistry) each(fn func(w *watcher)) {
+	if r == nil {
+		return
+	}
+	for _, ws := range r.prefixes {
+		for _, w := range ws {
+			fn(w)
+		}
+	}
+	for _, w := range r.patterns {
+		fn(w)
+	}
+}
+
+func (r *watchRegistry) match(k string, fn func(w *watcher)) {
+	if r == nil {
+		return
+	}
+	for _, n := range r.lengths {
+		if n > len(k) {
+			break
+		}
+		for _, w := range r.prefixes[k[:n]] {
+			fn(w)
+		}
+	}
+	for _, w := range r.patterns {
+		if ok, _ := path.Match(w.pattern, k); ok {
+			fn(w)
+		}
+	}
+}
+
+func (r *watchRegistry) with(w *watcher) *watchRegistry {
+	var ws []*watcher
+	r.each(func(w *watcher) { ws = append(ws, w) })
+	return newWatchRegistry(append(ws, w))
+}
+
+func (r *watchRegistry) without(w *watcher) *watchRegistry {
+	var ws []*watcher
+	r.each(func(x *watcher) {
+		if x != w {
+			ws = append(ws, x)
+		}
+	})
+	return newWatchRegistry(ws)
+}
+
+func newWatchRegistry(ws []*watcher) *watchRegistry {
+	if len(ws) == 0 {
+		return nil
+	}
+	r := &watchRegistry{prefixes: make(map[string][]*watcher)}
+	for _, w := range ws {
+		if w.isPattern {
+			r.patterns = append(r.patterns, w)
+			continue
+		}
+		if _, ok := r.prefixes[w.prefix]; !ok {
+			r.lengths = append(r.lengths, len(w.prefix))
+		}
+		r.prefixes[w.prefix] = append(r.prefixes[w.prefix], w)
+	}
+	sort.Ints(r.lengths)
+	r.lengths = dedupInts(r.lengths)
+	return r
+}
+
+func dedupInts(a []int) []int {
+	res := a[:0]
+	for i, n := range a {
+		if i == 0 || n != a[i-1] {
+			res = append(res, n)
+		}
+	}
+	return res
+}
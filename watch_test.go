@@ -0,0 +1,127 @@
+package tinykv
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, ch <-chan Event, n int) []Event {
+	var events []Event
+	for len(events) < n {
+		select {
+		case ev := <-ch:
+			events = append(events, ev)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d events of %d: %v", len(events), n, events)
+		}
+	}
+	return events
+}
+
+func TestWatchPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	all, cancelAll := kv.WatchPrefix("")
+	defer cancelAll()
+	session, cancelSession := kv.WatchPrefix("session:")
+	defer cancelSession()
+	sessionA, cancelSessionA := kv.WatchPrefix("session:a")
+	defer cancelSessionA()
+	pattern, cancelPattern := kv.WatchPattern("session:*")
+	defer cancelPattern()
+	other, cancelOther := kv.WatchPrefix("other:")
+	defer cancelOther()
+
+	kv.Put("session:a1", 1, ExpiresAfter(time.Minute))
+	kv.Put("session:b", 2)
+	kv.Put("other:x", 3)
+	kv.Append("session:a2", 4)
+	kv.Append("session:a2", 5)
+	kv.Delete("session:b")
+	clock.Advance(time.Minute * 2)
+	kv.ExpireNow()
+	kv.Put("session:a-end", 0) // matches all, but other:
+
+	var (
+		a1  = Event{EventPut, "session:a1", 1, 1, ""}
+		b   = Event{EventPut, "session:b", 2, 2, ""}
+		x   = Event{EventPut, "other:x", 3, 3, ""}
+		a2  = Event{EventPut, "session:a2", []interface{}{4}, 4, ""}
+		a2a = Event{EventPut, "session:a2", []interface{}{4, 5}, 5, ""}
+		db  = Event{EventDelete, "session:b", 2, 2, ""}
+		ea1 = Event{EventExpire, "session:a1", 1, 1, ""}
+		end = Event{EventPut, "session:a-end", 0, 6, ""}
+	)
+	assert.Equal([]Event{a1, b, x, a2, a2a, db, ea1, end}, receive(t, all, 8))
+	assert.Equal([]Event{a1, b, a2, a2a, db, ea1, end}, receive(t, session, 7))
+	assert.Equal([]Event{a1, a2, a2a, ea1, end}, receive(t, sessionA, 5))
+	assert.Equal([]Event{a1, b, a2, a2a, db, ea1, end}, receive(t, pattern, 7))
+	assert.Equal([]Event{x}, receive(t, other, 1))
+
+	// exactly once: nothing more was delivered
+	for _, ch := range []<-chan Event{all, session, sessionA, pattern, other} {
+		assert.Len(ch, 0)
+	}
+
+	kv.Clear()
+	assert.ElementsMatch([]Event{
+		{EventDelete, "other:x", 3, 3, ""},
+		{EventDelete, "session:a2", []interface{}{4, 5}, 5, ""},
+		{EventDelete, "session:a-end", 0, 6, ""},
+	}, receive(t, all, 3))
+}
+
+func TestWatchCancelAndStop(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := NewStore(time.Hour)
+	ch1, cancel1 := kv.WatchPrefix("a")
+	ch2, _ := kv.WatchPattern("a*")
+
+	kv.Put("a", 1)
+	assert.Equal(Event{EventPut, "a", 1, 1, ""}, receive(t, ch1, 1)[0])
+	cancel1()
+	cancel1()
+	_, ok := <-ch1
+	assert.False(ok)
+
+	kv.Stop()
+	for range ch2 {
+	}
+	ch3, cancel3 := kv.WatchPrefix("")
+	_, ok = <-ch3
+	assert.False(ok)
+	cancel3()
+
+	assert.Equal("expire", EventExpire.String())
+}
+
+func TestWatchRace(t *testing.T) {
+	kv := NewStore(time.Millisecond * 5)
+	defer kv.Stop()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ch, cancel := kv.WatchPrefix(strconv.Itoa(w))
+			for i := 0; i < 50; i++ {
+				<-ch
+			}
+			cancel() // while events are still being sent
+		}(w)
+	}
+	for i := 0; i < 1000; i++ {
+		kv.Put(strconv.Itoa(i%4)+"-"+strconv.Itoa(i), i, ExpiresAfter(time.Millisecond))
+	}
+	wg.Wait()
+}
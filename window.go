@@ -0,0 +1,46 @@
+package tinykv
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// windowCounter is the value of an entry created by IncrWindow.
+type windowCounter struct {
+	count int64
+}
+
+// IncrWindow increments the counter stored at k, which resets every window
+// (the entry expires after window, starting from the first hit). It reports
+// if the count stayed within limit and how long until the window resets.
+// If k holds a value that is not a window counter, ErrTypeConflict is returned.
+func (kv *Store) IncrWindow(k string, window time.Duration, limit int64) (count int64, allowed bool, retryAfter time.Duration, err error) {
+	defer wrapOp(&err, "incr-window", k)
+	kv.active()
+	if window <= 0 {
+		return 0, false, 0, ErrInvalidWindow
+	}
+	if err := kv.checkString((*windowCounter)(nil)); err != nil {
+		return 0, false, 0, err
+	}
+	kv.mx.Lock()
+	e, expired := kv.lookup(k)
+	if e == nil {
+		e = kv.newEntry(k, &windowCounter{count: 1}, &putOpt{expiresAfter: window})
+		kv.set(k, e)
+		kv.mx.Unlock()
+		kv.notify(expired)
+		return 1, 1 <= limit, window, nil
+	}
+	wc, ok := e.value.(*windowCounter)
+	if !ok || e.timeout == nil {
+		kv.mx.Unlock()
+		return 0, false, 0, errors.Wrapf(ErrTypeConflict, "holds a %T, not a window counter", e.value)
+	}
+	wc.count++
+	count = wc.count
+	retryAfter = e.expiresAt.Sub(kv.now())
+	kv.mx.Unlock()
+	return count, count <= limit, retryAfter, nil
+}
@@ -0,0 +1,58 @@
+package tinykv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncrWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	kv := NewStore(time.Hour, Clock(clock.Now))
+	defer kv.Stop()
+
+	for i := int64(1); i <= 5; i++ {
+		count, allowed, retryAfter, err := kv.IncrWindow("ip", time.Second, 3)
+		assert.NoError(err)
+		assert.Equal(i, count)
+		assert.Equal(i <= 3, allowed)
+		assert.Equal(time.Second, retryAfter)
+	}
+
+	clock.Advance(time.Millisecond * 400)
+	count, allowed, retryAfter, err := kv.IncrWindow("ip", time.Second, 3)
+	assert.NoError(err)
+	assert.Equal(int64(6), count)
+	assert.False(allowed)
+	assert.Equal(time.Millisecond*600, retryAfter)
+
+	clock.Advance(time.Millisecond * 601)
+	count, allowed, retryAfter, err = kv.IncrWindow("ip", time.Second, 3)
+	assert.NoError(err)
+	assert.Equal(int64(1), count)
+	assert.True(allowed)
+	assert.Equal(time.Second, retryAfter)
+	checkInvariants(t, kv)
+
+	_, _, _, err = kv.IncrWindow("ip", 0, 3)
+	assert.Equal(ErrInvalidWindow, errors.Cause(err))
+
+	kv.Put("other", 1)
+	_, _, _, err = kv.IncrWindow("other", time.Second, 3)
+	assert.Equal(ErrTypeConflict, errors.Cause(err))
+}
+
+func TestIncrWindowAllocs(t *testing.T) {
+	kv := New(-1)
+	defer kv.Stop()
+
+	kv.IncrWindow("ip", time.Hour, 10)
+	allocs := testing.AllocsPerRun(100, func() {
+		kv.IncrWindow("ip", time.Hour, 10)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
@@ -0,0 +1,103 @@
+package tinykv
+
+import (
+	"time"
+)
+
+const (
+	writeSketchRows  = 4
+	writeSketchWidth = 1024
+	// maxWriteSuspects bounds the keys tracked exactly in a window
+	maxWriteSuspects = 1024
+)
+
+// WriteRateAlarm makes the store count the writes of each key (Put, CAS and
+// the other writes) in windows of the given duration, and call fn, once per
+// key and window, when the writes of a key go over perKeyThreshold. fn gets
+// the key and its writes at that point, and is called on its own goroutine
+// (see Executor), so it can use the store. A window starts with the first
+// write after the last one ended. The writes are counted in a count-min
+// sketch, of a fixed size; only the keys it finds over half the threshold
+// are counted exactly, up to 1024 keys per window, so the memory does not
+// grow with the keys. The sketch may count a key with too many writes if it
+// collides with others, never with too few. A window that is not positive,
+// or a nil fn, turns it off.
+func WriteRateAlarm(perKeyThreshold int, window time.Duration, fn func(k string, writes int)) StoreOption {
+	return func(opt *storeOpt) {
+		if window <= 0 || fn == nil {
+			return
+		}
+		opt.writeRateThreshold = perKeyThreshold
+		opt.writeRateWindow = window
+		opt.onWriteRateAlarm = fn
+	}
+}
+
+// writeRate counts the writes of the current window: all the keys in the
+// sketch, and the suspects, over half the threshold, exactly
+type writeRate struct {
+	start    time.Time
+	sketch   *[writeSketchRows][writeSketchWidth]uint32
+	suspects map[string]*writeSuspect
+}
+
+type writeSuspect struct {
+	writes int
+	fired  bool
+}
+
+// countWrite counts a write of k, under the lock
+func (kv *Store) countWrite(k string) {
+	if kv.onWriteRateAlarm == nil {
+		return
+	}
+	w := &kv.writeRate
+	now := kv.now()
+	if w.sketch == nil {
+		w.sketch = new([writeSketchRows][writeSketchWidth]uint32)
+	}
+	if w.start.IsZero() || now.Sub(w.start) >= kv.writeRateWindow {
+		w.start = now
+		*w.sketch = [writeSketchRows][writeSketchWidth]uint32{}
+		w.suspects = nil
+	}
+	if s := w.suspects[k]; s != nil {
+		s.writes++
+		kv.checkWriteRate(k, s)
+		return
+	}
+	h1, h2 := bloomHash(k)
+	estimate := ^uint32(0)
+	for i := range w.sketch {
+		c := &w.sketch[i][(h1+uint64(i)*h2)%writeSketchWidth]
+		*c++
+		if *c < estimate {
+			estimate = *c
+		}
+	}
+	if int(estimate) <= kv.writeRateThreshold/2 || len(w.suspects) >= maxWriteSuspects {
+		return
+	}
+	if w.suspects == nil {
+		w.suspects = make(map[string]*writeSuspect)
+	}
+	s := &writeSuspect{writes: int(estimate)}
+	w.suspects[k] = s
+	kv.checkWriteRate(k, s)
+}
+
+// checkWriteRate calls the alarm for the suspect k, once, if it went over
+// the threshold
+func (kv *Store) checkWriteRate(k string, s *writeSuspect) {
+	if s.fired || s.writes <= kv.writeRateThreshold {
+		return
+	}
+	s.fired = true
+	writes, fn := s.writes, kv.onWriteRateAlarm
+	kv.spawnLocked(func() {
+		try(func() error {
+			fn(k, writes)
+			return nil
+		})
+	})
+}
@@ -0,0 +1,80 @@
+package tinykv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type writeAlarm struct {
+	k      string
+	writes int
+}
+
+func TestWriteRateAlarm(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := newFakeClock()
+	alarms := make(chan writeAlarm, 10)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		WriteRateAlarm(100, time.Minute, func(k string, writes int) { alarms <- writeAlarm{k, writes} }))
+	defer kv.Stop()
+	assert.Equal(time.Minute, kv.Config().WriteRateWindow)
+	assert.Equal(100, kv.Config().WriteRateThreshold)
+
+	// one hot key among many cold ones
+	for round := 0; round < 150; round++ {
+		assert.NoError(kv.Put("hot", round))
+		for i := 0; i < 20; i++ {
+			assert.NoError(kv.Put(fmt.Sprint("cold", round*20+i), i))
+		}
+	}
+	select {
+	case a := <-alarms:
+		assert.Equal(writeAlarm{"hot", 101}, a)
+	case <-time.After(time.Second):
+		t.Fatal("no alarm")
+	}
+	select {
+	case a := <-alarms:
+		t.Fatalf("unexpected alarm: %v", a)
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	// once per window
+	clock.Advance(time.Minute)
+	for i := 0; i < 101; i++ {
+		assert.NoError(kv.Put("hot", i))
+	}
+	select {
+	case a := <-alarms:
+		assert.Equal(writeAlarm{"hot", 101}, a)
+	case <-time.After(time.Second):
+		t.Fatal("no alarm")
+	}
+}
+
+func TestWriteRateAlarmUnderThreshold(t *testing.T) {
+	clock := newFakeClock()
+	alarms := make(chan writeAlarm, 10)
+	kv := NewStore(time.Hour,
+		Clock(clock.Now),
+		WriteRateAlarm(10, time.Minute, func(k string, writes int) { alarms <- writeAlarm{k, writes} }))
+	defer kv.Stop()
+
+	// 10 writes per window, over 5 windows
+	for w := 0; w < 5; w++ {
+		for i := 0; i < 10; i++ {
+			kv.Put("k", i)
+		}
+		clock.Advance(time.Minute)
+	}
+	select {
+	case a := <-alarms:
+		t.Fatalf("unexpected alarm: %v", a)
+	case <-time.After(time.Millisecond * 20):
+	}
+}